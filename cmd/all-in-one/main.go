@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/nats-io/nats.go"
+
+	"sistemas_distribuidos_gb/internal/allinone"
+	"sistemas_distribuidos_gb/internal/anomaly"
+	"sistemas_distribuidos_gb/internal/embedded"
+	"sistemas_distribuidos_gb/internal/secure"
+	"sistemas_distribuidos_gb/internal/subjects"
+)
+
+func main() {
+	var (
+		natsURL   = flag.String("nats", "nats://localhost:4222", "NATS server URL")
+		httpPort  = flag.String("http-port", "8080", "HTTP port for the dashboard and every component's API")
+		site      = flag.String("site", subjects.DefaultSite, "Site the sensors are installed at")
+		edges     = flag.Int("edges", 2, "Number of edge nodes, one per production line")
+		sensors   = flag.Int("sensors", 5, "Number of simulated sensors, spread over the lines")
+		interval  = flag.Duration("interval", 1*time.Second, "Sensor publication interval")
+		jetStream = flag.Bool("jetstream", false, "Edges consume readings through durable JetStream consumers")
+		detectors = flag.String("detectors", strings.Join(anomaly.DefaultConfig().Enabled, ","), "Comma-separated anomaly detectors: bands, ewma, cusum, zscore, seasonal")
+		useConfig = flag.Bool("config", true, "Load hot-reloadable settings from the central config bucket")
+		keyDir    = flag.String("keys", "keys", "Directory of the sensors' signing keys")
+	)
+	natsAuth := secure.RegisterNATSFlags(flag.CommandLine)
+	httpAuth := secure.RegisterHTTPFlags(flag.CommandLine)
+	embeddedNATS := embedded.RegisterFlags(flag.CommandLine, true)
+	flag.Parse()
+
+	ns, err := embeddedNATS.Start(*natsURL)
+	if err != nil {
+		log.Fatalf("Failed to start embedded NATS server: %v", err)
+	}
+	if ns != nil {
+		defer ns.Shutdown()
+		log.Printf("Embedded NATS server listening on %s", ns.ClientURL())
+		if u := embedded.LeafURL(ns); u != "" {
+			log.Printf("Accepting leaf nodes on %s", u)
+		}
+	}
+
+	opts := allinone.DefaultOptions()
+	opts.Site = *site
+	opts.Edges = *edges
+	opts.Sensors = *sensors
+	opts.KeyDir = *keyDir
+	opts.Cloud.UseConfig = *useConfig
+	opts.Dashboard.UseConfig = *useConfig
+	opts.Edge.UseConfig = *useConfig
+	opts.Edge.JetStream = *jetStream
+	opts.Edge.Detectors.Enabled = subjects.ParseFilters(*detectors)
+	opts.Sensor.UseConfig = *useConfig
+	opts.Sensor.Interval = *interval
+	opts.Connect = func(name string) (*nats.Conn, error) {
+		return natsAuth.Connect(*natsURL, name)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	system, err := allinone.Start(ctx, opts)
+	if err != nil {
+		log.Fatalf("Failed to start pipeline: %v", err)
+	}
+	defer system.Stop()
+	log.Printf("Pipeline started: %d edges, %d sensors on site %s", *edges, *sensors, *site)
+
+	errc := make(chan error, 1)
+	go func() {
+		log.Printf("Open http://localhost:%s in your browser (APIs under /cloud/, /edge/<id>/ and /sensor/<id>/)", *httpPort)
+		errc <- httpAuth.ListenAndServe(":"+*httpPort, system.Handler())
+	}()
+
+	select {
+	case err := <-errc:
+		log.Printf("HTTP Server failed: %v", err)
+	case <-ctx.Done():
+		log.Printf("Shutting down")
+	}
+}
@@ -2,352 +2,265 @@ package main
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"flag"
 	"log"
-	"math"
-	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
 	"sync"
+	"syscall"
 	"time"
 
+	"github.com/nats-io/nats-server/v2/server"
 	"github.com/nats-io/nats.go"
-	"github.com/nats-io/nats.go/jetstream"
-)
-
-type SensorReading struct {
-	SensorID  string  `json:"sensor_id"`
-	Value     float64 `json:"value"`
-	Timestamp int64   `json:"timestamp"`
-}
-
-type FilteredReading struct {
-	SensorID  string  `json:"sensor_id"`
-	Value     float64 `json:"value"`
-	Timestamp int64   `json:"timestamp"`
-	EdgeID    string  `json:"edge_id"`
-}
 
-type Alert struct {
-	SensorID  string  `json:"sensor_id"`
-	Value     float64 `json:"value"`
-	Timestamp int64   `json:"timestamp"`
-	EdgeID    string  `json:"edge_id"`
-	Type      string  `json:"type"`
-	Message   string  `json:"message"`
-}
-
-type EdgeStats struct {
-	mu            sync.RWMutex
-	Count         int       `json:"count"`
-	Sum           float64   `json:"sum"`
-	Min           float64   `json:"min"`
-	Max           float64   `json:"max"`
-	WindowValues  []float64 `json:"-"`
-	WindowSize    int       `json:"window_size"`
-	LastAggregate time.Time `json:"last_aggregate"`
-	EdgeID        string    `json:"edge_id"`
-	StartTime     time.Time `json:"start_time"`
-}
-
-var globalStats *EdgeStats
+	"sistemas_distribuidos_gb/internal/anomaly"
+	"sistemas_distribuidos_gb/internal/broker"
+	"sistemas_distribuidos_gb/internal/edge"
+	"sistemas_distribuidos_gb/internal/embedded"
+	"sistemas_distribuidos_gb/internal/modbus"
+	"sistemas_distribuidos_gb/internal/opcua"
+	"sistemas_distribuidos_gb/internal/outbox"
+	"sistemas_distribuidos_gb/internal/secure"
+	"sistemas_distribuidos_gb/internal/subjects"
+)
 
 func main() {
 	var (
 		edgeID       = flag.String("id", "", "Edge Node ID (auto-generated if empty)")
 		natsURL      = flag.String("nats", "nats://localhost:4222", "NATS server URL")
-		thresholdMin = flag.Float64("min", 30.0, "Minimum threshold for alerts")
-		thresholdMax = flag.Float64("max", 80.0, "Maximum threshold for alerts")
+		subjectList  = flag.String("subjects", subjects.AllReadings, "Comma-separated reading subjects this edge owns (e.g. sensors.plant-a.>)")
+		_            = flag.Float64("min", 30.0, "Deprecated: alert thresholds come from the registry and central config")
+		_            = flag.Float64("max", 80.0, "Deprecated: alert thresholds come from the registry and central config")
 		noiseFilter  = flag.Float64("noise", 3.0, "Noise filter threshold (std deviations)")
 		windowSize   = flag.Int("window", 10, "Aggregation window size")
 		aggregateInt = flag.Duration("aggregate", 5*time.Second, "Aggregation interval")
 		useJetStream = flag.Bool("jetstream", false, "Use JetStream for persistence")
 		httpPort     = flag.String("http-port", "8082", "HTTP API port")
+		useRegistry  = flag.Bool("registry", true, "Look up sensor metadata, calibration and thresholds in the registry")
+		useConfig    = flag.Bool("config", true, "Load hot-reloadable settings from the central config bucket")
+		detectorList = flag.String("detectors", strings.Join(anomaly.DefaultConfig().Enabled, ","), "Comma-separated anomaly detectors: bands, ewma, cusum, zscore, seasonal")
+		signatures   = flag.String("signatures", "quarantine", "What to do with readings whose signature can't be verified: off, warn, quarantine or reject")
+		maxClockSkew = flag.Duration("max-clock-skew", edge.DefaultMaxClockSkew, "How far a signed reading's timestamp may be from the edge clock before it's treated as a replay")
+		modbusFile   = flag.String("modbus", "", "Poll the Modbus/TCP devices listed in this JSON file (e.g. deploy/modbus/devices.json)")
+		modbusSim    = flag.Bool("modbus-simulator", false, "Serve simulated values for the devices of -modbus, at their addresses")
+		opcuaFile    = flag.String("opcua", "", "Subscribe to the OPC UA servers and nodes listed in this JSON file (e.g. deploy/opcua/servers.json)")
+		mqttBridge   = flag.String("mqtt-bridge", "", "Bridge readings from this MQTT topic template (e.g. "+edge.DefaultBridgeTopic+"); empty disables")
+		uplinkURL    = flag.String("uplink", "", "NATS URL of the hub the output, registry and config are on, apart from the sensors' -nats (same server if empty)")
+		bufferDir    = flag.String("buffer", "", "Directory where the output is kept until the uplink confirms it, one subdirectory per edge (off if empty; with -uplink or -leaf it defaults to edge-buffer in the user cache directory)")
+		bufferMax    = flag.Int64("buffer-max-bytes", outbox.DefaultMaxBytes, "Disk space of the buffer; past it the oldest messages are dropped")
+		leafURL      = flag.String("leaf", "", "Run an embedded NATS server on the -nats address, for the local sensors, as a leaf node of the hub at this URL (e.g. nats-leaf://hub:7422)")
+		leafSubjects = flag.String("leaf-subjects", strings.Join(embedded.DefaultUpstream, ","), "Comma-separated subjects the leaf node forwards to the hub")
+		leafCreds    = flag.String("leaf-creds", "", "Credentials file for the hub's leaf node port")
+		leafCA       = flag.String("leaf-ca", "", "CA certificate used to verify the hub's leaf node port (enables TLS)")
 	)
+	natsAuth := secure.RegisterNATSFlags(flag.CommandLine)
+	transport := broker.RegisterFlags(flag.CommandLine)
+	httpAuth := secure.RegisterHTTPFlags(flag.CommandLine)
+	embeddedNATS := embedded.RegisterFlags(flag.CommandLine, false)
 	flag.Parse()
 
+	if *leafURL != "" && *uplinkURL != "" {
+		log.Fatalf("-leaf and -uplink are exclusive: with -leaf the output reaches the hub through the leaf node")
+	}
 	// Generate edge ID if not provided
 	if *edgeID == "" {
 		*edgeID = "edge-" + time.Now().Format("20060102-150405")
 	}
 
-	// Initialize Stats
-	globalStats = &EdgeStats{
-		WindowValues: make([]float64, 0, *windowSize),
-		WindowSize:   *windowSize,
-		Min:          math.Inf(1),
-		Max:          math.Inf(-1),
-		EdgeID:       *edgeID,
-		StartTime:    time.Now(),
+	var ns *server.Server
+	var err error
+	if *leafURL != "" {
+		ns, err = embeddedNATS.StartLeaf(*natsURL, &embedded.Leaf{
+			Name:        *edgeID,
+			URL:         *leafURL,
+			Credentials: *leafCreds,
+			CA:          *leafCA,
+			Upstream:    subjects.ParseFilters(*leafSubjects),
+		})
+	} else {
+		ns, err = embeddedNATS.Start(*natsURL)
 	}
-
-	// Start HTTP Server
-	go startAPIServer(*httpPort)
-
-	// Connect to NATS
-	nc, err := nats.Connect(*natsURL)
 	if err != nil {
-		log.Fatalf("Failed to connect to NATS: %v", err)
+		log.Fatalf("Failed to start embedded NATS server: %v", err)
+	}
+	if ns != nil {
+		defer ns.Shutdown()
+		log.Printf("Embedded NATS server listening on %s", ns.ClientURL())
 	}
-	defer nc.Close()
 
-	var js jetstream.JetStream
-	if *useJetStream {
-		js, err = jetstream.New(nc)
+	opts := edge.DefaultOptions()
+	opts.ID = *edgeID
+	opts.Subjects = subjects.ParseFilters(*subjectList)
+	opts.NoiseFilter = *noiseFilter
+	opts.WindowSize = *windowSize
+	opts.AggregateInterval = *aggregateInt
+	opts.JetStream = *useJetStream
+	opts.UseRegistry = *useRegistry
+	opts.UseConfig = *useConfig
+	opts.Detectors = anomaly.DefaultConfig()
+	opts.Detectors.Enabled = subjects.ParseFilters(*detectorList)
+	opts.Signatures = *signatures
+	opts.MaxClockSkew = *maxClockSkew
+
+	if *modbusFile != "" {
+		devices, err := modbus.LoadConfig(*modbusFile)
 		if err != nil {
-			log.Fatalf("Failed to create JetStream context: %v", err)
-		}
-
-		// Create stream if it doesn't exist
-		ctx := context.Background()
-		_, err = js.CreateStream(ctx, jetstream.StreamConfig{
-			Name:     "SENSORS",
-			Subjects: []string{"sensors.readings"},
-			Replicas: 1,
-		})
-		if err != nil && err.Error() != "stream name already in use" {
-			log.Printf("Error creating stream (may already exist): %v", err)
+			log.Fatalf("Failed to load Modbus devices: %v", err)
 		}
+		opts.Modbus = devices
 	}
-
-	log.Printf("Edge Node %s started, listening to sensors.readings", *edgeID)
-
-	// Start aggregation timer
-	go func() {
-		ticker := time.NewTicker(*aggregateInt)
-		defer ticker.Stop()
-		for range ticker.C {
-			globalStats.publishAggregate(nc, *edgeID)
-		}
-	}()
-
-	// Subscribe to sensor readings
-	var sub *nats.Subscription
-	if *useJetStream && js != nil {
-		ctx := context.Background()
-		consumer, err := js.CreateOrUpdateConsumer(ctx, "SENSORS", jetstream.ConsumerConfig{
-			Durable:   "EDGE-" + *edgeID,
-			AckPolicy: jetstream.AckExplicitPolicy,
-		})
+	if *opcuaFile != "" {
+		servers, err := opcua.LoadConfig(*opcuaFile)
 		if err != nil {
-			log.Fatalf("Failed to create consumer: %v", err)
+			log.Fatalf("Failed to load OPC UA servers: %v", err)
 		}
-
-		msgs, err := consumer.Messages()
+		opts.OPCUA = servers
+	}
+	if *modbusSim {
+		sim, err := modbus.StartSimulator(opts.Modbus, nil)
 		if err != nil {
-			log.Fatalf("Failed to get messages: %v", err)
+			log.Fatalf("Failed to start Modbus simulator: %v", err)
 		}
+		defer sim.Close()
+		log.Printf("Modbus simulator listening on %v", sim.Addrs())
+	}
 
-		// Process messages in a goroutine
-		go func() {
-			for {
-				msg, err := msgs.Next()
-				if err != nil {
-					log.Printf("Error getting next message: %v", err)
-					continue
-				}
-				
-				processMessage(msg.Data(), globalStats, nc, *edgeID, *thresholdMin, *thresholdMax, *noiseFilter)
-				if err := msg.Ack(); err != nil {
-					log.Printf("Error acking message: %v", err)
-				}
-			}
-		}()
-
-		// Keep running
-		select {}
-	} else {
-		sub, err = nc.Subscribe("sensors.readings", func(msg *nats.Msg) {
-			processMessage(msg.Data, globalStats, nc, *edgeID, *thresholdMin, *thresholdMax, *noiseFilter)
-		})
+	// NATS connections keep reconnecting however long the server is away,
+	// while the buffer holds the output
+	connect := func(url, name string, extra ...nats.Option) (*nats.Conn, error) {
+		natsOpts, err := natsAuth.Options(name)
 		if err != nil {
-			log.Fatalf("Failed to subscribe: %v", err)
+			return nil, err
 		}
-
-		// Keep running
-		select {}
-	}
-
-	if sub != nil {
-		sub.Unsubscribe()
+		return nats.Connect(url, append(append(natsOpts, nats.MaxReconnects(-1)), extra...)...)
 	}
-}
-
-func startAPIServer(port string) {
-	http.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte("OK"))
-	})
-
-	http.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
-		globalStats.mu.RLock()
-		defer globalStats.mu.RUnlock()
 
-		// Display struct
-		type DisplayStats struct {
-			*EdgeStats
-			Mean float64 `json:"mean"`
-			Uptime string `json:"uptime"`
-		}
-
-		mean := 0.0
-		if globalStats.Count > 0 {
-			mean = globalStats.Sum / float64(globalStats.Count)
-		}
-
-		display := DisplayStats{
-			EdgeStats: globalStats,
-			Mean:      mean,
-			Uptime:    time.Since(globalStats.StartTime).String(),
-		}
-
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(display)
+	// Connect to the message transport
+	b, closeBroker, err := transport.Connect("edge-"+opts.ID, func() (*nats.Conn, error) {
+		return connect(*natsURL, "edge-"+opts.ID)
 	})
-
-	log.Printf("Starting HTTP API on port %s", port)
-	if err := http.ListenAndServe(":"+port, nil); err != nil {
-		log.Printf("HTTP Server failed: %v", err)
-	}
-}
-
-func processMessage(data []byte, stats *EdgeStats, nc *nats.Conn, edgeID string, thresholdMin, thresholdMax, noiseFilter float64) {
-	var reading SensorReading
-	if err := json.Unmarshal(data, &reading); err != nil {
-		log.Printf("Error unmarshaling reading: %v", err)
-		return
+	if err != nil {
+		log.Fatalf("Failed to connect to %s: %v", *transport.Transport, err)
 	}
+	defer closeBroker()
 
-	// Update statistics
-	stats.mu.Lock()
-	stats.Count++
-	stats.Sum += reading.Value
-	if reading.Value < stats.Min {
-		stats.Min = reading.Value
-	}
-	if reading.Value > stats.Max {
-		stats.Max = reading.Value
+	// Send the output to the hub over its own connection, which may start
+	// while the hub is away
+	if *uplinkURL != "" {
+		uplink, err := connect(*uplinkURL, "edge-"+opts.ID+"-uplink", nats.RetryOnFailedConnect(true))
+		if err != nil {
+			log.Fatalf("Failed to connect to the uplink %s: %v", *uplinkURL, err)
+		}
+		defer uplink.Close()
+		opts.Uplink = broker.NewNATS(uplink)
 	}
-	stats.WindowValues = append(stats.WindowValues, reading.Value)
-	if len(stats.WindowValues) > stats.WindowSize {
-		stats.WindowValues = stats.WindowValues[1:]
+	if *leafURL != "" {
+		local, ok := b.(*broker.NATS)
+		if !ok {
+			log.Fatalf("-leaf needs the NATS transport")
+		}
+		watch := embedded.WatchLeaf(ns)
+		defer watch.Stop()
+		opts.Uplink = &leafUplink{NATS: local, leaf: watch}
+		log.Printf("Forwarding %s to the hub at %s", *leafSubjects, *leafURL)
 	}
-	// mean := stats.Sum / float64(stats.Count) // Not used currently
-	stats.mu.Unlock()
-
-	// Calculate standard deviation for noise filtering (just for logging if needed)
-	/*
-	var stdDev float64
-	if len(stats.WindowValues) > 1 {
-		var variance float64
-		for _, v := range stats.WindowValues {
-			variance += (v - mean) * (v - mean)
+	// Output to a hub is buffered unless -buffer turns it off
+	buffer := *bufferDir
+	if !flagSet("buffer") && (*uplinkURL != "" || *leafURL != "") {
+		if buffer, err = defaultBufferDir(); err != nil {
+			log.Fatalf("No directory for the buffer, set -buffer: %v", err)
 		}
-		stdDev = math.Sqrt(variance / float64(len(stats.WindowValues)))
 	}
-
-	// Noise filtering disabled to allow drift detection
-	if stdDev > 0 && math.Abs(reading.Value-mean) > noiseFilter*stdDev {
-		log.Printf("Potential noise detected (kept): sensor_id=%s, value=%.2f, mean=%.2f, std=%.2f", 
-			reading.SensorID, reading.Value, mean, stdDev)
+	if buffer != "" {
+		opts.BufferDir = filepath.Join(buffer, opts.ID)
+		opts.BufferMaxBytes = *bufferMax
+		log.Printf("Buffering the output in %s", opts.BufferDir)
 	}
-	*/
 
-	// Create filtered reading
-	filtered := FilteredReading{
-		SensorID:  reading.SensorID,
-		Value:     reading.Value,
-		Timestamp: reading.Timestamp,
-		EdgeID:    edgeID,
+	// Bridge readings published by MQTT devices
+	if *mqttBridge != "" {
+		bridge, err := broker.DialMQTT(transport.MQTTOptions(opts.ID + "-bridge"))
+		if err != nil {
+			log.Fatalf("Failed to connect to the MQTT bridge: %v", err)
+		}
+		defer bridge.Close()
+		opts.Bridge, opts.BridgeTopic = bridge, *mqttBridge
 	}
 
-	filteredData, err := json.Marshal(filtered)
+	node, err := edge.New(b, opts)
 	if err != nil {
-		log.Printf("Error marshaling filtered reading: %v", err)
-		return
+		log.Fatalf("Invalid edge options: %v", err)
 	}
 
-	// Publish filtered reading
-	if err := nc.Publish("edge.filtered", filteredData); err != nil {
-		log.Printf("Error publishing filtered reading: %v", err)
-	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	// Check for threshold violations
-	// Critical range: < 0 or > 100 (Spikes)
-	// Warning range: < 40 or > 60 (Drift)
-	
-	alertType := ""
-	alertMsg := ""
-	
-	if reading.Value < 0 || reading.Value > 100 {
-		alertType = "critical"
-		alertMsg = "Critical value detected (Spike)"
-	} else if reading.Value < 40 || reading.Value > 60 {
-		alertType = "warning"
-		alertMsg = "Process drift detected (Warning)"
+	if err := node.Start(ctx); err != nil {
+		log.Fatalf("Failed to start edge node: %v", err)
 	}
+	defer node.Stop()
 
-	if alertType != "" {
-		alert := Alert{
-			SensorID:  reading.SensorID,
-			Value:     reading.Value,
-			Timestamp: reading.Timestamp,
-			EdgeID:    edgeID,
-			Type:      alertType,
-			Message:   alertMsg,
-		}
-
-		alertData, err := json.Marshal(alert)
-		if err != nil {
-			log.Printf("Error marshaling alert: %v", err)
-			return
-		}
-
-		if err := nc.Publish("edge.alerts", alertData); err != nil {
-			log.Printf("Error publishing alert: %v", err)
+	// Start HTTP Server once the node is running, as POST /ingest feeds it
+	go func() {
+		log.Printf("Starting HTTP API on port %s", *httpPort)
+		if err := httpAuth.ListenAndServe(":"+*httpPort, node.Handler()); err != nil {
+			log.Printf("HTTP Server failed: %v", err)
 		}
+	}()
 
-		log.Printf("Alert published [%s]: sensor_id=%s, value=%.2f, %s", alertType, reading.SensorID, reading.Value, alertMsg)
-	}
+	<-ctx.Done()
+	log.Printf("Shutting down edge node %s", opts.ID)
 }
 
-func (s *EdgeStats) publishAggregate(nc *nats.Conn, edgeID string) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+// flagSet reports whether the flag name was given on the command line.
+func flagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		set = set || f.Name == name
+	})
+	return set
+}
 
-	if s.Count == 0 {
-		return
+// defaultBufferDir is where the buffer goes when the edge sends to a hub
+// without -buffer: under the user cache directory, the same wherever the edge
+// is started from.
+func defaultBufferDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
 	}
+	return filepath.Join(dir, "sistemas_distribuidos_gb", "edge-buffer"), nil
+}
 
-	mean := s.Sum / float64(s.Count)
-	
-	aggregate := map[string]interface{}{
-		"edge_id":   edgeID,
-		"count":     s.Count,
-		"mean":      mean,
-		"min":       s.Min,
-		"max":       s.Max,
-		"timestamp": time.Now().Unix(),
-	}
+// leafUplink sends the output through the edge's own NATS server, confirming
+// it only while the server is connected to the hub as a leaf node, so that
+// the buffer keeps it while the link is down. What was published before a
+// drop of the link may not have crossed, so a flush after one fails, once,
+// and the buffer sends it again.
+type leafUplink struct {
+	*broker.NATS
+	leaf *embedded.LeafWatch
+
+	mu    sync.Mutex
+	drops int64 // of the link, at the last flush
+}
 
-	data, err := json.Marshal(aggregate)
-	if err != nil {
-		log.Printf("Error marshaling aggregate: %v", err)
-		return
+func (u *leafUplink) Flush(timeout time.Duration) error {
+	if !u.leaf.Connected() {
+		return errors.New("not connected to the hub")
 	}
-
-	// Publish aggregates on a dedicated subject to avoid mixing with per-reading stream
-	if err := nc.Publish("edge.aggregate", data); err != nil {
-		log.Printf("Error publishing aggregate: %v", err)
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if drops := u.leaf.Drops(); drops != u.drops {
+		u.drops = drops
+		return errors.New("the link to the hub dropped since the last flush")
 	}
-
-	log.Printf("Aggregate published: edge_id=%s, count=%d, mean=%.2f, min=%.2f, max=%.2f", 
-		edgeID, s.Count, mean, s.Min, s.Max)
-
-	// Reset stats
-	s.Count = 0
-	s.Sum = 0
-	s.Min = math.Inf(1)
-	s.Max = math.Inf(-1)
-	s.WindowValues = s.WindowValues[:0]
+	return u.NATS.Flush(timeout)
 }
 
+func (u *leafUplink) Unwrap() broker.Broker {
+	return u.NATS
+}
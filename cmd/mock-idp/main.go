@@ -0,0 +1,43 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"strings"
+
+	"sistemas_distribuidos_gb/internal/auth"
+)
+
+func main() {
+	var (
+		port         = flag.String("port", "9000", "Server port")
+		issuer       = flag.String("issuer", "", "Issuer URL, as the dashboard reaches it (default http://localhost:<port>)")
+		clientID     = flag.String("client-id", "dashboard", "Client ID of the dashboard")
+		clientSecret = flag.String("client-secret", "dashboard-secret", "Client secret of the dashboard")
+		users        = flag.String("users", "ana=admin,bruno=operator,carla=viewer", "Users to sign in as, name=role[+role...], comma separated")
+	)
+	flag.Parse()
+
+	if *issuer == "" {
+		*issuer = "http://localhost:" + *port
+	}
+	opts := auth.MockIdPOptions{Issuer: *issuer, ClientID: *clientID, ClientSecret: *clientSecret, Users: map[string][]string{}}
+	for _, u := range strings.Split(*users, ",") {
+		name, roles, _ := strings.Cut(strings.TrimSpace(u), "=")
+		if name == "" {
+			continue
+		}
+		opts.Users[name] = []string{}
+		if roles != "" {
+			opts.Users[name] = strings.Split(roles, "+")
+		}
+	}
+
+	idp, err := auth.NewMockIdP(opts)
+	if err != nil {
+		log.Fatalf("Failed to create the mock IdP: %v", err)
+	}
+	log.Printf("Mock OIDC provider %s with %d users, client %s", *issuer, len(opts.Users), *clientID)
+	log.Fatal(http.ListenAndServe(":"+*port, idp.Handler()))
+}
@@ -1,221 +1,112 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
 	"flag"
 	"log"
-	"math/rand"
-	"net/http"
-	"sync"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/nats-io/nats.go"
-)
-
-type SensorReading struct {
-	SensorID  string  `json:"sensor_id"`
-	Value     float64 `json:"value"`
-	Timestamp int64   `json:"timestamp"`
-}
-
-type SensorStatus struct {
-	mu            sync.RWMutex
-	SensorID      string    `json:"sensor_id"`
-	Status        string    `json:"status"`
-	LastReading   *SensorReading `json:"last_reading,omitempty"`
-	TotalReadings int64     `json:"total_readings"`
-	Uptime        time.Duration `json:"uptime"`
-	startTime     time.Time
-}
-
-// SimulationState maintains the state of drift simulation
-type SimulationState struct {
-	isDrifting    bool
-	driftDuration int
-	currentOffset float64
-	targetOffset  float64
-}
 
-var (
-	currentStatus *SensorStatus
-	simState      = &SimulationState{}
+	"sistemas_distribuidos_gb/internal/broker"
+	"sistemas_distribuidos_gb/internal/embedded"
+	"sistemas_distribuidos_gb/internal/secure"
+	"sistemas_distribuidos_gb/internal/sensor"
+	"sistemas_distribuidos_gb/internal/subjects"
 )
 
 func main() {
 	var (
 		sensorID      = flag.String("id", "", "Sensor ID (auto-generated if empty)")
+		site          = flag.String("site", subjects.DefaultSite, "Site the sensor is installed at")
+		line          = flag.String("line", subjects.DefaultLine, "Production line the sensor is installed at")
+		location      = flag.String("location", "", "Human readable location announced to the registry")
+		unit          = flag.String("unit", "", "Measurement unit announced to the registry")
+		owner         = flag.String("owner", "", "Owner announced to the registry")
 		natsURL       = flag.String("nats", "nats://localhost:4222", "NATS server URL")
 		interval      = flag.Duration("interval", 1*time.Second, "Publication interval")
 		baseValue     = flag.Float64("base", 50.0, "Base value for readings")
-		noiseLevel    = flag.Float64("noise", 2.0, "Noise level (std deviation)") // Reduced noise for stability
+		noiseLevel    = flag.Float64("noise", 2.0, "Noise level (std deviation)")    // Reduced noise for stability
 		anomalyChance = flag.Float64("anomaly", 0.005, "Probability of Drift (0-1)") // 0.5% chance (rare)
 		spikeChance   = flag.Float64("spike", 0.001, "Probability of Spike (0-1)")   // 0.1% chance (very rare)
 		httpPort      = flag.String("http-port", "8081", "HTTP API port")
+		useConfig     = flag.Bool("config", true, "Load hot-reloadable settings from the central config bucket")
+		sign          = flag.Bool("sign", true, "Sign readings with the sensor's NKey")
+		labels        = flag.Bool("labels", false, "Publish the simulator's ground-truth anomaly labels next to each reading")
+		keyFile       = flag.String("key", "", "NKey seed file used to sign readings, created if missing (default keys/<id>.nk)")
 	)
+	natsAuth := secure.RegisterNATSFlags(flag.CommandLine)
+	transport := broker.RegisterFlags(flag.CommandLine)
+	httpAuth := secure.RegisterHTTPFlags(flag.CommandLine)
+	embeddedNATS := embedded.RegisterFlags(flag.CommandLine, false)
 	flag.Parse()
 
+	ns, err := embeddedNATS.Start(*natsURL)
+	if err != nil {
+		log.Fatalf("Failed to start embedded NATS server: %v", err)
+	}
+	if ns != nil {
+		defer ns.Shutdown()
+		log.Printf("Embedded NATS server listening on %s", ns.ClientURL())
+	}
+
 	// Generate sensor ID if not provided
 	if *sensorID == "" {
 		*sensorID = "sensor-" + uuid.New().String()[:8]
 	}
 
-	// Initialize Status
-	currentStatus = &SensorStatus{
-		SensorID:  *sensorID,
-		Status:    "Starting",
-		startTime: time.Now(),
+	opts := sensor.Options{
+		ID:            *sensorID,
+		Site:          *site,
+		Line:          *line,
+		Location:      *location,
+		Unit:          *unit,
+		Owner:         *owner,
+		Interval:      *interval,
+		BaseValue:     *baseValue,
+		NoiseLevel:    *noiseLevel,
+		AnomalyChance: *anomalyChance,
+		SpikeChance:   *spikeChance,
+		UseConfig:     *useConfig,
+		Sign:          *sign,
+		KeyFile:       *keyFile,
+		Labels:        *labels,
 	}
 
-	// Start HTTP Server
-	go startAPIServer(*httpPort)
-
-	// Connect to NATS
-	nc, err := nats.Connect(*natsURL)
+	// Connect to the message transport
+	b, closeBroker, err := transport.Connect("sensor-"+*sensorID, func() (*nats.Conn, error) {
+		return natsAuth.Connect(*natsURL, "sensor-"+*sensorID)
+	})
 	if err != nil {
-		log.Fatalf("Failed to connect to NATS: %v", err)
-	}
-	defer nc.Close()
-
-	updateStatus("Running", nil)
-	log.Printf("Sensor %s started, publishing to sensors.readings every %v", *sensorID, *interval)
-
-	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
-	ticker := time.NewTicker(*interval)
-	defer ticker.Stop()
-
-	for range ticker.C {
-		value := generateValue(rng, *baseValue, *noiseLevel, *anomalyChance, *spikeChance)
-
-		reading := SensorReading{
-			SensorID:  *sensorID,
-			Value:     value,
-			Timestamp: time.Now().UnixMilli(), // use ms to enable precise latency
-		}
-
-		data, err := json.Marshal(reading)
-		if err != nil {
-			log.Printf("Error marshaling reading: %v", err)
-			continue
-		}
-
-		if err := nc.Publish("sensors.readings", data); err != nil {
-			log.Printf("Error publishing reading: %v", err)
-			updateStatus("Error Publishing", &reading)
-			continue
-		}
-
-		updateStatus("Running", &reading)
-		log.Printf("Published: sensor_id=%s, value=%.2f, timestamp=%d", reading.SensorID, reading.Value, reading.Timestamp)
-	}
-}
-
-func updateStatus(status string, reading *SensorReading) {
-	currentStatus.mu.Lock()
-	defer currentStatus.mu.Unlock()
-	
-	currentStatus.Status = status
-	if reading != nil {
-		currentStatus.LastReading = reading
-		currentStatus.TotalReadings++
+		log.Fatalf("Failed to connect to %s: %v", *transport.Transport, err)
 	}
-}
-
-func startAPIServer(port string) {
-	http.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte("OK"))
-	})
-
-	http.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
-		currentStatus.mu.RLock()
-		defer currentStatus.mu.RUnlock()
-
-		// Create a display struct to handle calculated fields
-		type DisplayStatus struct {
-			*SensorStatus
-			UptimeString string `json:"uptime_string"`
-		}
-		
-		display := DisplayStatus{
-			SensorStatus: currentStatus,
-			UptimeString: time.Since(currentStatus.startTime).String(),
-		}
+	defer closeBroker()
 
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(display)
-	})
-
-	log.Printf("Starting HTTP API on port %s", port)
-	if err := http.ListenAndServe(":"+port, nil); err != nil {
-		log.Printf("HTTP Server failed: %v", err)
+	s, err := sensor.New(b, opts)
+	if err != nil {
+		log.Fatalf("Failed to create sensor: %v", err)
 	}
-}
 
-func generateValue(rng *rand.Rand, base, noise, driftChance, spikeChance float64) float64 {
-	// 1. Manage Drift State (Gradual Transitions)
-	if simState.isDrifting {
-		simState.driftDuration--
-		
-		// Move currentOffset towards targetOffset (Approach Phase)
-		step := 0.5 // Slower transition for realism
-		if simState.currentOffset < simState.targetOffset {
-			simState.currentOffset += rng.Float64() * step
-			if simState.currentOffset > simState.targetOffset {
-				simState.currentOffset = simState.targetOffset
-			}
-		} else if simState.currentOffset > simState.targetOffset {
-			simState.currentOffset -= rng.Float64() * step
-			if simState.currentOffset < simState.targetOffset {
-				simState.currentOffset = simState.targetOffset
-			}
-		}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-		if simState.driftDuration <= 0 {
-			simState.isDrifting = false
-			log.Printf("End of Drift. Returning to normal.")
-		}
-	} else {
-		// Recovery Phase: Slowly return offset to 0
-		if simState.currentOffset != 0 {
-			approachSpeed := rng.Float64() * 0.5 // Slower recovery
-			if simState.currentOffset > 0 {
-				simState.currentOffset -= approachSpeed
-				if simState.currentOffset < 0 { simState.currentOffset = 0 }
-			} else {
-				simState.currentOffset += approachSpeed
-				if simState.currentOffset > 0 { simState.currentOffset = 0 }
-			}
+	// Start HTTP Server
+	go func() {
+		log.Printf("Starting HTTP API on port %s", *httpPort)
+		if err := httpAuth.ListenAndServe(":"+*httpPort, s.Handler()); err != nil {
+			log.Printf("HTTP Server failed: %v", err)
 		}
+	}()
 
-		// 2. Start new Drift?
-		if simState.currentOffset == 0 && rng.Float64() < driftChance {
-			simState.isDrifting = true
-			simState.driftDuration = rng.Intn(30) + 30 // Drift for 30-60 seconds
-			
-			// Target offset: +/- 35 (aiming for 15 or 85)
-			if rng.Float64() < 0.5 {
-				simState.targetOffset = -35.0 
-			} else {
-				simState.targetOffset = 35.0
-			}
-			log.Printf("Starting Drift! Target: %.2f", simState.targetOffset)
-		}
-	}
-
-	// 3. Spike Anomaly (Instantaneous)
-	// Add spike ON TOP of current state
-	spike := 0.0
-	if rng.Float64() < spikeChance {
-		if rng.Float64() < 0.5 {
-			spike = 60.0 + rng.Float64()*20.0 // +60 to +80
-		} else {
-			spike = -60.0 - rng.Float64()*20.0 // -60 to -80
-		}
-		log.Printf("Generating Spike! Value: %.2f", base + simState.currentOffset + spike)
+	if err := s.Start(ctx); err != nil {
+		log.Fatalf("Failed to start sensor: %v", err)
 	}
+	defer s.Stop()
 
-	// 4. Calculate Final Value
-	// Base + Gradual Offset + Spike + Noise
-	return base + simState.currentOffset + spike + rng.NormFloat64()*noise
+	<-ctx.Done()
+	log.Printf("Shutting down sensor %s", s.ID())
 }
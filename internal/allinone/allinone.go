@@ -0,0 +1,197 @@
+// Package allinone runs the whole pipeline in one process: the cloud
+// processor, the dashboard, N edge nodes and M simulated sensors, each with
+// its own NATS connection. It backs the all-in-one demo command and the
+// integration tests.
+package allinone
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path/filepath"
+
+	"github.com/nats-io/nats.go"
+
+	"sistemas_distribuidos_gb/internal/broker"
+	"sistemas_distribuidos_gb/internal/cloud"
+	"sistemas_distribuidos_gb/internal/dashboard"
+	"sistemas_distribuidos_gb/internal/edge"
+	"sistemas_distribuidos_gb/internal/sensor"
+	"sistemas_distribuidos_gb/internal/subjects"
+)
+
+// Options configure the pipeline. Edge and Sensor are templates: IDs, lines
+// and subjects are filled in for each instance.
+type Options struct {
+	Site    string
+	Edges   int
+	Sensors int
+	// KeyDir holds the sensors' signing keys (keys/ if empty).
+	KeyDir string
+
+	Cloud     cloud.Options
+	Dashboard dashboard.Options
+	Edge      edge.Options
+	Sensor    sensor.Options
+
+	// Connect opens a NATS connection for the named component.
+	Connect func(name string) (*nats.Conn, error)
+}
+
+// DefaultOptions returns a pipeline of 2 edges and 5 sensors with the
+// components' default options.
+func DefaultOptions() Options {
+	return Options{
+		Site:      subjects.DefaultSite,
+		Edges:     2,
+		Sensors:   5,
+		Cloud:     cloud.DefaultOptions(),
+		Dashboard: dashboard.DefaultOptions(),
+		Edge:      edge.DefaultOptions(),
+		Sensor:    sensor.DefaultOptions(),
+	}
+}
+
+// Line is the production line of edge i (0-based). Each edge owns one line.
+func Line(i int) string {
+	return fmt.Sprintf("line-%d", i+1)
+}
+
+// System is a running pipeline.
+type System struct {
+	Cloud     *cloud.Cloud
+	Dashboard *dashboard.Dashboard
+	Edges     []*edge.Edge
+	Sensors   []*sensor.Sensor
+
+	conns []*nats.Conn
+	stops []func()
+}
+
+// Start starts the cloud and dashboard, then the edges, then the sensors, so
+// that the registry is up when sensors announce themselves. Sensor i is
+// installed on the line of edge i mod Edges.
+func Start(ctx context.Context, opts Options) (*System, error) {
+	if opts.Edges < 1 || opts.Sensors < 0 {
+		return nil, fmt.Errorf("need at least one edge (got %d edges, %d sensors)", opts.Edges, opts.Sensors)
+	}
+	if opts.Connect == nil {
+		return nil, fmt.Errorf("no NATS connect function")
+	}
+	s := &System{}
+	if err := s.start(ctx, opts); err != nil {
+		s.Stop()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *System) start(ctx context.Context, opts Options) error {
+	site := subjects.Token(opts.Site, subjects.DefaultSite)
+
+	b, err := s.connect(opts, "cloud-"+opts.Cloud.ID)
+	if err != nil {
+		return err
+	}
+	if s.Cloud, err = cloud.New(b, opts.Cloud); err != nil {
+		return err
+	}
+	if err := s.Cloud.Start(ctx); err != nil {
+		return fmt.Errorf("start cloud: %w", err)
+	}
+	s.stops = append(s.stops, s.Cloud.Stop)
+
+	if b, err = s.connect(opts, "dashboard-"+opts.Dashboard.ID); err != nil {
+		return err
+	}
+	if s.Dashboard, err = dashboard.New(b, opts.Dashboard); err != nil {
+		return err
+	}
+	if err := s.Dashboard.Start(ctx); err != nil {
+		return fmt.Errorf("start dashboard: %w", err)
+	}
+	s.stops = append(s.stops, s.Dashboard.Stop)
+
+	for i := 0; i < opts.Edges; i++ {
+		eo := opts.Edge
+		eo.ID = fmt.Sprintf("edge-%d", i+1)
+		eo.Subjects = []string{subjects.LineReadings(site, Line(i))}
+		if b, err = s.connect(opts, "edge-"+eo.ID); err != nil {
+			return err
+		}
+		e, err := edge.New(b, eo)
+		if err != nil {
+			return err
+		}
+		if err := e.Start(ctx); err != nil {
+			return fmt.Errorf("start %s: %w", eo.ID, err)
+		}
+		s.Edges = append(s.Edges, e)
+		s.stops = append(s.stops, e.Stop)
+	}
+
+	keyDir := opts.KeyDir
+	if keyDir == "" {
+		keyDir = "keys"
+	}
+	for i := 0; i < opts.Sensors; i++ {
+		so := opts.Sensor
+		so.ID = fmt.Sprintf("sensor-%02d", i+1)
+		so.Site = site
+		so.Line = Line(i % opts.Edges)
+		so.KeyFile = filepath.Join(keyDir, so.ID+".nk")
+		if b, err = s.connect(opts, "sensor-"+so.ID); err != nil {
+			return err
+		}
+		sn, err := sensor.New(b, so)
+		if err != nil {
+			return err
+		}
+		if err := sn.Start(ctx); err != nil {
+			return fmt.Errorf("start %s: %w", so.ID, err)
+		}
+		s.Sensors = append(s.Sensors, sn)
+		s.stops = append(s.stops, sn.Stop)
+	}
+	return nil
+}
+
+func (s *System) connect(opts Options, name string) (*broker.NATS, error) {
+	nc, err := opts.Connect(name)
+	if err != nil {
+		return nil, fmt.Errorf("connect %s: %w", name, err)
+	}
+	s.conns = append(s.conns, nc)
+	return broker.NewNATS(nc), nil
+}
+
+// Stop stops the components in reverse start order and closes their
+// connections.
+func (s *System) Stop() {
+	for i := len(s.stops) - 1; i >= 0; i-- {
+		s.stops[i]()
+	}
+	s.stops = nil
+	for _, nc := range s.conns {
+		nc.Close()
+	}
+	s.conns = nil
+}
+
+// Handler serves every component's API on one port: the dashboard at /, the
+// cloud under /cloud/, edges under /edge/<id>/ and sensors under
+// /sensor/<id>/.
+func (s *System) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/", s.Dashboard.Handler())
+	mux.Handle("/cloud/", http.StripPrefix("/cloud", s.Cloud.Handler()))
+	for _, e := range s.Edges {
+		prefix := "/edge/" + e.ID()
+		mux.Handle(prefix+"/", http.StripPrefix(prefix, e.Handler()))
+	}
+	for _, sn := range s.Sensors {
+		prefix := "/sensor/" + sn.ID()
+		mux.Handle(prefix+"/", http.StripPrefix(prefix, sn.Handler()))
+	}
+	return mux
+}
@@ -0,0 +1,118 @@
+package allinone
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+
+	"sistemas_distribuidos_gb/internal/embedded"
+)
+
+// TestPipeline runs the whole pipeline on an embedded NATS server and checks
+// that readings of every line reach the cloud through their edge.
+func TestPipeline(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	dir := t.TempDir()
+	ns, err := embedded.Start(embedded.Options{JetStream: true, StoreDir: filepath.Join(dir, "jetstream")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ns.Shutdown()
+
+	opts := DefaultOptions()
+	opts.Edges = 2
+	opts.Sensors = 4
+	opts.KeyDir = filepath.Join(dir, "keys")
+	opts.Cloud.RegistryFile = filepath.Join(dir, "registry.json")
+	opts.Dashboard.HistoryDir = filepath.Join(dir, "history")
+	opts.Cloud.HistoryDir = filepath.Join(dir, "cloud-history")
+	opts.Sensor.Interval = 20 * time.Millisecond
+	opts.Connect = func(name string) (*nats.Conn, error) {
+		return nats.Connect(ns.ClientURL(), nats.Name(name))
+	}
+
+	system, err := Start(context.Background(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer system.Stop()
+
+	srv := httptest.NewServer(system.Handler())
+	defer srv.Close()
+
+	var stats struct {
+		TotalReadings int            `json:"total_readings"`
+		EdgeNodes     map[string]int `json:"edge_nodes"`
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		if err := fetchJSON(srv.URL+"/cloud/stats", &stats); err == nil &&
+			len(stats.EdgeNodes) == opts.Edges && stats.TotalReadings >= 50 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("cloud saw %d readings from edges %v, want readings from %d edges", stats.TotalReadings, stats.EdgeNodes, opts.Edges)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	// Every sensor announced itself, with its signing key
+	var sensors []struct {
+		ID        string `json:"id"`
+		PublicKey string `json:"public_key"`
+	}
+	getJSON(t, srv.URL+"/cloud/sensors", &sensors)
+	if len(sensors) != opts.Sensors {
+		t.Fatalf("registry has %d sensors, want %d", len(sensors), opts.Sensors)
+	}
+	for _, s := range sensors {
+		if s.PublicKey == "" {
+			t.Errorf("sensor %s registered without a public key", s.ID)
+		}
+	}
+
+	for _, path := range []string{"/api/data", "/edge/edge-1/metrics", "/sensor/sensor-01/status"} {
+		resp, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("GET %s: %s", path, resp.Status)
+		}
+	}
+}
+
+func getJSON(t *testing.T, url string, v any) {
+	t.Helper()
+	if err := fetchJSON(url, v); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func fetchJSON(url string, v any) error {
+	resp, err := http.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("GET %s: %w", url, err)
+	}
+	return nil
+}
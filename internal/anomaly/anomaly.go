@@ -0,0 +1,238 @@
+// Package anomaly implements adaptive per-sensor anomaly detectors. Unlike
+// fixed alert bands, each detector learns the normal behaviour of the sensor
+// it watches and flags readings that depart from it:
+//
+//	ewma      EWMA control chart, for sustained shifts of the mean
+//	cusum     two-sided CUSUM, for slow drift
+//	zscore    rolling z-score, for isolated spikes
+//	seasonal  per time-of-period baseline, for values unusual at that time
+//
+// Detectors hold per-sensor state and are not safe for concurrent use; keep
+// one set per sensor (see Config.New).
+package anomaly
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"sistemas_distribuidos_gb/internal/config"
+)
+
+// Detector names, as used in Config.Enabled and in alerts.
+const (
+	// Bands is the fixed threshold check. It is evaluated by the caller, which
+	// owns the per-sensor thresholds, but is enabled through the same list.
+	Bands    = "bands"
+	EWMA     = "ewma"
+	CUSUM    = "cusum"
+	ZScore   = "zscore"
+	Seasonal = "seasonal"
+)
+
+// Result is the outcome of feeding one value to a detector.
+type Result struct {
+	Detector string
+	// Anomaly is set when the value is outside what the detector considers normal.
+	Anomaly bool
+	// Score is the detector statistic, in units of its own threshold scale
+	// (standard deviations for ewma, zscore and seasonal; cumulative sum for cusum).
+	Score float64
+	// Baseline is the expected value the reading was compared against.
+	Baseline float64
+	// Kind describes the anomaly: "spike", "drift" or "seasonal".
+	Kind string
+}
+
+// Detector watches the values of a single sensor.
+type Detector interface {
+	Name() string
+	// Update feeds a value observed at t and reports whether it is anomalous.
+	// Anomalous values are not learned into the baseline, unless Relearn of
+	// them in a row show that the level changed for good.
+	Update(t time.Time, v float64) Result
+}
+
+// Config selects and tunes the detectors.
+type Config struct {
+	Enabled []string `json:"enabled"`
+	// Relearn is how many values in a row a detector may keep out of its
+	// baseline before taking them as the new normal; 0 never relearns.
+	Relearn  int            `json:"relearn"`
+	EWMA     EWMAConfig     `json:"ewma"`
+	CUSUM    CUSUMConfig    `json:"cusum"`
+	ZScore   ZScoreConfig   `json:"zscore"`
+	Seasonal SeasonalConfig `json:"seasonal"`
+}
+
+// EWMAConfig tunes the EWMA control chart.
+type EWMAConfig struct {
+	Lambda float64 `json:"lambda"` // weight of the newest value in the chart statistic
+	L      float64 `json:"l"`      // control limit width, in standard deviations of the statistic
+	Alpha  float64 `json:"alpha"`  // baseline learning rate
+	Warmup int     `json:"warmup"` // values learned before alerting
+}
+
+// CUSUMConfig tunes the CUSUM detector. K and H are in standard deviations.
+type CUSUMConfig struct {
+	K      float64 `json:"k"` // allowed slack per value
+	H      float64 `json:"h"` // decision threshold
+	Alpha  float64 `json:"alpha"`
+	Warmup int     `json:"warmup"`
+}
+
+// ZScoreConfig tunes the rolling z-score detector.
+type ZScoreConfig struct {
+	Window    int     `json:"window"`
+	Threshold float64 `json:"threshold"`
+	Warmup    int     `json:"warmup"`
+}
+
+// SeasonalConfig tunes the seasonal baseline: the period is split into
+// Buckets slots, each with its own learned mean and deviation.
+type SeasonalConfig struct {
+	Period    config.Duration `json:"period"`
+	Buckets   int             `json:"buckets"`
+	Threshold float64         `json:"threshold"`
+	Alpha     float64         `json:"alpha"`
+	Warmup    int             `json:"warmup"` // values per slot before alerting
+}
+
+// DefaultConfig returns detectors tuned for readings every few seconds with
+// a false alarm rate of a few per sensor and day. Only bands and cusum are
+// enabled: on the simulated drifts zscore and seasonal find almost nothing
+// (see evaluation.TestDetectionQuality), and the spikes they catch bands and
+// cusum catch too.
+func DefaultConfig() Config {
+	return Config{
+		Enabled: []string{Bands, CUSUM},
+		Relearn: 120,
+		EWMA:    EWMAConfig{Lambda: 0.2, L: 3.5, Alpha: 0.01, Warmup: 30},
+		CUSUM:   CUSUMConfig{K: 0.5, H: 8, Alpha: 0.01, Warmup: 30},
+		ZScore:  ZScoreConfig{Window: 60, Threshold: 4, Warmup: 20},
+		Seasonal: SeasonalConfig{
+			Period:    config.Duration(24 * time.Hour),
+			Buckets:   24,
+			Threshold: 4,
+			Alpha:     0.05,
+			Warmup:    10,
+		},
+	}
+}
+
+// Has reports whether the named detector is enabled.
+func (c Config) Has(name string) bool {
+	for _, n := range c.Enabled {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate checks detector names and parameters.
+func (c Config) Validate() error {
+	for _, n := range c.Enabled {
+		switch n {
+		case Bands, EWMA, CUSUM, ZScore, Seasonal:
+		default:
+			return fmt.Errorf("unknown detector %q", n)
+		}
+	}
+	switch {
+	case c.Relearn < 0:
+		return fmt.Errorf("relearn can't be negative")
+	case c.EWMA.Lambda <= 0 || c.EWMA.Lambda > 1:
+		return fmt.Errorf("ewma.lambda must be in (0, 1]")
+	case c.EWMA.L <= 0:
+		return fmt.Errorf("ewma.l must be positive")
+	case c.CUSUM.H <= 0 || c.CUSUM.K < 0:
+		return fmt.Errorf("cusum.h must be positive and cusum.k not negative")
+	case c.ZScore.Window < 2 || c.ZScore.Threshold <= 0:
+		return fmt.Errorf("zscore.window must be at least 2 and zscore.threshold positive")
+	case c.Seasonal.Period <= 0 || c.Seasonal.Buckets < 1 || c.Seasonal.Threshold <= 0:
+		return fmt.Errorf("seasonal.period, seasonal.buckets and seasonal.threshold must be positive")
+	}
+	for name, a := range map[string]float64{"ewma": c.EWMA.Alpha, "cusum": c.CUSUM.Alpha, "seasonal": c.Seasonal.Alpha} {
+		if a <= 0 || a > 1 {
+			return fmt.Errorf("%s.alpha must be in (0, 1]", name)
+		}
+	}
+	return nil
+}
+
+// New returns a fresh set of the enabled detectors for one sensor. Bands is
+// skipped, since the caller evaluates it.
+func (c Config) New() []Detector {
+	var ds []Detector
+	for _, n := range c.Enabled {
+		switch n {
+		case EWMA:
+			ds = append(ds, NewEWMA(c.EWMA, c.Relearn))
+		case CUSUM:
+			ds = append(ds, NewCUSUM(c.CUSUM, c.Relearn))
+		case ZScore:
+			ds = append(ds, NewZScore(c.ZScore, c.Relearn))
+		case Seasonal:
+			ds = append(ds, NewSeasonal(c.Seasonal, c.Relearn))
+		}
+	}
+	return ds
+}
+
+// baseline tracks an exponentially weighted mean and variance. While fewer
+// than 1/alpha values have been seen it uses the plain running average, so
+// the first values aren't dominated by the starting point.
+type baseline struct {
+	alpha          float64
+	n              int
+	mean, variance float64
+}
+
+func (b *baseline) add(v float64) {
+	b.n++
+	a := math.Max(b.alpha, 1/float64(b.n))
+	d := v - b.mean
+	b.mean += a * d
+	b.variance = (1 - a) * (b.variance + a*d*d)
+}
+
+// restart drops what was learned and learns vs instead.
+func (b *baseline) restart(vs []float64) {
+	*b = baseline{alpha: b.alpha}
+	for _, v := range vs {
+		b.add(v)
+	}
+}
+
+// relearner collects the values a detector keeps out of its baseline in a
+// row. Once there are limit of them the level has changed for good, and the
+// detector starts over from those values rather than alerting forever.
+type relearner struct {
+	limit  int
+	values []float64
+}
+
+// reject records a value kept out of the baseline and returns the values to
+// relearn from when the run is long enough, or nil.
+func (r *relearner) reject(v float64) []float64 {
+	if r.limit <= 0 {
+		return nil
+	}
+	r.values = append(r.values, v)
+	if len(r.values) < r.limit {
+		return nil
+	}
+	vs := r.values
+	r.values = nil
+	return vs
+}
+
+// learned records a value learned into the baseline, ending the run.
+func (r *relearner) learned() { r.values = r.values[:0] }
+
+// std returns the standard deviation, floored so that perfectly steady
+// signals don't divide by zero.
+func (b *baseline) std() float64 {
+	return math.Max(math.Sqrt(b.variance), 1e-6*math.Max(1, math.Abs(b.mean)))
+}
@@ -0,0 +1,125 @@
+package anomaly
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+)
+
+// signal feeds values to a detector, one per second, and records which of
+// them it flagged.
+type signal struct {
+	d   Detector
+	rng *rand.Rand
+	t   time.Time
+}
+
+func newSignal(d Detector) *signal {
+	return &signal{d: d, rng: rand.New(rand.NewSource(1)), t: time.Date(2024, 1, 1, 8, 0, 0, 0, time.UTC)}
+}
+
+// feed sends n noisy values around level(i) and returns the indexes of the
+// anomalous ones.
+func (s *signal) feed(n int, level func(i int) float64) []int {
+	var flagged []int
+	for i := 0; i < n; i++ {
+		if s.d.Update(s.t, level(i)+s.rng.NormFloat64()*2).Anomaly {
+			flagged = append(flagged, i)
+		}
+		s.t = s.t.Add(time.Second)
+	}
+	return flagged
+}
+
+func steady(v float64) func(int) float64 { return func(int) float64 { return v } }
+
+func detectors(relearn int) []Detector {
+	c := DefaultConfig()
+	c.Enabled = []string{EWMA, CUSUM, ZScore, Seasonal}
+	c.Relearn = relearn
+	return c.New()
+}
+
+// TestQuietSignal bounds false alarms on plain noise to 0.1% of the values.
+func TestQuietSignal(t *testing.T) {
+	for _, d := range detectors(0) {
+		if flagged := newSignal(d).feed(3000, steady(50)); len(flagged) > 3 {
+			t.Errorf("%s: %d false alarms on a quiet signal", d.Name(), len(flagged))
+		}
+	}
+}
+
+func TestSpike(t *testing.T) {
+	for _, d := range detectors(0) {
+		s := newSignal(d)
+		s.feed(200, steady(50))
+		if r := d.Update(s.t, 80); !r.Anomaly || r.Kind == "" {
+			t.Errorf("%s: spike not flagged: %+v", d.Name(), r)
+		}
+	}
+}
+
+// TestDrift ramps the level up by 0.25 a second, as the simulator does; the
+// drift detectors must notice within a minute.
+func TestDrift(t *testing.T) {
+	for _, d := range detectors(0) {
+		if d.Name() != EWMA && d.Name() != CUSUM {
+			continue
+		}
+		s := newSignal(d)
+		s.feed(200, steady(50))
+		flagged := s.feed(140, func(i int) float64 { return 50 + 0.25*float64(i) })
+		if len(flagged) == 0 || flagged[0] > 60 {
+			t.Errorf("%s: drift flagged at %v, want within 60 values", d.Name(), flagged)
+		}
+	}
+}
+
+// TestStepChange moves the level for good: the detectors alert, relearn it
+// after Relearn values in a row, and then flag spikes from the new level.
+func TestStepChange(t *testing.T) {
+	const relearn = 50
+	for _, d := range detectors(relearn) {
+		s := newSignal(d)
+		s.feed(200, steady(50))
+		flagged := s.feed(relearn+300, steady(70))
+		if len(flagged) == 0 || flagged[0] > 30 {
+			t.Errorf("%s: step flagged at %v", d.Name(), flagged)
+			continue
+		}
+		if last := flagged[len(flagged)-1]; last > relearn+30 {
+			t.Errorf("%s: still flagging the new level at value %d", d.Name(), last)
+		}
+		if r := d.Update(s.t, 100); !r.Anomaly {
+			t.Errorf("%s: spike over the new level not flagged: %+v", d.Name(), r)
+		}
+	}
+
+	// Without relearning the new level is anomalous for good
+	for _, d := range detectors(0) {
+		if d.Name() == CUSUM {
+			continue // alarms, restarts its sums and alarms again, but less often
+		}
+		s := newSignal(d)
+		s.feed(200, steady(50))
+		if flagged := s.feed(300, steady(70)); len(flagged) < 290 {
+			t.Errorf("%s: %d of 300 values flagged without relearning", d.Name(), len(flagged))
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if err := DefaultConfig().Validate(); err != nil {
+		t.Errorf("default config: %v", err)
+	}
+	c := DefaultConfig()
+	c.Relearn = -1
+	if c.Validate() == nil {
+		t.Error("negative relearn accepted")
+	}
+	c = DefaultConfig()
+	c.Enabled = []string{"magic"}
+	if c.Validate() == nil {
+		t.Error("unknown detector accepted")
+	}
+}
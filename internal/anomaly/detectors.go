@@ -0,0 +1,200 @@
+package anomaly
+
+import (
+	"math"
+	"time"
+)
+
+// ewmaDetector is an EWMA control chart: the smoothed statistic
+// z = lambda*v + (1-lambda)*z is compared against the learned mean, with
+// limits at L standard deviations of z.
+type ewmaDetector struct {
+	cfg     EWMAConfig
+	base    baseline
+	z       float64
+	relearn relearner
+}
+
+// NewEWMA returns an EWMA control chart detector that relearns after relearn
+// anomalous values in a row (never if 0).
+func NewEWMA(cfg EWMAConfig, relearn int) Detector {
+	return &ewmaDetector{cfg: cfg, base: baseline{alpha: cfg.Alpha}, relearn: relearner{limit: relearn}}
+}
+
+func (d *ewmaDetector) Name() string { return EWMA }
+
+func (d *ewmaDetector) Update(_ time.Time, v float64) Result {
+	if d.base.n < d.cfg.Warmup {
+		d.base.add(v)
+		d.z = d.base.mean
+		return Result{Detector: EWMA, Baseline: d.base.mean}
+	}
+
+	l := d.cfg.Lambda
+	d.z = l*v + (1-l)*d.z
+	sigma := d.base.std() * math.Sqrt(l/(2-l))
+	r := Result{
+		Detector: EWMA,
+		Score:    math.Abs(d.z-d.base.mean) / sigma,
+		Baseline: d.base.mean,
+		Kind:     "drift",
+	}
+	r.Anomaly = r.Score > d.cfg.L
+	if !r.Anomaly {
+		d.base.add(v)
+		d.relearn.learned()
+	} else if vs := d.relearn.reject(v); vs != nil {
+		d.base.restart(vs)
+		d.z = d.base.mean
+	}
+	return r
+}
+
+// cusumDetector accumulates standardized deviations above and below the
+// learned mean, minus a slack K, and alarms when either sum exceeds H. Both
+// sums restart after an alarm. The baseline is frozen while a sum is past
+// half the threshold, so a slow drift isn't learned as the new normal, until
+// it has been frozen for relearn values in a row.
+type cusumDetector struct {
+	cfg     CUSUMConfig
+	base    baseline
+	hi, lo  float64
+	relearn relearner
+}
+
+// NewCUSUM returns a two-sided CUSUM detector that relearns after relearn
+// values in a row kept out of its baseline (never if 0).
+func NewCUSUM(cfg CUSUMConfig, relearn int) Detector {
+	return &cusumDetector{cfg: cfg, base: baseline{alpha: cfg.Alpha}, relearn: relearner{limit: relearn}}
+}
+
+func (d *cusumDetector) Name() string { return CUSUM }
+
+func (d *cusumDetector) Update(_ time.Time, v float64) Result {
+	if d.base.n < d.cfg.Warmup {
+		d.base.add(v)
+		return Result{Detector: CUSUM, Baseline: d.base.mean}
+	}
+
+	z := (v - d.base.mean) / d.base.std()
+	d.hi = math.Max(0, d.hi+z-d.cfg.K)
+	d.lo = math.Max(0, d.lo-z-d.cfg.K)
+	r := Result{
+		Detector: CUSUM,
+		Score:    math.Max(d.hi, d.lo),
+		Baseline: d.base.mean,
+		Kind:     "drift",
+	}
+	r.Anomaly = r.Score > d.cfg.H
+	if r.Anomaly {
+		d.hi, d.lo = 0, 0
+	}
+	if !r.Anomaly && r.Score <= d.cfg.H/2 {
+		d.base.add(v)
+		d.relearn.learned()
+	} else if vs := d.relearn.reject(v); vs != nil {
+		d.base.restart(vs)
+		d.hi, d.lo = 0, 0
+	}
+	return r
+}
+
+// zscoreDetector compares each value with the mean and deviation of the
+// last Window normal values.
+type zscoreDetector struct {
+	cfg     ZScoreConfig
+	window  []float64
+	next    int
+	relearn relearner
+}
+
+// NewZScore returns a rolling z-score detector that relearns after relearn
+// anomalous values in a row (never if 0).
+func NewZScore(cfg ZScoreConfig, relearn int) Detector {
+	return &zscoreDetector{cfg: cfg, window: make([]float64, 0, cfg.Window), relearn: relearner{limit: relearn}}
+}
+
+func (d *zscoreDetector) Name() string { return ZScore }
+
+func (d *zscoreDetector) Update(_ time.Time, v float64) Result {
+	var mean, variance float64
+	for _, x := range d.window {
+		mean += x
+	}
+	if len(d.window) > 0 {
+		mean /= float64(len(d.window))
+	}
+	for _, x := range d.window {
+		variance += (x - mean) * (x - mean)
+	}
+	if len(d.window) > 1 {
+		variance /= float64(len(d.window) - 1)
+	}
+
+	r := Result{Detector: ZScore, Baseline: mean, Kind: "spike"}
+	if len(d.window) >= max(d.cfg.Warmup, 2) {
+		std := math.Max(math.Sqrt(variance), 1e-6*math.Max(1, math.Abs(mean)))
+		r.Score = math.Abs(v-mean) / std
+		r.Anomaly = r.Score > d.cfg.Threshold
+	}
+	if !r.Anomaly {
+		d.push(v)
+		d.relearn.learned()
+	} else if vs := d.relearn.reject(v); vs != nil {
+		d.window, d.next = d.window[:0], 0
+		for _, x := range vs {
+			d.push(x)
+		}
+	}
+	return r
+}
+
+func (d *zscoreDetector) push(v float64) {
+	if len(d.window) < d.cfg.Window {
+		d.window = append(d.window, v)
+		return
+	}
+	d.window[d.next] = v
+	d.next = (d.next + 1) % d.cfg.Window
+}
+
+// seasonalDetector keeps a separate baseline for each slot of the period
+// (by default each hour of the day) and scores values against the baseline
+// of the slot they fall in. Each slot relearns a level change on its own, as
+// the period comes round to it.
+type seasonalDetector struct {
+	cfg     SeasonalConfig
+	slots   []baseline
+	relearn relearner
+}
+
+// NewSeasonal returns a seasonal baseline detector that relearns the current
+// slot after relearn anomalous values in a row (never if 0).
+func NewSeasonal(cfg SeasonalConfig, relearn int) Detector {
+	slots := make([]baseline, cfg.Buckets)
+	for i := range slots {
+		slots[i].alpha = cfg.Alpha
+	}
+	return &seasonalDetector{cfg: cfg, slots: slots, relearn: relearner{limit: relearn}}
+}
+
+func (d *seasonalDetector) Name() string { return Seasonal }
+
+func (d *seasonalDetector) Update(t time.Time, v float64) Result {
+	period := d.cfg.Period.Std()
+	offset := time.Duration(t.UnixNano() % int64(period))
+	slot := &d.slots[int(offset*time.Duration(len(d.slots))/period)%len(d.slots)]
+
+	r := Result{Detector: Seasonal, Baseline: slot.mean, Kind: "seasonal"}
+	if slot.n >= d.cfg.Warmup {
+		r.Score = math.Abs(v-slot.mean) / slot.std()
+		r.Anomaly = r.Score > d.cfg.Threshold
+	}
+	if !r.Anomaly {
+		slot.add(v)
+		d.relearn.learned()
+	} else if vs := d.relearn.reject(v); vs != nil {
+		slot.restart(vs)
+	}
+	return r
+}
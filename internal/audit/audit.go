@@ -0,0 +1,136 @@
+// Package audit keeps the log of who changed what: settings edited from the
+// dashboard and other operator actions. Entries are appended to a JSON lines
+// file, and the most recent ones are kept in memory for the UI.
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"sistemas_distribuidos_gb/internal/clock"
+)
+
+// DefaultSize is how many entries Entries returns by default.
+const DefaultSize = 500
+
+// Entry is a line of the audit log.
+type Entry struct {
+	Time   time.Time       `json:"time"`
+	Actor  string          `json:"actor"`  // user name, or the client address when there's none
+	Action string          `json:"action"` // what was done, e.g. "sensor.thresholds"
+	Target string          `json:"target"` // what it was done to: a sensor ID, a config key
+	Before json.RawMessage `json:"before,omitempty"`
+	After  json.RawMessage `json:"after,omitempty"`
+}
+
+// Log is an audit log.
+type Log struct {
+	file  string
+	size  int
+	clock clock.Clock
+
+	mu      sync.Mutex
+	f       *os.File // opened on the first entry
+	entries []Entry
+}
+
+// Open returns the log kept in file, loading its last size entries. The file
+// and its directory are created on the first entry; with an empty file the
+// log is only kept in memory. A nil clock uses the wall clock.
+func Open(file string, size int, clk clock.Clock) (*Log, error) {
+	if size <= 0 {
+		size = DefaultSize
+	}
+	l := &Log{file: file, size: size, clock: clock.Or(clk)}
+	if file == "" {
+		return l, nil
+	}
+	f, err := os.Open(file)
+	if os.IsNotExist(err) {
+		return l, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("open audit log: %w", err)
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	for scanner.Scan() {
+		var e Entry
+		if json.Unmarshal(scanner.Bytes(), &e) != nil {
+			continue // a line cut short by a crash
+		}
+		l.append(e)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read audit log: %w", err)
+	}
+	return l, nil
+}
+
+func (l *Log) append(e Entry) {
+	l.entries = append(l.entries, e)
+	if len(l.entries) > l.size {
+		l.entries = l.entries[len(l.entries)-l.size:]
+	}
+}
+
+// Record adds an entry, stamping it with the current time if it has none.
+func (l *Log) Record(e Entry) error {
+	if e.Time.IsZero() {
+		e.Time = l.clock.Now()
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.append(e)
+	if l.file == "" {
+		return nil
+	}
+	if l.f == nil {
+		if err := os.MkdirAll(filepath.Dir(l.file), 0o755); err != nil {
+			return fmt.Errorf("create audit log directory: %w", err)
+		}
+		f, err := os.OpenFile(l.file, os.O_CREATE|os.O_APPEND|os.O_RDWR, 0o644)
+		if err != nil {
+			return fmt.Errorf("open audit log: %w", err)
+		}
+		// Don't glue the entry to a line cut short by a crash
+		last := make([]byte, 1)
+		if info, err := f.Stat(); err == nil && info.Size() > 0 {
+			if _, err := f.ReadAt(last, info.Size()-1); err == nil && last[0] != '\n' {
+				f.Write([]byte{'\n'})
+			}
+		}
+		l.f = f
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = l.f.Write(append(data, '\n'))
+	return err
+}
+
+// Entries returns the most recent entries, oldest first.
+func (l *Log) Entries() []Entry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]Entry(nil), l.entries...)
+}
+
+// Close closes the file.
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f == nil {
+		return nil
+	}
+	err := l.f.Close()
+	l.f = nil
+	return err
+}
@@ -0,0 +1,57 @@
+package audit
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"sistemas_distribuidos_gb/internal/clock"
+)
+
+// TestLog checks that entries are stamped, kept up to the size and loaded
+// back from the file.
+func TestLog(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "logs", "audit.jsonl")
+	clk := clock.NewFake(time.Date(2024, 1, 1, 8, 0, 0, 0, time.UTC))
+	l, err := Open(file, 2, clk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(file); !os.IsNotExist(err) {
+		t.Errorf("file created before the first entry: %v", err)
+	}
+	for _, target := range []string{"s1", "s2", "s3"} {
+		err := l.Record(Entry{Actor: "ana", Action: "sensor.thresholds", Target: target, After: json.RawMessage(`{"warning_max":60}`)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		clk.Advance(time.Minute)
+	}
+	entries := l.Entries()
+	if len(entries) != 2 || entries[0].Target != "s2" || !entries[1].Time.Equal(clk.Now().Add(-time.Minute)) {
+		t.Errorf("entries %+v, want s2 and s3", entries)
+	}
+	l.Close()
+
+	// A line cut short is skipped
+	f, _ := os.OpenFile(file, os.O_APPEND|os.O_WRONLY, 0)
+	f.WriteString(`{"time": "2024-01`)
+	f.Close()
+
+	l, err = Open(file, 10, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	entries = l.Entries()
+	if len(entries) != 3 || entries[2].Target != "s3" || string(entries[2].After) != `{"warning_max":60}` {
+		t.Errorf("reopened log %+v", entries)
+	}
+	l.Record(Entry{Actor: "ana", Action: "sensor.thresholds", Target: "s4"})
+	l.Close()
+	if l, _ = Open(file, 10, nil); len(l.Entries()) != 4 {
+		t.Errorf("entry after a cut line lost: %+v", l.Entries())
+	}
+}
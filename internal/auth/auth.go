@@ -0,0 +1,323 @@
+// Package auth signs users in to the dashboard, with local accounts (bcrypt
+// password hashes in a JSON file) or OpenID Connect, and checks what their
+// role allows. Sessions live in memory, behind an HttpOnly cookie; requests
+// that change something must also carry the session's CSRF token.
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"sistemas_distribuidos_gb/internal/clock"
+)
+
+// Role is what a user may do. Each role can do what the ones before it can.
+type Role string
+
+const (
+	Viewer   Role = "viewer"   // sees the dashboard
+	Operator Role = "operator" // acknowledges alerts
+	Admin    Role = "admin"    // edits the configuration
+)
+
+var roleRank = map[Role]int{Viewer: 1, Operator: 2, Admin: 3}
+
+// Valid reports whether r is one of the roles.
+func (r Role) Valid() bool { return roleRank[r] > 0 }
+
+// Allows reports whether r may do what needs the role required.
+func (r Role) Allows(required Role) bool { return r.Valid() && roleRank[r] >= roleRank[required] }
+
+// User is a local account.
+type User struct {
+	Name         string `json:"name"`
+	Role         Role   `json:"role"`
+	PasswordHash string `json:"password_hash"` // bcrypt, from HashPassword
+}
+
+// ErrInvalidCredentials is returned by Login for an unknown user or a wrong
+// password, without telling which.
+var ErrInvalidCredentials = errors.New("invalid user or password")
+
+// HashPassword returns the bcrypt hash of password, for a users file.
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return string(hash), err
+}
+
+// LoadUsers reads a users file: {"users": [{"name", "role", "password_hash"}]}.
+func LoadUsers(path string) (map[string]User, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read users: %w", err)
+	}
+	var file struct {
+		Users []User `json:"users"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("parse users %s: %w", path, err)
+	}
+	users := make(map[string]User, len(file.Users))
+	for _, u := range file.Users {
+		if u.Name == "" {
+			return nil, fmt.Errorf("users %s: user without a name", path)
+		}
+		if _, dup := users[u.Name]; dup {
+			return nil, fmt.Errorf("users %s: duplicate user %q", path, u.Name)
+		}
+		if !u.Role.Valid() {
+			return nil, fmt.Errorf("users %s: user %q has unknown role %q", path, u.Name, u.Role)
+		}
+		if _, err := bcrypt.Cost([]byte(u.PasswordHash)); err != nil {
+			return nil, fmt.Errorf("users %s: user %q: password_hash isn't a bcrypt hash", path, u.Name)
+		}
+		users[u.Name] = u
+	}
+	return users, nil
+}
+
+// Session is a signed-in user.
+type Session struct {
+	ID      string
+	User    string
+	Role    Role
+	CSRF    string // sent back in the X-CSRF-Token header by the pages
+	Expires time.Time
+}
+
+// Options configure an Auth.
+type Options struct {
+	Users      map[string]User // local accounts
+	OIDC       *OIDCConfig     // sign in with an OpenID Connect provider too, if set
+	SessionTTL time.Duration   // how long a session lasts (DefaultSessionTTL if zero)
+	// SecureCookies marks the cookies Secure, for dashboards served over TLS
+	SecureCookies bool
+	Clock         clock.Clock
+}
+
+// DefaultSessionTTL is how long sessions last by default.
+const DefaultSessionTTL = 12 * time.Hour
+
+const (
+	sessionCookie = "session"
+	csrfCookie    = "csrf" // readable by the pages, which echo it in CSRFHeader
+	// CSRFHeader carries the CSRF token of requests that change something.
+	CSRFHeader = "X-CSRF-Token"
+	// CSRFField carries the CSRF token of HTML forms.
+	CSRFField = "csrf"
+)
+
+// Auth keeps the users and their sessions.
+type Auth struct {
+	opts  Options
+	clock clock.Clock
+	oidc  *oidcClient
+
+	mu       sync.Mutex
+	sessions map[string]*Session
+}
+
+// New returns an Auth for opts. It needs users, an OIDC provider, or both.
+func New(opts Options) (*Auth, error) {
+	if len(opts.Users) == 0 && opts.OIDC == nil {
+		return nil, errors.New("auth needs local users or an OIDC provider")
+	}
+	if opts.SessionTTL <= 0 {
+		opts.SessionTTL = DefaultSessionTTL
+	}
+	a := &Auth{opts: opts, clock: clock.Or(opts.Clock), sessions: make(map[string]*Session)}
+	if opts.OIDC != nil {
+		var err error
+		if a.oidc, err = newOIDCClient(*opts.OIDC, a.clock); err != nil {
+			return nil, err
+		}
+	}
+	return a, nil
+}
+
+// HasOIDC reports whether users can sign in with the OIDC provider.
+func (a *Auth) HasOIDC() bool { return a.oidc != nil }
+
+// dummyHash is compared against when the user doesn't exist, so unknown
+// users take as long to reject as wrong passwords.
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("dummy"), bcrypt.DefaultCost)
+
+// Login checks the password of a local user.
+func (a *Auth) Login(name, password string) (User, error) {
+	u, ok := a.opts.Users[name]
+	hash := dummyHash
+	if ok {
+		hash = []byte(u.PasswordHash)
+	}
+	if bcrypt.CompareHashAndPassword(hash, []byte(password)) != nil || !ok {
+		return User{}, ErrInvalidCredentials
+	}
+	return u, nil
+}
+
+// StartSession signs user in with role on the browser of w, replacing the
+// session it had, if any.
+func (a *Auth) StartSession(w http.ResponseWriter, r *http.Request, user string, role Role) *Session {
+	now := a.clock.Now()
+	s := &Session{ID: randomToken(), User: user, Role: role, CSRF: randomToken(), Expires: now.Add(a.opts.SessionTTL)}
+
+	a.mu.Lock()
+	if c, err := r.Cookie(sessionCookie); err == nil {
+		delete(a.sessions, c.Value)
+	}
+	for id, old := range a.sessions {
+		if !now.Before(old.Expires) {
+			delete(a.sessions, id)
+		}
+	}
+	a.sessions[s.ID] = s
+	a.mu.Unlock()
+
+	a.setCookie(w, sessionCookie, s.ID, s.Expires, true)
+	a.setCookie(w, csrfCookie, s.CSRF, s.Expires, false)
+	return s
+}
+
+// EndSession signs the browser of r out.
+func (a *Auth) EndSession(w http.ResponseWriter, r *http.Request) {
+	if c, err := r.Cookie(sessionCookie); err == nil {
+		a.mu.Lock()
+		delete(a.sessions, c.Value)
+		a.mu.Unlock()
+	}
+	a.setCookie(w, sessionCookie, "", time.Unix(0, 0), true)
+	a.setCookie(w, csrfCookie, "", time.Unix(0, 0), false)
+}
+
+// Session returns the live session of the browser of r, nil if there's none.
+func (a *Auth) Session(r *http.Request) *Session {
+	c, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	s, ok := a.sessions[c.Value]
+	if !ok {
+		return nil
+	}
+	if !a.clock.Now().Before(s.Expires) {
+		delete(a.sessions, c.Value)
+		return nil
+	}
+	return s
+}
+
+// FormToken returns the CSRF token for a form shown before sign in, the
+// login form, setting its cookie if the browser has none.
+func (a *Auth) FormToken(w http.ResponseWriter, r *http.Request) string {
+	if c, err := r.Cookie(csrfCookie); err == nil && c.Value != "" {
+		return c.Value
+	}
+	token := randomToken()
+	a.setCookie(w, csrfCookie, token, time.Time{}, false)
+	return token
+}
+
+// CheckFormToken reports whether the form posted in r carries the CSRF
+// token of its cookie.
+func CheckFormToken(r *http.Request) bool {
+	c, err := r.Cookie(csrfCookie)
+	return err == nil && c.Value != "" && equal(r.PostFormValue(CSRFField), c.Value)
+}
+
+// safeMethod reports whether requests with method change nothing.
+func safeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// Require serves h to sessions whose role allows role. Without a session,
+// pages redirect to /login and the API (/api/) answers 401; with a role
+// that falls short, 403. Requests other than GET, HEAD and OPTIONS must
+// carry the session's CSRF token, in CSRFHeader or in the CSRFField form
+// field. The session goes in the request context (see FromContext).
+func (a *Auth) Require(role Role, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s := a.Session(r)
+		if s == nil {
+			if strings.HasPrefix(r.URL.Path, "/api/") {
+				http.Error(w, "not signed in", http.StatusUnauthorized)
+				return
+			}
+			http.Redirect(w, r, "/login?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusSeeOther)
+			return
+		}
+		if !s.Role.Allows(role) {
+			http.Error(w, fmt.Sprintf("forbidden: needs the %s role", role), http.StatusForbidden)
+			return
+		}
+		if !safeMethod(r.Method) {
+			token := r.Header.Get(CSRFHeader)
+			if token == "" {
+				token = r.PostFormValue(CSRFField)
+			}
+			if !equal(token, s.CSRF) {
+				http.Error(w, "missing or wrong CSRF token", http.StatusForbidden)
+				return
+			}
+		}
+		h.ServeHTTP(w, r.WithContext(NewContext(r.Context(), s)))
+	})
+}
+
+type contextKey struct{}
+
+// NewContext returns ctx carrying s.
+func NewContext(ctx context.Context, s *Session) context.Context {
+	return context.WithValue(ctx, contextKey{}, s)
+}
+
+// FromContext returns the session put in ctx by Require, nil if there's none.
+func FromContext(ctx context.Context) *Session {
+	s, _ := ctx.Value(contextKey{}).(*Session)
+	return s
+}
+
+func (a *Auth) setCookie(w http.ResponseWriter, name, value string, expires time.Time, httpOnly bool) {
+	c := &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		HttpOnly: httpOnly,
+		Secure:   a.opts.SecureCookies,
+		SameSite: http.SameSiteLaxMode,
+	}
+	if !expires.IsZero() {
+		c.Expires = expires
+		if expires.Unix() <= 0 {
+			c.MaxAge = -1
+		}
+	}
+	http.SetCookie(w, c)
+}
+
+// randomToken returns 32 random bytes, base64url encoded.
+func randomToken() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func equal(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
@@ -0,0 +1,208 @@
+package auth
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"sistemas_distribuidos_gb/internal/clock"
+)
+
+func writeUsers(t *testing.T, users ...User) string {
+	t.Helper()
+	var lines []string
+	for _, u := range users {
+		lines = append(lines, fmt.Sprintf(`{"name": %q, "role": %q, "password_hash": %q}`, u.Name, u.Role, u.PasswordHash))
+	}
+	path := filepath.Join(t.TempDir(), "users.json")
+	if err := os.WriteFile(path, []byte(`{"users": [`+strings.Join(lines, ",")+`]}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLogin(t *testing.T) {
+	hash, err := HashPassword("s3cret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, bad := range []User{
+		{Name: "ana", Role: "root", PasswordHash: hash},
+		{Name: "ana", Role: Admin, PasswordHash: "s3cret"},
+		{Role: Admin, PasswordHash: hash},
+	} {
+		if _, err := LoadUsers(writeUsers(t, bad)); err == nil {
+			t.Errorf("LoadUsers accepted %+v", bad)
+		}
+	}
+
+	users, err := LoadUsers(writeUsers(t, User{Name: "ana", Role: Admin, PasswordHash: hash}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := New(Options{Users: users})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u, err := a.Login("ana", "s3cret"); err != nil || u.Role != Admin {
+		t.Errorf("Login(ana) = %+v, %v", u, err)
+	}
+	for _, c := range [][2]string{{"ana", "wrong"}, {"bruno", "s3cret"}} {
+		if _, err := a.Login(c[0], c[1]); err != ErrInvalidCredentials {
+			t.Errorf("Login(%s, %s) = %v, want ErrInvalidCredentials", c[0], c[1], err)
+		}
+	}
+}
+
+// TestRequire checks roles, CSRF tokens and session expiry.
+func TestRequire(t *testing.T) {
+	clk := clock.NewFake(time.Date(2024, 1, 1, 8, 0, 0, 0, time.UTC))
+	a, err := New(Options{Users: map[string]User{"x": {}}, SessionTTL: time.Hour, Clock: clk})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, FromContext(r.Context()).User)
+	})
+	mux := http.NewServeMux()
+	mux.Handle("/page", a.Require(Viewer, ok))
+	mux.Handle("/api/ack", a.Require(Operator, ok))
+	mux.Handle("/api/settings", a.Require(Admin, ok))
+
+	// Sessions are started on a recorder and their cookies copied over
+	signIn := func(user string, role Role) (*Session, []*http.Cookie) {
+		rec := httptest.NewRecorder()
+		s := a.StartSession(rec, httptest.NewRequest("POST", "/login", nil), user, role)
+		return s, rec.Result().Cookies()
+	}
+	call := func(method, path string, cookies []*http.Cookie, csrf string) *http.Response {
+		req := httptest.NewRequest(method, path, nil)
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		if csrf != "" {
+			req.Header.Set(CSRFHeader, csrf)
+		}
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec.Result()
+	}
+
+	if resp := call("GET", "/page", nil, ""); resp.StatusCode != http.StatusSeeOther || resp.Header.Get("Location") != "/login?next=%2Fpage" {
+		t.Errorf("page without session: %s to %q, want a redirect to the login", resp.Status, resp.Header.Get("Location"))
+	}
+	if resp := call("GET", "/api/settings", nil, ""); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("API without session: %s, want 401", resp.Status)
+	}
+
+	viewer, viewerCookies := signIn("carla", Viewer)
+	operator, operatorCookies := signIn("bruno", Operator)
+	if resp := call("GET", "/page", viewerCookies, ""); resp.StatusCode != http.StatusOK {
+		t.Errorf("viewer page: %s", resp.Status)
+	}
+	if resp := call("POST", "/api/ack", viewerCookies, viewer.CSRF); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("viewer ack: %s, want 403", resp.Status)
+	}
+	if resp := call("POST", "/api/ack", operatorCookies, ""); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("ack without CSRF token: %s, want 403", resp.Status)
+	}
+	if resp := call("POST", "/api/ack", operatorCookies, viewer.CSRF); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("ack with another session's CSRF token: %s, want 403", resp.Status)
+	}
+	if resp := call("POST", "/api/ack", operatorCookies, operator.CSRF); resp.StatusCode != http.StatusOK {
+		t.Errorf("operator ack: %s", resp.Status)
+	}
+	if resp := call("GET", "/api/settings", operatorCookies, ""); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("operator settings: %s, want 403", resp.Status)
+	}
+
+	// Signing out ends the session; the other one lasts until it expires
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/logout", nil)
+	req.AddCookie(viewerCookies[0])
+	a.EndSession(rec, req)
+	if resp := call("GET", "/page", viewerCookies, ""); resp.StatusCode != http.StatusSeeOther {
+		t.Errorf("page after logout: %s, want a redirect", resp.Status)
+	}
+	clk.Advance(time.Hour)
+	if resp := call("GET", "/api/ack", operatorCookies, ""); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expired session: %s, want 401", resp.Status)
+	}
+}
+
+// TestOIDC signs in through the mock IdP.
+func TestOIDC(t *testing.T) {
+	idp, err := NewMockIdP(MockIdPOptions{
+		ClientID:     "dashboard",
+		ClientSecret: "secret",
+		Users:        map[string][]string{"ana": {"viewer", "admin"}, "bruno": {"operator"}, "davi": nil},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(idp.Handler())
+	defer srv.Close()
+
+	a, err := New(Options{OIDC: &OIDCConfig{
+		Issuer:       srv.URL,
+		ClientID:     "dashboard",
+		ClientSecret: "secret",
+		RedirectURL:  "http://dashboard.test/auth/oidc/callback",
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// signIn goes to the provider, picks user and returns the callback
+	// request the browser would make, with its state cookie.
+	signIn := func(user string) *http.Request {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		target, err := a.OIDCRedirect(rec, "/edge/e1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		u, _ := url.Parse(target)
+		form := u.Query()
+		form.Set("user", user)
+		client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+		resp, err := client.PostForm(srv.URL+"/authorize", form)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusFound {
+			t.Fatalf("authorize: %s", resp.Status)
+		}
+		req := httptest.NewRequest("GET", resp.Header.Get("Location"), nil)
+		for _, c := range rec.Result().Cookies() {
+			req.AddCookie(c)
+		}
+		return req
+	}
+
+	callback := signIn("ana")
+	user, role, next, err := a.OIDCCallback(httptest.NewRecorder(), callback)
+	if err != nil || user != "ana" || role != Admin || next != "/edge/e1" {
+		t.Errorf("OIDCCallback = %q, %q, %q, %v; want ana, admin, /edge/e1", user, role, next, err)
+	}
+	if _, _, _, err := a.OIDCCallback(httptest.NewRecorder(), callback); err == nil {
+		t.Error("a callback was accepted twice")
+	}
+	if _, _, _, err := a.OIDCCallback(httptest.NewRecorder(), signIn("davi")); err == nil {
+		t.Error("a user without a role was signed in")
+	}
+
+	// The state must come back to the browser it was given to
+	callback = signIn("bruno")
+	stolen := httptest.NewRequest("GET", callback.URL.String(), nil)
+	if _, _, _, err := a.OIDCCallback(httptest.NewRecorder(), stolen); err == nil {
+		t.Error("a callback without the state cookie was accepted")
+	}
+}
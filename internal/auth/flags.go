@@ -0,0 +1,63 @@
+package auth
+
+import (
+	"flag"
+	"time"
+)
+
+// Flags are the login options registered by RegisterFlags.
+type Flags struct {
+	Users            *string
+	SessionTTL       *time.Duration
+	OIDCIssuer       *string
+	OIDCClientID     *string
+	OIDCClientSecret *string
+	OIDCRedirectURL  *string
+	OIDCRoleClaim    *string
+	OIDCDefaultRole  *string
+}
+
+// RegisterFlags registers the login flags on fs.
+func RegisterFlags(fs *flag.FlagSet) *Flags {
+	return &Flags{
+		Users:            fs.String("users", "", "JSON file with the local users, their roles and bcrypt password hashes (login disabled without it or -oidc-issuer)"),
+		SessionTTL:       fs.Duration("session-ttl", DefaultSessionTTL, "How long a login lasts"),
+		OIDCIssuer:       fs.String("oidc-issuer", "", "OpenID Connect provider to sign in with (disabled if empty)"),
+		OIDCClientID:     fs.String("oidc-client-id", "dashboard", "OIDC client ID"),
+		OIDCClientSecret: fs.String("oidc-client-secret", "", "OIDC client secret"),
+		OIDCRedirectURL:  fs.String("oidc-redirect-url", "", "URL of /auth/oidc/callback registered with the provider (default http://localhost:<port>/auth/oidc/callback)"),
+		OIDCRoleClaim:    fs.String("oidc-role-claim", "roles", "ID token claim with the user's role or roles"),
+		OIDCDefaultRole:  fs.String("oidc-default-role", "", "Role of OIDC users whose claim names none (refused if empty)"),
+	}
+}
+
+// New returns the Auth the flags configure, nil if they configure no login.
+// defaultRedirect is used when -oidc-redirect-url isn't given.
+func (f *Flags) New(defaultRedirect string, secureCookies bool) (*Auth, error) {
+	opts := Options{SessionTTL: *f.SessionTTL, SecureCookies: secureCookies}
+	if *f.Users != "" {
+		users, err := LoadUsers(*f.Users)
+		if err != nil {
+			return nil, err
+		}
+		opts.Users = users
+	}
+	if *f.OIDCIssuer != "" {
+		redirect := *f.OIDCRedirectURL
+		if redirect == "" {
+			redirect = defaultRedirect
+		}
+		opts.OIDC = &OIDCConfig{
+			Issuer:       *f.OIDCIssuer,
+			ClientID:     *f.OIDCClientID,
+			ClientSecret: *f.OIDCClientSecret,
+			RedirectURL:  redirect,
+			RoleClaim:    *f.OIDCRoleClaim,
+			DefaultRole:  Role(*f.OIDCDefaultRole),
+		}
+	}
+	if opts.Users == nil && opts.OIDC == nil {
+		return nil, nil
+	}
+	return New(opts)
+}
@@ -0,0 +1,255 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"html/template"
+	"math/big"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"sistemas_distribuidos_gb/internal/clock"
+)
+
+// MockIdPOptions configure a MockIdP.
+type MockIdPOptions struct {
+	// Issuer is the URL the provider is reached at; if empty, the scheme
+	// and host of each request.
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// Users are the users offered on the sign in page, with the roles put
+	// in their "roles" claim.
+	Users map[string][]string
+	Clock clock.Clock
+}
+
+// MockIdP is a minimal OpenID Connect provider, to try the dashboard's OIDC
+// sign in locally and to test it: its sign in page lets you pick any of its
+// users, without a password. ID tokens are signed with RS256 by a key made
+// at start.
+type MockIdP struct {
+	opts  MockIdPOptions
+	clock clock.Clock
+	key   *rsa.PrivateKey
+
+	mu    sync.Mutex
+	codes map[string]mockCode
+}
+
+// mockCode is an issued authorization code.
+type mockCode struct {
+	user        string
+	redirectURI string
+	nonce       string
+	expires     time.Time
+}
+
+const mockKeyID = "mock-1"
+
+// NewMockIdP returns a MockIdP for opts.
+func NewMockIdP(opts MockIdPOptions) (*MockIdP, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	return &MockIdP{opts: opts, clock: clock.Or(opts.Clock), key: key, codes: make(map[string]mockCode)}, nil
+}
+
+// Handler serves the discovery document, the sign in page (/authorize), the
+// token endpoint (/token) and the keys (/jwks).
+func (p *MockIdP) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", p.handleDiscovery)
+	mux.HandleFunc("/authorize", p.handleAuthorize)
+	mux.HandleFunc("/token", p.handleToken)
+	mux.HandleFunc("/jwks", p.handleJWKS)
+	return mux
+}
+
+func (p *MockIdP) issuer(r *http.Request) string {
+	if p.opts.Issuer != "" {
+		return strings.TrimSuffix(p.opts.Issuer, "/")
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+func (p *MockIdP) handleDiscovery(w http.ResponseWriter, r *http.Request) {
+	iss := p.issuer(r)
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                iss,
+		"authorization_endpoint":                iss + "/authorize",
+		"token_endpoint":                        iss + "/token",
+		"jwks_uri":                              iss + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+	})
+}
+
+var mockSignIn = template.Must(template.New("").Parse(`<!DOCTYPE html>
+<html><head><meta charset="UTF-8"><title>Mock IdP</title></head>
+<body style="font-family: system-ui, sans-serif; max-width: 360px; margin: 60px auto;">
+<h1>Mock IdP</h1>
+<p>Entrar em <b>{{.Client}}</b> como:</p>
+<form method="post">
+{{range .Users}}<p><button name="user" value="{{.Name}}">{{.Name}}</button> <small>{{.Roles}}</small></p>
+{{end}}{{range $k, $v := .Params}}<input type="hidden" name="{{$k}}" value="{{$v}}">
+{{end}}</form>
+</body></html>
+`))
+
+// handleAuthorize shows the users to pick from (GET) and, once one is
+// picked (POST), sends the browser back to the client with a code.
+func (p *MockIdP) handleAuthorize(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	redirectURI := r.Form.Get("redirect_uri")
+	if r.Form.Get("client_id") != p.opts.ClientID || r.Form.Get("response_type") != "code" || redirectURI == "" {
+		http.Error(w, "invalid_request: unknown client or unsupported response type", http.StatusBadRequest)
+		return
+	}
+	if r.Method != http.MethodPost {
+		type user struct{ Name, Roles string }
+		var users []user
+		for name, roles := range p.opts.Users {
+			users = append(users, user{name, strings.Join(roles, ", ")})
+		}
+		sort.Slice(users, func(i, j int) bool { return users[i].Name < users[j].Name })
+		params := map[string]string{}
+		for _, k := range []string{"client_id", "redirect_uri", "response_type", "state", "nonce", "scope"} {
+			params[k] = r.Form.Get(k)
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		mockSignIn.Execute(w, map[string]interface{}{"Client": p.opts.ClientID, "Users": users, "Params": params})
+		return
+	}
+
+	user := r.Form.Get("user")
+	if _, ok := p.opts.Users[user]; !ok {
+		http.Error(w, "unknown user", http.StatusBadRequest)
+		return
+	}
+	code := randomToken()
+	p.mu.Lock()
+	p.codes[code] = mockCode{user: user, redirectURI: redirectURI, nonce: r.Form.Get("nonce"), expires: p.clock.Now().Add(time.Minute)}
+	p.mu.Unlock()
+	back, err := url.Parse(redirectURI)
+	if err != nil {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+	q := back.Query()
+	q.Set("code", code)
+	q.Set("state", r.Form.Get("state"))
+	back.RawQuery = q.Encode()
+	http.Redirect(w, r, back.String(), http.StatusFound)
+}
+
+// handleToken redeems a code for an ID token. The client authenticates with
+// basic auth or with client_id and client_secret in the form.
+func (p *MockIdP) handleToken(w http.ResponseWriter, r *http.Request) {
+	fail := func(status int, code string) {
+		writeJSON(w, status, map[string]string{"error": code})
+	}
+	if r.Method != http.MethodPost {
+		fail(http.StatusMethodNotAllowed, "invalid_request")
+		return
+	}
+	r.ParseForm()
+	id, secret, ok := r.BasicAuth()
+	if ok {
+		id, _ = url.QueryUnescape(id)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		id, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	if !equal(id, p.opts.ClientID) || !equal(secret, p.opts.ClientSecret) {
+		fail(http.StatusUnauthorized, "invalid_client")
+		return
+	}
+	if r.PostForm.Get("grant_type") != "authorization_code" {
+		fail(http.StatusBadRequest, "unsupported_grant_type")
+		return
+	}
+
+	code := r.PostForm.Get("code")
+	p.mu.Lock()
+	c, ok := p.codes[code]
+	delete(p.codes, code) // codes are good once
+	p.mu.Unlock()
+	now := p.clock.Now()
+	if !ok || !now.Before(c.expires) || c.redirectURI != r.PostForm.Get("redirect_uri") {
+		fail(http.StatusBadRequest, "invalid_grant")
+		return
+	}
+
+	claims := map[string]interface{}{
+		"iss":                p.issuer(r),
+		"sub":                c.user,
+		"aud":                p.opts.ClientID,
+		"iat":                now.Unix(),
+		"exp":                now.Add(time.Hour).Unix(),
+		"preferred_username": c.user,
+		"roles":              p.opts.Users[c.user],
+	}
+	if c.nonce != "" {
+		claims["nonce"] = c.nonce
+	}
+	token, err := p.sign(claims)
+	if err != nil {
+		fail(http.StatusInternalServerError, "server_error")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": randomToken(),
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     token,
+	})
+}
+
+func (p *MockIdP) handleJWKS(w http.ResponseWriter, r *http.Request) {
+	pub := p.key.PublicKey
+	writeJSON(w, http.StatusOK, map[string]interface{}{"keys": []map[string]string{{
+		"kty": "RSA",
+		"kid": mockKeyID,
+		"alg": "RS256",
+		"use": "sig",
+		"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+	}}})
+}
+
+// sign returns claims as a JWT signed with RS256.
+func (p *MockIdP) sign(claims map[string]interface{}) (string, error) {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": mockKeyID})
+	body, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(body)
+	sum := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, p.key, crypto.SHA256, sum[:])
+	if err != nil {
+		return "", err
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
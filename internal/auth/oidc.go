@@ -0,0 +1,367 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"sistemas_distribuidos_gb/internal/clock"
+)
+
+// OIDCConfig configures sign in with an OpenID Connect provider, by the
+// authorization code flow. ID tokens may be signed with RS256 (keys from the
+// provider's JWKS) or HS256 (the client secret).
+type OIDCConfig struct {
+	Issuer       string // the provider, discovered at <Issuer>/.well-known/openid-configuration
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string // the dashboard's /auth/oidc/callback, as registered with the provider
+	RoleClaim    string // ID token claim with the role, or a list of them ("roles" if empty)
+	DefaultRole  Role   // role of users whose claim names none; refused if empty
+}
+
+// loginTimeout is how long a user has to come back from the provider.
+const loginTimeout = 10 * time.Minute
+
+const stateCookie = "oidc_state"
+
+type providerMetadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// pendingLogin is a user sent to the provider, by state.
+type pendingLogin struct {
+	nonce   string
+	next    string
+	expires time.Time
+}
+
+type oidcClient struct {
+	cfg   OIDCConfig
+	clock clock.Clock
+	http  *http.Client
+
+	mu       sync.Mutex
+	provider *providerMetadata         // discovered on first use, as the provider may start later
+	keys     map[string]*rsa.PublicKey // by kid
+	pending  map[string]pendingLogin
+}
+
+func newOIDCClient(cfg OIDCConfig, clk clock.Clock) (*oidcClient, error) {
+	if cfg.Issuer == "" || cfg.ClientID == "" || cfg.RedirectURL == "" {
+		return nil, errors.New("OIDC needs an issuer, a client ID and a redirect URL")
+	}
+	if cfg.DefaultRole != "" && !cfg.DefaultRole.Valid() {
+		return nil, fmt.Errorf("unknown OIDC default role %q", cfg.DefaultRole)
+	}
+	if cfg.RoleClaim == "" {
+		cfg.RoleClaim = "roles"
+	}
+	return &oidcClient{
+		cfg:     cfg,
+		clock:   clk,
+		http:    &http.Client{Timeout: 10 * time.Second},
+		pending: make(map[string]pendingLogin),
+	}, nil
+}
+
+// OIDCRedirect starts signing in with the provider: it returns the provider
+// URL to send the browser to, which comes back to the callback with a code.
+// next is where to go once signed in.
+func (a *Auth) OIDCRedirect(w http.ResponseWriter, next string) (string, error) {
+	if a.oidc == nil {
+		return "", errors.New("OIDC isn't configured")
+	}
+	provider, err := a.oidc.discover()
+	if err != nil {
+		return "", err
+	}
+	state, nonce := randomToken(), randomToken()
+	now := a.clock.Now()
+	a.oidc.mu.Lock()
+	for s, p := range a.oidc.pending {
+		if !now.Before(p.expires) {
+			delete(a.oidc.pending, s)
+		}
+	}
+	a.oidc.pending[state] = pendingLogin{nonce: nonce, next: next, expires: now.Add(loginTimeout)}
+	a.oidc.mu.Unlock()
+	// Ties the state to this browser, so nobody can sign it in as someone else
+	a.setCookie(w, stateCookie, state, now.Add(loginTimeout), true)
+
+	q := url.Values{
+		"response_type": {"code"},
+		"client_id":     {a.oidc.cfg.ClientID},
+		"redirect_uri":  {a.oidc.cfg.RedirectURL},
+		"scope":         {"openid profile email"},
+		"state":         {state},
+		"nonce":         {nonce},
+	}
+	sep := "?"
+	if strings.Contains(provider.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return provider.AuthorizationEndpoint + sep + q.Encode(), nil
+}
+
+// OIDCCallback finishes signing in with the provider: it redeems the code
+// the browser brought back in r and checks the ID token. It returns the
+// user, their role, and the next URL given to OIDCRedirect.
+func (a *Auth) OIDCCallback(w http.ResponseWriter, r *http.Request) (user string, role Role, next string, err error) {
+	if a.oidc == nil {
+		return "", "", "", errors.New("OIDC isn't configured")
+	}
+	q := r.URL.Query()
+	if e := q.Get("error"); e != "" {
+		return "", "", "", fmt.Errorf("provider refused: %s %s", e, q.Get("error_description"))
+	}
+	state := q.Get("state")
+	c, cookieErr := r.Cookie(stateCookie)
+	a.setCookie(w, stateCookie, "", time.Unix(0, 0), true)
+	a.oidc.mu.Lock()
+	pending, ok := a.oidc.pending[state]
+	delete(a.oidc.pending, state)
+	a.oidc.mu.Unlock()
+	if cookieErr != nil || state == "" || !equal(c.Value, state) || !ok || !a.clock.Now().Before(pending.expires) {
+		return "", "", "", errors.New("unknown or expired sign in, try again")
+	}
+
+	claims, err := a.oidc.exchange(q.Get("code"))
+	if err != nil {
+		return "", "", "", err
+	}
+	if n, _ := claims["nonce"].(string); !equal(n, pending.nonce) {
+		return "", "", "", errors.New("ID token nonce doesn't match")
+	}
+	for _, claim := range []string{"preferred_username", "email", "sub"} {
+		if user, _ = claims[claim].(string); user != "" {
+			break
+		}
+	}
+	if user == "" {
+		return "", "", "", errors.New("ID token names no user")
+	}
+	role = claimRole(claims[a.oidc.cfg.RoleClaim])
+	if role == "" {
+		role = a.oidc.cfg.DefaultRole
+	}
+	if role == "" {
+		return "", "", "", fmt.Errorf("%s has no role in claim %q", user, a.oidc.cfg.RoleClaim)
+	}
+	return user, role, pending.next, nil
+}
+
+// claimRole returns the highest role named by a role claim, a string or a
+// list of them; other values are ignored.
+func claimRole(v interface{}) Role {
+	var names []interface{}
+	switch v := v.(type) {
+	case string:
+		names = []interface{}{v}
+	case []interface{}:
+		names = v
+	}
+	var best Role
+	for _, n := range names {
+		if s, ok := n.(string); ok && Role(s).Valid() && roleRank[Role(s)] > roleRank[best] {
+			best = Role(s)
+		}
+	}
+	return best
+}
+
+func (c *oidcClient) discover() (*providerMetadata, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.provider != nil {
+		return c.provider, nil
+	}
+	var m providerMetadata
+	if err := c.getJSON(strings.TrimSuffix(c.cfg.Issuer, "/")+"/.well-known/openid-configuration", &m); err != nil {
+		return nil, fmt.Errorf("discover OIDC provider: %w", err)
+	}
+	if m.Issuer != c.cfg.Issuer || m.AuthorizationEndpoint == "" || m.TokenEndpoint == "" {
+		return nil, fmt.Errorf("discover OIDC provider: bad metadata for issuer %q", c.cfg.Issuer)
+	}
+	c.provider = &m
+	return c.provider, nil
+}
+
+// exchange redeems an authorization code and returns the claims of the
+// verified ID token.
+func (c *oidcClient) exchange(code string) (map[string]interface{}, error) {
+	provider, err := c.discover()
+	if err != nil {
+		return nil, err
+	}
+	form := url.Values{
+		"grant_type":   {"authorization_code"},
+		"code":         {code},
+		"redirect_uri": {c.cfg.RedirectURL},
+	}
+	req, err := http.NewRequest(http.MethodPost, provider.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(c.cfg.ClientID), url.QueryEscape(c.cfg.ClientSecret))
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("redeem code: %w", err)
+	}
+	defer resp.Body.Close()
+	var tokens struct {
+		IDToken string `json:"id_token"`
+		Error   string `json:"error"`
+	}
+	json.NewDecoder(resp.Body).Decode(&tokens)
+	if resp.StatusCode != http.StatusOK || tokens.IDToken == "" {
+		return nil, fmt.Errorf("redeem code: %s %s", resp.Status, tokens.Error)
+	}
+	return c.verify(provider, tokens.IDToken)
+}
+
+// verify checks the signature, issuer, audience and expiry of an ID token
+// and returns its claims.
+func (c *oidcClient) verify(provider *providerMetadata, token string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed ID token")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("ID token header: %w", err)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("ID token signature: %w", err)
+	}
+	signed := []byte(parts[0] + "." + parts[1])
+	switch header.Alg {
+	case "RS256":
+		key, err := c.key(provider, header.Kid)
+		if err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256(signed)
+		if rsa.VerifyPKCS1v15(key, crypto.SHA256, sum[:], sig) != nil {
+			return nil, errors.New("bad ID token signature")
+		}
+	case "HS256":
+		mac := hmac.New(sha256.New, []byte(c.cfg.ClientSecret))
+		mac.Write(signed)
+		if !hmac.Equal(mac.Sum(nil), sig) {
+			return nil, errors.New("bad ID token signature")
+		}
+	default:
+		return nil, fmt.Errorf("unsupported ID token algorithm %q", header.Alg)
+	}
+
+	var claims map[string]interface{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("ID token claims: %w", err)
+	}
+	if iss, _ := claims["iss"].(string); iss != provider.Issuer {
+		return nil, fmt.Errorf("ID token from issuer %q", iss)
+	}
+	if !hasAudience(claims["aud"], c.cfg.ClientID) {
+		return nil, errors.New("ID token isn't for this client")
+	}
+	// A minute of leeway for clock skew
+	if exp, _ := claims["exp"].(float64); c.clock.Now().After(time.Unix(int64(exp), 0).Add(time.Minute)) {
+		return nil, errors.New("ID token expired")
+	}
+	return claims, nil
+}
+
+func hasAudience(aud interface{}, clientID string) bool {
+	switch aud := aud.(type) {
+	case string:
+		return aud == clientID
+	case []interface{}:
+		for _, a := range aud {
+			if a == clientID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// key returns the provider key kid, fetching the JWKS again if it's new:
+// the provider may have rotated its keys.
+func (c *oidcClient) key(provider *providerMetadata, kid string) (*rsa.PublicKey, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if key, ok := c.keys[kid]; ok {
+		return key, nil
+	}
+	if provider.JWKSURI == "" {
+		return nil, errors.New("OIDC provider has no jwks_uri")
+	}
+	var jwks struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := c.getJSON(provider.JWKSURI, &jwks); err != nil {
+		return nil, fmt.Errorf("fetch OIDC keys: %w", err)
+	}
+	c.keys = make(map[string]*rsa.PublicKey)
+	for _, k := range jwks.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+		n, errN := base64.RawURLEncoding.DecodeString(k.N)
+		e, errE := base64.RawURLEncoding.DecodeString(k.E)
+		if errN != nil || errE != nil {
+			continue
+		}
+		c.keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+	key, ok := c.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown ID token key %q", kid)
+	}
+	return key, nil
+}
+
+func (c *oidcClient) getJSON(url string, v interface{}) error {
+	resp, err := c.http.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func decodeSegment(seg string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
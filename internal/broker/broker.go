@@ -0,0 +1,76 @@
+// Package broker is the messaging interface the pipeline services publish
+// and subscribe through. NATS is the production implementation; Memory is an
+// in-process broker for unit tests.
+//
+// Features built on JetStream or request-reply (durable consumers, the sensor
+// registry, the central config bucket) need the NATS connection itself,
+// which Conn returns when the broker has one.
+package broker
+
+import (
+	"errors"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// Handler processes a message received on subject.
+type Handler func(subject string, data []byte)
+
+// Subscription is an active subscription.
+type Subscription interface {
+	Unsubscribe() error
+}
+
+// Broker publishes messages and delivers them to matching subscriptions.
+// Subjects follow NATS syntax: dot-separated tokens, with * matching one
+// token and > the rest of the subject.
+type Broker interface {
+	Publish(subject string, data []byte) error
+	Subscribe(subject string, handler Handler) (Subscription, error)
+}
+
+// ErrNotNATS is returned by Conn for brokers that aren't backed by NATS.
+var ErrNotNATS = errors.New("broker is not backed by a NATS connection")
+
+// Conn returns the NATS connection behind b, looking through brokers that
+// wrap another one with an Unwrap method.
+func Conn(b Broker) (*nats.Conn, error) {
+	switch n := b.(type) {
+	case *NATS:
+		return n.nc, nil
+	case interface{ Unwrap() Broker }:
+		return Conn(n.Unwrap())
+	}
+	return nil, ErrNotNATS
+}
+
+// NATS is a broker backed by a NATS connection.
+type NATS struct {
+	nc *nats.Conn
+}
+
+// NewNATS wraps nc. Closing the connection is left to the caller.
+func NewNATS(nc *nats.Conn) *NATS {
+	return &NATS{nc: nc}
+}
+
+func (b *NATS) Publish(subject string, data []byte) error {
+	return b.nc.Publish(subject, data)
+}
+
+func (b *NATS) Subscribe(subject string, handler Handler) (Subscription, error) {
+	return b.nc.Subscribe(subject, func(msg *nats.Msg) {
+		handler(msg.Subject, msg.Data)
+	})
+}
+
+// Flush waits until the server has received everything published so far.
+// It fails at once while the connection is down, rather than leaving the
+// messages to the reconnect buffer.
+func (b *NATS) Flush(timeout time.Duration) error {
+	if !b.nc.IsConnected() {
+		return nats.ErrConnectionReconnecting
+	}
+	return b.nc.FlushTimeout(timeout)
+}
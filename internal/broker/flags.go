@@ -0,0 +1,67 @@
+package broker
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/nats-io/nats.go"
+)
+
+// Flags are the transport options registered by RegisterFlags.
+type Flags struct {
+	Transport   *string
+	MQTTURL     *string
+	MQTTVersion *int
+	MQTTUser    *string
+	MQTTPass    *string
+	MQTTQoS     *int
+}
+
+// RegisterFlags registers -transport and the MQTT connection flags on fs.
+func RegisterFlags(fs *flag.FlagSet) *Flags {
+	return &Flags{
+		Transport:   fs.String("transport", "nats", "Message transport: nats or mqtt (JetStream, the registry and central config need nats)"),
+		MQTTURL:     fs.String("mqtt", "tcp://localhost:1883", "MQTT server URL"),
+		MQTTVersion: fs.Int("mqtt-version", MQTT311, "MQTT protocol version: 4 (3.1.1) or 5"),
+		MQTTUser:    fs.String("mqtt-user", "", "MQTT username"),
+		MQTTPass:    fs.String("mqtt-pass", "", "MQTT password"),
+		MQTTQoS:     fs.Int("mqtt-qos", 1, "MQTT QoS of publications and subscriptions: 0 or 1"),
+	}
+}
+
+// MQTTOptions returns the MQTT connection options for client clientID.
+func (f *Flags) MQTTOptions(clientID string) MQTTOptions {
+	return MQTTOptions{
+		URL:      *f.MQTTURL,
+		Version:  *f.MQTTVersion,
+		ClientID: clientID,
+		Username: *f.MQTTUser,
+		Password: *f.MQTTPass,
+		QoS:      byte(*f.MQTTQoS),
+	}
+}
+
+// Connect connects to the selected transport as clientID; connectNATS opens
+// the connection when the transport is NATS. The returned function closes
+// the connection.
+func (f *Flags) Connect(clientID string, connectNATS func() (*nats.Conn, error)) (Broker, func(), error) {
+	switch *f.Transport {
+	case "nats":
+		nc, err := connectNATS()
+		if err != nil {
+			return nil, nil, err
+		}
+		return NewNATS(nc), nc.Close, nil
+	case "mqtt":
+		if *f.MQTTQoS < 0 || *f.MQTTQoS > 1 {
+			return nil, nil, fmt.Errorf("invalid -mqtt-qos %d (want 0 or 1)", *f.MQTTQoS)
+		}
+		m, err := DialMQTT(f.MQTTOptions(clientID))
+		if err != nil {
+			return nil, nil, err
+		}
+		return m, m.Close, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown transport %q (want nats or mqtt)", *f.Transport)
+	}
+}
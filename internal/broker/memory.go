@@ -0,0 +1,97 @@
+package broker
+
+import (
+	"errors"
+	"strings"
+	"sync"
+)
+
+// Memory is an in-process broker. Publish delivers to the matching
+// subscriptions synchronously, in subscription order, before returning, so
+// tests can check the effects of a message right after publishing it.
+type Memory struct {
+	mu     sync.Mutex
+	subs   []*memorySub
+	closed bool
+}
+
+// NewMemory creates an empty in-process broker.
+func NewMemory() *Memory {
+	return &Memory{}
+}
+
+type memorySub struct {
+	m       *Memory
+	subject string
+	handler Handler
+}
+
+// ErrClosed is returned when publishing to or subscribing on a closed broker.
+var ErrClosed = errors.New("broker closed")
+
+func (m *Memory) Publish(subject string, data []byte) error {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return ErrClosed
+	}
+	var handlers []Handler
+	for _, s := range m.subs {
+		if Match(s.subject, subject) {
+			handlers = append(handlers, s.handler)
+		}
+	}
+	m.mu.Unlock()
+
+	// Handlers may publish themselves, so they run without the lock held
+	for _, h := range handlers {
+		h(subject, append([]byte(nil), data...))
+	}
+	return nil
+}
+
+func (m *Memory) Subscribe(subject string, handler Handler) (Subscription, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return nil, ErrClosed
+	}
+	s := &memorySub{m: m, subject: subject, handler: handler}
+	m.subs = append(m.subs, s)
+	return s, nil
+}
+
+// Close drops every subscription; later publications fail.
+func (m *Memory) Close() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.closed = true
+	m.subs = nil
+}
+
+func (s *memorySub) Unsubscribe() error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	for i, other := range s.m.subs {
+		if other == s {
+			s.m.subs = append(s.m.subs[:i:i], s.m.subs[i+1:]...)
+			return nil
+		}
+	}
+	return nil
+}
+
+// Match reports whether subject matches the NATS subject pattern.
+func Match(pattern, subject string) bool {
+	pt := strings.Split(pattern, ".")
+	st := strings.Split(subject, ".")
+	for i, p := range pt {
+		if p == ">" {
+			return len(st) > i
+		}
+		if i >= len(st) || (p != "*" && p != st[i]) {
+			return false
+		}
+	}
+	return len(pt) == len(st)
+}
@@ -0,0 +1,49 @@
+package broker
+
+import "testing"
+
+func TestMatch(t *testing.T) {
+	for _, tc := range []struct {
+		pattern, subject string
+		want             bool
+	}{
+		{"edge.e1.alerts", "edge.e1.alerts", true},
+		{"edge.e1.alerts", "edge.e2.alerts", false},
+		{"edge.*.alerts", "edge.e2.alerts", true},
+		{"edge.*.alerts", "edge.e2.filtered", false},
+		{"edge.*", "edge.e2.alerts", false},
+		{"edge.>", "edge.e2.alerts", true},
+		{"edge.>", "edge", false},
+		{"sensors.*.*.*.readings", "sensors.plant.l1.s1.readings", true},
+		{"sensors.*.*.*.readings", "sensors.plant.l1.readings", false},
+	} {
+		if got := Match(tc.pattern, tc.subject); got != tc.want {
+			t.Errorf("Match(%q, %q) = %v, want %v", tc.pattern, tc.subject, got, tc.want)
+		}
+	}
+}
+
+func TestMemory(t *testing.T) {
+	m := NewMemory()
+	var got []string
+	sub, err := m.Subscribe("edge.*.alerts", func(subject string, data []byte) {
+		got = append(got, subject+"="+string(data))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m.Publish("edge.e1.alerts", []byte("a"))
+	m.Publish("edge.e1.filtered", []byte("b"))
+	sub.Unsubscribe()
+	m.Publish("edge.e2.alerts", []byte("c"))
+
+	if len(got) != 1 || got[0] != "edge.e1.alerts=a" {
+		t.Errorf("got %v, want [edge.e1.alerts=a]", got)
+	}
+
+	m.Close()
+	if err := m.Publish("edge.e1.alerts", nil); err != ErrClosed {
+		t.Errorf("publish after close: %v, want ErrClosed", err)
+	}
+}
@@ -0,0 +1,342 @@
+package broker
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/eclipse/paho.golang/autopaho"
+	"github.com/eclipse/paho.golang/paho"
+	mqtt3 "github.com/eclipse/paho.mqtt.golang"
+)
+
+// MQTT protocol versions.
+const (
+	MQTT311 = 4 // MQTT 3.1.1
+	MQTT5   = 5
+)
+
+// MQTTOptions configure a connection to an MQTT server.
+type MQTTOptions struct {
+	URL      string // tcp://host:1883, ssl://host:8883, ws://host/mqtt...
+	Version  int    // MQTT311 (default) or MQTT5
+	ClientID string // must be unique on the server
+	Username string
+	Password string
+	TLS      *tls.Config
+	// QoS of publications and subscriptions: 0 (at most once) or 1 (at
+	// least once).
+	QoS            byte
+	ConnectTimeout time.Duration // default 10s
+}
+
+// MQTT is a broker backed by an MQTT 3.1.1 or 5 server. Subjects map onto
+// topics by turning dots into slashes and the * and > wildcards into + and
+// #, so "sensors.*.*.*.readings" subscribes to "sensors/+/+/+/readings".
+//
+// Subscriptions are re-established after a reconnection. Overlapping
+// subscriptions on one connection may see a message twice, as the server
+// can deliver it once per matching filter.
+type MQTT struct {
+	client  mqttClient
+	qos     byte
+	timeout time.Duration
+
+	mu      sync.Mutex
+	subs    []*mqttSub
+	filters map[string]int // active topic filters, with the subscriptions using them
+}
+
+// mqttClient is what MQTT needs from the protocol-specific clients.
+type mqttClient interface {
+	publish(ctx context.Context, topic string, qos byte, payload []byte) error
+	subscribe(ctx context.Context, filter string, qos byte) error
+	unsubscribe(ctx context.Context, filter string) error
+	close()
+}
+
+type mqttSub struct {
+	m       *MQTT
+	subject string
+	handler Handler
+}
+
+// DialMQTT connects to the server and waits for the connection to be up.
+func DialMQTT(opts MQTTOptions) (*MQTT, error) {
+	if opts.QoS > 1 {
+		return nil, fmt.Errorf("unsupported MQTT QoS %d (want 0 or 1)", opts.QoS)
+	}
+	if opts.ConnectTimeout <= 0 {
+		opts.ConnectTimeout = 10 * time.Second
+	}
+	m := &MQTT{qos: opts.QoS, timeout: opts.ConnectTimeout, filters: map[string]int{}}
+
+	var err error
+	switch opts.Version {
+	case 0, MQTT311:
+		m.client, err = dialMQTT311(opts, m)
+	case MQTT5:
+		m.client, err = dialMQTT5(opts, m)
+	default:
+		return nil, fmt.Errorf("unsupported MQTT version %d (want 4 for 3.1.1 or 5)", opts.Version)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("connect to MQTT server %s: %w", opts.URL, err)
+	}
+	return m, nil
+}
+
+func (m *MQTT) Publish(subject string, data []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), m.timeout)
+	defer cancel()
+	return m.client.publish(ctx, TopicFromSubject(subject), m.qos, data)
+}
+
+func (m *MQTT) Subscribe(subject string, handler Handler) (Subscription, error) {
+	filter := TopicFromSubject(subject)
+	s := &mqttSub{m: m, subject: subject, handler: handler}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.filters[filter] == 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), m.timeout)
+		defer cancel()
+		if err := m.client.subscribe(ctx, filter, m.qos); err != nil {
+			return nil, fmt.Errorf("subscribe to %s: %w", filter, err)
+		}
+	}
+	m.filters[filter]++
+	m.subs = append(m.subs, s)
+	return s, nil
+}
+
+// Close disconnects from the server.
+func (m *MQTT) Close() {
+	m.client.close()
+}
+
+func (s *mqttSub) Unsubscribe() error {
+	m := s.m
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, other := range m.subs {
+		if other != s {
+			continue
+		}
+		m.subs = append(m.subs[:i:i], m.subs[i+1:]...)
+		filter := TopicFromSubject(s.subject)
+		if m.filters[filter]--; m.filters[filter] > 0 {
+			return nil
+		}
+		delete(m.filters, filter)
+		ctx, cancel := context.WithTimeout(context.Background(), m.timeout)
+		defer cancel()
+		return m.client.unsubscribe(ctx, filter)
+	}
+	return nil
+}
+
+// deliver hands a received message to the subscriptions matching its topic.
+func (m *MQTT) deliver(topic string, payload []byte) {
+	subject := SubjectFromTopic(topic)
+	m.mu.Lock()
+	var handlers []Handler
+	for _, s := range m.subs {
+		if Match(s.subject, subject) {
+			handlers = append(handlers, s.handler)
+		}
+	}
+	m.mu.Unlock()
+	for _, h := range handlers {
+		h(subject, payload)
+	}
+}
+
+// resubscribe restores the subscriptions after a reconnection; the session
+// is clean, so the server forgot them.
+func (m *MQTT) resubscribe() {
+	m.mu.Lock()
+	filters := make([]string, 0, len(m.filters))
+	for f := range m.filters {
+		filters = append(filters, f)
+	}
+	m.mu.Unlock()
+	for _, f := range filters {
+		ctx, cancel := context.WithTimeout(context.Background(), m.timeout)
+		if err := m.client.subscribe(ctx, f, m.qos); err != nil {
+			log.Printf("Error resubscribing to %s: %v", f, err)
+		}
+		cancel()
+	}
+}
+
+// TopicFromSubject maps a subject (or subject pattern) onto an MQTT topic.
+func TopicFromSubject(subject string) string {
+	tokens := strings.Split(subject, ".")
+	for i, t := range tokens {
+		switch t {
+		case "*":
+			tokens[i] = "+"
+		case ">":
+			tokens[i] = "#"
+		}
+	}
+	return strings.Join(tokens, "/")
+}
+
+// SubjectFromTopic maps an MQTT topic onto a subject.
+func SubjectFromTopic(topic string) string {
+	return strings.ReplaceAll(topic, "/", ".")
+}
+
+// mqtt311 is an MQTT 3.1.1 client.
+type mqtt311 struct {
+	c mqtt3.Client
+}
+
+func dialMQTT311(opts MQTTOptions, m *MQTT) (*mqtt311, error) {
+	o := mqtt3.NewClientOptions().
+		AddBroker(opts.URL).
+		SetClientID(opts.ClientID).
+		SetUsername(opts.Username).
+		SetPassword(opts.Password).
+		SetProtocolVersion(MQTT311).
+		SetCleanSession(true).
+		SetAutoReconnect(true).
+		SetConnectTimeout(opts.ConnectTimeout).
+		SetDefaultPublishHandler(func(_ mqtt3.Client, msg mqtt3.Message) {
+			m.deliver(msg.Topic(), msg.Payload())
+		})
+	if opts.TLS != nil {
+		o.SetTLSConfig(opts.TLS)
+	}
+	var connected atomic.Bool
+	o.SetOnConnectHandler(func(mqtt3.Client) {
+		if !connected.Swap(true) {
+			return
+		}
+		log.Printf("Reconnected to MQTT server %s", opts.URL)
+		go m.resubscribe()
+	})
+
+	c := mqtt3.NewClient(o)
+	t := c.Connect()
+	if !t.WaitTimeout(opts.ConnectTimeout) {
+		c.Disconnect(0)
+		return nil, fmt.Errorf("timed out after %v", opts.ConnectTimeout)
+	}
+	if err := t.Error(); err != nil {
+		return nil, err
+	}
+	return &mqtt311{c: c}, nil
+}
+
+func wait(ctx context.Context, t mqtt3.Token) error {
+	select {
+	case <-t.Done():
+		return t.Error()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (c *mqtt311) publish(ctx context.Context, topic string, qos byte, payload []byte) error {
+	return wait(ctx, c.c.Publish(topic, qos, false, payload))
+}
+
+func (c *mqtt311) subscribe(ctx context.Context, filter string, qos byte) error {
+	// A nil callback routes messages to the default publish handler
+	return wait(ctx, c.c.Subscribe(filter, qos, nil))
+}
+
+func (c *mqtt311) unsubscribe(ctx context.Context, filter string) error {
+	return wait(ctx, c.c.Unsubscribe(filter))
+}
+
+func (c *mqtt311) close() {
+	c.c.Disconnect(250)
+}
+
+// mqtt5 is an MQTT 5 client.
+type mqtt5 struct {
+	cm     *autopaho.ConnectionManager
+	cancel context.CancelFunc
+}
+
+func dialMQTT5(opts MQTTOptions, m *MQTT) (*mqtt5, error) {
+	u, err := url.Parse(opts.URL)
+	if err != nil {
+		return nil, err
+	}
+	var connected atomic.Bool
+	cfg := autopaho.ClientConfig{
+		ServerUrls:                    []*url.URL{u},
+		TlsCfg:                        opts.TLS,
+		KeepAlive:                     30,
+		CleanStartOnInitialConnection: true,
+		ConnectTimeout:                opts.ConnectTimeout,
+		OnConnectionUp: func(*autopaho.ConnectionManager, *paho.Connack) {
+			if !connected.Swap(true) {
+				return
+			}
+			log.Printf("Reconnected to MQTT server %s", opts.URL)
+			go m.resubscribe()
+		},
+		ClientConfig: paho.ClientConfig{
+			ClientID: opts.ClientID,
+			OnPublishReceived: []func(paho.PublishReceived) (bool, error){
+				func(pr paho.PublishReceived) (bool, error) {
+					m.deliver(pr.Packet.Topic, pr.Packet.Payload)
+					return true, nil
+				},
+			},
+		},
+	}
+	if opts.Username != "" {
+		cfg.SetUsernamePassword(opts.Username, []byte(opts.Password))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cm, err := autopaho.NewConnection(ctx, cfg)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	waitCtx, waitCancel := context.WithTimeout(ctx, opts.ConnectTimeout)
+	defer waitCancel()
+	if err := cm.AwaitConnection(waitCtx); err != nil {
+		cancel()
+		return nil, err
+	}
+	return &mqtt5{cm: cm, cancel: cancel}, nil
+}
+
+func (c *mqtt5) publish(ctx context.Context, topic string, qos byte, payload []byte) error {
+	_, err := c.cm.Publish(ctx, &paho.Publish{Topic: topic, QoS: qos, Payload: payload})
+	return err
+}
+
+func (c *mqtt5) subscribe(ctx context.Context, filter string, qos byte) error {
+	_, err := c.cm.Subscribe(ctx, &paho.Subscribe{
+		Subscriptions: []paho.SubscribeOptions{{Topic: filter, QoS: qos}},
+	})
+	return err
+}
+
+func (c *mqtt5) unsubscribe(ctx context.Context, filter string) error {
+	_, err := c.cm.Unsubscribe(ctx, &paho.Unsubscribe{Topics: []string{filter}})
+	return err
+}
+
+func (c *mqtt5) close() {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	c.cm.Disconnect(ctx)
+	c.cancel()
+}
@@ -0,0 +1,78 @@
+package broker
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"sistemas_distribuidos_gb/internal/embedded"
+)
+
+func TestTopicMapping(t *testing.T) {
+	for subject, topic := range map[string]string{
+		"sensors.plant.l1.s1.readings": "sensors/plant/l1/s1/readings",
+		"sensors.*.*.*.readings":       "sensors/+/+/+/readings",
+		"edge.>":                       "edge/#",
+	} {
+		if got := TopicFromSubject(subject); got != topic {
+			t.Errorf("TopicFromSubject(%q) = %q, want %q", subject, got, topic)
+		}
+	}
+	if got := SubjectFromTopic("sensors/plant/l1/s1/readings"); got != "sensors.plant.l1.s1.readings" {
+		t.Errorf("SubjectFromTopic = %q", got)
+	}
+}
+
+// TestMQTT publishes between two clients of an embedded MQTT server, with
+// both protocol versions.
+func TestMQTT(t *testing.T) {
+	srv, err := embedded.StartMQTT("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Shutdown()
+
+	for _, version := range []int{MQTT311, MQTT5} {
+		t.Run(fmt.Sprintf("v%d", version), func(t *testing.T) {
+			dial := func(id string) *MQTT {
+				m, err := DialMQTT(MQTTOptions{URL: srv.URL(), Version: version, ClientID: fmt.Sprintf("%s-v%d", id, version), QoS: 1})
+				if err != nil {
+					t.Fatal(err)
+				}
+				t.Cleanup(m.Close)
+				return m
+			}
+			pub, sub := dial("pub"), dial("sub")
+
+			type msg struct{ subject, data string }
+			got := make(chan msg, 10)
+			s, err := sub.Subscribe("sensors.*.*.*.readings", func(subject string, data []byte) {
+				got <- msg{subject, string(data)}
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := pub.Publish("sensors.plant.l1.s1.readings", []byte("42")); err != nil {
+				t.Fatal(err)
+			}
+			pub.Publish("sensors.plant.l1.s1.labels", []byte("ignored"))
+			select {
+			case m := <-got:
+				if m.subject != "sensors.plant.l1.s1.readings" || m.data != "42" {
+					t.Errorf("got %+v", m)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("message not delivered")
+			}
+
+			s.Unsubscribe()
+			pub.Publish("sensors.plant.l1.s1.readings", []byte("43"))
+			select {
+			case m := <-got:
+				t.Errorf("got %+v after unsubscribing", m)
+			case <-time.After(200 * time.Millisecond):
+			}
+		})
+	}
+}
@@ -0,0 +1,619 @@
+// Package cloud implements the cloud processor: it collects the filtered
+// readings, aggregates and alerts of every edge into global statistics,
+// notifies alerts, and hosts the sensor registry and the central config API.
+// Several processors can share the load as a cluster, see ClusterOptions.
+package cloud
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+
+	"sistemas_distribuidos_gb/internal/broker"
+	"sistemas_distribuidos_gb/internal/clock"
+	"sistemas_distribuidos_gb/internal/cluster"
+	"sistemas_distribuidos_gb/internal/config/settings"
+	"sistemas_distribuidos_gb/internal/export"
+	"sistemas_distribuidos_gb/internal/history"
+	"sistemas_distribuidos_gb/internal/notify"
+	"sistemas_distribuidos_gb/internal/registry"
+	"sistemas_distribuidos_gb/internal/subjects"
+)
+
+type FilteredReading struct {
+	SensorID  string  `json:"sensor_id"`
+	Site      string  `json:"site,omitempty"`
+	Line      string  `json:"line,omitempty"`
+	Location  string  `json:"location,omitempty"`
+	Unit      string  `json:"unit,omitempty"`
+	Value     float64 `json:"value"`
+	Timestamp int64   `json:"timestamp"`
+	EdgeID    string  `json:"edge_id"`
+}
+
+type Alert struct {
+	SensorID  string  `json:"sensor_id"`
+	Site      string  `json:"site,omitempty"`
+	Line      string  `json:"line,omitempty"`
+	Location  string  `json:"location,omitempty"`
+	Unit      string  `json:"unit,omitempty"`
+	Value     float64 `json:"value"`
+	Timestamp int64   `json:"timestamp"`
+	EdgeID    string  `json:"edge_id"`
+	Type      string  `json:"type"`
+	Message   string  `json:"message"`
+	Detector  string  `json:"detector,omitempty"`
+	Score     float64 `json:"score,omitempty"`
+	Baseline  float64 `json:"baseline,omitempty"`
+}
+
+type GlobalStats struct {
+	mu            sync.RWMutex
+	Readings      []float64       `json:"-"`
+	LastValue     float64         `json:"last_value"`
+	Alerts        []Alert         `json:"alerts"`
+	EdgeNodes     map[string]int  `json:"edge_nodes"`
+	TotalReadings int             `json:"total_readings"`
+	Sum           float64         `json:"sum"`
+	Min           float64         `json:"min"`
+	Max           float64         `json:"max"`
+	StartTime     time.Time       `json:"start_time"`
+	Latencies     []time.Duration `json:"-"`
+
+	lastTime    int64   // of LastValue, Unix milliseconds
+	latency     []int64 // histogram of every latency, see latencyBucket
+	latencySum  time.Duration
+	totalAlerts int64
+}
+
+// Options configure the cloud processor.
+type Options struct {
+	ID              string // used to look up its settings
+	StatsInterval   time.Duration
+	MaxReadings     int    // readings kept in memory
+	RegistryBackend string // kv (NATS KV bucket) or file
+	RegistryFile    string // used by the file backend or when KV is unavailable
+	UseConfig       bool
+
+	// Notify routes alerts to notification sinks; disabled without sinks
+	Notify notify.Config
+	// EdgeTimeout raises an edge_offline alert when an edge sends nothing
+	// for that long; zero disables the check
+	EdgeTimeout time.Duration
+	// HistoryDir keeps every filtered reading and alert for /export;
+	// disabled if empty. In a cluster each instance keeps the sensors of its
+	// partitions in a subdirectory named after it.
+	HistoryDir       string
+	HistoryRetention time.Duration
+
+	Cluster ClusterOptions
+
+	Clock clock.Clock // nil uses the wall clock
+}
+
+// DefaultOptions returns the options used by the cloud command by default.
+func DefaultOptions() Options {
+	return Options{
+		ID:               "cloud",
+		StatsInterval:    settings.DefaultCloud().StatsInterval.Std(),
+		MaxReadings:      10000,
+		RegistryBackend:  "kv",
+		RegistryFile:     "data/registry.json",
+		UseConfig:        true,
+		HistoryDir:       "data/cloud-history",
+		HistoryRetention: history.DefaultRetention,
+		Cluster: ClusterOptions{
+			Partitions: DefaultPartitions,
+			LeaseTTL:   cluster.DefaultTTL,
+		},
+	}
+}
+
+// Cloud is the cloud processor.
+type Cloud struct {
+	opts   Options
+	broker broker.Broker
+	clock  clock.Clock
+	stats  *GlobalStats
+	// registry is nil when the broker isn't backed by NATS
+	registry *registry.Service
+
+	settingsMu sync.RWMutex
+	settings   settings.Cloud
+	statsReset chan struct{} // tells the reporting loop that its interval changed
+
+	// configBucket is nil when JetStream isn't available
+	configBucket jetstream.KeyValue
+
+	// notifier is nil when no sinks are configured
+	notifier *notify.Notifier
+
+	// history is nil when disabled
+	history *history.Store
+
+	edgesMu  sync.Mutex
+	lastSeen map[string]time.Time // per edge
+	offline  map[string]bool
+
+	// group is nil when the processor isn't clustered
+	group      *cluster.Group
+	nc         *nats.Conn
+	js         jetstream.JetStream
+	baseline   Summary // shared before the last restart
+	clusterMu  sync.Mutex
+	partitions map[int]func() // owned, to what stops consuming them
+	peers      map[string]Summary
+	routed     chan routed // handed over, waiting for the stream to confirm
+	routeStop  chan struct{}
+	routeDone  chan struct{}
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	subs   []broker.Subscription
+}
+
+// New creates a cloud processor that consumes the edges' output through b.
+// The sensor registry and the config bucket need b to be backed by NATS.
+func New(b broker.Broker, opts Options) (*Cloud, error) {
+	if opts.ID == "" {
+		opts.ID = "cloud"
+	}
+	if opts.MaxReadings <= 0 || opts.StatsInterval <= 0 {
+		return nil, fmt.Errorf("max readings and stats interval must be positive")
+	}
+	if opts.EdgeTimeout < 0 {
+		return nil, fmt.Errorf("edge timeout can't be negative")
+	}
+	if opts.Cluster.Enabled {
+		if opts.Cluster.Instance == "" {
+			opts.Cluster.Instance = DefaultInstance()
+		}
+		if opts.Cluster.Partitions <= 0 {
+			opts.Cluster.Partitions = DefaultPartitions
+		}
+		if opts.HistoryDir != "" {
+			opts.HistoryDir = filepath.Join(opts.HistoryDir, opts.Cluster.Instance)
+		}
+	}
+	clk := clock.Or(opts.Clock)
+	var notifier *notify.Notifier
+	if len(opts.Notify.Sinks) > 0 {
+		var err error
+		if notifier, err = notify.New(opts.Notify, clk); err != nil {
+			return nil, fmt.Errorf("notifications: %w", err)
+		}
+	}
+	return &Cloud{
+		opts:   opts,
+		broker: b,
+		clock:  clk,
+		stats: &GlobalStats{
+			Readings:  make([]float64, 0, opts.MaxReadings),
+			Alerts:    make([]Alert, 0),
+			EdgeNodes: make(map[string]int),
+			Min:       math.Inf(1),
+			Max:       math.Inf(-1),
+			StartTime: clk.Now(),
+			Latencies: make([]time.Duration, 0),
+			latency:   make([]int64, latencyBuckets),
+		},
+		statsReset: make(chan struct{}, 1),
+		notifier:   notifier,
+		lastSeen:   make(map[string]time.Time),
+		offline:    make(map[string]bool),
+		partitions: make(map[int]func()),
+	}, nil
+}
+
+// Start opens the history, the registry and the config bucket, starts the
+// notifier, subscribes to the edge subjects and starts the statistics
+// reporter and the edge watchdog.
+func (c *Cloud) Start(ctx context.Context) error {
+	ctx, c.cancel = context.WithCancel(ctx)
+
+	if c.opts.HistoryDir != "" {
+		var err error
+		if c.history, err = history.Open(c.opts.HistoryDir, c.opts.HistoryRetention, c.clock); err != nil {
+			return fmt.Errorf("open history: %w", err)
+		}
+	}
+
+	if c.notifier != nil {
+		if err := c.notifier.Start(ctx); err != nil {
+			return err
+		}
+	}
+
+	if nc, err := broker.Conn(c.broker); err == nil {
+		store, err := openRegistryStore(nc, c.opts.RegistryBackend, c.opts.RegistryFile)
+		if err != nil {
+			return fmt.Errorf("open sensor registry: %w", err)
+		}
+		c.registry = registry.NewService(nc, store)
+		if err := c.registry.Start(); err != nil {
+			return fmt.Errorf("start sensor registry: %w", err)
+		}
+	} else {
+		log.Printf("Sensor registry disabled: %v", err)
+	}
+
+	// Load settings, from the config bucket if available
+	base := c.opts.settings()
+	if c.opts.UseConfig {
+		if err := c.startConfig(ctx, base); err != nil {
+			log.Printf("Central config unavailable, using flags: %v", err)
+		}
+	} else {
+		c.applySettings(base)
+	}
+
+	if c.opts.Cluster.Enabled {
+		nc, err := broker.Conn(c.broker)
+		if err == nil {
+			err = c.startCluster(ctx, nc)
+		}
+		if err != nil {
+			c.Stop()
+			return fmt.Errorf("cluster: %w", err)
+		}
+	} else {
+		for _, s := range []struct{ subject, kind string }{
+			{subjects.AllFiltered, "filtered"},
+			{subjects.AllAggregates, "aggregate"},
+			{subjects.AllAlerts, "alerts"},
+		} {
+			kind := s.kind
+			if err := c.subscribe(s.subject, func(_ string, data []byte) { c.handle(kind, data) }); err != nil {
+				c.Stop()
+				return err
+			}
+		}
+	}
+
+	// Start statistics reporter
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		ticker := c.clock.NewTicker(c.currentSettings().StatsInterval.Std())
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C():
+				if c.isLeader() {
+					report(c.summary(), c.clock.Now())
+				}
+			case <-c.statsReset:
+				ticker.Reset(c.currentSettings().StatsInterval.Std())
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	if c.opts.EdgeTimeout > 0 {
+		c.wg.Add(1)
+		go func() {
+			defer c.wg.Done()
+			c.watchEdges(ctx)
+		}()
+	}
+
+	log.Printf("Cloud Processor started, listening to %s", subjects.AllEdge)
+	return nil
+}
+
+// handle processes an edge message of the given kind: filtered, aggregate
+// or alerts.
+func (c *Cloud) handle(kind string, data []byte) {
+	switch kind {
+	case "filtered":
+		var filtered FilteredReading
+		if err := json.Unmarshal(data, &filtered); err != nil {
+			// Ignore non-reading payloads on this subject
+			return
+		}
+		c.edgeSeen(filtered.EdgeID)
+		processFilteredReading(filtered, c.stats, c.clock.Now())
+		if c.history != nil {
+			if err := c.history.AddReading(history.Reading(filtered)); err != nil {
+				log.Printf("Error storing reading history: %v", err)
+			}
+		}
+	case "aggregate":
+		var agg map[string]interface{}
+		if err := json.Unmarshal(data, &agg); err != nil {
+			return
+		}
+		if edgeID, ok := agg["edge_id"].(string); ok {
+			c.edgeSeen(edgeID)
+		}
+		processAggregate(agg, c.stats)
+	case "alerts":
+		var alert Alert
+		if err := json.Unmarshal(data, &alert); err != nil {
+			log.Printf("Error unmarshaling alert: %v", err)
+			return
+		}
+		c.edgeSeen(alert.EdgeID)
+		c.processAlert(alert)
+	}
+}
+
+func (c *Cloud) subscribe(subject string, handler broker.Handler) error {
+	sub, err := c.broker.Subscribe(subject, handler)
+	if err != nil {
+		return fmt.Errorf("failed to subscribe to %s: %w", subject, err)
+	}
+	c.subs = append(c.subs, sub)
+	return nil
+}
+
+// Stop unsubscribes, stops the registry and the notifier, waits for the
+// background loops to finish, leaves the cluster and closes the history.
+func (c *Cloud) Stop() {
+	if c.cancel != nil {
+		c.cancel()
+	}
+	for _, sub := range c.subs {
+		sub.Unsubscribe()
+	}
+	c.subs = nil
+	if c.registry != nil {
+		c.registry.Stop()
+	}
+	c.wg.Wait()
+	if c.group != nil {
+		c.stopCluster()
+	}
+	if c.notifier != nil {
+		c.notifier.Stop()
+	}
+	if c.history != nil {
+		if err := c.history.Close(); err != nil {
+			log.Printf("Error closing history: %v", err)
+		}
+	}
+}
+
+// Handler returns the cloud HTTP API: /health, /config, /sensors, /stats,
+// /notifications, /export and /cluster.
+func (c *Cloud) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("OK"))
+	})
+
+	mux.HandleFunc("/config", c.handleConfigList)
+	mux.HandleFunc("/config/", c.handleConfig)
+
+	mux.HandleFunc("/sensors", c.handleSensors)
+	mux.HandleFunc("/sensors/", c.handleSensor)
+
+	mux.HandleFunc("/notifications", c.handleNotifications)
+
+	mux.HandleFunc("/export", c.handleExport)
+
+	mux.HandleFunc("/cluster", c.handleCluster)
+
+	// The statistics of the whole cluster, when clustered
+	mux.HandleFunc("/stats", func(w http.ResponseWriter, r *http.Request) {
+		s := c.summary()
+		uptime := c.clock.Since(s.Start)
+		edges := make(map[string]int, len(s.EdgeReadings))
+		for edge, n := range s.EdgeReadings {
+			edges[edge] = int(n)
+		}
+		alerts := s.Alerts
+		if alerts == nil {
+			alerts = []Alert{}
+		}
+
+		display := struct {
+			LastValue      float64        `json:"last_value"`
+			Alerts         []Alert        `json:"alerts"`
+			EdgeNodes      map[string]int `json:"edge_nodes"`
+			TotalReadings  int64          `json:"total_readings"`
+			Sum            float64        `json:"sum"`
+			Min            float64        `json:"min"`
+			Max            float64        `json:"max"`
+			StartTime      time.Time      `json:"start_time"`
+			Mean           float64        `json:"mean"`
+			StdDev         float64        `json:"std_dev"`
+			Uptime         string         `json:"uptime"`
+			UptimeSeconds  float64        `json:"uptime_seconds"`
+			ReadingsPerSec float64        `json:"readings_per_sec"`
+			TotalAlerts    int64          `json:"total_alerts"`
+		}{
+			LastValue:      s.LastValue,
+			Alerts:         alerts,
+			EdgeNodes:      edges,
+			TotalReadings:  s.Readings,
+			Sum:            s.Sum,
+			Min:            s.Min,
+			Max:            s.Max,
+			StartTime:      s.Start,
+			Mean:           s.Mean(),
+			StdDev:         s.StdDev(),
+			Uptime:         formatDuration(uptime),
+			UptimeSeconds:  uptime.Seconds(),
+			ReadingsPerSec: float64(s.Readings) / uptime.Seconds(),
+			TotalAlerts:    s.TotalAlerts,
+		}
+		writeJSON(w, http.StatusOK, display)
+	})
+	return mux
+}
+
+// handleExport streams the stored readings or alerts of a time range as a
+// CSV, JSON lines or Parquet file, see export.Serve. A cluster instance only
+// has the history of its partitions, so it asks to be named in ?instance=,
+// rather than pass that off as the whole export.
+func (c *Cloud) handleExport(w http.ResponseWriter, r *http.Request) {
+	if c.history == nil {
+		http.Error(w, "history disabled", http.StatusServiceUnavailable)
+		return
+	}
+	if c.group != nil && r.URL.Query().Get("instance") != c.group.ID() {
+		http.Error(w, fmt.Sprintf("this is instance %s of a cloud cluster, whose history only has the sensors of its partitions: "+
+			"export from every instance, with ?instance=<its name>", c.group.ID()), http.StatusConflict)
+		return
+	}
+	export.Serve(w, r, c.history, c.clock.Now())
+}
+
+func processFilteredReading(reading FilteredReading, stats *GlobalStats, now time.Time) {
+	latency := time.Duration(now.UnixMilli()-reading.Timestamp) * time.Millisecond
+
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+
+	stats.TotalReadings++
+	stats.Sum += reading.Value
+	if reading.Timestamp >= stats.lastTime {
+		stats.LastValue, stats.lastTime = reading.Value, reading.Timestamp
+	}
+	if reading.Value < stats.Min {
+		stats.Min = reading.Value
+	}
+	if reading.Value > stats.Max {
+		stats.Max = reading.Value
+	}
+
+	// Keep readings in sliding window
+	if len(stats.Readings) >= cap(stats.Readings) {
+		stats.Readings = stats.Readings[1:]
+	}
+	stats.Readings = append(stats.Readings, reading.Value)
+
+	// Track edge nodes
+	stats.EdgeNodes[reading.EdgeID]++
+
+	// Track latencies
+	stats.latency[latencyBucket(latency)]++
+	stats.latencySum += latency
+	stats.Latencies = append(stats.Latencies, latency)
+	if len(stats.Latencies) > 10000 {
+		stats.Latencies = stats.Latencies[1:]
+	}
+}
+
+func processAggregate(agg map[string]interface{}, stats *GlobalStats) {
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+
+	if edgeID, ok := agg["edge_id"].(string); ok {
+		if count, ok := agg["count"].(float64); ok {
+			stats.EdgeNodes[edgeID] += int(count)
+		}
+	}
+}
+
+func (c *Cloud) processAlert(alert Alert) {
+	stats := c.stats
+
+	// Label alerts from edges that don't use the registry themselves
+	if alert.Location == "" && alert.SensorID != "" && c.registry != nil {
+		if sensor, err := c.registry.Get(context.Background(), alert.SensorID); err == nil {
+			alert.Location = sensor.Location
+		}
+	}
+
+	stats.mu.Lock()
+	stats.totalAlerts++
+	stats.Alerts = append(stats.Alerts, alert)
+	if len(stats.Alerts) > 1000 {
+		stats.Alerts = stats.Alerts[1:]
+	}
+	stats.mu.Unlock()
+
+	if c.notifier != nil {
+		c.notifier.Notify(notify.Alert(alert))
+	}
+	if c.history != nil {
+		if err := c.history.AddAlert(history.Alert(alert)); err != nil {
+			log.Printf("Error storing alert history: %v", err)
+		}
+	}
+
+	log.Printf("Alert received: sensor_id=%s, location=%s, edge_id=%s, value=%.2f, message=%s",
+		alert.SensorID, alert.Location, alert.EdgeID, alert.Value, alert.Message)
+}
+
+// summary returns the statistics as a Summary.
+func (s *GlobalStats) summary() Summary {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	sum := Summary{
+		Readings:     int64(s.TotalReadings),
+		Sum:          s.Sum,
+		LastValue:    s.LastValue,
+		LastTime:     s.lastTime,
+		Window:       int64(len(s.Readings)),
+		EdgeReadings: make(map[string]int64, len(s.EdgeNodes)),
+		Latency:      append([]int64(nil), s.latency...),
+		LatencySum:   s.latencySum,
+		TotalAlerts:  s.totalAlerts,
+		Alerts:       append([]Alert(nil), s.Alerts...),
+		Start:        s.StartTime,
+	}
+	if s.TotalReadings > 0 {
+		sum.Min, sum.Max = s.Min, s.Max
+	}
+	for _, v := range s.Readings {
+		sum.WindowSum += v
+		sum.WindowSumSq += v * v
+	}
+	for edge, n := range s.EdgeNodes {
+		sum.EdgeReadings[edge] = int64(n)
+	}
+	return sum
+}
+
+func report(s Summary, now time.Time) {
+	if s.Readings == 0 {
+		log.Println("No readings received yet")
+		return
+	}
+
+	uptime := now.Sub(s.Start)
+	rate := float64(s.Readings) / uptime.Seconds()
+
+	log.Println("=== GLOBAL STATISTICS ===")
+	log.Printf("Uptime: %v", uptime)
+	log.Printf("Total Readings: %d", s.Readings)
+	log.Printf("Readings/sec: %.2f", rate)
+	log.Printf("Mean: %.2f", s.Mean())
+	log.Printf("Std Dev: %.2f", s.StdDev())
+	log.Printf("Min: %.2f", s.Min)
+	log.Printf("Max: %.2f", s.Max)
+	log.Printf("Active Edge Nodes: %d", len(s.EdgeReadings))
+	log.Printf("Total Alerts: %d", s.TotalAlerts)
+	log.Printf("Latency - Avg: %v, P95: %v, P99: %v", s.AvgLatency(), s.LatencyPercentile(95), s.LatencyPercentile(99))
+
+	// Edge node breakdown
+	for edgeID, count := range s.EdgeReadings {
+		log.Printf("  Edge %s: %d readings", edgeID, count)
+	}
+	log.Println("=========================")
+}
+
+func formatDuration(d time.Duration) string {
+	h := d / time.Hour
+	d -= h * time.Hour
+	m := d / time.Minute
+	d -= m * time.Minute
+	s := d / time.Second
+	return fmt.Sprintf("%02d:%02d:%02d", h, m, s)
+}
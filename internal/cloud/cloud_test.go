@@ -0,0 +1,297 @@
+package cloud
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+
+	"sistemas_distribuidos_gb/internal/broker"
+	"sistemas_distribuidos_gb/internal/clock"
+	"sistemas_distribuidos_gb/internal/embedded"
+	"sistemas_distribuidos_gb/internal/notify"
+	"sistemas_distribuidos_gb/internal/subjects"
+)
+
+// TestCloudStats feeds the cloud through an in-process broker and checks the
+// statistics it serves.
+func TestCloudStats(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	b := broker.NewMemory()
+	clk := clock.NewFake(time.Date(2024, 1, 1, 8, 0, 0, 0, time.UTC))
+	opts := DefaultOptions()
+	opts.UseConfig = false
+	opts.Clock = clk
+	opts.HistoryDir = t.TempDir()
+	c, err := New(b, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+
+	sent := clk.Now().UnixMilli()
+	clk.Advance(10 * time.Second)
+	for i, v := range []float64{40, 50, 60} {
+		edgeID := []string{"edge-1", "edge-2", "edge-1"}[i]
+		data, _ := json.Marshal(FilteredReading{SensorID: "s1", Value: v, Timestamp: sent, EdgeID: edgeID})
+		b.Publish(subjects.Filtered(edgeID), data)
+	}
+	data, _ := json.Marshal(Alert{SensorID: "s1", Value: 60, EdgeID: "edge-1", Type: "warning"})
+	b.Publish(subjects.Alerts("edge-1"), data)
+
+	srv := httptest.NewServer(c.Handler())
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/stats")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var stats struct {
+		TotalReadings  int            `json:"total_readings"`
+		Mean           float64        `json:"mean"`
+		Min            float64        `json:"min"`
+		Max            float64        `json:"max"`
+		EdgeNodes      map[string]int `json:"edge_nodes"`
+		TotalAlerts    int            `json:"total_alerts"`
+		UptimeSeconds  float64        `json:"uptime_seconds"`
+		ReadingsPerSec float64        `json:"readings_per_sec"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&stats); err != nil {
+		t.Fatal(err)
+	}
+	if stats.TotalReadings != 3 || stats.Mean != 50 || stats.Min != 40 || stats.Max != 60 {
+		t.Errorf("unexpected reading statistics %+v", stats)
+	}
+	if stats.EdgeNodes["edge-1"] != 2 || stats.EdgeNodes["edge-2"] != 1 || stats.TotalAlerts != 1 {
+		t.Errorf("unexpected edge and alert counts %+v", stats)
+	}
+	if stats.UptimeSeconds != 10 || stats.ReadingsPerSec != 0.3 {
+		t.Errorf("uptime %vs at %v readings/s, want 10s at 0.3 from the fake clock", stats.UptimeSeconds, stats.ReadingsPerSec)
+	}
+
+	c.stats.mu.RLock()
+	latency := c.stats.Latencies[0]
+	c.stats.mu.RUnlock()
+	if latency != 10*time.Second {
+		t.Errorf("latency %v, want 10s from the fake clock", latency)
+	}
+
+	// The history keeps the records, for exports; the alert was stored at
+	// the time it arrived, the end of the range until the clock moves
+	clk.Advance(time.Second)
+	resp, err = http.Get(srv.URL + "/export?range=1m&format=csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	exported, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if lines := strings.Split(strings.TrimSpace(string(exported)), "\n"); len(lines) != 4 || lines[3] != "2024-01-01T08:00:00.000Z,s1,edge-1,,,,,60" {
+		t.Errorf("exported readings:\n%s", exported)
+	}
+	resp, err = http.Get(srv.URL + "/export?range=1m&format=jsonl&kind=alerts&edge=edge-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	exported, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(exported), `"type":"warning"`) || strings.Count(string(exported), "\n") != 1 {
+		t.Errorf("exported alerts:\n%s", exported)
+	}
+
+	// The registry needs NATS
+	resp, err = http.Get(srv.URL + "/sensors")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("GET /sensors without NATS: %s, want 503", resp.Status)
+	}
+}
+
+// TestNotifications checks that edge alerts and edge_offline alerts reach a
+// webhook sink.
+func TestNotifications(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	received := make(chan Alert, 4)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var a Alert
+		json.NewDecoder(r.Body).Decode(&a)
+		received <- a
+	}))
+	defer hook.Close()
+
+	b := broker.NewMemory()
+	clk := clock.NewFake(time.Date(2024, 1, 1, 8, 0, 0, 0, time.UTC))
+	opts := DefaultOptions()
+	opts.UseConfig = false
+	opts.Clock = clk
+	opts.HistoryDir = ""
+	opts.EdgeTimeout = 30 * time.Second
+	opts.Notify = notify.Config{
+		Sinks:  []notify.SinkConfig{{Name: "hook", Type: notify.Webhook, URL: hook.URL}},
+		Routes: []notify.Route{{Sinks: []string{"hook"}, Severity: []string{"critical", "edge_offline"}}},
+	}
+	c, err := New(b, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+
+	next := func() Alert {
+		t.Helper()
+		select {
+		case a := <-received:
+			return a
+		case <-time.After(5 * time.Second):
+			t.Fatal("no notification")
+			return Alert{}
+		}
+	}
+
+	for _, typ := range []string{"warning", "critical"} {
+		data, _ := json.Marshal(Alert{SensorID: "s1", Value: 99, EdgeID: "edge-1", Type: typ})
+		b.Publish(subjects.Alerts("edge-1"), data)
+	}
+	if a := next(); a.Type != "critical" || a.SensorID != "s1" {
+		t.Errorf("got %+v, want the critical alert", a)
+	}
+
+	clk.Advance(time.Minute)
+	if a := next(); a.Type != "edge_offline" || a.EdgeID != "edge-1" {
+		t.Errorf("got %+v, want edge-1 offline", a)
+	}
+
+	srv := httptest.NewServer(c.Handler())
+	defer srv.Close()
+	// The log entry is written once the webhook has answered
+	var entries []notify.Entry
+	for deadline := time.Now().Add(5 * time.Second); len(entries) < 2 && time.Now().Before(deadline); {
+		resp, err := http.Get(srv.URL + "/notifications")
+		if err != nil {
+			t.Fatal(err)
+		}
+		json.NewDecoder(resp.Body).Decode(&entries)
+		resp.Body.Close()
+		time.Sleep(5 * time.Millisecond)
+	}
+	if len(entries) != 2 || entries[0].Status != notify.StatusSent || entries[1].Status != notify.StatusSent {
+		t.Errorf("notification log %+v, want 2 sent", entries)
+	}
+
+	// Routes from the central config replace those of the file
+	settings := c.currentSettings()
+	settings.NotifyRoutes = []notify.Route{{Sinks: []string{"hook"}, Severity: []string{"warning"}}}
+	c.applySettings(settings)
+	for _, typ := range []string{"critical", "warning"} {
+		data, _ := json.Marshal(Alert{SensorID: "s2", Value: 99, EdgeID: "edge-1", Type: typ})
+		b.Publish(subjects.Alerts("edge-1"), data)
+	}
+	if a := next(); a.Type != "warning" || a.SensorID != "s2" {
+		t.Errorf("got %+v, want the warning alert", a)
+	}
+}
+
+// TestConfigAPI checks that settings documents are validated against their
+// component's settings before they're stored.
+func TestConfigAPI(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	ns, err := embedded.Start(embedded.Options{JetStream: true, StoreDir: filepath.Join(t.TempDir(), "jetstream")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ns.Shutdown()
+	nc, err := nats.Connect(ns.ClientURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nc.Close()
+	opts := DefaultOptions()
+	opts.HistoryDir = t.TempDir()
+	opts.RegistryFile = filepath.Join(t.TempDir(), "registry.json")
+	c, err := New(broker.NewNATS(nc), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	srv := httptest.NewServer(c.Handler())
+	defer srv.Close()
+
+	put := func(key, doc string) int {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodPut, srv.URL+"/config/"+key, strings.NewReader(doc))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Logf("PUT %s: %s", key, body)
+		}
+		return resp.StatusCode
+	}
+	for _, tc := range []struct {
+		key, doc string
+		want     int
+	}{
+		{"edge.defaults", `{"noise_filter": 2.5}`, http.StatusOK},
+		{"edge.edge-1", `{"aggregate_interval": "10s"}`, http.StatusOK},
+		{"sensor.defaults", `{"interval": "500ms", "spike": 0.01}`, http.StatusOK},
+		{"dashboard.dashboard", `{"max_alerts": 50}`, http.StatusOK},
+		{"cloud.defaults", `{"stats_interval": "30s"}`, http.StatusOK},
+		{"gateway.defaults", `{}`, http.StatusBadRequest},
+		{"edge.defaults", `{"noise_filtr": 2}`, http.StatusBadRequest},
+		{"edge.edge-1", `{"aggregate_interval": 10}`, http.StatusBadRequest},
+		{"edge.edge-1", `{"thresholds": {"warning_min": 90, "warning_max": 60, "critical_min": 0, "critical_max": 100}}`, http.StatusBadRequest},
+		{"edge.edge-1", `{"detectors": {"enabled": ["magic"]}}`, http.StatusBadRequest},
+		{"sensor.s1", `{"anomaly": 2}`, http.StatusBadRequest},
+		{"dashboard.defaults", `{"max_readings": 0}`, http.StatusBadRequest},
+		{"cloud.defaults", `{"stats_interval": "-1s"}`, http.StatusBadRequest},
+	} {
+		if got := put(tc.key, tc.doc); got != tc.want {
+			t.Errorf("PUT /config/%s %s: %d, want %d", tc.key, tc.doc, got, tc.want)
+		}
+	}
+
+	// An instance document is checked over the stored defaults
+	if got := put("edge.defaults", `{"thresholds": {"warning_min": 10, "warning_max": 20, "critical_min": 0, "critical_max": 30}}`); got != http.StatusOK {
+		t.Fatalf("PUT edge.defaults: %d", got)
+	}
+	if got := put("edge.edge-2", `{"thresholds": {"warning_min": 10, "warning_max": 20, "critical_min": 0, "critical_max": 15}}`); got != http.StatusBadRequest {
+		t.Errorf("PUT of thresholds invalid on their own: %d", got)
+	}
+	resp, err := http.Get(srv.URL + "/config/edge.edge-2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("a rejected document was stored: GET %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,470 @@
+package cloud
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+
+	"sistemas_distribuidos_gb/internal/cluster"
+	"sistemas_distribuidos_gb/internal/subjects"
+)
+
+// With Options.Cluster, several cloud processes share the edges' output.
+// They read the edge subjects as one queue group, so each message reaches
+// one of them, which hands it to the owner of its partition, the hash of the
+// sensor ID (or of the edge ID, for aggregates), on cloud.partition.<n>,
+// through PartitionStream: a partition changing owners is resumed where it
+// was left instead of losing what arrived in between, and delivery is at
+// least once.
+// Partitions are leases of the cloud-cluster bucket, spread evenly over the
+// live instances and taken over when one dies. The leader lease runs what
+// must happen once: the statistics report and the edge_offline rule. Every
+// instance shares its Summary in the bucket and serves the merge of all.
+
+const (
+	// DefaultPartitions is how many partitions the sensors are hashed into.
+	DefaultPartitions = 16
+	// ClusterBucket holds the members, leases and summaries of the cluster.
+	ClusterBucket = "cloud-cluster"
+	// PartitionStream keeps the messages handed to the partition owners.
+	PartitionStream = "CLOUD_PARTITIONS"
+
+	leaderLease = "leader"
+
+	// routeMaxPending bounds the messages handed over to the partitions that
+	// the stream hasn't confirmed yet; past it route waits. routeAckWait is
+	// how long a confirmation may take before the message is sent again.
+	routeMaxPending = 256
+	routeAckWait    = 5 * time.Second
+)
+
+// ClusterOptions run the cloud as one instance of a cluster.
+type ClusterOptions struct {
+	Enabled bool
+	// Instance names this process in the cluster: unique, and the same
+	// across restarts, so that it carries on from its last summary.
+	// DefaultInstance() if empty.
+	Instance   string
+	Partitions int           // DefaultPartitions if zero
+	LeaseTTL   time.Duration // cluster.DefaultTTL if zero
+}
+
+// DefaultInstance returns the host name, made a valid instance name.
+func DefaultInstance() string {
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		return "cloud"
+	}
+	return regexp.MustCompile(`[^-_=a-zA-Z0-9]`).ReplaceAllString(host, "-")
+}
+
+func partitionOf(key string, partitions int) int {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % uint32(partitions))
+}
+
+func partitionLease(p int) string {
+	return "partition-" + strconv.Itoa(p)
+}
+
+// startCluster joins the cluster, takes its share of the partitions and
+// starts reading the edge subjects in the queue group.
+func (c *Cloud) startCluster(ctx context.Context, nc *nats.Conn) error {
+	js, err := jetstream.New(nc, jetstream.WithPublishAsyncMaxPending(routeMaxPending))
+	if err != nil {
+		return err
+	}
+	c.nc, c.js = nc, js
+	c.group, err = cluster.Join(ctx, js, cluster.Options{
+		Bucket: ClusterBucket,
+		ID:     c.opts.Cluster.Instance,
+		TTL:    c.opts.Cluster.LeaseTTL,
+		Clock:  c.clock,
+	})
+	if err != nil {
+		return err
+	}
+
+	// A restarted instance carries on from the summary it shared last
+	if states, err := c.group.Shared(ctx); err == nil {
+		if data, ok := states[c.group.ID()]; ok && json.Unmarshal(data, &c.baseline) == nil {
+			log.Printf("Resuming from the summary of %d readings shared before the restart", c.baseline.Readings)
+		}
+	}
+
+	_, err = js.CreateOrUpdateStream(ctx, jetstream.StreamConfig{
+		Name:        PartitionStream,
+		Description: "Edge output handed to the owners of the cloud partitions",
+		Subjects:    []string{subjects.AllCloudPartitions},
+		Retention:   jetstream.WorkQueuePolicy,
+		MaxAge:      24 * time.Hour,
+	})
+	if err != nil {
+		return fmt.Errorf("create partition stream: %w", err)
+	}
+
+	c.coordinate(ctx)
+	c.routed = make(chan routed, routeMaxPending)
+	c.routeStop, c.routeDone = make(chan struct{}), make(chan struct{})
+	go c.confirmRoutes()
+	for _, s := range []struct{ subject, kind string }{
+		{subjects.AllFiltered, "filtered"},
+		{subjects.AllAggregates, "aggregate"},
+		{subjects.AllAlerts, "alerts"},
+	} {
+		kind := s.kind
+		sub, err := nc.QueueSubscribe(s.subject, "cloud-"+c.opts.ID, func(msg *nats.Msg) {
+			c.route(kind, msg.Data)
+		})
+		if err != nil {
+			return fmt.Errorf("failed to subscribe to %s: %w", s.subject, err)
+		}
+		c.subs = append(c.subs, sub)
+	}
+
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		ticker := c.clock.NewTicker(c.group.TTL() / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C():
+				c.coordinate(ctx)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	log.Printf("Cloud instance %s joined the cluster with partitions %v", c.group.ID(), c.ownedPartitions())
+	return nil
+}
+
+// stopCluster stops consuming the partitions, shares the final summary and
+// leaves the cluster, so the others take over at once.
+func (c *Cloud) stopCluster() {
+	if c.routeStop != nil {
+		if !c.nc.IsClosed() {
+			select {
+			case <-c.js.PublishAsyncComplete():
+			case <-time.After(routeAckWait):
+			}
+		}
+		close(c.routeStop)
+		<-c.routeDone
+	}
+	for _, p := range c.ownedPartitions() {
+		c.stopPartition(p)
+	}
+	if c.nc.IsClosed() {
+		return // the others take over once the leases expire
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if data, err := json.Marshal(c.localSummary()); err == nil {
+		c.group.Share(ctx, data)
+	}
+	if err := c.group.Leave(ctx); err != nil {
+		log.Printf("Error leaving the cluster: %v", err)
+	}
+}
+
+// routed is a message handed over to a partition.
+type routed struct {
+	kind      string
+	partition int
+	ack       jetstream.PubAckFuture
+}
+
+// route hands an edge message to the owner of its partition. It doesn't
+// wait for the stream to confirm it, which confirmRoutes does, so the queue
+// group isn't held back by a round trip per message.
+func (c *Cloud) route(kind string, data []byte) {
+	var msg struct {
+		SensorID string `json:"sensor_id"`
+		EdgeID   string `json:"edge_id"`
+	}
+	if json.Unmarshal(data, &msg) != nil {
+		return
+	}
+	key := msg.SensorID
+	if key == "" {
+		key = msg.EdgeID
+	}
+	p := partitionOf(key, c.opts.Cluster.Partitions)
+	ack, err := c.js.PublishAsync(subjects.CloudPartition(p, kind), data)
+	if err != nil {
+		log.Printf("Error handing %s over to partition %d: %v", kind, p, err)
+		return
+	}
+	select {
+	case c.routed <- routed{kind: kind, partition: p, ack: ack}:
+	case <-c.routeStop:
+	}
+}
+
+// confirmRoutes waits for the stream to confirm the messages route handed
+// over, and sends again, once, those it rejects or doesn't confirm within
+// routeAckWait. Once stopped, it only counts those left unconfirmed.
+func (c *Cloud) confirmRoutes() {
+	defer close(c.routeDone)
+	for {
+		select {
+		case r := <-c.routed:
+			c.confirmRoute(r)
+		case <-c.routeStop:
+			unconfirmed := 0
+			for len(c.routed) > 0 {
+				r := <-c.routed
+				select {
+				case <-r.ack.Ok():
+				default:
+					unconfirmed++
+				}
+			}
+			if unconfirmed > 0 {
+				log.Printf("%d messages handed over to the partitions weren't confirmed", unconfirmed)
+			}
+			return
+		}
+	}
+}
+
+func (c *Cloud) confirmRoute(r routed) {
+	var err error
+	select {
+	case <-r.ack.Ok():
+		return
+	case err = <-r.ack.Err():
+	case <-time.After(routeAckWait):
+		err = errors.New("no confirmation")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), routeAckWait)
+	defer cancel()
+	if _, retryErr := c.js.PublishMsg(ctx, r.ack.Msg()); retryErr != nil {
+		log.Printf("Error handing %s over to partition %d: %v (retry: %v)", r.kind, r.partition, err, retryErr)
+	}
+}
+
+// coordinate renews the instance's membership and leases, tries to become
+// the leader, evens out the partitions over the live instances, shares the
+// instance's summary and reads the others'.
+func (c *Cloud) coordinate(ctx context.Context) {
+	g := c.group
+	lost, err := g.Heartbeat(ctx)
+	for _, name := range lost {
+		if name == leaderLease {
+			log.Printf("Lost the cluster leadership")
+		} else if p, ok := strings.CutPrefix(name, "partition-"); ok {
+			n, _ := strconv.Atoi(p)
+			c.stopPartition(n)
+			log.Printf("Lost partition %d to another instance", n)
+		}
+	}
+	if err != nil {
+		log.Printf("Cluster heartbeat failed: %v", err)
+		c.dropExpired()
+		return
+	}
+
+	if !g.Holds(leaderLease) {
+		if ok, err := g.Acquire(ctx, leaderLease); err == nil && ok {
+			log.Printf("Cloud instance %s is now the cluster leader", g.ID())
+		}
+	}
+
+	members, err := g.Members(ctx)
+	if err != nil || len(members) == 0 {
+		members = []string{g.ID()}
+	}
+	n := c.opts.Cluster.Partitions
+	target := (n + len(members) - 1) / len(members)
+	owned := c.ownedPartitions()
+	for len(owned) > target {
+		p := owned[len(owned)-1]
+		owned = owned[:len(owned)-1]
+		c.stopPartition(p)
+		if err := g.Release(ctx, partitionLease(p)); err != nil {
+			log.Printf("Error releasing partition %d: %v", p, err)
+		}
+	}
+	// Each instance starts looking at a different offset, so they don't all
+	// race for the same free partitions
+	offset := sort.SearchStrings(members, g.ID()) * target
+	for i := 0; i < n && len(owned) < target; i++ {
+		p := (offset + i) % n
+		if c.ownsPartition(p) {
+			continue
+		}
+		ok, err := g.Acquire(ctx, partitionLease(p))
+		if err != nil {
+			log.Printf("Error acquiring partition %d: %v", p, err)
+			break
+		}
+		if !ok {
+			continue
+		}
+		if err := c.startPartition(ctx, p); err != nil {
+			log.Printf("Error consuming partition %d: %v", p, err)
+			g.Release(ctx, partitionLease(p))
+			continue
+		}
+		owned = append(owned, p)
+	}
+
+	if data, err := json.Marshal(c.localSummary()); err == nil {
+		if err := g.Share(ctx, data); err != nil {
+			log.Printf("Error sharing the summary: %v", err)
+		}
+	}
+	states, err := g.Shared(ctx)
+	if err != nil {
+		return
+	}
+	peers := make(map[string]Summary, len(states))
+	for id, data := range states {
+		var s Summary
+		if id != g.ID() && json.Unmarshal(data, &s) == nil {
+			peers[id] = s
+		}
+	}
+	c.clusterMu.Lock()
+	c.peers = peers
+	c.clusterMu.Unlock()
+}
+
+// dropExpired stops the partitions whose lease ran out without being
+// renewed, which others may have taken over by now. The leadership needs no
+// stopping: isLeader asks the lease.
+func (c *Cloud) dropExpired() {
+	for _, p := range c.ownedPartitions() {
+		if !c.group.Holds(partitionLease(p)) {
+			c.stopPartition(p)
+			log.Printf("Partition %d expired without a heartbeat", p)
+		}
+	}
+}
+
+// startPartition starts handling the messages of partition p.
+func (c *Cloud) startPartition(ctx context.Context, p int) error {
+	consumer, err := c.js.CreateOrUpdateConsumer(ctx, PartitionStream, jetstream.ConsumerConfig{
+		Durable:       fmt.Sprintf("cloud-p%d", p),
+		FilterSubject: subjects.CloudPartition(p, "*"),
+		AckPolicy:     jetstream.AckExplicitPolicy,
+		// What a dead owner had fetched comes back once it's taken over
+		AckWait: c.group.TTL(),
+	})
+	if err != nil {
+		return err
+	}
+	cc, err := consumer.Consume(func(msg jetstream.Msg) {
+		subject := msg.Subject()
+		c.handle(subject[strings.LastIndexByte(subject, '.')+1:], msg.Data())
+		msg.Ack()
+	})
+	if err != nil {
+		return err
+	}
+	c.clusterMu.Lock()
+	c.partitions[p] = cc.Stop
+	c.clusterMu.Unlock()
+	return nil
+}
+
+func (c *Cloud) stopPartition(p int) {
+	c.clusterMu.Lock()
+	stop, ok := c.partitions[p]
+	delete(c.partitions, p)
+	c.clusterMu.Unlock()
+	if ok {
+		stop()
+	}
+}
+
+func (c *Cloud) ownsPartition(p int) bool {
+	c.clusterMu.Lock()
+	defer c.clusterMu.Unlock()
+	_, ok := c.partitions[p]
+	return ok
+}
+
+// ownedPartitions returns the partitions the instance handles, sorted.
+func (c *Cloud) ownedPartitions() []int {
+	c.clusterMu.Lock()
+	defer c.clusterMu.Unlock()
+	owned := make([]int, 0, len(c.partitions))
+	for p := range c.partitions {
+		owned = append(owned, p)
+	}
+	sort.Ints(owned)
+	return owned
+}
+
+// isLeader reports whether the instance runs the once-per-cluster tasks:
+// always when it isn't clustered.
+func (c *Cloud) isLeader() bool {
+	return c.group == nil || c.group.Holds(leaderLease)
+}
+
+// localSummary returns the summary of what this instance handled, since
+// before its restarts.
+func (c *Cloud) localSummary() Summary {
+	s := c.stats.summary()
+	c.edgesMu.Lock()
+	for edge, t := range c.lastSeen {
+		if s.EdgeSeen == nil {
+			s.EdgeSeen = make(map[string]int64)
+		}
+		s.EdgeSeen[edge] = t.UnixMilli()
+	}
+	c.edgesMu.Unlock()
+	s.Merge(c.baseline)
+	return s
+}
+
+// summary returns the statistics of the whole cluster: the local summary
+// merged with the latest ones shared by the other instances, gone ones
+// included.
+func (c *Cloud) summary() Summary {
+	s := c.localSummary()
+	c.clusterMu.Lock()
+	defer c.clusterMu.Unlock()
+	for _, peer := range c.peers {
+		s.Merge(peer)
+	}
+	return s
+}
+
+// handleCluster serves the instance's view of the cluster.
+func (c *Cloud) handleCluster(w http.ResponseWriter, r *http.Request) {
+	if c.group == nil {
+		http.Error(w, "not clustered", http.StatusServiceUnavailable)
+		return
+	}
+	members, err := c.group.Members(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	leader, _ := c.group.Owner(r.Context(), leaderLease)
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"instance":   c.group.ID(),
+		"leader":     leader,
+		"members":    members,
+		"partitions": c.ownedPartitions(),
+	})
+}
@@ -0,0 +1,230 @@
+package cloud
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+
+	"sistemas_distribuidos_gb/internal/broker"
+	"sistemas_distribuidos_gb/internal/embedded"
+	"sistemas_distribuidos_gb/internal/subjects"
+)
+
+// TestCluster runs two instances, checks that they split the sensors and
+// serve the same merged statistics, then kills one and checks that the other
+// takes over its partitions and the leadership, without losing what was
+// published meanwhile.
+func TestCluster(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	dir := t.TempDir()
+	ns, err := embedded.Start(embedded.Options{JetStream: true, StoreDir: filepath.Join(dir, "jetstream")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(ns.Shutdown)
+	connect := func() *nats.Conn {
+		nc, err := nats.Connect(ns.ClientURL())
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(nc.Close)
+		return nc
+	}
+
+	start := func(instance string) (*Cloud, *nats.Conn) {
+		nc := connect()
+		opts := DefaultOptions()
+		opts.UseConfig = false
+		opts.StatsInterval = time.Hour
+		opts.RegistryBackend = "file"
+		opts.RegistryFile = filepath.Join(dir, instance, "registry.json")
+		opts.HistoryDir = filepath.Join(dir, "history")
+		opts.Cluster = ClusterOptions{Enabled: true, Instance: instance, Partitions: 8, LeaseTTL: 600 * time.Millisecond}
+		c, err := New(broker.NewNATS(nc), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := c.Start(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(c.Stop)
+		return c, nc
+	}
+	eventually := func(what string, cond func() bool) {
+		t.Helper()
+		for deadline := time.Now().Add(10 * time.Second); !cond(); time.Sleep(50 * time.Millisecond) {
+			if time.Now().After(deadline) {
+				t.Fatalf("timed out waiting for %s", what)
+			}
+		}
+	}
+
+	pub := connect()
+	publish := func(prefix string, n int) {
+		for i := 0; i < n; i++ {
+			data, _ := json.Marshal(FilteredReading{SensorID: fmt.Sprintf("%s-%d", prefix, i), Value: float64(i), Timestamp: time.Now().UnixMilli(), EdgeID: "edge-1"})
+			pub.Publish(subjects.Filtered("edge-1"), data)
+		}
+		pub.Flush()
+	}
+	handled := func(c *Cloud) map[string]bool {
+		sensors := make(map[string]bool)
+		for _, r := range c.history.Latest() {
+			sensors[r.SensorID] = true
+		}
+		return sensors
+	}
+
+	a, ncA := start("a")
+	b, _ := start("b")
+	eventually("the partitions to be split", func() bool {
+		return len(a.ownedPartitions()) == 4 && len(b.ownedPartitions()) == 4
+	})
+	if !a.isLeader() || b.isLeader() {
+		t.Errorf("leaders: a %v, b %v; want a, which joined first", a.isLeader(), b.isLeader())
+	}
+
+	publish("s", 40)
+	eventually("the readings to be handled", func() bool {
+		return len(handled(a))+len(handled(b)) == 40
+	})
+	for _, c := range []*Cloud{a, b} {
+		sensors := handled(c)
+		if len(sensors) == 0 {
+			t.Errorf("%s handled no sensors", c.group.ID())
+		}
+		for sensor := range sensors {
+			if !c.ownsPartition(partitionOf(sensor, 8)) {
+				t.Errorf("%s handled %s, of partition %d it doesn't own", c.group.ID(), sensor, partitionOf(sensor, 8))
+			}
+		}
+	}
+
+	stats := func(c *Cloud) (total int64) {
+		rec := httptest.NewRecorder()
+		c.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/stats", nil))
+		var s struct {
+			TotalReadings int64 `json:"total_readings"`
+		}
+		json.NewDecoder(rec.Body).Decode(&s)
+		return s.TotalReadings
+	}
+	eventually("both instances to serve the merged statistics", func() bool {
+		return stats(a) == 40 && stats(b) == 40
+	})
+
+	// Each instance exports the history of its own partitions, when asked
+	// for by name
+	if a.opts.HistoryDir == b.opts.HistoryDir {
+		t.Errorf("both instances keep their history in %s", a.opts.HistoryDir)
+	}
+	export := func(c *Cloud, query string) (int, int) {
+		rec := httptest.NewRecorder()
+		c.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/export?kind=readings&format=jsonl&from=0"+query, nil))
+		return rec.Code, strings.Count(rec.Body.String(), "\n")
+	}
+	if code, _ := export(a, ""); code != http.StatusConflict {
+		t.Errorf("GET /export without the instance: %d, want %d", code, http.StatusConflict)
+	}
+	if code, _ := export(a, "&instance=b"); code != http.StatusConflict {
+		t.Errorf("GET /export of b from a: %d, want %d", code, http.StatusConflict)
+	}
+	codeA, rowsA := export(a, "&instance=a")
+	codeB, rowsB := export(b, "&instance=b")
+	if codeA != http.StatusOK || codeB != http.StatusOK || rowsA+rowsB != 40 {
+		t.Errorf("GET /export of each instance: %d with %d rows, %d with %d rows; want 40 in all", codeA, rowsA, codeB, rowsB)
+	}
+
+	// a dies without leaving; nothing published before b takes over is lost
+	ncA.Close()
+	eventually("the server to drop a", func() bool { return ns.NumClients() == 2 })
+	publish("gap", 10)
+	eventually("b to take over", func() bool {
+		return len(b.ownedPartitions()) == 8 && b.isLeader()
+	})
+	eventually("the readings to be handled by b", func() bool {
+		sensors := handled(b)
+		for i := 0; i < 10; i++ {
+			if !sensors[fmt.Sprintf("gap-%d", i)] {
+				return false
+			}
+		}
+		return true
+	})
+	eventually("the statistics to keep what a handled", func() bool { return stats(b) >= 50 })
+
+	rec := httptest.NewRecorder()
+	b.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/cluster", nil))
+	var view struct {
+		Leader     string   `json:"leader"`
+		Members    []string `json:"members"`
+		Partitions []int    `json:"partitions"`
+	}
+	json.NewDecoder(rec.Body).Decode(&view)
+	if view.Leader != "b" || len(view.Members) != 1 || len(view.Partitions) != 8 {
+		t.Errorf("GET /cluster: %+v", view)
+	}
+}
+
+// TestClusterHeartbeatFailure cuts an instance off from the cluster bucket
+// and checks that it gives up its partitions and the leadership once their
+// leases expire, instead of handling what another instance now owns.
+func TestClusterHeartbeatFailure(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	dir := t.TempDir()
+	ns, err := embedded.Start(embedded.Options{JetStream: true, StoreDir: filepath.Join(dir, "jetstream")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ns.Shutdown()
+	nc, err := nats.Connect(ns.ClientURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nc.Close()
+
+	opts := DefaultOptions()
+	opts.UseConfig = false
+	opts.StatsInterval = time.Hour
+	opts.RegistryBackend = "file"
+	opts.RegistryFile = filepath.Join(dir, "registry.json")
+	opts.HistoryDir = filepath.Join(dir, "history")
+	opts.Cluster = ClusterOptions{Enabled: true, Instance: "a", Partitions: 4, LeaseTTL: 600 * time.Millisecond}
+	c, err := New(broker.NewNATS(nc), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	if len(c.ownedPartitions()) != 4 || !c.isLeader() {
+		t.Fatalf("partitions %v, leader %v; want all of them", c.ownedPartitions(), c.isLeader())
+	}
+
+	js, _ := jetstream.New(nc)
+	if err := js.DeleteKeyValue(context.Background(), ClusterBucket); err != nil {
+		t.Fatal(err)
+	}
+	for deadline := time.Now().Add(5 * time.Second); len(c.ownedPartitions()) > 0 || c.isLeader(); time.Sleep(50 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("still owns partitions %v, leader %v, after the heartbeats failed", c.ownedPartitions(), c.isLeader())
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package cloud
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/nats-io/nats.go/jetstream"
+
+	"sistemas_distribuidos_gb/internal/broker"
+	"sistemas_distribuidos_gb/internal/config"
+	"sistemas_distribuidos_gb/internal/config/settings"
+)
+
+// settings returns the settings a cloud started with o runs on until the
+// central config says otherwise.
+func (o Options) settings() settings.Cloud {
+	return settings.Cloud{StatsInterval: config.Duration(o.StatsInterval), NotifyRoutes: o.Notify.Routes}
+}
+
+func (c *Cloud) currentSettings() settings.Cloud {
+	c.settingsMu.RLock()
+	defer c.settingsMu.RUnlock()
+	return c.settings
+}
+
+func (c *Cloud) applySettings(s settings.Cloud) {
+	if c.notifier != nil && !reflect.DeepEqual(s.NotifyRoutes, c.notifier.Routes()) {
+		if err := c.notifier.SetRoutes(s.NotifyRoutes); err != nil {
+			log.Printf("Ignoring notification routes: %v", err)
+			s.NotifyRoutes = c.notifier.Routes()
+		}
+	}
+
+	c.settingsMu.Lock()
+	previous := c.settings
+	c.settings = s
+	c.settingsMu.Unlock()
+
+	if previous.StatsInterval != 0 && previous.StatsInterval != s.StatsInterval {
+		select {
+		case c.statsReset <- struct{}{}:
+		default:
+		}
+	}
+	log.Printf("Settings applied: stats_interval=%s, notify_routes=%d", s.StatsInterval.Std(), len(s.NotifyRoutes))
+}
+
+// startConfig opens the config bucket, which the cloud also serves over HTTP,
+// and starts watching its own settings.
+func (c *Cloud) startConfig(ctx context.Context, base settings.Cloud) error {
+	var js jetstream.JetStream
+	nc, err := broker.Conn(c.broker)
+	if err == nil {
+		js, err = jetstream.New(nc)
+	}
+	if err == nil {
+		c.configBucket, err = config.Open(ctx, js)
+	}
+	if err == nil {
+		err = config.Watch(ctx, c.configBucket, "cloud", c.opts.ID, base, c.applySettings)
+	}
+	if err != nil {
+		c.configBucket = nil
+		c.applySettings(base)
+	}
+	return err
+}
+
+// handleConfigList serves GET /config: every settings document in the bucket.
+func (c *Cloud) handleConfigList(w http.ResponseWriter, r *http.Request) {
+	if c.configBucket == nil {
+		http.Error(w, "central config unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	docs := make(map[string]json.RawMessage)
+	keys, err := c.configBucket.Keys(r.Context())
+	if err != nil && !errors.Is(err, jetstream.ErrNoKeysFound) {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for _, k := range keys {
+		entry, err := c.configBucket.Get(r.Context(), k)
+		if err != nil {
+			continue
+		}
+		docs[k] = json.RawMessage(entry.Value())
+	}
+	writeJSON(w, http.StatusOK, docs)
+}
+
+// handleConfig serves GET, PUT and DELETE /config/{component}.{defaults|id}.
+func (c *Cloud) handleConfig(w http.ResponseWriter, r *http.Request) {
+	if c.configBucket == nil {
+		http.Error(w, "central config unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	key := strings.TrimPrefix(r.URL.Path, "/config/")
+	parts := strings.Split(key, ".")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		http.Error(w, "key must look like <component>.defaults or <component>.<id>", http.StatusBadRequest)
+		return
+	}
+	if !settings.Known(parts[0]) {
+		http.Error(w, "unknown component "+parts[0], http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		entry, err := c.configBucket.Get(r.Context(), key)
+		if errors.Is(err, jetstream.ErrKeyNotFound) {
+			http.NotFound(w, r)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(entry.Value())
+
+	case http.MethodPut:
+		var doc map[string]json.RawMessage
+		if err := json.NewDecoder(r.Body).Decode(&doc); err != nil {
+			http.Error(w, "settings must be a JSON object: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		data, _ := json.Marshal(doc)
+		var defaults []byte
+		if defaultsKey := config.DefaultsKey(parts[0]); key != defaultsKey {
+			entry, err := c.configBucket.Get(r.Context(), defaultsKey)
+			if err != nil && !errors.Is(err, jetstream.ErrKeyNotFound) {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if err == nil {
+				defaults = entry.Value()
+			}
+		}
+		if err := settings.Check(parts[0], defaults, data); err != nil {
+			http.Error(w, "invalid "+parts[0]+" settings: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if _, err := c.configBucket.Put(r.Context(), key, data); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		log.Printf("Config updated: %s = %s", key, data)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(data)
+
+	case http.MethodDelete:
+		if err := c.configBucket.Delete(r.Context(), key); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		log.Printf("Config deleted: %s", key)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,103 @@
+package cloud
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// edgeSeen records that an edge sent something.
+func (c *Cloud) edgeSeen(edgeID string) {
+	if c.opts.EdgeTimeout <= 0 || edgeID == "" {
+		return
+	}
+	c.edgesMu.Lock()
+	defer c.edgesMu.Unlock()
+	c.lastSeen[edgeID] = c.clock.Now()
+	if c.offline[edgeID] {
+		delete(c.offline, edgeID)
+		log.Printf("Edge %s is back online", edgeID)
+	}
+}
+
+// watchEdges raises an edge_offline alert for every edge that has been
+// silent for longer than the edge timeout, once until it's seen again. In a
+// cluster only the leader does, from when any instance saw the edge last.
+func (c *Cloud) watchEdges(ctx context.Context) {
+	ticker := c.clock.NewTicker(c.opts.EdgeTimeout / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C():
+			if !c.isLeader() {
+				continue
+			}
+			for _, alert := range c.offlineEdges(c.clock.Now()) {
+				c.processAlert(alert)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (c *Cloud) offlineEdges(now time.Time) []Alert {
+	// Another instance may have seen an edge later
+	seen := make(map[string]time.Time)
+	c.clusterMu.Lock()
+	for _, peer := range c.peers {
+		for edgeID, t := range peer.EdgeSeen {
+			if last := time.UnixMilli(t); last.After(seen[edgeID]) {
+				seen[edgeID] = last
+			}
+		}
+	}
+	c.clusterMu.Unlock()
+
+	c.edgesMu.Lock()
+	defer c.edgesMu.Unlock()
+	for edgeID, last := range c.lastSeen {
+		if last.After(seen[edgeID]) {
+			seen[edgeID] = last
+		}
+	}
+	var alerts []Alert
+	for edgeID, last := range seen {
+		silent := now.Sub(last)
+		if silent <= c.opts.EdgeTimeout {
+			if c.offline[edgeID] {
+				delete(c.offline, edgeID)
+				log.Printf("Edge %s is back online", edgeID)
+			}
+			continue
+		}
+		if c.offline[edgeID] {
+			continue
+		}
+		c.offline[edgeID] = true
+		alerts = append(alerts, Alert{
+			EdgeID:    edgeID,
+			Timestamp: now.UnixMilli(),
+			Type:      "edge_offline",
+			Message:   fmt.Sprintf("No data from %s for %v", edgeID, silent.Truncate(time.Second)),
+		})
+	}
+	return alerts
+}
+
+// handleNotifications serves the notification log, oldest first.
+func (c *Cloud) handleNotifications(w http.ResponseWriter, r *http.Request) {
+	if c.notifier == nil {
+		http.Error(w, "notifications disabled", http.StatusServiceUnavailable)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(c.notifier.Entries())
+}
@@ -0,0 +1,119 @@
+package cloud
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+
+	"sistemas_distribuidos_gb/internal/registry"
+)
+
+// openRegistryStore opens the registry in the NATS KV bucket, falling back to
+// a local file when JetStream isn't available or a file was asked for.
+func openRegistryStore(nc *nats.Conn, backend, path string) (registry.Store, error) {
+	if backend == "kv" {
+		js, err := jetstream.New(nc)
+		if err == nil {
+			var store registry.Store
+			store, err = registry.NewKVStore(context.Background(), js, registry.DefaultBucket)
+			if err == nil {
+				log.Printf("Sensor registry stored in KV bucket %s", registry.DefaultBucket)
+				return store, nil
+			}
+		}
+		log.Printf("KV registry unavailable (%v), falling back to %s", err, path)
+	}
+	store, err := registry.NewFileStore(path)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Sensor registry stored in %s", path)
+	return store, nil
+}
+
+// handleSensors serves GET /sensors.
+func (c *Cloud) handleSensors(w http.ResponseWriter, r *http.Request) {
+	if c.registry == nil {
+		http.Error(w, "sensor registry unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	sensors, err := c.registry.List(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, sensors)
+}
+
+// handleSensor serves GET, PUT and DELETE /sensors/{id}.
+func (c *Cloud) handleSensor(w http.ResponseWriter, r *http.Request) {
+	if c.registry == nil {
+		http.Error(w, "sensor registry unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	id := strings.TrimPrefix(r.URL.Path, "/sensors/")
+	if id == "" || strings.Contains(id, "/") {
+		http.NotFound(w, r)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		sensor, err := c.registry.Get(r.Context(), id)
+		if errors.Is(err, registry.ErrNotFound) {
+			http.NotFound(w, r)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, sensor)
+
+	case http.MethodPut:
+		var sensor registry.Sensor
+		if err := json.NewDecoder(r.Body).Decode(&sensor); err != nil {
+			http.Error(w, "invalid sensor: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		sensor.ID = id
+		updated, err := c.registry.Update(r.Context(), sensor)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		log.Printf("Sensor metadata updated: id=%s", id)
+		writeJSON(w, http.StatusOK, updated)
+
+	case http.MethodDelete:
+		err := c.registry.Delete(r.Context(), id)
+		if errors.Is(err, registry.ErrNotFound) {
+			http.NotFound(w, r)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
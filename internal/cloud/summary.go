@@ -0,0 +1,175 @@
+package cloud
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// Summary is a mergeable digest of the statistics of a cloud instance.
+// Counters add up, min and max combine, the last value and the edges' last
+// seen times take the latest, latencies fall in a fixed histogram and recent
+// alerts merge by time, so the statistics of a cluster are the merge of its
+// instances' summaries, in any order.
+type Summary struct {
+	Readings  int64   `json:"readings"`
+	Sum       float64 `json:"sum"`
+	Min       float64 `json:"min"` // zero without readings
+	Max       float64 `json:"max"`
+	LastValue float64 `json:"last_value"`
+	LastTime  int64   `json:"last_time"` // of the last value, Unix milliseconds
+
+	// Moments of the readings in the sliding windows, for the spread
+	Window      int64   `json:"window"`
+	WindowSum   float64 `json:"window_sum"`
+	WindowSumSq float64 `json:"window_sum_sq"`
+
+	EdgeReadings map[string]int64 `json:"edge_readings"`
+	EdgeSeen     map[string]int64 `json:"edge_seen,omitempty"` // last message per edge, Unix milliseconds
+
+	Latency    []int64       `json:"latency"` // counts per latencyBucket
+	LatencySum time.Duration `json:"latency_sum"`
+
+	TotalAlerts int64   `json:"total_alerts"`
+	Alerts      []Alert `json:"alerts"` // the latest maxSummaryAlerts, oldest first
+
+	Start time.Time `json:"start"`
+}
+
+// maxSummaryAlerts bounds the alerts a summary carries.
+const maxSummaryAlerts = 1000
+
+// Latency histogram: bucket i counts latencies up to latencyBase·2^(i/4),
+// about 19% wide, from 100µs to over a minute.
+const (
+	latencyBase    = 100 * time.Microsecond
+	latencyBuckets = 80
+)
+
+func latencyBucket(d time.Duration) int {
+	if d <= latencyBase {
+		return 0
+	}
+	i := int(math.Ceil(4 * math.Log2(float64(d)/float64(latencyBase))))
+	if i >= latencyBuckets {
+		i = latencyBuckets - 1
+	}
+	return i
+}
+
+func latencyBound(i int) time.Duration {
+	return time.Duration(float64(latencyBase) * math.Pow(2, float64(i)/4))
+}
+
+// Merge adds o into s.
+func (s *Summary) Merge(o Summary) {
+	if o.Readings > 0 {
+		if s.Readings == 0 || o.Min < s.Min {
+			s.Min = o.Min
+		}
+		if s.Readings == 0 || o.Max > s.Max {
+			s.Max = o.Max
+		}
+	}
+	s.Readings += o.Readings
+	s.Sum += o.Sum
+	if o.LastTime > s.LastTime {
+		s.LastValue, s.LastTime = o.LastValue, o.LastTime
+	}
+	s.Window += o.Window
+	s.WindowSum += o.WindowSum
+	s.WindowSumSq += o.WindowSumSq
+
+	if s.EdgeReadings == nil {
+		s.EdgeReadings = make(map[string]int64)
+	}
+	for edge, n := range o.EdgeReadings {
+		s.EdgeReadings[edge] += n
+	}
+	for edge, t := range o.EdgeSeen {
+		if s.EdgeSeen == nil {
+			s.EdgeSeen = make(map[string]int64)
+		}
+		if t > s.EdgeSeen[edge] {
+			s.EdgeSeen[edge] = t
+		}
+	}
+
+	if len(o.Latency) > 0 && len(s.Latency) == 0 {
+		s.Latency = make([]int64, latencyBuckets)
+	}
+	for i, n := range o.Latency {
+		if i < len(s.Latency) {
+			s.Latency[i] += n
+		}
+	}
+	s.LatencySum += o.LatencySum
+
+	s.TotalAlerts += o.TotalAlerts
+	if len(o.Alerts) > 0 {
+		alerts := append(append(make([]Alert, 0, len(s.Alerts)+len(o.Alerts)), s.Alerts...), o.Alerts...)
+		sort.SliceStable(alerts, func(i, j int) bool { return alerts[i].Timestamp < alerts[j].Timestamp })
+		if len(alerts) > maxSummaryAlerts {
+			alerts = alerts[len(alerts)-maxSummaryAlerts:]
+		}
+		s.Alerts = alerts
+	}
+
+	if !o.Start.IsZero() && (s.Start.IsZero() || o.Start.Before(s.Start)) {
+		s.Start = o.Start
+	}
+}
+
+// Mean returns the mean of every reading.
+func (s Summary) Mean() float64 {
+	if s.Readings == 0 {
+		return 0
+	}
+	return s.Sum / float64(s.Readings)
+}
+
+// StdDev returns the spread of the readings in the sliding windows around
+// the overall mean.
+func (s Summary) StdDev() float64 {
+	if s.Window == 0 {
+		return 0
+	}
+	m := s.Mean()
+	variance := (s.WindowSumSq - 2*m*s.WindowSum + float64(s.Window)*m*m) / float64(s.Window)
+	return math.Sqrt(math.Max(variance, 0))
+}
+
+// LatencyCount returns how many latencies the histogram holds.
+func (s Summary) LatencyCount() int64 {
+	var n int64
+	for _, c := range s.Latency {
+		n += c
+	}
+	return n
+}
+
+// AvgLatency returns the mean latency.
+func (s Summary) AvgLatency() time.Duration {
+	n := s.LatencyCount()
+	if n == 0 {
+		return 0
+	}
+	return s.LatencySum / time.Duration(n)
+}
+
+// LatencyPercentile returns the upper bound of the histogram bucket holding
+// the p-th percentile latency.
+func (s Summary) LatencyPercentile(p float64) time.Duration {
+	n := s.LatencyCount()
+	if n == 0 {
+		return 0
+	}
+	rank := int64(math.Ceil(float64(n) * p / 100))
+	var seen int64
+	for i, c := range s.Latency {
+		if seen += c; seen >= rank {
+			return latencyBound(i)
+		}
+	}
+	return latencyBound(latencyBuckets - 1)
+}
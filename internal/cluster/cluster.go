@@ -0,0 +1,338 @@
+// Package cluster coordinates the instances of a service through a NATS KV
+// bucket. Every instance keeps a member key alive, leases give one instance
+// at a time a named job (being the leader, owning a partition), and each
+// instance can share a state document the others read.
+//
+// Member and lease keys carry their expiry, set from the writer's clock and
+// pushed forward by Heartbeat; an expired lease is taken over with a
+// compare-and-set on its revision, so two instances never both believe they
+// won it. The instances' clocks must agree to well within the TTL.
+//
+// An instance also keeps the expiry of each lease it wrote last: when it
+// can't reach the bucket to renew them, Holds turns false by the time the
+// others may take them over, so that it stops acting on them on its own.
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+
+	"sistemas_distribuidos_gb/internal/clock"
+)
+
+// DefaultTTL is how long members and leases last without a heartbeat.
+const DefaultTTL = 10 * time.Second
+
+// Key prefixes in the bucket.
+const (
+	memberPrefix = "member."
+	leasePrefix  = "lease."
+	statePrefix  = "state."
+)
+
+// validID matches the names instances and leases may have: KV key tokens,
+// without dots.
+var validID = regexp.MustCompile(`^[-_=a-zA-Z0-9]+$`)
+
+// Options configure an instance's membership in a group.
+type Options struct {
+	Bucket string // KV bucket shared by the group, created if missing
+	ID     string // this instance, unique in the group
+	// TTL is how long the instance keeps its membership and leases without a
+	// heartbeat; DefaultTTL if zero. Call Heartbeat well within it.
+	TTL   time.Duration
+	Clock clock.Clock // nil uses the wall clock
+}
+
+// Group is an instance's membership in a group.
+type Group struct {
+	opts  Options
+	kv    jetstream.KeyValue
+	clock clock.Clock
+
+	mu   sync.Mutex
+	held map[string]lease // leases held, by name
+}
+
+// lease is what the instance wrote last to a lease it holds.
+type lease struct {
+	rev     uint64
+	expires time.Time
+}
+
+// record is the value of member and lease keys.
+type record struct {
+	Owner   string `json:"owner"`
+	Expires int64  `json:"expires"` // Unix milliseconds
+}
+
+// Join opens the group's bucket and announces the instance.
+func Join(ctx context.Context, js jetstream.JetStream, opts Options) (*Group, error) {
+	if !validID.MatchString(opts.ID) {
+		return nil, fmt.Errorf("invalid instance ID %q (letters, digits, -, _ and =)", opts.ID)
+	}
+	if opts.TTL <= 0 {
+		opts.TTL = DefaultTTL
+	}
+	kv, err := js.KeyValue(ctx, opts.Bucket)
+	if errors.Is(err, jetstream.ErrBucketNotFound) {
+		kv, err = js.CreateKeyValue(ctx, jetstream.KeyValueConfig{
+			Bucket:      opts.Bucket,
+			Description: "Cluster members, leases and shared state",
+		})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("open cluster bucket: %w", err)
+	}
+	g := &Group{opts: opts, kv: kv, clock: clock.Or(opts.Clock), held: make(map[string]lease)}
+	if _, err := g.kv.Put(ctx, memberPrefix+opts.ID, g.record(g.expiry())); err != nil {
+		return nil, fmt.Errorf("join cluster: %w", err)
+	}
+	return g, nil
+}
+
+// ID returns the instance's ID.
+func (g *Group) ID() string { return g.opts.ID }
+
+// TTL returns how long membership and leases last without a heartbeat.
+func (g *Group) TTL() time.Duration { return g.opts.TTL }
+
+// expiry returns when a key written now expires. It's read before writing,
+// so the local expiry of a lease never outlasts the one in the bucket.
+func (g *Group) expiry() time.Time {
+	return g.clock.Now().Add(g.opts.TTL)
+}
+
+func (g *Group) record(expires time.Time) []byte {
+	data, _ := json.Marshal(record{Owner: g.opts.ID, Expires: expires.UnixMilli()})
+	return data
+}
+
+// Heartbeat renews the instance's membership and leases, and returns the
+// leases it found taken over by others, which it no longer holds. The leases
+// it fails to renew run out at their local expiry; it keeps renewing the
+// others, and returns the failures joined.
+func (g *Group) Heartbeat(ctx context.Context) (lost []string, err error) {
+	if _, err := g.kv.Put(ctx, memberPrefix+g.opts.ID, g.record(g.expiry())); err != nil {
+		return nil, fmt.Errorf("renew membership: %w", err)
+	}
+	// Renew a copy, so that Holds isn't stuck behind the round trips
+	g.mu.Lock()
+	held := make(map[string]lease, len(g.held))
+	for name, l := range g.held {
+		held[name] = l
+	}
+	g.mu.Unlock()
+
+	renewed := make(map[string]lease, len(held))
+	taken := make(map[string]bool)
+	var errs []error
+	for name, l := range held {
+		expires := g.expiry()
+		next, err := g.kv.Update(ctx, leasePrefix+name, g.record(expires), l.rev)
+		switch {
+		case err == nil:
+			renewed[name] = lease{rev: next, expires: expires}
+		case errors.Is(err, jetstream.ErrKeyExists), errors.Is(err, jetstream.ErrKeyNotFound):
+			taken[name] = true
+		default:
+			errs = append(errs, fmt.Errorf("renew lease %s: %w", name, err))
+		}
+	}
+
+	// Leases released or acquired again meanwhile are left as they are now
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for name, l := range held {
+		if current, ok := g.held[name]; !ok || current != l {
+			continue
+		}
+		if next, ok := renewed[name]; ok {
+			g.held[name] = next
+		} else if taken[name] {
+			delete(g.held, name)
+			lost = append(lost, name)
+		}
+	}
+	sort.Strings(lost)
+	return lost, errors.Join(errs...)
+}
+
+// Acquire takes the lease name if it's free, expired or already held by the
+// instance, and reports whether the instance holds it.
+func (g *Group) Acquire(ctx context.Context, name string) (bool, error) {
+	if !validID.MatchString(name) {
+		return false, fmt.Errorf("invalid lease name %q", name)
+	}
+	key := leasePrefix + name
+	var rev uint64
+	expires := g.expiry()
+	entry, err := g.kv.Get(ctx, key)
+	switch {
+	case errors.Is(err, jetstream.ErrKeyNotFound):
+		rev, err = g.kv.Create(ctx, key, g.record(expires))
+	case err != nil:
+		return false, err
+	default:
+		var r record
+		json.Unmarshal(entry.Value(), &r)
+		if r.Owner != g.opts.ID && r.Expires > g.clock.Now().UnixMilli() {
+			return false, nil
+		}
+		rev, err = g.kv.Update(ctx, key, g.record(expires), entry.Revision())
+	}
+	if errors.Is(err, jetstream.ErrKeyExists) {
+		return false, nil // another instance got there first
+	}
+	if err != nil {
+		return false, err
+	}
+	g.mu.Lock()
+	g.held[name] = lease{rev: rev, expires: expires}
+	g.mu.Unlock()
+	return true, nil
+}
+
+// Release gives up the lease name, if the instance still holds it.
+func (g *Group) Release(ctx context.Context, name string) error {
+	g.mu.Lock()
+	l, ok := g.held[name]
+	delete(g.held, name)
+	g.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	err := g.kv.Delete(ctx, leasePrefix+name, jetstream.LastRevision(l.rev))
+	if errors.Is(err, jetstream.ErrKeyExists) {
+		return nil // taken over meanwhile
+	}
+	return err
+}
+
+// Holds reports whether the instance holds the lease name: it won or renewed
+// it last, and that hasn't expired since.
+func (g *Group) Holds(name string) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	l, ok := g.held[name]
+	return ok && g.clock.Now().Before(l.expires)
+}
+
+// Held returns the leases the instance holds, as Holds, sorted.
+func (g *Group) Held() []string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := g.clock.Now()
+	names := make([]string, 0, len(g.held))
+	for name, l := range g.held {
+		if now.Before(l.expires) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Owner returns the instance holding the lease name, "" if none does.
+func (g *Group) Owner(ctx context.Context, name string) (string, error) {
+	entry, err := g.kv.Get(ctx, leasePrefix+name)
+	if errors.Is(err, jetstream.ErrKeyNotFound) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	var r record
+	if json.Unmarshal(entry.Value(), &r) != nil || r.Expires <= g.clock.Now().UnixMilli() {
+		return "", nil
+	}
+	return r.Owner, nil
+}
+
+// Members returns the instances whose membership hasn't expired, sorted.
+func (g *Group) Members(ctx context.Context) ([]string, error) {
+	keys, err := g.keys(ctx, memberPrefix)
+	if err != nil {
+		return nil, err
+	}
+	now := g.clock.Now().UnixMilli()
+	var members []string
+	for _, key := range keys {
+		entry, err := g.kv.Get(ctx, key)
+		if err != nil {
+			continue
+		}
+		var r record
+		if json.Unmarshal(entry.Value(), &r) == nil && r.Expires > now {
+			members = append(members, strings.TrimPrefix(key, memberPrefix))
+		}
+	}
+	sort.Strings(members)
+	return members, nil
+}
+
+// Share publishes the instance's state document. It outlives the instance,
+// so that Shared still returns it once the instance is gone.
+func (g *Group) Share(ctx context.Context, state []byte) error {
+	_, err := g.kv.Put(ctx, statePrefix+g.opts.ID, state)
+	return err
+}
+
+// Shared returns the state documents of every instance that shared one, by
+// instance ID, its own included.
+func (g *Group) Shared(ctx context.Context) (map[string][]byte, error) {
+	keys, err := g.keys(ctx, statePrefix)
+	if err != nil {
+		return nil, err
+	}
+	states := make(map[string][]byte, len(keys))
+	for _, key := range keys {
+		if entry, err := g.kv.Get(ctx, key); err == nil {
+			states[strings.TrimPrefix(key, statePrefix)] = entry.Value()
+		}
+	}
+	return states, nil
+}
+
+// Leave releases the instance's leases and ends its membership, so that
+// others take over without waiting for the TTL.
+func (g *Group) Leave(ctx context.Context) error {
+	g.mu.Lock()
+	names := make([]string, 0, len(g.held))
+	for name := range g.held {
+		names = append(names, name) // expired ones too, if nobody took them
+	}
+	g.mu.Unlock()
+	var errs []error
+	for _, name := range names {
+		errs = append(errs, g.Release(ctx, name))
+	}
+	errs = append(errs, g.kv.Delete(ctx, memberPrefix+g.opts.ID))
+	return errors.Join(errs...)
+}
+
+func (g *Group) keys(ctx context.Context, prefix string) ([]string, error) {
+	keys, err := g.kv.Keys(ctx, jetstream.MetaOnly())
+	if errors.Is(err, jetstream.ErrNoKeysFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var matching []string
+	for _, key := range keys {
+		if strings.HasPrefix(key, prefix) {
+			matching = append(matching, key)
+		}
+	}
+	return matching, nil
+}
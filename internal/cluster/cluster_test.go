@@ -0,0 +1,141 @@
+package cluster
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+
+	"sistemas_distribuidos_gb/internal/clock"
+	"sistemas_distribuidos_gb/internal/embedded"
+)
+
+// TestLeases checks that a lease has one holder at a time and is taken over
+// once its holder stops renewing it.
+func TestLeases(t *testing.T) {
+	ns, err := embedded.Start(embedded.Options{JetStream: true, StoreDir: filepath.Join(t.TempDir(), "jetstream")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ns.Shutdown()
+	nc, err := nats.Connect(ns.ClientURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nc.Close()
+	js, _ := jetstream.New(nc)
+
+	ctx := context.Background()
+	clk := clock.NewFake(time.Date(2024, 1, 1, 8, 0, 0, 0, time.UTC))
+	join := func(id string) *Group {
+		g, err := Join(ctx, js, Options{Bucket: "test-cluster", ID: id, TTL: 10 * time.Second, Clock: clk})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return g
+	}
+	a, b := join("a"), join("b")
+	if _, err := Join(ctx, js, Options{Bucket: "test-cluster", ID: "host.local"}); err == nil {
+		t.Error("an ID with dots was accepted")
+	}
+
+	acquire := func(g *Group, name string, want bool) {
+		t.Helper()
+		if got, err := g.Acquire(ctx, name); err != nil || got != want {
+			t.Errorf("%s.Acquire(%s) = %v, %v; want %v", g.ID(), name, got, err, want)
+		}
+	}
+	acquire(a, "leader", true)
+	acquire(b, "leader", false)
+	acquire(b, "partition-1", true)
+	if owner, _ := b.Owner(ctx, "leader"); owner != "a" {
+		t.Errorf("leader is %q, want a", owner)
+	}
+	if members, _ := a.Members(ctx); !reflect.DeepEqual(members, []string{"a", "b"}) {
+		t.Errorf("members %v", members)
+	}
+
+	// Renewed leases survive the TTL; the others are taken over
+	clk.Advance(6 * time.Second)
+	if lost, err := b.Heartbeat(ctx); err != nil || len(lost) != 0 {
+		t.Errorf("b.Heartbeat() = %v, %v", lost, err)
+	}
+	clk.Advance(6 * time.Second)
+	if members, _ := b.Members(ctx); !reflect.DeepEqual(members, []string{"b"}) {
+		t.Errorf("members after a stopped its heartbeat: %v, want b", members)
+	}
+	acquire(a, "partition-1", false)
+	acquire(b, "leader", true)
+	if lost, err := a.Heartbeat(ctx); err != nil || !reflect.DeepEqual(lost, []string{"leader"}) || a.Holds("leader") {
+		t.Errorf("a.Heartbeat() = %v, %v; want the leader lease lost", lost, err)
+	}
+
+	// Released leases are free at once
+	if err := b.Release(ctx, "leader"); err != nil {
+		t.Fatal(err)
+	}
+	acquire(a, "leader", true)
+	if !reflect.DeepEqual(b.Held(), []string{"partition-1"}) {
+		t.Errorf("b holds %v", b.Held())
+	}
+
+	// Shared state outlives the instance that shared it
+	a.Share(ctx, []byte(`{"n": 1}`))
+	b.Share(ctx, []byte(`{"n": 2}`))
+	if err := b.Leave(ctx); err != nil {
+		t.Fatal(err)
+	}
+	acquire(a, "partition-1", true)
+	if members, _ := a.Members(ctx); !reflect.DeepEqual(members, []string{"a"}) {
+		t.Errorf("members after b left: %v", members)
+	}
+	if states, _ := a.Shared(ctx); len(states) != 2 || string(states["b"]) != `{"n": 2}` {
+		t.Errorf("shared states %q", states)
+	}
+}
+
+// TestHeartbeatFailure checks that an instance cut off from the bucket stops
+// holding its leases once they expire, as the others then take them over.
+func TestHeartbeatFailure(t *testing.T) {
+	ns, err := embedded.Start(embedded.Options{JetStream: true, StoreDir: filepath.Join(t.TempDir(), "jetstream")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ns.Shutdown()
+	nc, err := nats.Connect(ns.ClientURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nc.Close()
+	js, _ := jetstream.New(nc)
+
+	ctx := context.Background()
+	clk := clock.NewFake(time.Date(2024, 1, 1, 8, 0, 0, 0, time.UTC))
+	g, err := Join(ctx, js, Options{Bucket: "test-cluster", ID: "a", TTL: 10 * time.Second, Clock: clk})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := g.Acquire(ctx, "leader"); !ok || err != nil {
+		t.Fatalf("Acquire = %v, %v", ok, err)
+	}
+
+	if err := js.DeleteKeyValue(ctx, "test-cluster"); err != nil {
+		t.Fatal(err)
+	}
+	clk.Advance(6 * time.Second)
+	if _, err := g.Heartbeat(ctx); err == nil {
+		t.Error("Heartbeat succeeded without the bucket")
+	}
+	if !g.Holds("leader") {
+		t.Error("lease dropped before its expiry")
+	}
+	clk.Advance(6 * time.Second)
+	g.Heartbeat(ctx)
+	if g.Holds("leader") || len(g.Held()) != 0 {
+		t.Errorf("lease still held past its expiry: %v", g.Held())
+	}
+}
@@ -0,0 +1,200 @@
+// Package config distributes component settings through a NATS KV bucket.
+//
+// Settings are stored as JSON objects under two keys per component type:
+//
+//	<component>.defaults   applies to every instance
+//	<component>.<id>       applies to one instance and wins over the defaults
+//
+// A component starts from its flag values, overlays whatever fields the two
+// keys define, and re-applies the result every time either key changes.
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// Bucket is the KV bucket holding component settings.
+const Bucket = "component-config"
+
+// DefaultsKey returns the key of the settings shared by every instance of a component.
+func DefaultsKey(component string) string {
+	return component + ".defaults"
+}
+
+// InstanceKey returns the key of the settings of a single instance.
+func InstanceKey(component, id string) string {
+	return component + "." + keyToken(id)
+}
+
+// Open returns the config bucket, creating it if needed.
+func Open(ctx context.Context, js jetstream.JetStream) (jetstream.KeyValue, error) {
+	kv, err := js.KeyValue(ctx, Bucket)
+	if errors.Is(err, jetstream.ErrBucketNotFound) {
+		kv, err = js.CreateKeyValue(ctx, jetstream.KeyValueConfig{
+			Bucket:      Bucket,
+			Description: "Hot-reloadable component settings",
+			History:     10,
+		})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("open config bucket: %w", err)
+	}
+	return kv, nil
+}
+
+// Start opens the config bucket over nc and watches the settings of a
+// component instance (see Watch). If the bucket can't be reached, apply is
+// called with base and the error is returned so the caller can log that it is
+// running on flag values only.
+func Start[T any](ctx context.Context, nc *nats.Conn, component, id string, base T, apply func(T)) error {
+	js, err := jetstream.New(nc)
+	if err == nil {
+		var kv jetstream.KeyValue
+		if kv, err = Open(ctx, js); err == nil {
+			err = Watch(ctx, kv, component, id, base, apply)
+		}
+	}
+	if err != nil {
+		apply(base)
+	}
+	return err
+}
+
+// Watch applies the settings of a component instance and keeps them up to
+// date. apply is called once with the initial settings before Watch returns,
+// then again after every change, always from the same goroutine. Invalid
+// documents are logged and ignored. Watching stops when ctx is done.
+func Watch[T any](ctx context.Context, kv jetstream.KeyValue, component, id string, base T, apply func(T)) error {
+	watcher, err := kv.Watch(ctx, component+".*")
+	if err != nil {
+		return fmt.Errorf("watch %s settings: %w", component, err)
+	}
+
+	defaultsKey, instanceKey := DefaultsKey(component), InstanceKey(component, id)
+	layers := map[string][]byte{}
+
+	update := func() {
+		next, err := Merge(base, layers[defaultsKey], layers[instanceKey])
+		if err != nil {
+			log.Printf("Ignoring invalid %s settings: %v", component, err)
+			return
+		}
+		apply(next)
+	}
+
+	// The watcher sends the current values, then a nil entry marking the end
+	// of the initial state
+	for entry := range watcher.Updates() {
+		if entry == nil {
+			break
+		}
+		if k := entry.Key(); k == defaultsKey || k == instanceKey {
+			layers[k] = entry.Value()
+		}
+	}
+	update()
+
+	go func() {
+		defer watcher.Stop()
+		for entry := range watcher.Updates() {
+			if entry == nil {
+				continue
+			}
+			k := entry.Key()
+			if k != defaultsKey && k != instanceKey {
+				continue
+			}
+			if entry.Operation() == jetstream.KeyValuePut {
+				layers[k] = entry.Value()
+			} else {
+				delete(layers, k)
+			}
+			log.Printf("Settings changed (%s), reloading", k)
+			update()
+		}
+	}()
+	return nil
+}
+
+// Validator is implemented by settings types that check their own values.
+// Merge rejects results whose Validate method fails.
+type Validator interface {
+	Validate() error
+}
+
+// Merge overlays the JSON documents on base, in order. Only the fields present
+// in a document are changed; base itself is left as it was.
+func Merge[T any](base T, docs ...[]byte) (T, error) {
+	// Decode over a deep copy: decoding an array into a copied slice would
+	// write to the array of base
+	var out T
+	data, err := json.Marshal(base)
+	if err == nil {
+		err = json.Unmarshal(data, &out)
+	}
+	if err != nil {
+		return base, err
+	}
+	for _, doc := range docs {
+		if len(doc) == 0 {
+			continue
+		}
+		if err := json.Unmarshal(doc, &out); err != nil {
+			return base, err
+		}
+	}
+	if v, ok := any(out).(Validator); ok {
+		if err := v.Validate(); err != nil {
+			return base, err
+		}
+	}
+	return out, nil
+}
+
+// Duration is a time.Duration that reads and writes as a string such as "5s".
+type Duration time.Duration
+
+// Std returns d as a time.Duration.
+func (d Duration) Std() time.Duration {
+	return time.Duration(d)
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"5s\": %w", err)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	if v <= 0 {
+		return fmt.Errorf("duration must be positive, got %s", s)
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// keyToken maps an instance ID onto the characters allowed in a single KV key token.
+func keyToken(id string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_':
+			return r
+		}
+		return '_'
+	}, id)
+}
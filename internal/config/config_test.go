@@ -0,0 +1,138 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+
+	"sistemas_distribuidos_gb/internal/embedded"
+)
+
+type testSettings struct {
+	Interval Duration `json:"interval"`
+	Limit    int      `json:"limit"`
+	Names    []string `json:"names"`
+}
+
+func (s testSettings) Validate() error {
+	if s.Limit < 0 {
+		return errors.New("limit can't be negative")
+	}
+	return nil
+}
+
+func TestMerge(t *testing.T) {
+	base := testSettings{Interval: Duration(time.Second), Limit: 10, Names: []string{"a", "b", "c"}}
+
+	got, err := Merge(base, []byte(`{"limit": 20, "names": ["x"]}`), nil, []byte(`{"interval": "2s"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Interval.Std() != 2*time.Second || got.Limit != 20 || len(got.Names) != 1 || got.Names[0] != "x" {
+		t.Errorf("merged %+v", got)
+	}
+	if base.Names[0] != "a" {
+		t.Errorf("base changed by the merge: %+v", base)
+	}
+
+	for _, doc := range []string{
+		`{"limit": -1}`,       // fails Validate
+		`{"interval": 5}`,     // not a duration string
+		`{"interval": "-1s"}`, // not positive
+		`{"limit": "ten"}`,
+		`not json`,
+	} {
+		got, err := Merge(base, []byte(doc))
+		if err == nil {
+			t.Errorf("Merge of %s succeeded: %+v", doc, got)
+		}
+		if got.Limit != base.Limit || got.Interval != base.Interval {
+			t.Errorf("Merge of %s returned %+v, want base", doc, got)
+		}
+	}
+}
+
+func TestKeys(t *testing.T) {
+	if k := DefaultsKey("edge"); k != "edge.defaults" {
+		t.Errorf("DefaultsKey = %s", k)
+	}
+	if k := InstanceKey("edge", "plant-a.edge 1"); k != "edge.plant-a_edge_1" {
+		t.Errorf("InstanceKey = %s", k)
+	}
+}
+
+// TestWatch checks that an instance follows its defaults and instance keys,
+// in that order of precedence, and nothing else.
+func TestWatch(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	ns, err := embedded.Start(embedded.Options{JetStream: true, StoreDir: filepath.Join(t.TempDir(), "jetstream")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ns.Shutdown()
+	nc, err := nats.Connect(ns.ClientURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nc.Close()
+	js, _ := jetstream.New(nc)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	kv, err := Open(ctx, js)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kv.Put(ctx, "edge.defaults", []byte(`{"limit": 20}`))
+
+	applied := make(chan testSettings, 16)
+	base := testSettings{Interval: Duration(time.Second), Limit: 10}
+	if err := Watch(ctx, kv, "edge", "e1", base, func(s testSettings) { applied <- s }); err != nil {
+		t.Fatal(err)
+	}
+	next := func(what string) testSettings {
+		t.Helper()
+		select {
+		case s := <-applied:
+			return s
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for %s", what)
+			return testSettings{}
+		}
+	}
+	if s := next("the initial settings"); s.Limit != 20 || s.Interval.Std() != time.Second {
+		t.Errorf("initial settings %+v", s)
+	}
+
+	kv.Put(ctx, "edge.e1", []byte(`{"interval": "3s"}`))
+	if s := next("the instance settings"); s.Limit != 20 || s.Interval.Std() != 3*time.Second {
+		t.Errorf("after the instance key %+v", s)
+	}
+	kv.Put(ctx, "edge.defaults", []byte(`{"limit": 30, "interval": "9s"}`))
+	if s := next("the new defaults"); s.Limit != 30 || s.Interval.Std() != 3*time.Second {
+		t.Errorf("the instance key must win over the defaults: %+v", s)
+	}
+
+	// Other instances, other components and invalid documents are ignored
+	kv.Put(ctx, "edge.e2", []byte(`{"limit": 1}`))
+	kv.Put(ctx, "sensor.defaults", []byte(`{"limit": 2}`))
+	kv.Put(ctx, "edge.e1", []byte(`{"limit": -5}`))
+	kv.Delete(ctx, "edge.e1")
+	if s := next("the deleted instance key"); s.Limit != 30 || s.Interval.Std() != 9*time.Second {
+		t.Errorf("after deleting the instance key %+v", s)
+	}
+	select {
+	case s := <-applied:
+		t.Errorf("unexpected settings applied: %+v", s)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -0,0 +1,177 @@
+// Package settings defines the settings each component reads from the
+// central config bucket, with their defaults and checks. The components and
+// the cloud, which checks documents before storing them, share the types
+// here instead of importing each other.
+package settings
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"sistemas_distribuidos_gb/internal/anomaly"
+	"sistemas_distribuidos_gb/internal/config"
+	"sistemas_distribuidos_gb/internal/notify"
+	"sistemas_distribuidos_gb/internal/registry"
+)
+
+// Sensor are the simulation options that can be changed at runtime
+// (keys sensor.defaults and sensor.<id>).
+type Sensor struct {
+	Interval      config.Duration `json:"interval"`
+	BaseValue     float64         `json:"base"`
+	NoiseLevel    float64         `json:"noise"`
+	AnomalyChance float64         `json:"anomaly"`
+	SpikeChance   float64         `json:"spike"`
+}
+
+// DefaultSensor returns the settings of a sensor started with the default flags.
+func DefaultSensor() Sensor {
+	return Sensor{
+		Interval:      config.Duration(time.Second),
+		BaseValue:     50,
+		NoiseLevel:    2,
+		AnomalyChance: 0.005,
+		SpikeChance:   0.001,
+	}
+}
+
+// Validate rejects settings the simulation can't run with.
+func (s Sensor) Validate() error {
+	if s.NoiseLevel < 0 {
+		return errors.New("noise can't be negative")
+	}
+	if s.AnomalyChance < 0 || s.AnomalyChance > 1 || s.SpikeChance < 0 || s.SpikeChance > 1 {
+		return errors.New("anomaly and spike are probabilities, between 0 and 1")
+	}
+	return nil
+}
+
+// Edge are the edge options that can be changed at runtime (keys
+// edge.defaults and edge.<id>).
+type Edge struct {
+	// Thresholds apply to sensors without rules in the registry.
+	Thresholds        registry.Thresholds `json:"thresholds"`
+	NoiseFilter       float64             `json:"noise_filter"`
+	AggregateInterval config.Duration     `json:"aggregate_interval"`
+	// Detectors selects and tunes the per-sensor anomaly detectors.
+	Detectors anomaly.Config `json:"detectors"`
+}
+
+// DefaultEdge returns the settings of an edge started with the default flags.
+func DefaultEdge() Edge {
+	return Edge{
+		Thresholds: registry.Thresholds{
+			WarningMin:  40,
+			WarningMax:  60,
+			CriticalMin: 0,
+			CriticalMax: 100,
+		},
+		NoiseFilter:       3,
+		AggregateInterval: config.Duration(5 * time.Second),
+		Detectors:         anomaly.DefaultConfig(),
+	}
+}
+
+// Validate rejects settings the edge can't run with.
+func (s Edge) Validate() error {
+	if err := s.Thresholds.Validate(); err != nil {
+		return err
+	}
+	if s.NoiseFilter < 0 {
+		return errors.New("noise_filter can't be negative")
+	}
+	return s.Detectors.Validate()
+}
+
+// Dashboard are the dashboard options that can be changed at runtime (keys
+// dashboard.defaults and dashboard.<id>).
+type Dashboard struct {
+	MaxReadings int `json:"max_readings"`
+	MaxAlerts   int `json:"max_alerts"`
+}
+
+// DefaultDashboard returns the settings of a dashboard started with the
+// default flags.
+func DefaultDashboard() Dashboard {
+	return Dashboard{MaxReadings: 1000, MaxAlerts: 100}
+}
+
+// Validate rejects non-positive limits.
+func (s Dashboard) Validate() error {
+	if s.MaxReadings <= 0 || s.MaxAlerts <= 0 {
+		return errors.New("max_readings and max_alerts must be positive")
+	}
+	return nil
+}
+
+// Cloud are the cloud options that can be changed at runtime (keys
+// cloud.defaults and cloud.<id>).
+type Cloud struct {
+	StatsInterval config.Duration `json:"stats_interval"`
+	// NotifyRoutes replace the routes of the -notify file; the sinks stay
+	// those of the file.
+	NotifyRoutes []notify.Route `json:"notify_routes"`
+}
+
+// DefaultCloud returns the settings of a cloud started with the default flags.
+func DefaultCloud() Cloud {
+	return Cloud{StatsInterval: config.Duration(10 * time.Second)}
+}
+
+// Validate rejects malformed routes; unknown sinks are only found when the
+// routes are applied.
+func (s Cloud) Validate() error {
+	for i, r := range s.NotifyRoutes {
+		if err := r.Validate(); err != nil {
+			return fmt.Errorf("notify_routes %d: %w", i+1, err)
+		}
+	}
+	return nil
+}
+
+// ErrUnknownComponent is returned by Check for components without settings.
+var ErrUnknownComponent = errors.New("unknown component")
+
+// checks check a settings document of each component, laid over its
+// defaults document, the way the component does before applying it.
+var checks = map[string]func(defaults, doc []byte) error{
+	"cloud":     check(DefaultCloud()),
+	"dashboard": check(DefaultDashboard()),
+	"edge":      check(DefaultEdge()),
+	"sensor":    check(DefaultSensor()),
+}
+
+// Known reports whether component has settings in the config bucket.
+func Known(component string) bool {
+	_, ok := checks[component]
+	return ok
+}
+
+// Check rejects a settings document of component with unknown fields, or
+// that the component would ignore once laid over defaults, the document of
+// its defaults key (nil if there's none).
+func Check(component string, defaults, doc []byte) error {
+	c, ok := checks[component]
+	if !ok {
+		return fmt.Errorf("%w %s", ErrUnknownComponent, component)
+	}
+	return c(defaults, doc)
+}
+
+// check rejects documents with fields T doesn't have, and those
+// config.Merge would reject over base.
+func check[T any](base T) func(defaults, doc []byte) error {
+	return func(defaults, doc []byte) error {
+		dec := json.NewDecoder(bytes.NewReader(doc))
+		dec.DisallowUnknownFields()
+		var fields T
+		if err := dec.Decode(&fields); err != nil {
+			return err
+		}
+		_, err := config.Merge(base, defaults, doc)
+		return err
+	}
+}
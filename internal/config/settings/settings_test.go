@@ -0,0 +1,38 @@
+package settings
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	for _, tc := range []struct {
+		component     string
+		defaults, doc string
+		ok            bool
+	}{
+		{"edge", ``, `{"noise_filter": 2}`, true},
+		{"edge", ``, `{"noise_filtr": 2}`, false},
+		{"edge", ``, `{"noise_filter": -1}`, false},
+		{"edge", `{"thresholds": {"warning_min": 10, "warning_max": 20, "critical_min": 0, "critical_max": 30}}`, `{"thresholds": {"warning_max": 40}}`, false},
+		{"sensor", ``, `{"interval": "2s", "spike": 0.5}`, true},
+		{"sensor", `{"noise": 1}`, `{"anomaly": 2}`, false},
+		{"dashboard", ``, `{"max_alerts": 0}`, false},
+		{"cloud", ``, `{"stats_interval": "30s"}`, true},
+	} {
+		var defaults []byte
+		if tc.defaults != "" {
+			defaults = []byte(tc.defaults)
+		}
+		if err := Check(tc.component, defaults, []byte(tc.doc)); (err == nil) != tc.ok {
+			t.Errorf("Check(%s, %s, %s) = %v, want ok %v", tc.component, tc.defaults, tc.doc, err, tc.ok)
+		}
+	}
+
+	if Known("gateway") {
+		t.Error("gateway is known")
+	}
+	if err := Check("gateway", nil, []byte(`{}`)); !errors.Is(err, ErrUnknownComponent) {
+		t.Errorf("unknown component: %v", err)
+	}
+}
@@ -0,0 +1,647 @@
+// Package dashboard serves the web UI: live statistics, readings and alerts
+// of every edge, pushed to browsers with server-sent events, and their
+// history over a chosen time range.
+package dashboard
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+
+	"sistemas_distribuidos_gb/internal/audit"
+	"sistemas_distribuidos_gb/internal/auth"
+	"sistemas_distribuidos_gb/internal/broker"
+	"sistemas_distribuidos_gb/internal/clock"
+	"sistemas_distribuidos_gb/internal/config"
+	"sistemas_distribuidos_gb/internal/config/settings"
+	"sistemas_distribuidos_gb/internal/history"
+	"sistemas_distribuidos_gb/internal/registry"
+	"sistemas_distribuidos_gb/internal/subjects"
+)
+
+// DashboardData is the snapshot sent to browsers.
+type DashboardData struct {
+	Site            string           `json:"site,omitempty"`
+	TotalReadings   int64            `json:"total_readings"`
+	ReadingsPerSec  float64          `json:"readings_per_sec"`
+	Mean            float64          `json:"mean"`
+	StdDev          float64          `json:"std_dev"`
+	Min             float64          `json:"min"`
+	Max             float64          `json:"max"`
+	ActiveEdgeNodes int              `json:"active_edge_nodes"`
+	TotalAlerts     int              `json:"total_alerts"`
+	AlertsByType    map[string]int   `json:"alerts_by_type"`
+	AvgLatency      string           `json:"avg_latency"`
+	LatencyP95      string           `json:"latency_p95"`
+	LatencyP99      string           `json:"latency_p99"`
+	Uptime          time.Duration    `json:"uptime"`
+	Time            time.Time        `json:"time"` // of the server, for the pages to judge staleness
+	RecentReadings  []ReadingDisplay `json:"recent_readings"`
+	RecentAlerts    []AlertDisplay   `json:"recent_alerts"`
+	LatencyHistory  []float64        `json:"latency_history"` // avg latency in ms, sampled once a second for the last 60
+	EdgeNodes       map[string]int   `json:"edge_nodes"`
+}
+
+// Dashboard holds the live state behind the web UI.
+type Dashboard struct {
+	mu sync.RWMutex
+	DashboardData
+	startTime   time.Time
+	latencies   []time.Duration
+	readings    []float64
+	maxReadings int
+	maxAlerts   int
+	registry    *registry.Client        // nil when the broker isn't backed by NATS
+	history     *history.Store          // nil when disabled
+	audit       *audit.Log              // changes made on the settings page
+	seq         int64                   // of the last reading or alert
+	evictedSeq  int64                   // newest seq dropped from the recent readings and alerts
+	sensors     map[string]*SensorPanel // the grid, by sensor ID
+	edges       map[string]*edgeState   // the topology, by edge ID
+
+	// Browsers streaming /api/events, fed by the broadcaster
+	clientsMu sync.Mutex
+	clients   map[*sseClient]struct{}
+	sent      int64 // seq of the last reading or alert broadcast
+	wsClients map[*wsClient]struct{}
+
+	// configBucket is nil when JetStream isn't available
+	configBucket jetstream.KeyValue
+
+	opts   Options
+	broker broker.Broker
+	clock  clock.Clock
+	cancel context.CancelFunc
+	subs   []broker.Subscription
+	wg     sync.WaitGroup
+}
+
+type ReadingDisplay struct {
+	Seq       int64     `json:"seq"`
+	SensorID  string    `json:"sensor_id"`
+	Site      string    `json:"site,omitempty"`
+	Line      string    `json:"line,omitempty"`
+	Location  string    `json:"location,omitempty"`
+	Unit      string    `json:"unit,omitempty"`
+	Value     float64   `json:"value"`
+	EdgeID    string    `json:"edge_id"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+type AlertDisplay struct {
+	Seq       int64     `json:"seq"`
+	SensorID  string    `json:"sensor_id"`
+	Site      string    `json:"site,omitempty"`
+	Line      string    `json:"line,omitempty"`
+	Location  string    `json:"location,omitempty"`
+	Unit      string    `json:"unit,omitempty"`
+	Value     float64   `json:"value"`
+	EdgeID    string    `json:"edge_id"`
+	Type      string    `json:"type"`
+	Message   string    `json:"message"`
+	Detector  string    `json:"detector,omitempty"`
+	Score     float64   `json:"score,omitempty"`
+	Baseline  float64   `json:"baseline,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+	AckedAt   int64     `json:"acked_at,omitempty"` // Unix ms, once acknowledged
+}
+
+type FilteredReading struct {
+	SensorID  string  `json:"sensor_id"`
+	Site      string  `json:"site,omitempty"`
+	Line      string  `json:"line,omitempty"`
+	Location  string  `json:"location,omitempty"`
+	Unit      string  `json:"unit,omitempty"`
+	Value     float64 `json:"value"`
+	Timestamp int64   `json:"timestamp"`
+	EdgeID    string  `json:"edge_id"`
+}
+
+type Alert struct {
+	SensorID  string  `json:"sensor_id"`
+	Site      string  `json:"site,omitempty"`
+	Line      string  `json:"line,omitempty"`
+	Location  string  `json:"location,omitempty"`
+	Unit      string  `json:"unit,omitempty"`
+	Value     float64 `json:"value"`
+	Timestamp int64   `json:"timestamp"`
+	EdgeID    string  `json:"edge_id"`
+	Type      string  `json:"type"`
+	Message   string  `json:"message"`
+	Detector  string  `json:"detector,omitempty"`
+	Score     float64 `json:"score,omitempty"`
+	Baseline  float64 `json:"baseline,omitempty"`
+}
+
+// Options configure the dashboard.
+type Options struct {
+	ID          string // used to look up its settings
+	Site        string // only show readings and alerts from this site (all if empty)
+	MaxReadings int    // readings kept in memory
+	MaxAlerts   int    // alerts kept in memory
+	UseConfig   bool
+
+	// HistoryDir keeps every reading and alert for the history view;
+	// disabled if empty
+	HistoryDir       string
+	HistoryRetention time.Duration
+	// AuditFile keeps the log of the changes made on the settings page;
+	// only in memory if empty
+	AuditFile string
+	// Auth signs users in and checks their roles; without it anyone can
+	// see and change everything
+	Auth *auth.Auth
+
+	Clock clock.Clock // nil uses the wall clock
+}
+
+// DefaultOptions returns the options used by the dashboard command by default.
+func DefaultOptions() Options {
+	defaults := settings.DefaultDashboard()
+	return Options{
+		ID:          "dashboard",
+		MaxReadings: defaults.MaxReadings,
+		MaxAlerts:   defaults.MaxAlerts,
+		UseConfig:   true,
+
+		HistoryDir:       "data/history",
+		HistoryRetention: history.DefaultRetention,
+		AuditFile:        "data/audit.jsonl",
+	}
+}
+
+// New creates a dashboard fed through b.
+func New(b broker.Broker, opts Options) (*Dashboard, error) {
+	if opts.ID == "" {
+		opts.ID = "dashboard"
+	}
+	if opts.MaxReadings <= 0 || opts.MaxAlerts <= 0 {
+		return nil, fmt.Errorf("max readings and max alerts must be positive")
+	}
+	clk := clock.Or(opts.Clock)
+	return &Dashboard{
+		DashboardData: DashboardData{
+			Site:           opts.Site,
+			EdgeNodes:      make(map[string]int),
+			RecentReadings: make([]ReadingDisplay, 0),
+			RecentAlerts:   make([]AlertDisplay, 0),
+			AlertsByType:   make(map[string]int),
+			LatencyHistory: make([]float64, 0),
+			Min:            -1,
+			Max:            -1,
+		},
+		startTime:   clk.Now(),
+		latencies:   make([]time.Duration, 0),
+		readings:    make([]float64, 0),
+		maxReadings: opts.MaxReadings,
+		maxAlerts:   opts.MaxAlerts,
+		sensors:     make(map[string]*SensorPanel),
+		edges:       make(map[string]*edgeState),
+		clients:     make(map[*sseClient]struct{}),
+		wsClients:   make(map[*wsClient]struct{}),
+		opts:        opts,
+		broker:      b,
+		clock:       clk,
+	}, nil
+}
+
+// Start loads the settings, opens the history, subscribes to the readings
+// and alerts of every edge and starts streaming them to browsers.
+func (d *Dashboard) Start(ctx context.Context) error {
+	ctx, d.cancel = context.WithCancel(ctx)
+
+	if d.opts.HistoryDir != "" {
+		var err error
+		if d.history, err = history.Open(d.opts.HistoryDir, d.opts.HistoryRetention, d.clock); err != nil {
+			return fmt.Errorf("open history: %w", err)
+		}
+	}
+
+	var err error
+	if d.audit, err = audit.Open(d.opts.AuditFile, audit.DefaultSize, d.clock); err != nil {
+		return err
+	}
+
+	// Load settings, from the config bucket if available
+	base := d.opts.Settings()
+	nc, connErr := broker.Conn(d.broker)
+	if d.opts.UseConfig {
+		err := connErr
+		if err == nil {
+			err = config.Start(ctx, nc, "dashboard", d.opts.ID, base, d.applySettings)
+		}
+		if err != nil {
+			log.Printf("Central config unavailable, using flags: %v", err)
+		}
+	}
+
+	if connErr == nil {
+		d.registry, err = registry.NewClient(nc, 500*time.Millisecond)
+		if err != nil {
+			return fmt.Errorf("create registry client: %w", err)
+		}
+		// The settings page edits the config bucket
+		var js jetstream.JetStream
+		if js, err = jetstream.New(nc); err == nil {
+			d.configBucket, err = config.Open(ctx, js)
+		}
+		if err != nil {
+			log.Printf("Central config unavailable, only sensor thresholds can be edited: %v", err)
+		}
+	} else {
+		log.Printf("Sensor registry disabled: %v", connErr)
+	}
+
+	// Edge subjects don't carry the site, so scoping happens on the payload
+	scoped := func(readingSite string) bool {
+		return d.opts.Site == "" || subjects.Token(d.opts.Site, "") == readingSite
+	}
+
+	// Subscribe to filtered readings
+	err = d.subscribe(subjects.AllFiltered, func(_ string, data []byte) {
+		var filtered FilteredReading
+		if err := json.Unmarshal(data, &filtered); err != nil {
+			return
+		}
+		if !scoped(filtered.Site) {
+			return
+		}
+		d.processReading(filtered)
+	})
+	if err == nil {
+		// Subscribe to alerts
+		err = d.subscribe(subjects.AllAlerts, func(_ string, data []byte) {
+			var alert Alert
+			if err := json.Unmarshal(data, &alert); err != nil {
+				log.Printf("Error unmarshaling alert: %v", err)
+				return
+			}
+			if !scoped(alert.Site) {
+				return
+			}
+			d.processAlert(alert)
+		})
+	}
+	if err != nil {
+		d.Stop()
+		return err
+	}
+
+	ticker := d.clock.NewTicker(broadcastInterval)
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		d.broadcastLoop(ctx, ticker)
+	}()
+	return nil
+}
+
+func (d *Dashboard) subscribe(subject string, handler broker.Handler) error {
+	sub, err := d.broker.Subscribe(subject, handler)
+	if err != nil {
+		return fmt.Errorf("failed to subscribe to %s: %w", subject, err)
+	}
+	d.subs = append(d.subs, sub)
+	return nil
+}
+
+// Stop unsubscribes from the broker, disconnects the browsers and closes
+// the history.
+func (d *Dashboard) Stop() {
+	if d.cancel != nil {
+		d.cancel()
+	}
+	d.wg.Wait()
+	d.closeClients()
+	for _, sub := range d.subs {
+		sub.Unsubscribe()
+	}
+	d.subs = nil
+	if d.registry != nil {
+		d.registry.Close()
+	}
+	if d.history != nil {
+		if err := d.history.Close(); err != nil {
+			log.Printf("Error closing history: %v", err)
+		}
+	}
+	if d.audit != nil {
+		d.audit.Close()
+	}
+}
+
+// Handler returns the web UI, its assets under /static/ and its API: /,
+// /sensor/<id>, /edge/<id>, /settings, /api/data, /api/events, /api/ws,
+// /api/sensors, /api/grid, /api/topology, /api/history, /api/export, /api/me,
+// /api/alerts/ack, /api/settings and /api/audit. With Options.Auth, also
+// /login, /logout and /auth/oidc/, and each route needs the role it's
+// registered with.
+func (d *Dashboard) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/static/", staticHandler())
+	if d.opts.Auth != nil {
+		mux.HandleFunc("/login", d.handleLogin)
+		mux.Handle("/logout", d.allow(auth.Viewer, d.handleLogout))
+		mux.HandleFunc("/auth/oidc/login", d.handleOIDCLogin)
+		mux.HandleFunc("/auth/oidc/callback", d.handleOIDCCallback)
+	}
+
+	mux.Handle("/", d.allow(auth.Viewer, d.handleIndex))
+	mux.Handle("/sensor/", d.allow(auth.Viewer, d.handleSensorPage))
+	mux.Handle("/edge/", d.allow(auth.Viewer, d.handleEdgePage))
+	mux.Handle("/api/data", d.allow(auth.Viewer, d.handleAPI))
+	mux.Handle("/api/events", d.allow(auth.Viewer, d.handleSSE))
+	mux.Handle("/api/ws", d.allow(auth.Viewer, d.handleWS))
+	mux.Handle("/api/sensors", d.allow(auth.Viewer, d.handleSensors))
+	mux.Handle("/api/grid", d.allow(auth.Viewer, d.handleGrid))
+	mux.Handle("/api/topology", d.allow(auth.Viewer, d.handleTopology))
+	mux.Handle("/api/history", d.allow(auth.Viewer, d.handleHistory))
+	mux.Handle("/api/history/alerts", d.allow(auth.Viewer, d.handleHistoryAlerts))
+	mux.Handle("/api/history/sensors", d.allow(auth.Viewer, d.handleHistorySensors))
+	mux.Handle("/api/export", d.allow(auth.Viewer, d.handleExport))
+	mux.Handle("/api/me", d.allow(auth.Viewer, d.handleMe))
+
+	mux.Handle("/api/alerts/ack", d.allow(auth.Operator, d.handleAck))
+
+	mux.Handle("/settings", d.allow(auth.Admin, d.handleSettingsPage))
+	mux.Handle("/api/settings/sensors/", d.allow(auth.Admin, d.handleSensorSettings))
+	mux.Handle("/api/settings/edge", d.allow(auth.Admin, d.handleEdgeSettingsList))
+	mux.Handle("/api/settings/edge/", d.allow(auth.Admin, d.handleEdgeSettings))
+	mux.Handle("/api/settings/notify", d.allow(auth.Admin, d.handleNotifySettings))
+	mux.Handle("/api/audit", d.allow(auth.Admin, d.handleAudit))
+	return mux
+}
+
+// Settings returns the settings a dashboard started with o runs on until
+// the central config says otherwise.
+func (o Options) Settings() settings.Dashboard {
+	return settings.Dashboard{MaxReadings: o.MaxReadings, MaxAlerts: o.MaxAlerts}
+}
+
+func (d *Dashboard) applySettings(s settings.Dashboard) {
+	if s.MaxReadings <= 0 || s.MaxAlerts <= 0 {
+		log.Printf("Ignoring settings with non-positive limits: %+v", s)
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.maxReadings = s.MaxReadings
+	d.maxAlerts = s.MaxAlerts
+	if len(d.readings) > d.maxReadings {
+		d.readings = d.readings[len(d.readings)-d.maxReadings:]
+	}
+	d.trimRecent()
+	log.Printf("Settings applied: max_readings=%d, max_alerts=%d", s.MaxReadings, s.MaxAlerts)
+}
+
+// label fills in metadata the edge didn't attach, looking it up in the registry.
+func (d *Dashboard) label(sensorID string, location, unit *string) {
+	if (*location != "" && *unit != "") || d.registry == nil {
+		return
+	}
+	if sensor, ok := d.registry.Lookup(sensorID); ok {
+		if *location == "" {
+			*location = sensor.Location
+		}
+		if *unit == "" {
+			*unit = sensor.Unit
+		}
+	}
+}
+
+func (d *Dashboard) processReading(reading FilteredReading) {
+	d.label(reading.SensorID, &reading.Location, &reading.Unit)
+
+	now := d.clock.Now()
+	if d.history != nil {
+		if err := d.history.AddReading(history.Reading(reading)); err != nil {
+			log.Printf("Error storing reading history: %v", err)
+		}
+	}
+	latency := time.Duration(now.UnixMilli()-reading.Timestamp) * time.Millisecond
+	if latency < 0 {
+		latency = 0
+	} // Prevent negative latency
+
+	d.mu.Lock()
+	d.TotalReadings++
+	d.readings = append(d.readings, reading.Value)
+	if len(d.readings) > d.maxReadings {
+		d.readings = d.readings[1:]
+	}
+
+	// Update min/max
+	if d.Min < 0 || reading.Value < d.Min {
+		d.Min = reading.Value
+	}
+	if d.Max < 0 || reading.Value > d.Max {
+		d.Max = reading.Value
+	}
+
+	// Track edge nodes
+	d.EdgeNodes[reading.EdgeID]++
+	d.ActiveEdgeNodes = len(d.EdgeNodes)
+
+	// Track latencies
+	d.latencies = append(d.latencies, latency)
+	if len(d.latencies) > 1000 {
+		d.latencies = d.latencies[1:]
+	}
+
+	// Add to recent readings
+	d.seq++
+	display := ReadingDisplay{
+		Seq:       d.seq,
+		SensorID:  reading.SensorID,
+		Site:      reading.Site,
+		Line:      reading.Line,
+		Location:  reading.Location,
+		Unit:      reading.Unit,
+		Value:     reading.Value,
+		EdgeID:    reading.EdgeID,
+		Timestamp: now,
+	}
+	d.RecentReadings = append([]ReadingDisplay{display}, d.RecentReadings...)
+	d.trimRecent()
+	d.trackReading(display)
+	d.mu.Unlock()
+
+	d.fanOut(wsMessage{Type: "reading", Reading: &display})
+}
+
+func (d *Dashboard) processAlert(alert Alert) {
+	d.label(alert.SensorID, &alert.Location, &alert.Unit)
+
+	if d.history != nil {
+		if err := d.history.AddAlert(history.Alert(alert)); err != nil {
+			log.Printf("Error storing alert history: %v", err)
+		}
+	}
+
+	d.mu.Lock()
+	d.TotalAlerts++
+	d.AlertsByType[alert.Type]++
+
+	d.seq++
+	display := AlertDisplay{
+		Seq:       d.seq,
+		SensorID:  alert.SensorID,
+		Site:      alert.Site,
+		Line:      alert.Line,
+		Location:  alert.Location,
+		Unit:      alert.Unit,
+		Value:     alert.Value,
+		EdgeID:    alert.EdgeID,
+		Type:      alert.Type,
+		Message:   alert.Message,
+		Detector:  alert.Detector,
+		Score:     alert.Score,
+		Baseline:  alert.Baseline,
+		Timestamp: d.clock.Now(),
+	}
+
+	d.RecentAlerts = append([]AlertDisplay{display}, d.RecentAlerts...)
+	d.trimRecent()
+	d.trackAlert(display)
+	d.mu.Unlock()
+
+	d.fanOut(wsMessage{Type: "alert", Alert: &display})
+}
+
+// trimRecent drops the oldest recent readings and alerts beyond the limits,
+// remembering the newest seq dropped: streams that missed it can't resume.
+func (d *Dashboard) trimRecent() {
+	if len(d.RecentReadings) > d.maxReadings {
+		d.evictedSeq = max(d.evictedSeq, d.RecentReadings[d.maxReadings].Seq)
+		d.RecentReadings = d.RecentReadings[:d.maxReadings]
+	}
+	if len(d.RecentAlerts) > d.maxAlerts {
+		d.evictedSeq = max(d.evictedSeq, d.RecentAlerts[d.maxAlerts].Seq)
+		d.RecentAlerts = d.RecentAlerts[:d.maxAlerts]
+	}
+}
+
+// getStats returns a snapshot of the statistics and recent entries.
+func (d *Dashboard) getStats() DashboardData {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.statsLocked()
+}
+
+// statsLocked computes the snapshot; d.mu must be held.
+func (d *Dashboard) statsLocked() DashboardData {
+	stats := d.DashboardData // Shallow copy
+	// Manually copy maps and slices to avoid race conditions on read
+	stats.EdgeNodes = make(map[string]int)
+	for k, v := range d.EdgeNodes {
+		stats.EdgeNodes[k] = v
+	}
+	stats.AlertsByType = make(map[string]int)
+	for k, v := range d.AlertsByType {
+		stats.AlertsByType[k] = v
+	}
+	stats.LatencyHistory = append([]float64(nil), d.LatencyHistory...)
+
+	stats.Time = d.clock.Now()
+	stats.Uptime = stats.Time.Sub(d.startTime)
+	stats.ReadingsPerSec = float64(d.TotalReadings) / stats.Uptime.Seconds()
+
+	// Calculate mean and std dev
+	if len(d.readings) > 0 {
+		var sum float64
+		for _, v := range d.readings {
+			sum += v
+		}
+		stats.Mean = sum / float64(len(d.readings))
+
+		var variance float64
+		for _, v := range d.readings {
+			variance += (v - stats.Mean) * (v - stats.Mean)
+		}
+		if len(d.readings) > 1 {
+			variance = variance / float64(len(d.readings))
+			stats.StdDev = math.Sqrt(variance)
+		}
+	}
+
+	// Calculate latency percentiles
+	if len(d.latencies) > 0 {
+		stats.AvgLatency = fmt.Sprintf("%.2fms", float64(d.avgLatency().Microseconds())/1000.0)
+
+		sorted := make([]time.Duration, len(d.latencies))
+		copy(sorted, d.latencies)
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+		p95Idx := int(float64(len(sorted)) * 0.95)
+		p99Idx := int(float64(len(sorted)) * 0.99)
+		if p95Idx >= len(sorted) {
+			p95Idx = len(sorted) - 1
+		}
+		if p99Idx >= len(sorted) {
+			p99Idx = len(sorted) - 1
+		}
+		stats.LatencyP95 = fmt.Sprintf("%.2fms", float64(sorted[p95Idx].Microseconds())/1000.0)
+		stats.LatencyP99 = fmt.Sprintf("%.2fms", float64(sorted[p99Idx].Microseconds())/1000.0)
+	}
+
+	return stats
+}
+
+// avgLatency averages the latencies kept; d.mu must be held.
+func (d *Dashboard) avgLatency() time.Duration {
+	var sum time.Duration
+	for _, l := range d.latencies {
+		sum += l
+	}
+	return sum / time.Duration(len(d.latencies))
+}
+
+// sampleLatency appends the average latency to the history (keeping the last
+// 60 points, in ms). The broadcaster calls it once per tick; d.mu must be held.
+func (d *Dashboard) sampleLatency() {
+	if len(d.latencies) == 0 {
+		return
+	}
+	d.LatencyHistory = append(d.LatencyHistory, float64(d.avgLatency().Microseconds())/1000.0)
+	if len(d.LatencyHistory) > 60 {
+		d.LatencyHistory = d.LatencyHistory[1:]
+	}
+}
+
+func (d *Dashboard) handleIndex(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := pages.ExecuteTemplate(w, "index.html", rules); err != nil {
+		log.Printf("Error rendering index: %v", err)
+	}
+}
+
+func (d *Dashboard) handleAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	stats := d.getStats()
+	json.NewEncoder(w).Encode(stats)
+}
+
+func (d *Dashboard) handleSensors(w http.ResponseWriter, r *http.Request) {
+	if d.registry == nil {
+		http.Error(w, "registry unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	sensors, err := d.registry.List()
+	if err != nil {
+		http.Error(w, "registry unavailable: "+err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sensors)
+}
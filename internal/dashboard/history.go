@@ -0,0 +1,101 @@
+package dashboard
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"sistemas_distribuidos_gb/internal/export"
+	"sistemas_distribuidos_gb/internal/history"
+)
+
+// maxHistoryAlerts bounds the limit of /api/history/alerts.
+const maxHistoryAlerts = 1000
+
+// historyQuery reads the time range and filters of a history request, as
+// history.ParseQuery does.
+func (d *Dashboard) historyQuery(r *http.Request) (history.Query, error) {
+	return history.ParseQuery(r.URL.Query(), d.clock.Now())
+}
+
+// handleHistory serves the readings of a time range aggregated in buckets:
+// the count, min, max and mean of each step.
+func (d *Dashboard) handleHistory(w http.ResponseWriter, r *http.Request) {
+	if d.history == nil {
+		http.Error(w, "history disabled", http.StatusServiceUnavailable)
+		return
+	}
+	q, err := d.historyQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	series, err := d.history.ReadingSeries(q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(series)
+}
+
+// handleHistoryAlerts serves the alerts of a time range, newest first, up to
+// limit (default 100).
+func (d *Dashboard) handleHistoryAlerts(w http.ResponseWriter, r *http.Request) {
+	if d.history == nil {
+		http.Error(w, "history disabled", http.StatusServiceUnavailable)
+		return
+	}
+	q, err := d.historyQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	limit := 100
+	if s := r.URL.Query().Get("limit"); s != "" {
+		if limit, err = strconv.Atoi(s); err != nil || limit <= 0 || limit > maxHistoryAlerts {
+			http.Error(w, fmt.Sprintf("limit must be between 1 and %d", maxHistoryAlerts), http.StatusBadRequest)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(d.history.Alerts(q, limit))
+}
+
+// handleExport streams the readings or alerts of a time range as a CSV,
+// JSON lines or Parquet file, see export.Serve.
+func (d *Dashboard) handleExport(w http.ResponseWriter, r *http.Request) {
+	if d.history == nil {
+		http.Error(w, "history disabled", http.StatusServiceUnavailable)
+		return
+	}
+	export.Serve(w, r, d.history, d.clock.Now())
+}
+
+// handleHistorySensors serves the last stored reading of every sensor.
+func (d *Dashboard) handleHistorySensors(w http.ResponseWriter, r *http.Request) {
+	if d.history == nil {
+		http.Error(w, "history disabled", http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(d.history.Latest())
+}
+
+// handleSensorPage serves the drill-down page of /sensor/<id>.
+func (d *Dashboard) handleSensorPage(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/sensor/")
+	if id == "" || strings.Contains(id, "/") {
+		http.NotFound(w, r)
+		return
+	}
+	data := struct{ SensorID, Location, Unit string }{SensorID: id}
+	d.label(id, &data.Location, &data.Unit)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := pages.ExecuteTemplate(w, "sensor.html", data); err != nil {
+		log.Printf("Error rendering page of sensor %s: %v", id, err)
+	}
+}
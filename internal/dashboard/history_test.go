@@ -0,0 +1,132 @@
+package dashboard
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"sistemas_distribuidos_gb/internal/broker"
+	"sistemas_distribuidos_gb/internal/clock"
+	"sistemas_distribuidos_gb/internal/history"
+	"sistemas_distribuidos_gb/internal/subjects"
+)
+
+// TestHistory feeds the dashboard through an in-process broker, restarts it
+// and queries the history it kept.
+func TestHistory(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	b := broker.NewMemory()
+	start := time.Date(2024, 1, 1, 8, 0, 0, 0, time.UTC)
+	clk := clock.NewFake(start.Add(time.Hour))
+	opts := DefaultOptions()
+	opts.UseConfig = false
+	opts.Clock = clk
+	opts.HistoryDir = t.TempDir()
+	run := func() (*Dashboard, *httptest.Server) {
+		d, err := New(b, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := d.Start(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		return d, httptest.NewServer(d.Handler())
+	}
+
+	d, srv := run()
+	for i := 0; i < 6; i++ {
+		ts := start.Add(time.Duration(i) * 10 * time.Minute).UnixMilli()
+		data, _ := json.Marshal(FilteredReading{SensorID: "s1", Value: float64(i), Timestamp: ts, EdgeID: "edge-1"})
+		b.Publish(subjects.Filtered("edge-1"), data)
+		data, _ = json.Marshal(FilteredReading{SensorID: "s2", Value: 50, Timestamp: ts, EdgeID: "edge-2"})
+		b.Publish(subjects.Filtered("edge-2"), data)
+	}
+	data, _ := json.Marshal(Alert{SensorID: "s1", Value: 5, EdgeID: "edge-1", Type: "critical", Timestamp: start.Add(50 * time.Minute).UnixMilli()})
+	b.Publish(subjects.Alerts("edge-1"), data)
+	srv.Close()
+	d.Stop()
+
+	// The history outlives the dashboard
+	d, srv = run()
+	defer d.Stop()
+	defer srv.Close()
+
+	get := func(path string, v interface{}) int {
+		t.Helper()
+		resp, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if v != nil && resp.StatusCode == http.StatusOK {
+			if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return resp.StatusCode
+	}
+
+	var series history.Series
+	get("/api/history?range=1h&sensor=s1&step=30m", &series)
+	if series.Count != 6 || len(series.Buckets) != 2 || series.Buckets[1].Mean != 4 {
+		t.Errorf("history of s1 %+v, want 6 readings in 2 buckets", series)
+	}
+	get("/api/history?from="+start.Add(30*time.Minute).Format(time.RFC3339)+"&edge=edge-2", &series)
+	if series.Count != 3 || series.To != clk.Now().UnixMilli() {
+		t.Errorf("history of edge-2 since 08:30 %+v, want 3 readings until now", series)
+	}
+
+	var alerts []history.Alert
+	get("/api/history/alerts?range=2h&sensor=s1", &alerts)
+	if len(alerts) != 1 || alerts[0].Type != "critical" {
+		t.Errorf("alerts of s1 %+v", alerts)
+	}
+
+	var latest []history.Reading
+	get("/api/history/sensors", &latest)
+	if len(latest) != 2 || latest[0].Value != 5 {
+		t.Errorf("latest readings %+v", latest)
+	}
+
+	for _, bad := range []string{"range=soon", "from=yesterday", "from=2&to=1", "step=-1s", "range=1h&step=1ms", "range=1m&step=500us"} {
+		if code := get("/api/history?"+bad, nil); code != http.StatusBadRequest {
+			t.Errorf("GET /api/history?%s: %d, want 400", bad, code)
+		}
+	}
+
+	// The export streams the raw records of the same range and filters
+	resp, err := http.Get(srv.URL + "/api/export?range=1h&edge=edge-2&format=jsonl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	exported, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if lines := strings.Count(string(exported), "\n"); lines != 6 || !strings.Contains(resp.Header.Get("Content-Disposition"), "readings-20240101T0800Z-20240101T0900Z.jsonl") {
+		t.Errorf("export of edge-2: %d lines as %q", lines, resp.Header.Get("Content-Disposition"))
+	}
+
+	for path, want := range map[string]string{
+		"/":            `<div class="range-picker">`,
+		"/sensor/s1":   `<body data-sensor="s1"`,
+		"/edge/edge-1": `class="range-export"`,
+	} {
+		resp, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		page, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || !strings.Contains(string(page), want) {
+			t.Errorf("GET %s: %s, page without %s", path, resp.Status, want)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package dashboard
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+
+	"sistemas_distribuidos_gb/internal/auth"
+)
+
+// With Options.Auth, viewers see the dashboard, operators also acknowledge
+// alerts and admins also use the settings page. Sign ins, sign outs and
+// acknowledgements go to the audit log, next to the settings changes.
+
+// allow serves h to users whose role allows role, or to anyone without
+// Options.Auth.
+func (d *Dashboard) allow(role auth.Role, h http.HandlerFunc) http.Handler {
+	if d.opts.Auth == nil {
+		return h
+	}
+	return d.opts.Auth.Require(role, h)
+}
+
+// role returns the role of the user making r: that of their session, or
+// admin without Options.Auth.
+func (d *Dashboard) role(r *http.Request) auth.Role {
+	if s := auth.FromContext(r.Context()); s != nil {
+		return s.Role
+	}
+	if d.opts.Auth == nil {
+		return auth.Admin
+	}
+	return ""
+}
+
+// safeNext returns next if it's a path of this server, "/" otherwise, so
+// the login page can't send users elsewhere.
+func safeNext(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return "/"
+	}
+	return next
+}
+
+// loginPage is the data of the login page.
+type loginPage struct {
+	CSRF  string
+	Next  string
+	Error string
+	OIDC  bool
+}
+
+func (d *Dashboard) renderLogin(w http.ResponseWriter, r *http.Request, status int, next, message string) {
+	page := loginPage{CSRF: d.opts.Auth.FormToken(w, r), Next: next, Error: message, OIDC: d.opts.Auth.HasOIDC()}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if err := pages.ExecuteTemplate(w, "login.html", page); err != nil {
+		log.Printf("Error rendering login page: %v", err)
+	}
+}
+
+// handleLogin shows the login form (GET) and signs local users in (POST).
+func (d *Dashboard) handleLogin(w http.ResponseWriter, r *http.Request) {
+	next := safeNext(r.FormValue("next"))
+	switch r.Method {
+	case http.MethodGet:
+		if d.opts.Auth.Session(r) != nil {
+			http.Redirect(w, r, next, http.StatusSeeOther)
+			return
+		}
+		d.renderLogin(w, r, http.StatusOK, next, "")
+
+	case http.MethodPost:
+		if !auth.CheckFormToken(r) {
+			d.renderLogin(w, r, http.StatusForbidden, next, "Sessão do formulário expirada, tente de novo.")
+			return
+		}
+		name := r.PostFormValue("user")
+		user, err := d.opts.Auth.Login(name, r.PostFormValue("password"))
+		if err != nil {
+			log.Printf("Failed login for %q from %s", name, actor(r))
+			d.renderLogin(w, r, http.StatusUnauthorized, next, "Usuário ou senha inválidos.")
+			return
+		}
+		d.opts.Auth.StartSession(w, r, user.Name, user.Role)
+		d.record(user.Name, "session.login", user.Name, nil, map[string]string{"method": "password", "role": string(user.Role)})
+		http.Redirect(w, r, next, http.StatusSeeOther)
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleLogout signs the user out. It's a POST, with the CSRF token.
+func (d *Dashboard) handleLogout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	s := auth.FromContext(r.Context())
+	d.opts.Auth.EndSession(w, r)
+	d.record(s.User, "session.logout", s.User, nil, nil)
+	http.Redirect(w, r, "/login", http.StatusSeeOther)
+}
+
+// handleOIDCLogin sends the browser to the OIDC provider.
+func (d *Dashboard) handleOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	next := safeNext(r.FormValue("next"))
+	target, err := d.opts.Auth.OIDCRedirect(w, next)
+	if err != nil {
+		log.Printf("OIDC sign in unavailable: %v", err)
+		d.renderLogin(w, r, http.StatusServiceUnavailable, next, "Provedor de identidade indisponível.")
+		return
+	}
+	http.Redirect(w, r, target, http.StatusFound)
+}
+
+// handleOIDCCallback signs in the user the OIDC provider sent back.
+func (d *Dashboard) handleOIDCCallback(w http.ResponseWriter, r *http.Request) {
+	user, role, next, err := d.opts.Auth.OIDCCallback(w, r)
+	if err != nil {
+		log.Printf("OIDC sign in failed from %s: %v", actor(r), err)
+		d.renderLogin(w, r, http.StatusUnauthorized, "/", "Não foi possível entrar pelo provedor de identidade.")
+		return
+	}
+	d.opts.Auth.StartSession(w, r, user, role)
+	d.record(user, "session.login", user, nil, map[string]string{"method": "oidc", "role": string(role)})
+	http.Redirect(w, r, safeNext(next), http.StatusSeeOther)
+}
+
+// me is the body of /api/me. User is empty without Options.Auth.
+type me struct {
+	User string    `json:"user,omitempty"`
+	Role auth.Role `json:"role"`
+}
+
+// handleMe serves the signed-in user and their role, for the pages to show
+// only what the role allows.
+func (d *Dashboard) handleMe(w http.ResponseWriter, r *http.Request) {
+	m := me{Role: d.role(r)}
+	if s := auth.FromContext(r.Context()); s != nil {
+		m.User = s.User
+	}
+	writeJSON(w, http.StatusOK, m)
+}
+
+// handleAck serves POST /api/alerts/ack, {"seq": <seq>}, which acknowledges
+// a recent alert, as the WebSocket ack command does.
+func (d *Dashboard) handleAck(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var body struct {
+		Seq int64 `json:"seq"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "invalid ack: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	acked, err := d.ackAlert(body.Seq)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	d.record(actor(r), "alert.ack", acked.SensorID, nil, acked)
+	writeJSON(w, http.StatusOK, acked)
+}
@@ -0,0 +1,197 @@
+package dashboard
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+
+	"sistemas_distribuidos_gb/internal/auth"
+	"sistemas_distribuidos_gb/internal/broker"
+	"sistemas_distribuidos_gb/internal/subjects"
+)
+
+// browser is a test client of a dashboard with login, keeping its cookies.
+type browser struct {
+	t      *testing.T
+	base   string
+	client *http.Client
+}
+
+func newBrowser(t *testing.T, base string) *browser {
+	jar, _ := cookiejar.New(nil)
+	return &browser{t: t, base: base, client: &http.Client{
+		Jar:           jar,
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}}
+}
+
+func (b *browser) cookie(name string) string {
+	u, _ := url.Parse(b.base)
+	for _, c := range b.client.Jar.Cookies(u) {
+		if c.Name == name {
+			return c.Value
+		}
+	}
+	return ""
+}
+
+// do sends a request, with the CSRF token of the session if csrf is set,
+// and returns the response with its body read.
+func (b *browser) do(method, path, body string, csrf bool) (*http.Response, string) {
+	b.t.Helper()
+	req, _ := http.NewRequest(method, b.base+path, strings.NewReader(body))
+	if strings.HasPrefix(body, "{") {
+		req.Header.Set("Content-Type", "application/json")
+	} else {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	if csrf {
+		req.Header.Set(auth.CSRFHeader, b.cookie("csrf"))
+	}
+	resp, err := b.client.Do(req)
+	if err != nil {
+		b.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	return resp, string(data)
+}
+
+func (b *browser) login(user, password string) *http.Response {
+	b.t.Helper()
+	b.do("GET", "/login", "", false)
+	form := url.Values{"user": {user}, "password": {password}, "csrf": {b.cookie("csrf")}, "next": {"/edge/e1"}}
+	resp, _ := b.do("POST", "/login", form.Encode(), false)
+	return resp
+}
+
+// TestLogin checks what each role may do, and that sign ins and operator
+// actions are audited under the user's name.
+func TestLogin(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	users := map[string]auth.User{}
+	for name, role := range map[string]auth.Role{"ana": auth.Admin, "bruno": auth.Operator, "carla": auth.Viewer} {
+		hash, err := auth.HashPassword(name + "-secret")
+		if err != nil {
+			t.Fatal(err)
+		}
+		users[name] = auth.User{Name: name, Role: role, PasswordHash: hash}
+	}
+	a, err := auth.New(auth.Options{Users: users})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := broker.NewMemory()
+	opts := DefaultOptions()
+	opts.UseConfig = false
+	opts.HistoryDir = ""
+	opts.AuditFile = ""
+	opts.Auth = a
+	d, err := New(b, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer d.Stop()
+	srv := httptest.NewServer(d.Handler())
+	defer srv.Close()
+
+	anonymous := newBrowser(t, srv.URL)
+	if resp, _ := anonymous.do("GET", "/sensor/s1", "", false); resp.StatusCode != http.StatusSeeOther || resp.Header.Get("Location") != "/login?next=%2Fsensor%2Fs1" {
+		t.Errorf("page without login: %s to %q", resp.Status, resp.Header.Get("Location"))
+	}
+	if resp, _ := anonymous.do("GET", "/api/data", "", false); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("API without login: %s, want 401", resp.Status)
+	}
+	if resp, _ := anonymous.do("GET", "/static/style.css", "", false); resp.StatusCode != http.StatusOK {
+		t.Errorf("static asset without login: %s", resp.Status)
+	}
+	if resp := anonymous.login("ana", "wrong"); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("wrong password: %s, want 401", resp.Status)
+	}
+	form := url.Values{"user": {"ana"}, "password": {"ana-secret"}, "csrf": {"forged"}}
+	if resp, _ := anonymous.do("POST", "/login", form.Encode(), false); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("login without the form's CSRF token: %s, want 403", resp.Status)
+	}
+
+	viewer, operator, admin := newBrowser(t, srv.URL), newBrowser(t, srv.URL), newBrowser(t, srv.URL)
+	for _, c := range []struct {
+		b    *browser
+		user string
+	}{{viewer, "carla"}, {operator, "bruno"}, {admin, "ana"}} {
+		if resp := c.b.login(c.user, c.user+"-secret"); resp.StatusCode != http.StatusSeeOther || resp.Header.Get("Location") != "/edge/e1" {
+			t.Fatalf("login %s: %s to %q", c.user, resp.Status, resp.Header.Get("Location"))
+		}
+	}
+	if _, body := viewer.do("GET", "/api/me", "", false); !strings.Contains(body, `"user":"carla","role":"viewer"`) {
+		t.Errorf("/api/me = %s", body)
+	}
+
+	data, _ := json.Marshal(Alert{SensorID: "temp-01", EdgeID: "edge-1", Type: "critical"})
+	b.Publish(subjects.Alerts("edge-1"), data)
+	seq := d.getStats().RecentAlerts[0].Seq
+	ack := fmt.Sprintf(`{"seq": %d}`, seq)
+
+	if resp, _ := viewer.do("POST", "/api/alerts/ack", ack, true); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("viewer ack: %s, want 403", resp.Status)
+	}
+	if resp, _ := viewer.do("GET", "/settings", "", false); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("viewer settings page: %s, want 403", resp.Status)
+	}
+	if resp, _ := operator.do("POST", "/api/alerts/ack", ack, false); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("ack without CSRF token: %s, want 403", resp.Status)
+	}
+	if resp, body := operator.do("POST", "/api/alerts/ack", ack, true); resp.StatusCode != http.StatusOK || !strings.Contains(body, "acked_at") {
+		t.Errorf("operator ack: %s %s", resp.Status, body)
+	}
+	if resp, _ := operator.do("GET", "/api/audit", "", false); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("operator audit: %s, want 403", resp.Status)
+	}
+
+	// Over the WebSocket, acks need the operator role too
+	header := http.Header{"Cookie": {"session=" + viewer.cookie("session")}, "Origin": {srv.URL}}
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/api/ws", header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ws := &wsConn{t: t, conn: conn}
+	if res := ws.command(wsCommand{Type: "ack", ID: "1", Seq: seq}); !strings.Contains(res.Error, "forbidden") {
+		t.Errorf("viewer ack over WebSocket: %+v, want forbidden", res)
+	}
+	conn.Close()
+
+	if resp, _ := viewer.do("POST", "/logout", "", true); resp.StatusCode != http.StatusSeeOther {
+		t.Errorf("logout: %s", resp.Status)
+	}
+	if resp, _ := viewer.do("GET", "/api/me", "", false); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("/api/me after logout: %s, want 401", resp.Status)
+	}
+
+	var entries []struct{ Actor, Action, Target string }
+	_, body := admin.do("GET", "/api/audit", "", false)
+	json.Unmarshal([]byte(body), &entries)
+	var got []string
+	for _, e := range entries {
+		got = append(got, e.Actor+" "+e.Action)
+	}
+	want := "carla session.login, bruno session.login, ana session.login, bruno alert.ack, carla session.logout"
+	if strings.Join(got, ", ") != want {
+		t.Errorf("audit log: %s\nwant: %s", strings.Join(got, ", "), want)
+	}
+}
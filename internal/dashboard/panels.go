@@ -0,0 +1,228 @@
+package dashboard
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// sparklinePoints is how many recent values each sensor keeps for its
+// sparkline.
+const sparklinePoints = 60
+
+// A sensor without readings for staleAfter is stale; one with an alert in the
+// last alertWindow is in the state of that alert. The pages apply the same
+// rules to what they receive afterwards.
+const (
+	staleAfter  = time.Minute
+	alertWindow = 5 * time.Minute
+)
+
+// Sensor and edge states, from best to worst.
+const (
+	StateOK       = "ok"
+	StateWarning  = "warning" // any alert but a critical one
+	StateCritical = "critical"
+	StateStale    = "stale"
+)
+
+// SensorPanel is a sensor of the grid.
+type SensorPanel struct {
+	SensorID  string    `json:"sensor_id"`
+	EdgeID    string    `json:"edge_id"` // of the last reading
+	Site      string    `json:"site,omitempty"`
+	Location  string    `json:"location,omitempty"`
+	Unit      string    `json:"unit,omitempty"`
+	Value     float64   `json:"value"`
+	Timestamp time.Time `json:"timestamp"`
+	Count     int64     `json:"count"`
+	Min       float64   `json:"min"`
+	Max       float64   `json:"max"`
+	Sparkline []float64 `json:"sparkline"` // last values, oldest first
+	AlertType string    `json:"alert_type,omitempty"`
+	AlertTime time.Time `json:"alert_time"`
+	State     string    `json:"state"`
+}
+
+// EdgePanel is an edge of the topology view, with the sensors whose last
+// reading came through it.
+type EdgePanel struct {
+	EdgeID   string    `json:"edge_id"`
+	Sensors  []string  `json:"sensors"`
+	Readings int64     `json:"readings"`
+	Alerts   int64     `json:"alerts"`
+	LastSeen time.Time `json:"last_seen"`
+	State    string    `json:"state"` // stale, or the worst state of its sensors
+}
+
+type edgeState struct {
+	readings int64
+	alerts   int64
+	lastSeen time.Time
+}
+
+// trackReading updates the panel of the sensor and edge of r; d.mu must be
+// held.
+func (d *Dashboard) trackReading(r ReadingDisplay) {
+	s, ok := d.sensors[r.SensorID]
+	if !ok {
+		s = &SensorPanel{SensorID: r.SensorID, Min: r.Value, Max: r.Value}
+		d.sensors[r.SensorID] = s
+	}
+	s.EdgeID, s.Site = r.EdgeID, r.Site
+	if r.Location != "" {
+		s.Location = r.Location
+	}
+	if r.Unit != "" {
+		s.Unit = r.Unit
+	}
+	s.Value, s.Timestamp = r.Value, r.Timestamp
+	s.Count++
+	s.Min, s.Max = min(s.Min, r.Value), max(s.Max, r.Value)
+	s.Sparkline = append(s.Sparkline, r.Value)
+	if len(s.Sparkline) > sparklinePoints {
+		s.Sparkline = s.Sparkline[len(s.Sparkline)-sparklinePoints:]
+	}
+
+	e := d.edgeState(r.EdgeID)
+	e.readings++
+	e.lastSeen = r.Timestamp
+}
+
+// trackAlert notes a on the panel of its sensor and edge; d.mu must be held.
+func (d *Dashboard) trackAlert(a AlertDisplay) {
+	if s, ok := d.sensors[a.SensorID]; ok {
+		s.AlertType, s.AlertTime = a.Type, a.Timestamp
+	}
+	if a.EdgeID != "" {
+		d.edgeState(a.EdgeID).alerts++
+	}
+}
+
+func (d *Dashboard) edgeState(id string) *edgeState {
+	e, ok := d.edges[id]
+	if !ok {
+		e = &edgeState{}
+		d.edges[id] = e
+	}
+	return e
+}
+
+// sensorState tells the state of s at now.
+func sensorState(s *SensorPanel, now time.Time) string {
+	switch {
+	case now.Sub(s.Timestamp) > staleAfter:
+		return StateStale
+	case s.AlertType == "" || now.Sub(s.AlertTime) > alertWindow:
+		return StateOK
+	case s.AlertType == StateCritical:
+		return StateCritical
+	default:
+		return StateWarning
+	}
+}
+
+// worse returns the worst of two states.
+func worse(a, b string) string {
+	rank := map[string]int{StateOK: 0, StateWarning: 1, StateCritical: 2, StateStale: 3}
+	if rank[b] > rank[a] {
+		return b
+	}
+	return a
+}
+
+// sensorPanels returns the sensors sorted by ID, of one edge if edge isn't
+// empty.
+func (d *Dashboard) sensorPanels(edge string) []SensorPanel {
+	now := d.clock.Now()
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	panels := make([]SensorPanel, 0, len(d.sensors))
+	for _, s := range d.sensors {
+		if edge != "" && s.EdgeID != edge {
+			continue
+		}
+		p := *s
+		p.Sparkline = append([]float64(nil), s.Sparkline...)
+		p.State = sensorState(s, now)
+		panels = append(panels, p)
+	}
+	sort.Slice(panels, func(i, j int) bool { return panels[i].SensorID < panels[j].SensorID })
+	return panels
+}
+
+// edgePanels returns the edges sorted by ID.
+func (d *Dashboard) edgePanels() []EdgePanel {
+	now := d.clock.Now()
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	byEdge := make(map[string]*EdgePanel, len(d.edges))
+	panels := make([]*EdgePanel, 0, len(d.edges))
+	for id, e := range d.edges {
+		p := &EdgePanel{EdgeID: id, Sensors: []string{}, Readings: e.readings, Alerts: e.alerts, LastSeen: e.lastSeen, State: StateOK}
+		if now.Sub(e.lastSeen) > staleAfter {
+			p.State = StateStale
+		}
+		byEdge[id] = p
+		panels = append(panels, p)
+	}
+	for _, s := range d.sensors {
+		p := byEdge[s.EdgeID]
+		p.Sensors = append(p.Sensors, s.SensorID)
+		if p.State != StateStale {
+			// A silent sensor on a live edge is worth a look, not a stale edge
+			st := sensorState(s, now)
+			if st == StateStale {
+				st = StateWarning
+			}
+			p.State = worse(p.State, st)
+		}
+	}
+	sort.Slice(panels, func(i, j int) bool { return panels[i].EdgeID < panels[j].EdgeID })
+	result := make([]EdgePanel, len(panels))
+	for i, p := range panels {
+		sort.Strings(p.Sensors)
+		result[i] = *p
+	}
+	return result
+}
+
+// handleGrid serves the sensors grid, of one edge with ?edge=.
+func (d *Dashboard) handleGrid(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(d.sensorPanels(r.URL.Query().Get("edge")))
+}
+
+// handleTopology serves the edges and the sensors flowing through them.
+func (d *Dashboard) handleTopology(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(d.edgePanels())
+}
+
+// pageRules are the state rules handed to the pages, in milliseconds.
+type pageRules struct {
+	StaleAfter  int64
+	AlertWindow int64
+}
+
+var rules = pageRules{StaleAfter: staleAfter.Milliseconds(), AlertWindow: alertWindow.Milliseconds()}
+
+// handleEdgePage serves the drill-down page of /edge/<id>.
+func (d *Dashboard) handleEdgePage(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/edge/")
+	if id == "" || strings.Contains(id, "/") {
+		http.NotFound(w, r)
+		return
+	}
+	data := struct {
+		EdgeID string
+		pageRules
+	}{id, rules}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := pages.ExecuteTemplate(w, "edge.html", data); err != nil {
+		log.Printf("Error rendering page of edge %s: %v", id, err)
+	}
+}
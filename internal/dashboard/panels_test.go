@@ -0,0 +1,114 @@
+package dashboard
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"sistemas_distribuidos_gb/internal/broker"
+	"sistemas_distribuidos_gb/internal/clock"
+	"sistemas_distribuidos_gb/internal/subjects"
+)
+
+// TestPanels checks the states of the sensors grid and the edge topology as
+// readings and alerts arrive and time passes.
+func TestPanels(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	b := broker.NewMemory()
+	clk := clock.NewFake(time.Date(2024, 1, 1, 8, 0, 0, 0, time.UTC))
+	opts := DefaultOptions()
+	opts.UseConfig = false
+	opts.Clock = clk
+	opts.HistoryDir = ""
+	d, err := New(b, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer d.Stop()
+	srv := httptest.NewServer(d.Handler())
+	defer srv.Close()
+
+	get := func(path string, v interface{}) string {
+		t.Helper()
+		resp, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if v != nil {
+			if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+				t.Fatal(err)
+			}
+			return ""
+		}
+		body, _ := io.ReadAll(resp.Body)
+		return string(body)
+	}
+	reading := func(sensor, edge string, value float64) {
+		data, _ := json.Marshal(FilteredReading{SensorID: sensor, Value: value, EdgeID: edge, Unit: "°C"})
+		b.Publish(subjects.Filtered(edge), data)
+	}
+	alert := func(sensor, edge, typ string) {
+		data, _ := json.Marshal(Alert{SensorID: sensor, EdgeID: edge, Type: typ})
+		b.Publish(subjects.Alerts(edge), data)
+	}
+
+	for i := 0; i < sparklinePoints+5; i++ {
+		reading("s1", "e1", float64(i))
+	}
+	reading("s2", "e1", 20)
+	reading("s3", "e2", 30)
+	alert("s2", "e1", "critical")
+
+	var grid []SensorPanel
+	get("/api/grid", &grid)
+	if len(grid) != 3 || grid[0].SensorID != "s1" || grid[2].SensorID != "s3" {
+		t.Fatalf("grid %+v, want s1, s2 and s3", grid)
+	}
+	if s1 := grid[0]; len(s1.Sparkline) != sparklinePoints || s1.Value != sparklinePoints+4 || s1.Min != 0 || s1.Count != sparklinePoints+5 || s1.Unit != "°C" {
+		t.Errorf("s1 = %+v", s1)
+	}
+	if grid[0].State != StateOK || grid[1].State != StateCritical {
+		t.Errorf("states %s and %s, want ok and critical", grid[0].State, grid[1].State)
+	}
+
+	var topology []EdgePanel
+	get("/api/topology", &topology)
+	if len(topology) != 2 || strings.Join(topology[0].Sensors, ",") != "s1,s2" || topology[0].State != StateCritical ||
+		topology[0].Alerts != 1 || topology[1].State != StateOK {
+		t.Fatalf("topology %+v", topology)
+	}
+
+	// The alert fades, s1 and s3 go silent and s2 moves to e2
+	clk.Advance(alertWindow + time.Second)
+	reading("s2", "e2", 21)
+	grid, topology = nil, nil
+	get("/api/grid?edge=e2", &grid)
+	if len(grid) != 2 || grid[0].SensorID != "s2" || grid[0].State != StateOK || grid[1].State != StateStale {
+		t.Errorf("grid of e2 %+v, want s2 ok and s3 stale", grid)
+	}
+	get("/api/topology", &topology)
+	if topology[0].State != StateStale || len(topology[0].Sensors) != 1 || topology[1].State != StateWarning {
+		t.Errorf("topology %+v, want e1 stale and e2 in warning for its silent sensor", topology)
+	}
+
+	page := get("/edge/e2", nil)
+	if !strings.Contains(page, `data-edge="e2"`) || !strings.Contains(page, `data-stale-after="60000"`) {
+		t.Errorf("edge page:\n%s", page)
+	}
+	if resp, _ := http.Get(srv.URL + "/edge/e2/x"); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("GET /edge/e2/x: %s", resp.Status)
+	}
+}
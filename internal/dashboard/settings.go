@@ -0,0 +1,297 @@
+package dashboard
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/nats-io/nats.go/jetstream"
+
+	"sistemas_distribuidos_gb/internal/audit"
+	"sistemas_distribuidos_gb/internal/auth"
+	"sistemas_distribuidos_gb/internal/config"
+	"sistemas_distribuidos_gb/internal/config/settings"
+	"sistemas_distribuidos_gb/internal/notify"
+	"sistemas_distribuidos_gb/internal/registry"
+)
+
+// The settings page edits the alert thresholds of each sensor, kept in the
+// registry; the edge pipeline, in the config keys edge.defaults and
+// edge.<id>; and the notification routes, in the notify_routes field of
+// cloud.defaults. The components pick the changes up over NATS as usual.
+// Every change goes to the audit log.
+
+// actor names who made a request, for the audit log: the signed-in user, the
+// basic auth user, or else the client address.
+func actor(r *http.Request) string {
+	if s := auth.FromContext(r.Context()); s != nil {
+		return s.User
+	}
+	if user, _, ok := r.BasicAuth(); ok && user != "" {
+		return user
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// record adds a change made by who to the audit log.
+func (d *Dashboard) record(who, action, target string, before, after interface{}) {
+	e := audit.Entry{Actor: who, Action: action, Target: target}
+	e.Before, _ = json.Marshal(before)
+	e.After, _ = json.Marshal(after)
+	log.Printf("%s by %s: %s = %s", action, e.Actor, target, e.After)
+	if err := d.audit.Record(e); err != nil {
+		log.Printf("Error writing audit log: %v", err)
+	}
+}
+
+// handleSensorSettings serves PUT /api/settings/sensors/<id>, which sets
+// the thresholds of a sensor, {"thresholds": {...}}, or removes them,
+// {"thresholds": null}, leaving the sensor to the edge thresholds.
+func (d *Dashboard) handleSensorSettings(w http.ResponseWriter, r *http.Request) {
+	if d.registry == nil {
+		http.Error(w, "registry unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	if r.Method != http.MethodPut {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id := strings.TrimPrefix(r.URL.Path, "/api/settings/sensors/")
+	if id == "" || strings.Contains(id, "/") {
+		http.NotFound(w, r)
+		return
+	}
+	var body struct {
+		Thresholds *registry.Thresholds `json:"thresholds"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "invalid settings: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if body.Thresholds != nil {
+		if err := body.Thresholds.Validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	sensor, err := d.registry.Get(id)
+	if errors.Is(err, registry.ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, "registry unavailable: "+err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	before := sensor.Thresholds
+	sensor.Thresholds = body.Thresholds
+	updated, err := d.registry.Update(*sensor)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	d.record(actor(r), "sensor.thresholds", id, before, updated.Thresholds)
+	writeJSON(w, http.StatusOK, updated)
+}
+
+// handleEdgeSettingsList serves GET /api/settings/edge: the edge settings
+// documents by key.
+func (d *Dashboard) handleEdgeSettingsList(w http.ResponseWriter, r *http.Request) {
+	if d.configBucket == nil {
+		http.Error(w, "central config unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	docs := make(map[string]json.RawMessage)
+	keys, err := d.configBucket.Keys(r.Context())
+	if err != nil && !errors.Is(err, jetstream.ErrNoKeysFound) {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for _, k := range keys {
+		if !strings.HasPrefix(k, "edge.") {
+			continue
+		}
+		if entry, err := d.configBucket.Get(r.Context(), k); err == nil {
+			docs[k] = json.RawMessage(entry.Value())
+		}
+	}
+	writeJSON(w, http.StatusOK, docs)
+}
+
+// handleEdgeSettings serves PUT and DELETE /api/settings/edge/<defaults|id>.
+func (d *Dashboard) handleEdgeSettings(w http.ResponseWriter, r *http.Request) {
+	if d.configBucket == nil {
+		http.Error(w, "central config unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	id := strings.TrimPrefix(r.URL.Path, "/api/settings/edge/")
+	if id == "" || strings.Contains(id, "/") {
+		http.NotFound(w, r)
+		return
+	}
+	key := config.InstanceKey("edge", id)
+	if id == "defaults" {
+		key = config.DefaultsKey("edge")
+	}
+	before, err := d.configDoc(r, key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		var doc map[string]json.RawMessage
+		if err := json.NewDecoder(r.Body).Decode(&doc); err != nil {
+			http.Error(w, "settings must be a JSON object: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		data, _ := json.Marshal(doc)
+		var defaults []byte
+		if key != config.DefaultsKey("edge") {
+			if defaults, err = d.configDoc(r, config.DefaultsKey("edge")); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+		if err := settings.Check("edge", defaults, data); err != nil {
+			http.Error(w, "invalid edge settings: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if _, err := d.configBucket.Put(r.Context(), key, data); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		d.record(actor(r), "edge.settings", key, json.RawMessage(before), json.RawMessage(data))
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(data)
+
+	case http.MethodDelete:
+		if err := d.configBucket.Delete(r.Context(), key); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		d.record(actor(r), "edge.settings", key, json.RawMessage(before), nil)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// configDoc returns the document under key, nil if there's none.
+func (d *Dashboard) configDoc(r *http.Request, key string) ([]byte, error) {
+	entry, err := d.configBucket.Get(r.Context(), key)
+	if errors.Is(err, jetstream.ErrKeyNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return entry.Value(), nil
+}
+
+// notifySettings is the body of /api/settings/notify. Null routes leave the
+// cloud with those of its -notify file.
+type notifySettings struct {
+	Routes []notify.Route `json:"routes"`
+}
+
+// handleNotifySettings serves GET and PUT /api/settings/notify.
+func (d *Dashboard) handleNotifySettings(w http.ResponseWriter, r *http.Request) {
+	if d.configBucket == nil {
+		http.Error(w, "central config unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	key := config.DefaultsKey("cloud")
+	entry, err := d.configBucket.Get(r.Context(), key)
+	if err != nil && !errors.Is(err, jetstream.ErrKeyNotFound) {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// The other cloud settings are kept as they are
+	doc := map[string]json.RawMessage{}
+	var revision uint64
+	if entry != nil {
+		revision = entry.Revision()
+		if err := json.Unmarshal(entry.Value(), &doc); err != nil {
+			http.Error(w, fmt.Sprintf("%s isn't a JSON object: %v", key, err), http.StatusInternalServerError)
+			return
+		}
+	}
+	var current notifySettings
+	if routes, ok := doc["notify_routes"]; ok {
+		json.Unmarshal(routes, &current.Routes)
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, current)
+
+	case http.MethodPut:
+		var next notifySettings
+		if err := json.NewDecoder(r.Body).Decode(&next); err != nil {
+			http.Error(w, "invalid routes: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		for i, route := range next.Routes {
+			if err := route.Validate(); err != nil {
+				http.Error(w, fmt.Sprintf("route %d: %v", i+1, err), http.StatusBadRequest)
+				return
+			}
+		}
+		if next.Routes == nil {
+			delete(doc, "notify_routes")
+		} else {
+			doc["notify_routes"], _ = json.Marshal(next.Routes)
+		}
+		data, _ := json.Marshal(doc)
+		// Fails if the document changed since it was read
+		if entry == nil {
+			_, err = d.configBucket.Create(r.Context(), key, data)
+		} else {
+			_, err = d.configBucket.Update(r.Context(), key, data, revision)
+		}
+		if err != nil {
+			http.Error(w, "saving routes: "+err.Error(), http.StatusConflict)
+			return
+		}
+		d.record(actor(r), "notify.routes", key, current.Routes, next.Routes)
+		writeJSON(w, http.StatusOK, next)
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleAudit serves the audit log, oldest first.
+func (d *Dashboard) handleAudit(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, d.audit.Entries())
+}
+
+// handleSettingsPage serves the settings page.
+func (d *Dashboard) handleSettingsPage(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := pages.ExecuteTemplate(w, "settings.html", nil); err != nil {
+		log.Printf("Error rendering settings page: %v", err)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
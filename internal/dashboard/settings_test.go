@@ -0,0 +1,142 @@
+package dashboard
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nats-io/nats.go"
+
+	"sistemas_distribuidos_gb/internal/broker"
+	"sistemas_distribuidos_gb/internal/config"
+	"sistemas_distribuidos_gb/internal/embedded"
+	"sistemas_distribuidos_gb/internal/registry"
+)
+
+// TestSettings edits thresholds, edge settings and notification routes
+// through the settings API, against an embedded NATS server with a registry.
+func TestSettings(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	dir := t.TempDir()
+	ns, err := embedded.Start(embedded.Options{JetStream: true, StoreDir: filepath.Join(dir, "jetstream")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ns.Shutdown()
+	nc, err := nats.Connect(ns.ClientURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nc.Close()
+
+	store, err := registry.NewFileStore(filepath.Join(dir, "sensors.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg := registry.NewService(nc, store)
+	if err := reg.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer reg.Stop()
+	if _, err := reg.Announce(context.Background(), registry.Sensor{ID: "temp-01", Unit: "°C"}); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := DefaultOptions()
+	opts.HistoryDir = ""
+	opts.AuditFile = filepath.Join(dir, "audit.jsonl")
+	d, err := New(broker.NewNATS(nc), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer d.Stop()
+	srv := httptest.NewServer(d.Handler())
+	defer srv.Close()
+
+	do := func(method, path, body string, want int) string {
+		t.Helper()
+		req, _ := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+		req.SetBasicAuth("maria", "")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != want {
+			t.Fatalf("%s %s: status %d (%s), want %d", method, path, resp.StatusCode, data, want)
+		}
+		return string(data)
+	}
+
+	if page := do("GET", "/settings", "", http.StatusOK); !strings.Contains(page, "settings.") {
+		t.Error("settings page doesn't load settings.js")
+	}
+
+	// Sensor thresholds
+	do("PUT", "/api/settings/sensors/temp-01", `{"thresholds": {"warning_min": 10, "warning_max": 30, "critical_min": 0, "critical_max": 40}}`, http.StatusOK)
+	do("PUT", "/api/settings/sensors/temp-01", `{"thresholds": {"warning_min": 10, "warning_max": 50, "critical_min": 0, "critical_max": 40}}`, http.StatusBadRequest)
+	do("PUT", "/api/settings/sensors/temp-99", `{"thresholds": null}`, http.StatusNotFound)
+	if s, err := reg.Get(context.Background(), "temp-01"); err != nil || s.Thresholds == nil || s.Thresholds.WarningMax != 30 {
+		t.Fatalf("registry has %+v (%v), want the new thresholds", s, err)
+	}
+
+	// Edge settings
+	do("PUT", "/api/settings/edge/defaults", `{"noise_filter": 2}`, http.StatusOK)
+	do("PUT", "/api/settings/edge/edge-1", `{"aggregate_interval": "10s", "detectors": {"enabled": ["zscore"]}}`, http.StatusOK)
+	do("PUT", "/api/settings/edge/edge-1", `{"noise_filtr": 2}`, http.StatusBadRequest)
+	do("PUT", "/api/settings/edge/edge-1", `{"detectors": {"enabled": ["crystal-ball"]}}`, http.StatusBadRequest)
+	do("PUT", "/api/settings/edge/edge-1", `{"noise_filter": -1}`, http.StatusBadRequest)
+	var docs map[string]json.RawMessage
+	json.Unmarshal([]byte(do("GET", "/api/settings/edge", "", http.StatusOK)), &docs)
+	if len(docs) != 2 || docs[config.InstanceKey("edge", "edge-1")] == nil {
+		t.Fatalf("edge docs %v, want edge.defaults and edge-1", docs)
+	}
+	do("DELETE", "/api/settings/edge/edge-1", "", http.StatusNoContent)
+
+	// Notification routes, next to the other cloud settings
+	if _, err := d.configBucket.Put(context.Background(), config.DefaultsKey("cloud"), []byte(`{"stats_interval": "30s"}`)); err != nil {
+		t.Fatal(err)
+	}
+	do("PUT", "/api/settings/notify", `{"routes": [{"sinks": []}]}`, http.StatusBadRequest)
+	do("PUT", "/api/settings/notify", `{"routes": [{"sinks": ["ops"], "severity": ["critical"], "rate_limit": "5m"}]}`, http.StatusOK)
+	entry, err := d.configBucket.Get(context.Background(), config.DefaultsKey("cloud"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cloud map[string]json.RawMessage
+	json.Unmarshal(entry.Value(), &cloud)
+	if string(cloud["stats_interval"]) != `"30s"` || cloud["notify_routes"] == nil {
+		t.Errorf("cloud.defaults = %s, want stats_interval kept and notify_routes set", entry.Value())
+	}
+	if got := do("GET", "/api/settings/notify", "", http.StatusOK); !strings.Contains(got, `"ops"`) {
+		t.Errorf("routes = %s", got)
+	}
+
+	// Only the changes that went through are audited
+	var entries []struct {
+		Actor, Action, Target string
+	}
+	json.Unmarshal([]byte(do("GET", "/api/audit", "", http.StatusOK)), &entries)
+	actions := []string{"sensor.thresholds", "edge.settings", "edge.settings", "edge.settings", "notify.routes"}
+	if len(entries) != len(actions) {
+		t.Fatalf("audit log has %d entries, want %d: %+v", len(entries), len(actions), entries)
+	}
+	for i, e := range entries {
+		if e.Action != actions[i] || e.Actor != "maria" {
+			t.Errorf("entry %d = %+v, want %s by maria", i, e, actions[i])
+		}
+	}
+}
@@ -0,0 +1,252 @@
+package dashboard
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"sistemas_distribuidos_gb/internal/clock"
+)
+
+// broadcastInterval is how often the broadcaster pushes to browsers.
+const broadcastInterval = time.Second
+
+// sseBuffer is how many pushes a browser may fall behind before it is
+// disconnected. EventSource reconnects on its own and resumes with
+// Last-Event-ID.
+const sseBuffer = 8
+
+// sseWriteTimeout bounds a write to a browser that stopped reading.
+const sseWriteTimeout = 10 * time.Second
+
+// sseRetry is the reconnection delay suggested to browsers, in milliseconds.
+const sseRetry = 3000
+
+// sseClient is a browser streaming /api/events.
+type sseClient struct {
+	addr   string
+	frames chan []byte // closed when the client is dropped
+}
+
+// statsEvent is the payload of a stats event: the statistics without the
+// recent readings and alerts, which arrive as readings and alerts events.
+type statsEvent struct {
+	DashboardData
+	RecentReadings []ReadingDisplay `json:"recent_readings,omitempty"`
+	RecentAlerts   []AlertDisplay   `json:"recent_alerts,omitempty"`
+}
+
+// broadcastLoop computes the statistics once per tick and pushes what
+// changed to every browser.
+func (d *Dashboard) broadcastLoop(ctx context.Context, ticker clock.Ticker) {
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C():
+			d.broadcast()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// broadcast sends the readings and alerts that arrived since the last push,
+// then the statistics. Browsers too far behind are dropped rather than
+// waited for.
+func (d *Dashboard) broadcast() {
+	d.clientsMu.Lock()
+	since := d.sent
+	d.clientsMu.Unlock()
+
+	d.mu.Lock()
+	d.sampleLatency()
+	stats := d.statsLocked()
+	seq := d.seq
+	d.mu.Unlock()
+
+	frame := d.deltaFrame(stats, since, seq)
+
+	d.clientsMu.Lock()
+	defer d.clientsMu.Unlock()
+	d.sent = seq
+	for c := range d.clients {
+		select {
+		case c.frames <- frame:
+		default:
+			log.Printf("Dropping slow dashboard client %s", c.addr)
+			d.dropClient(c)
+		}
+	}
+}
+
+// deltaFrame encodes the readings and alerts of stats newer than since, up
+// to seq, oldest first, followed by the stats event carrying the id.
+func (d *Dashboard) deltaFrame(stats DashboardData, since, seq int64) []byte {
+	var readings []ReadingDisplay
+	for i := len(stats.RecentReadings) - 1; i >= 0; i-- {
+		if r := stats.RecentReadings[i]; r.Seq > since && r.Seq <= seq {
+			readings = append(readings, r)
+		}
+	}
+	var alerts []AlertDisplay
+	for i := len(stats.RecentAlerts) - 1; i >= 0; i-- {
+		if a := stats.RecentAlerts[i]; a.Seq > since && a.Seq <= seq {
+			alerts = append(alerts, a)
+		}
+	}
+
+	var buf bytes.Buffer
+	if len(readings) > 0 {
+		writeEvent(&buf, "readings", "", readings)
+	}
+	if len(alerts) > 0 {
+		writeEvent(&buf, "alerts", "", alerts)
+	}
+	// The id goes last: a browser cut off mid-frame resumes before it
+	writeEvent(&buf, "stats", d.eventID(seq), statsEvent{DashboardData: stats})
+	return buf.Bytes()
+}
+
+// snapshotFrame encodes everything up to seq for a browser that just
+// connected.
+func (d *Dashboard) snapshotFrame(stats DashboardData, seq int64) []byte {
+	readings := stats.RecentReadings[:0:0]
+	for _, r := range stats.RecentReadings {
+		if r.Seq <= seq {
+			readings = append(readings, r)
+		}
+	}
+	alerts := stats.RecentAlerts[:0:0]
+	for _, a := range stats.RecentAlerts {
+		if a.Seq <= seq {
+			alerts = append(alerts, a)
+		}
+	}
+	stats.RecentReadings, stats.RecentAlerts = readings, alerts
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "retry: %d\n\n", sseRetry)
+	writeEvent(&buf, "snapshot", d.eventID(seq), stats)
+	return buf.Bytes()
+}
+
+func writeEvent(buf *bytes.Buffer, event, id string, v interface{}) {
+	data, _ := json.Marshal(v)
+	fmt.Fprintf(buf, "event: %s\n", event)
+	if id != "" {
+		fmt.Fprintf(buf, "id: %s\n", id)
+	}
+	fmt.Fprintf(buf, "data: %s\n\n", data)
+}
+
+// eventID tells the seq of the last reading or alert sent, prefixed with
+// the start time so that ids from an earlier run are told apart.
+func (d *Dashboard) eventID(seq int64) string {
+	return fmt.Sprintf("%d-%d", d.startTime.UnixMilli(), seq)
+}
+
+// parseEventID returns the seq of an id from this run.
+func (d *Dashboard) parseEventID(id string) (int64, bool) {
+	start, seq, ok := strings.Cut(id, "-")
+	if !ok || start != strconv.FormatInt(d.startTime.UnixMilli(), 10) {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(seq, 10, 64)
+	return n, err == nil
+}
+
+// addClient registers a browser and returns its first frame: what it missed
+// since lastID if it can resume from there, a full snapshot otherwise.
+func (d *Dashboard) addClient(addr, lastID string) (*sseClient, []byte) {
+	d.clientsMu.Lock()
+	defer d.clientsMu.Unlock()
+
+	d.mu.RLock()
+	stats := d.statsLocked()
+	evicted := d.evictedSeq
+	d.mu.RUnlock()
+
+	var frame []byte
+	if seq, ok := d.parseEventID(lastID); ok && seq >= evicted && seq <= d.sent {
+		frame = d.deltaFrame(stats, seq, d.sent)
+	} else {
+		frame = d.snapshotFrame(stats, d.sent)
+	}
+	c := &sseClient{addr: addr, frames: make(chan []byte, sseBuffer)}
+	d.clients[c] = struct{}{}
+	return c, frame
+}
+
+// removeClient unregisters a browser that went away.
+func (d *Dashboard) removeClient(c *sseClient) {
+	d.clientsMu.Lock()
+	defer d.clientsMu.Unlock()
+	if _, ok := d.clients[c]; ok {
+		d.dropClient(c)
+	}
+}
+
+// dropClient ends the stream of c; d.clientsMu must be held.
+func (d *Dashboard) dropClient(c *sseClient) {
+	delete(d.clients, c)
+	close(c.frames)
+}
+
+// closeClients ends the SSE and WebSocket streams.
+func (d *Dashboard) closeClients() {
+	d.clientsMu.Lock()
+	defer d.clientsMu.Unlock()
+	for c := range d.clients {
+		d.dropClient(c)
+	}
+	for c := range d.wsClients {
+		d.dropWS(c, websocket.CloseGoingAway, "dashboard stopping")
+	}
+}
+
+// handleSSE streams server-sent events: a snapshot on connect (or, with a
+// Last-Event-ID, the readings and alerts missed since), then every second
+// the new readings, the new alerts and the statistics.
+func (d *Dashboard) handleSSE(w http.ResponseWriter, r *http.Request) {
+	// EventSource sends Last-Event-ID when it reconnects by itself; pages that
+	// open a new one pass it in the URL
+	lastID := r.Header.Get("Last-Event-ID")
+	if lastID == "" {
+		lastID = r.URL.Query().Get("last_event_id")
+	}
+	c, frame := d.addClient(r.RemoteAddr, lastID)
+	defer d.removeClient(c)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	rc := http.NewResponseController(w)
+	for {
+		rc.SetWriteDeadline(time.Now().Add(sseWriteTimeout))
+		if _, err := w.Write(frame); err != nil {
+			return
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+
+		var ok bool
+		select {
+		case frame, ok = <-c.frames:
+			if !ok {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
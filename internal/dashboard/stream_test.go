@@ -0,0 +1,205 @@
+package dashboard
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"sistemas_distribuidos_gb/internal/broker"
+	"sistemas_distribuidos_gb/internal/clock"
+	"sistemas_distribuidos_gb/internal/subjects"
+)
+
+// sseEvent is an event read off /api/events.
+type sseEvent struct {
+	event, id string
+	data      json.RawMessage
+}
+
+// sseStream reads the events of an /api/events response.
+type sseStream struct {
+	t    *testing.T
+	resp *http.Response
+	r    *bufio.Reader
+}
+
+func openStream(t *testing.T, url, lastID string) *sseStream {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodGet, url+"/api/events", nil)
+	if lastID != "" {
+		req.Header.Set("Last-Event-ID", lastID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Content-Type %q", ct)
+	}
+	return &sseStream{t: t, resp: resp, r: bufio.NewReader(resp.Body)}
+}
+
+// next reads the events of one push, up to the one carrying an id.
+func (s *sseStream) next() []sseEvent {
+	s.t.Helper()
+	var events []sseEvent
+	var ev sseEvent
+	for {
+		line, err := s.r.ReadString('\n')
+		if err != nil {
+			s.t.Fatalf("reading events: %v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			ev.event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "id: "):
+			ev.id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "data: "):
+			ev.data = json.RawMessage(strings.TrimPrefix(line, "data: "))
+		case line == "" && ev.event != "":
+			events = append(events, ev)
+			if ev.id != "" {
+				return events
+			}
+			ev = sseEvent{}
+		}
+	}
+}
+
+func (s *sseStream) close() { s.resp.Body.Close() }
+
+// kinds lists the event types of a push.
+func kinds(events []sseEvent) string {
+	var k []string
+	for _, ev := range events {
+		k = append(k, ev.event)
+	}
+	return strings.Join(k, ",")
+}
+
+// TestStream drives the broadcaster with a fake clock: browsers get a
+// snapshot, then only what's new, and resume where they left off.
+func TestStream(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	b := broker.NewMemory()
+	clk := clock.NewFake(time.Date(2024, 1, 1, 8, 0, 0, 0, time.UTC))
+	opts := DefaultOptions()
+	opts.UseConfig = false
+	opts.Clock = clk
+	opts.HistoryDir = ""
+	opts.MaxReadings = 2
+	d, err := New(b, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer d.Stop()
+	srv := httptest.NewServer(d.Handler())
+	defer srv.Close()
+
+	publish := func(sensor string) {
+		data, _ := json.Marshal(FilteredReading{SensorID: sensor, Value: 1, Timestamp: clk.Now().UnixMilli(), EdgeID: "edge-1"})
+		b.Publish(subjects.Filtered("edge-1"), data)
+	}
+
+	s1 := openStream(t, srv.URL, "")
+	if ev := s1.next(); kinds(ev) != "snapshot" {
+		t.Fatalf("first push %s, want a snapshot", kinds(ev))
+	}
+
+	publish("s1")
+	publish("s2")
+	alert, _ := json.Marshal(Alert{SensorID: "s2", EdgeID: "edge-1", Type: "threshold"})
+	b.Publish(subjects.Alerts("edge-1"), alert)
+	clk.Advance(time.Second)
+	events := s1.next()
+	if kinds(events) != "readings,alerts,stats" {
+		t.Fatalf("push after new data: %s", kinds(events))
+	}
+	var readings []ReadingDisplay
+	json.Unmarshal(events[0].data, &readings)
+	if len(readings) != 2 || readings[0].SensorID != "s1" || readings[1].Seq != 2 {
+		t.Errorf("new readings %+v, want s1 and s2 oldest first", readings)
+	}
+	if strings.Contains(string(events[2].data), "recent_readings") {
+		t.Error("stats event repeats the recent readings")
+	}
+	lastID := events[2].id
+
+	// A second browser doesn't speed up the latency history
+	s2 := openStream(t, srv.URL, "")
+	defer s2.close()
+	s2.next()
+	clk.Advance(time.Second)
+	s2.next()
+	events = s1.next()
+	var stats DashboardData
+	json.Unmarshal(events[len(events)-1].data, &stats)
+	if kinds(events) != "stats" || len(stats.LatencyHistory) != 2 {
+		t.Errorf("push without new data: %s with %d latency samples, want stats with 2", kinds(events), len(stats.LatencyHistory))
+	}
+	s1.close()
+
+	// The first browser comes back after missing a reading
+	publish("s3")
+	clk.Advance(time.Second)
+	s2.next()
+	s1 = openStream(t, srv.URL, lastID)
+	events = s1.next()
+	json.Unmarshal(events[0].data, &readings)
+	if kinds(events) != "readings,stats" || len(readings) != 1 || readings[0].SensorID != "s3" {
+		t.Errorf("resumed with %s %+v, want the missed reading of s3", kinds(events), readings)
+	}
+	s1.close()
+
+	// Too far behind (s1 was dropped to keep 2 readings) or from another run:
+	// start over
+	for _, id := range []string{strings.Replace(lastID, "-3", "-0", 1), "1-3", "garbage"} {
+		s := openStream(t, srv.URL, id)
+		if ev := s.next(); kinds(ev) != "snapshot" {
+			t.Errorf("Last-Event-ID %s: %s, want a snapshot", id, kinds(ev))
+		}
+		s.close()
+	}
+}
+
+// TestSlowClient checks that a browser that stops reading is dropped
+// instead of holding the broadcaster up.
+func TestSlowClient(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	opts := DefaultOptions()
+	opts.Clock = clock.NewFake(time.Now())
+	d, err := New(broker.NewMemory(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, _ := d.addClient("slow", "")
+	for i := 0; i <= sseBuffer; i++ {
+		d.broadcast()
+	}
+	if len(d.clients) != 0 {
+		t.Fatal("slow client still registered")
+	}
+	n := 0
+	for range c.frames {
+		n++
+	}
+	if n != sseBuffer {
+		t.Errorf("%d frames queued, want %d", n, sseBuffer)
+	}
+}
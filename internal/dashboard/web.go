@@ -0,0 +1,105 @@
+package dashboard
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+// web holds the pages (templates/) and the stylesheet, scripts and chart
+// library they load (static/), so the dashboard needs no internet access.
+//
+//go:embed web
+var web embed.FS
+
+// staticAsset is a file of web/static, served at /static/<name> and, for
+// caching, at /static/<base>.<hash><ext>.
+type staticAsset struct {
+	data   []byte
+	hash   string
+	hashed string // the name with the hash
+}
+
+var (
+	// assets are the static assets by name.
+	assets = loadAssets()
+	// hashedAssets are the static assets by hashed name.
+	hashedAssets = byHashedName(assets)
+	// pages are the templates of web/templates, parsed once.
+	pages = template.Must(template.New("").Funcs(template.FuncMap{"asset": assetURL}).ParseFS(web, "web/templates/*.html"))
+)
+
+// loadAssets reads web/static and names each file after the first 10 hex
+// digits of its SHA-256: a changed file gets a new URL, so browsers can
+// cache the old one for good.
+func loadAssets() map[string]*staticAsset {
+	entries, err := fs.ReadDir(web, "web/static")
+	if err != nil {
+		panic(err)
+	}
+	m := make(map[string]*staticAsset, len(entries))
+	for _, e := range entries {
+		data, err := web.ReadFile("web/static/" + e.Name())
+		if err != nil {
+			panic(err)
+		}
+		sum := sha256.Sum256(data)
+		hash := hex.EncodeToString(sum[:])[:10]
+		ext := path.Ext(e.Name())
+		m[e.Name()] = &staticAsset{
+			data:   data,
+			hash:   hash,
+			hashed: strings.TrimSuffix(e.Name(), ext) + "." + hash + ext,
+		}
+	}
+	return m
+}
+
+func byHashedName(assets map[string]*staticAsset) map[string]*staticAsset {
+	m := make(map[string]*staticAsset, len(assets))
+	for _, a := range assets {
+		m[a.hashed] = a
+	}
+	return m
+}
+
+// assetURL is the template function asset: the hashed URL of a static asset.
+func assetURL(name string) (string, error) {
+	a, ok := assets[name]
+	if !ok {
+		return "", fmt.Errorf("no static asset %q", name)
+	}
+	return "/static/" + a.hashed, nil
+}
+
+// staticHandler serves the static assets. Hashed names never change, so
+// browsers keep them for a year; plain names are revalidated by ETag.
+func staticHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.URL.Path, "/static/")
+		if a, ok := hashedAssets[name]; ok {
+			w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+			serveAsset(w, r, name, a)
+			return
+		}
+		if a, ok := assets[name]; ok {
+			w.Header().Set("Cache-Control", "no-cache")
+			serveAsset(w, r, name, a)
+			return
+		}
+		http.NotFound(w, r)
+	})
+}
+
+func serveAsset(w http.ResponseWriter, r *http.Request, name string, a *staticAsset) {
+	w.Header().Set("ETag", `"`+a.hash+`"`)
+	http.ServeContent(w, r, name, time.Time{}, bytes.NewReader(a.data))
+}
@@ -0,0 +1,411 @@
+// charts.js: the canvas charts of the dashboard, a line chart with time on
+// the x axis, a doughnut and sparklines. They're served with the pages, so
+// the dashboard works on networks without internet access.
+(function(global) {
+    'use strict';
+
+    const FONT = '12px Inter, system-ui, -apple-system, sans-serif';
+    const TEXT = '#6b7280';
+    const GRID = '#f3f4f6';
+
+    const SECOND = 1000, MINUTE = 60 * SECOND, HOUR = 60 * MINUTE, DAY = 24 * HOUR;
+    const TIME_STEPS = [SECOND, 2 * SECOND, 5 * SECOND, 10 * SECOND, 15 * SECOND, 30 * SECOND,
+        MINUTE, 2 * MINUTE, 5 * MINUTE, 10 * MINUTE, 15 * MINUTE, 30 * MINUTE,
+        HOUR, 2 * HOUR, 3 * HOUR, 6 * HOUR, 12 * HOUR, DAY, 2 * DAY, 7 * DAY];
+
+    // fit sizes the canvas to its parent, for the device pixel ratio, and
+    // returns a context that draws in CSS pixels.
+    function fit(canvas) {
+        const parent = canvas.parentNode;
+        const w = parent.clientWidth, h = parent.clientHeight;
+        const ratio = window.devicePixelRatio || 1;
+        if (canvas.width !== Math.round(w * ratio) || canvas.height !== Math.round(h * ratio)) {
+            canvas.width = Math.round(w * ratio);
+            canvas.height = Math.round(h * ratio);
+            canvas.style.width = w + 'px';
+            canvas.style.height = h + 'px';
+        }
+        const ctx = canvas.getContext('2d');
+        ctx.setTransform(ratio, 0, 0, ratio, 0, 0);
+        ctx.clearRect(0, 0, w, h);
+        ctx.font = FONT;
+        return { ctx: ctx, w: w, h: h };
+    }
+
+    // niceTicks returns round values (1, 2 or 5 times a power of ten) that
+    // split [min, max] in about count steps.
+    function niceTicks(min, max, count) {
+        const raw = (max - min) / count;
+        const mag = Math.pow(10, Math.floor(Math.log10(raw)));
+        const step = [1, 2, 5, 10].map(m => m * mag).find(s => s >= raw);
+        const ticks = [];
+        for (let v = Math.ceil(min / step) * step; v <= max + step / 1e6; v += step) {
+            ticks.push(Math.abs(v) < step / 1e6 ? 0 : v);
+        }
+        return ticks;
+    }
+
+    // timeTicks returns times on round local minutes, hours or days.
+    function timeTicks(min, max, count) {
+        const step = TIME_STEPS.find(s => (max - min) / s <= count) || Math.ceil((max - min) / count / DAY) * DAY;
+        const offset = new Date(min).getTimezoneOffset() * MINUTE;
+        const ticks = [];
+        for (let v = Math.ceil((min - offset) / step) * step + offset; v <= max; v += step) ticks.push(v);
+        return ticks;
+    }
+
+    function formatNumber(v) {
+        const abs = Math.abs(v);
+        if (abs >= 1e6) return (v / 1e6).toFixed(1) + 'M';
+        if (abs >= 1e4) return (v / 1e3).toFixed(0) + 'k';
+        if (abs >= 100 || v === Math.round(v)) return v.toFixed(0);
+        return v.toFixed(abs >= 1 ? 1 : 2);
+    }
+
+    function extent(values, pad) {
+        let min = Math.min.apply(null, values), max = Math.max.apply(null, values);
+        if (!isFinite(min)) return null;
+        if (min === max) {
+            const d = Math.abs(min) * 0.1 || 1;
+            return [min - d, max + d];
+        }
+        const d = (max - min) * pad;
+        return [min - d, max + d];
+    }
+
+    // nearest returns the index of the point of data closest to x; data is
+    // sorted by x.
+    function nearest(data, x) {
+        let lo = 0, hi = data.length - 1;
+        if (hi < 0) return -1;
+        while (lo < hi) {
+            const mid = (lo + hi) >> 1;
+            if (data[mid].x < x) lo = mid + 1; else hi = mid;
+        }
+        if (lo > 0 && x - data[lo - 1].x < data[lo].x - x) lo--;
+        return lo;
+    }
+
+    // LineChart draws datasets of {x, y} points sorted by x, with x in Unix
+    // milliseconds. Dataset options: label, color, width, dash, axis ('y' on
+    // the left or 'y1' on the right), fill (a color) and fillTo (the index of
+    // the dataset the fill goes down to).
+    class LineChart {
+        constructor(canvas, options) {
+            this.canvas = canvas;
+            this.options = Object.assign({ legend: true, axes: true, xFormat: v => new Date(v).toLocaleTimeString(), tooltipTitle: v => new Date(v).toLocaleString() }, options);
+            this.datasets = (options.datasets || []).map(d => Object.assign({ width: 2, axis: 'y', data: [], hidden: false }, d));
+            this.min = null;
+            this.max = null;
+            this.hoverX = null;
+            this.legendBoxes = [];
+            this.scheduled = false;
+
+            new ResizeObserver(() => this.update()).observe(canvas.parentNode);
+            canvas.addEventListener('mousemove', e => {
+                this.hoverX = e.offsetX;
+                this.update();
+            });
+            canvas.addEventListener('mouseleave', () => {
+                this.hoverX = null;
+                this.update();
+            });
+            canvas.addEventListener('click', e => {
+                const box = this.legendBoxes.find(b => e.offsetX >= b.x && e.offsetX <= b.x + b.w && e.offsetY >= b.y && e.offsetY <= b.y + b.h);
+                if (box) {
+                    box.dataset.hidden = !box.dataset.hidden;
+                    this.update();
+                }
+            });
+        }
+
+        // setRange fixes the x axis; null bounds follow the data.
+        setRange(min, max) {
+            this.min = min;
+            this.max = max;
+        }
+
+        // valueAt returns the x value under a horizontal offset in CSS pixels.
+        valueAt(px) {
+            const a = this.area;
+            if (!a) return null;
+            return a.xMin + (px - a.left) / a.width * (a.xMax - a.xMin);
+        }
+
+        // update redraws on the next animation frame.
+        update() {
+            if (this.scheduled) return;
+            this.scheduled = true;
+            requestAnimationFrame(() => {
+                this.scheduled = false;
+                this.draw();
+            });
+        }
+
+        draw() {
+            const { ctx, w, h } = fit(this.canvas);
+            const o = this.options;
+            const visible = this.datasets.filter(d => !d.hidden);
+
+            let top = 8;
+            if (o.legend) top = this.drawLegend(ctx, w) + 8;
+
+            // Ranges
+            let xMin = this.min, xMax = this.max;
+            if (xMin === null || xMax === null) {
+                const xs = [];
+                visible.forEach(d => d.data.length && xs.push(d.data[0].x, d.data[d.data.length - 1].x));
+                const e = extent(xs, 0) || [Date.now() - MINUTE, Date.now()];
+                if (xMin === null) xMin = e[0];
+                if (xMax === null) xMax = e[1];
+            }
+            const axes = {};
+            ['y', 'y1'].forEach(axis => {
+                const ys = [];
+                visible.filter(d => d.axis === axis).forEach(d => d.data.forEach(p => {
+                    if (p.x >= xMin && p.x <= xMax && p.y !== null) ys.push(p.y);
+                }));
+                const e = extent(ys, 0.05);
+                if (e) axes[axis] = { min: e[0], max: e[1], ticks: niceTicks(e[0], e[1], Math.max(2, Math.floor((h - top) / 50))) };
+            });
+
+            // Plot area
+            let left = 8, right = w - 8;
+            const bottom = h - (o.axes ? 24 : 4);
+            if (o.axes && axes.y) left += Math.max.apply(null, axes.y.ticks.map(t => ctx.measureText(formatNumber(t)).width)) + 8;
+            if (o.axes && axes.y1) right -= Math.max.apply(null, axes.y1.ticks.map(t => ctx.measureText(formatNumber(t)).width)) + 8;
+            const a = this.area = { left: left, top: top, width: Math.max(1, right - left), height: Math.max(1, bottom - top), xMin: xMin, xMax: xMax };
+            const px = x => a.left + (x - xMin) / (xMax - xMin || 1) * a.width;
+            const py = (axis, y) => a.top + a.height - (y - axis.min) / (axis.max - axis.min) * a.height;
+
+            // Grid and axes
+            if (o.axes) {
+                ctx.fillStyle = TEXT;
+                ctx.strokeStyle = GRID;
+                ctx.lineWidth = 1;
+                ctx.textBaseline = 'middle';
+                if (axes.y) {
+                    ctx.textAlign = 'right';
+                    axes.y.ticks.forEach(t => {
+                        const y = Math.round(py(axes.y, t)) + 0.5;
+                        ctx.beginPath();
+                        ctx.moveTo(a.left, y);
+                        ctx.lineTo(a.left + a.width, y);
+                        ctx.stroke();
+                        ctx.fillText(formatNumber(t), a.left - 8, y);
+                    });
+                }
+                if (axes.y1) {
+                    ctx.textAlign = 'left';
+                    axes.y1.ticks.forEach(t => ctx.fillText(formatNumber(t), a.left + a.width + 8, py(axes.y1, t)));
+                }
+                ctx.textAlign = 'center';
+                ctx.textBaseline = 'top';
+                const maxTicks = Math.max(2, Math.floor(a.width / 110));
+                timeTicks(xMin, xMax, maxTicks).forEach(t => ctx.fillText(o.xFormat(t), px(t), bottom + 6));
+            }
+
+            // Datasets, clipped to the plot area
+            ctx.save();
+            ctx.beginPath();
+            ctx.rect(a.left, a.top - 2, a.width, a.height + 4);
+            ctx.clip();
+            this.datasets.forEach(d => {
+                const axis = axes[d.axis];
+                if (d.hidden || !axis || !d.data.length) return;
+                if (d.fill) {
+                    const to = this.datasets[d.fillTo];
+                    ctx.beginPath();
+                    d.data.forEach((p, i) => i ? ctx.lineTo(px(p.x), py(axis, p.y)) : ctx.moveTo(px(p.x), py(axis, p.y)));
+                    if (to && !to.hidden && to.data.length) {
+                        for (let i = to.data.length - 1; i >= 0; i--) ctx.lineTo(px(to.data[i].x), py(axes[to.axis], to.data[i].y));
+                    } else {
+                        ctx.lineTo(px(d.data[d.data.length - 1].x), a.top + a.height);
+                        ctx.lineTo(px(d.data[0].x), a.top + a.height);
+                    }
+                    ctx.closePath();
+                    ctx.fillStyle = d.fill;
+                    ctx.fill();
+                }
+                ctx.beginPath();
+                d.data.forEach((p, i) => i ? ctx.lineTo(px(p.x), py(axis, p.y)) : ctx.moveTo(px(p.x), py(axis, p.y)));
+                ctx.strokeStyle = d.color;
+                ctx.lineWidth = d.width;
+                ctx.setLineDash(d.dash || []);
+                ctx.lineJoin = 'round';
+                ctx.stroke();
+                ctx.setLineDash([]);
+            });
+            ctx.restore();
+
+            if (this.hoverX !== null && this.hoverX >= a.left && this.hoverX <= a.left + a.width) {
+                this.drawTooltip(ctx, w, axes, px, py);
+            }
+        }
+
+        drawLegend(ctx, w) {
+            this.legendBoxes = [];
+            const items = this.datasets.map(d => ({ d: d, w: 26 + ctx.measureText(d.label).width }));
+            const total = items.reduce((s, i) => s + i.w + 16, -16);
+            let x = Math.max(8, (w - total) / 2);
+            ctx.textBaseline = 'middle';
+            ctx.textAlign = 'left';
+            items.forEach(i => {
+                ctx.fillStyle = i.d.fill || i.d.color;
+                ctx.strokeStyle = i.d.color;
+                ctx.lineWidth = 1;
+                ctx.fillRect(x, 6, 18, 10);
+                ctx.strokeRect(x + 0.5, 6.5, 17, 9);
+                ctx.fillStyle = TEXT;
+                ctx.fillText(i.d.label, x + 24, 11);
+                if (i.d.hidden) {
+                    ctx.beginPath();
+                    ctx.moveTo(x + 24, 11);
+                    ctx.lineTo(x + i.w, 11);
+                    ctx.strokeStyle = TEXT;
+                    ctx.stroke();
+                }
+                this.legendBoxes.push({ x: x, y: 0, w: i.w, h: 22, dataset: i.d });
+                x += i.w + 16;
+            });
+            return 22;
+        }
+
+        drawTooltip(ctx, w, axes, px, py) {
+            const a = this.area;
+            const x = this.valueAt(this.hoverX);
+            const lines = [];
+            let at = null;
+            this.datasets.forEach(d => {
+                if (d.hidden || !axes[d.axis]) return;
+                const i = nearest(d.data, x);
+                if (i < 0) return;
+                const p = d.data[i];
+                if (at === null || Math.abs(p.x - x) < Math.abs(at - x)) at = p.x;
+                lines.push({ d: d, p: p });
+            });
+            if (at === null) return;
+            const shown = lines.filter(l => l.p.x === at);
+
+            ctx.strokeStyle = 'rgba(107, 114, 128, 0.4)';
+            ctx.lineWidth = 1;
+            ctx.beginPath();
+            ctx.moveTo(Math.round(px(at)) + 0.5, a.top);
+            ctx.lineTo(Math.round(px(at)) + 0.5, a.top + a.height);
+            ctx.stroke();
+            shown.forEach(l => {
+                ctx.beginPath();
+                ctx.arc(px(l.p.x), py(axes[l.d.axis], l.p.y), 3, 0, 2 * Math.PI);
+                ctx.fillStyle = l.d.color;
+                ctx.fill();
+            });
+
+            const title = this.options.tooltipTitle(at);
+            const texts = shown.map(l => l.d.label + ': ' + formatNumber(l.p.y));
+            const bw = Math.max.apply(null, [title].concat(texts).map(t => ctx.measureText(t).width)) + 20;
+            const bh = 12 + 16 * (texts.length + 1);
+            let bx = px(at) + 12;
+            if (bx + bw > w) bx = px(at) - 12 - bw;
+            const by = a.top + 4;
+            ctx.fillStyle = 'rgba(31, 41, 55, 0.9)';
+            ctx.beginPath();
+            ctx.rect(bx, by, bw, bh);
+            ctx.fill();
+            ctx.fillStyle = '#fff';
+            ctx.textAlign = 'left';
+            ctx.textBaseline = 'top';
+            ctx.fillText(title, bx + 10, by + 6);
+            texts.forEach((t, i) => ctx.fillText(t, bx + 10, by + 6 + 16 * (i + 1)));
+        }
+    }
+
+    // DoughnutChart draws the shares of a few labelled counts.
+    class DoughnutChart {
+        constructor(canvas, options) {
+            this.canvas = canvas;
+            this.options = Object.assign({ colors: ['#ef4444', '#f59e0b', '#6366f1', '#10b981', '#6b7280'], cutout: 0.7 }, options);
+            this.labels = [];
+            this.values = [];
+            new ResizeObserver(() => this.draw()).observe(canvas.parentNode);
+        }
+
+        setData(labels, values) {
+            this.labels = labels;
+            this.values = values;
+            this.draw();
+        }
+
+        draw() {
+            const { ctx, w, h } = fit(this.canvas);
+            const colors = this.options.colors;
+
+            // Legend rows at the bottom
+            ctx.textBaseline = 'middle';
+            ctx.textAlign = 'left';
+            const items = this.labels.map((l, i) => ({ text: l + ' (' + this.values[i] + ')', color: colors[i % colors.length] }));
+            const rows = [[]];
+            let rowWidth = 0;
+            items.forEach(it => {
+                it.w = 20 + ctx.measureText(it.text).width;
+                if (rowWidth + it.w > w - 16 && rows[rows.length - 1].length) {
+                    rows.push([]);
+                    rowWidth = 0;
+                }
+                rows[rows.length - 1].push(it);
+                rowWidth += it.w + 16;
+            });
+            const legendHeight = items.length ? rows.length * 20 + 8 : 0;
+            rows.forEach((row, r) => {
+                const total = row.reduce((s, it) => s + it.w + 16, -16);
+                let x = (w - total) / 2;
+                const y = h - legendHeight + 8 + r * 20 + 10;
+                row.forEach(it => {
+                    ctx.fillStyle = it.color;
+                    ctx.fillRect(x, y - 5, 12, 10);
+                    ctx.fillStyle = TEXT;
+                    ctx.fillText(it.text, x + 18, y);
+                    x += it.w + 16;
+                });
+            });
+
+            // Ring
+            const cx = w / 2, cy = (h - legendHeight) / 2;
+            const radius = Math.max(0, Math.min(w, h - legendHeight) / 2 - 4);
+            const inner = radius * this.options.cutout;
+            const total = this.values.reduce((s, v) => s + v, 0);
+            let angle = -Math.PI / 2;
+            const slices = total ? this.values.map((v, i) => ({ v: v, color: colors[i % colors.length] })) : [{ v: 1, color: GRID }];
+            const sum = total || 1;
+            slices.forEach(s => {
+                const next = angle + s.v / sum * 2 * Math.PI;
+                ctx.beginPath();
+                ctx.arc(cx, cy, radius, angle, next);
+                ctx.arc(cx, cy, inner, next, angle, true);
+                ctx.closePath();
+                ctx.fillStyle = s.color;
+                ctx.fill();
+                angle = next;
+            });
+        }
+    }
+
+    // sparkline draws values as a bare line filling the canvas.
+    function sparkline(canvas, values, color) {
+        const { ctx, w, h } = fit(canvas);
+        if (values.length < 2) return;
+        const lo = Math.min.apply(null, values), hi = Math.max.apply(null, values);
+        const span = hi - lo || 1;
+        ctx.beginPath();
+        values.forEach((v, i) => {
+            const x = i / (values.length - 1) * w;
+            const y = h - 2 - (v - lo) / span * (h - 4);
+            if (i === 0) ctx.moveTo(x, y);
+            else ctx.lineTo(x, y);
+        });
+        ctx.strokeStyle = color;
+        ctx.lineWidth = 1.5;
+        ctx.stroke();
+    }
+
+    global.Charts = { LineChart: LineChart, DoughnutChart: DoughnutChart, sparkline: sparkline };
+})(window);
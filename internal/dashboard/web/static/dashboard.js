@@ -0,0 +1,174 @@
+// dashboard.js: the overview page, fed over /api/events: a snapshot on
+// connect, then every second the new readings, the new alerts and the stats.
+
+let mainChart = null;
+let alertsChart = null;
+let recentReadings = [];
+let recentAlerts = [];
+let panels = null;
+let canAck = false; // operators and admins acknowledge alerts
+
+// Rows of the readings and alerts tables
+const TABLE_ROWS = 15;
+
+function initCharts() {
+    // Main Chart (Readings history & Latency)
+    mainChart = createHistoryChart('mainChart', {}, [{
+        label: 'Latência (ms)',
+        color: '#f59e0b',
+        width: 2,
+        dash: [5, 5],
+        axis: 'y1'
+    }]);
+    bindRangePicker(mainChart);
+    setRange(mainChart, '15m');
+
+    // Alerts Chart (Doughnut)
+    alertsChart = new Charts.DoughnutChart(document.getElementById('alertsChart'), {
+        colors: ['#ef4444', '#f59e0b', '#6b7280', '#6366f1', '#10b981']
+    });
+    alertsChart.setData(['Threshold', 'Anomaly', 'Other'], [0, 0, 0]);
+}
+
+function sensorLink(id) {
+    return '<a class="sensor-link" href="/sensor/' + encodeURIComponent(id) + '">' + id + '</a>';
+}
+
+function edgeLink(id) {
+    return '<a class="sensor-link" href="/edge/' + encodeURIComponent(id) + '">' + id + '</a>';
+}
+
+function sensorLocation(r) {
+    if (!r.location) return '';
+    return '<div style="font-family: inherit; font-size: 0.75rem; color: #6b7280;">' + r.location + '</div>';
+}
+
+function updateStats(data) {
+    // Metrics
+    document.getElementById('total-readings').innerText = data.total_readings.toLocaleString();
+    document.getElementById('readings-per-sec').innerText = data.readings_per_sec.toFixed(1);
+    document.getElementById('mean').innerText = data.mean.toFixed(2);
+    document.getElementById('min').innerText = data.min.toFixed(1);
+    document.getElementById('max').innerText = data.max.toFixed(1);
+    document.getElementById('std-dev').innerText = data.std_dev.toFixed(2);
+    document.getElementById('avg-latency').innerText = data.avg_latency || '0ms';
+    document.getElementById('latency-p95').innerText = data.latency_p95 || '0ms';
+    document.getElementById('latency-p99').innerText = data.latency_p99 || '0ms';
+    document.getElementById('active-edges').innerText = data.active_edge_nodes + ' Ativos';
+    document.getElementById('total-alerts').innerText = data.total_alerts;
+
+    // Uptime
+    const hours = Math.floor(data.uptime / 3600).toString().padStart(2, '0');
+    const minutes = Math.floor((data.uptime % 3600) / 60).toString().padStart(2, '0');
+    const seconds = Math.floor(data.uptime % 60).toString().padStart(2, '0');
+    document.getElementById('uptime').innerText = 'Uptime: ' + hours + 'h ' + minutes + 'm ' + seconds + 's';
+
+    // Sensors grid and topology
+    syncServerTime(data);
+    renderPanels(panels);
+
+    // Update Main Chart: one latency sample per push
+    if (data.latency_history && data.latency_history.length) {
+        const latency = mainChart.chart.datasets[3].data;
+        latency.push({ x: Date.now(), y: data.latency_history[data.latency_history.length - 1] });
+        while (latency.length && latency[0].x < mainChart.from) latency.shift();
+    }
+    advanceHistory(mainChart);
+
+    // Update Alerts Chart
+    if (data.alerts_by_type) {
+        const types = Object.keys(data.alerts_by_type);
+        const counts = Object.values(data.alerts_by_type);
+        
+        if (types.length > 0) {
+            alertsChart.setData(types, counts);
+        }
+    }
+}
+
+function renderReadings() {
+    const readingsBody = document.getElementById('readings-tbody');
+    readingsBody.innerHTML = recentReadings.map(function(r) {
+        return '<tr>' +
+            '<td style="font-family: monospace;">' + sensorLink(r.sensor_id) + sensorLocation(r) + '</td>' +
+            '<td>' + r.value.toFixed(2) + (r.unit ? ' ' + r.unit : '') + '</td>' +
+            '<td style="font-size: 0.75rem; color: #6b7280;">' + edgeLink(r.edge_id) + '</td>' +
+            '<td>' + new Date(r.timestamp).toLocaleTimeString() + '</td>' +
+        '</tr>';
+    }).join('');
+}
+
+function renderAlerts() {
+    const alertsBody = document.getElementById('alerts-tbody');
+    alertsBody.innerHTML = recentAlerts.map(function(a) {
+        return '<tr>' +
+            '<td style="font-family: monospace;">' + sensorLink(a.sensor_id) + sensorLocation(a) + '</td>' +
+            '<td>' + a.value.toFixed(2) + (a.unit ? ' ' + a.unit : '') + '</td>' +
+            '<td><span class="badge badge-threshold">' + a.type + (a.detector ? ' · ' + a.detector : '') + '</span></td>' +
+            '<td style="max-width: 200px; overflow: hidden; text-overflow: ellipsis; white-space: nowrap;">' + a.message + '</td>' +
+            '<td>' + new Date(a.timestamp).toLocaleTimeString() + '</td>' +
+            '<td>' + ackCell(a) + '</td>' +
+        '</tr>';
+    }).join('');
+}
+
+function ackCell(a) {
+    if (a.acked_at) return '<span title="Reconhecido às ' + new Date(a.acked_at).toLocaleTimeString() + '">✓</span>';
+    if (!canAck) return '';
+    return '<button class="btn" data-ack="' + a.seq + '">Reconhecer</button>';
+}
+
+function ackAlert(seq) {
+    fetch('/api/alerts/ack', {
+        method: 'POST',
+        headers: csrfHeaders({ 'Content-Type': 'application/json' }),
+        body: JSON.stringify({ seq: seq })
+    }).then(function(resp) {
+        if (!resp.ok) throw new Error(resp.status + ' ' + resp.statusText);
+        return resp.json();
+    }).then(function(acked) {
+        recentAlerts.forEach(a => { if (a.seq === acked.seq) a.acked_at = acked.acked_at; });
+        renderAlerts();
+    }).catch(err => console.error('ack', err));
+}
+
+document.addEventListener('DOMContentLoaded', () => {
+    initCharts();
+    document.getElementById('settings-link').href = '/settings';
+    loadSession(function(me) {
+        document.getElementById('settings-link').hidden = !canDo(me, 'admin');
+        canAck = canDo(me, 'operator');
+        renderAlerts();
+    });
+    document.getElementById('alerts-tbody').addEventListener('click', function(e) {
+        if (e.target.dataset.ack) ackAlert(Number(e.target.dataset.ack));
+    });
+    panels = createPanels(document.getElementById('sensor-grid'), document.getElementById('topology'));
+    connectSSE({
+        snapshot: function(data, again) {
+            // A fresh start, or back after missing too much: redraw everything
+            recentReadings = data.recent_readings.slice(0, TABLE_ROWS);
+            recentAlerts = data.recent_alerts.slice(0, TABLE_ROWS);
+            renderReadings();
+            renderAlerts();
+            if (again) loadHistory(mainChart);
+            loadPanels(panels);
+            updateStats(data);
+        },
+        readings: function(list) {
+            // Oldest first
+            list.forEach(function(r) {
+                appendHistory(mainChart, new Date(r.timestamp).getTime(), r.value);
+            });
+            panelReadings(panels, list);
+            recentReadings = list.slice().reverse().concat(recentReadings).slice(0, TABLE_ROWS);
+            renderReadings();
+        },
+        alerts: function(list) {
+            panelAlerts(panels, list);
+            recentAlerts = list.slice().reverse().concat(recentAlerts).slice(0, TABLE_ROWS);
+            renderAlerts();
+        },
+        stats: updateStats
+    });
+});
@@ -0,0 +1,124 @@
+// detail.js: the drill-down pages of a sensor and of an edge, named by the
+// data-sensor or data-edge attribute of the body.
+
+const SENSOR_ID = document.body.dataset.sensor;
+const EDGE_ID = document.body.dataset.edge;
+const UNIT = document.body.dataset.unit;
+const FILTER = SENSOR_ID ? { sensor: SENSOR_ID } : { edge: EDGE_ID };
+
+let detailHistory = null;
+let edgePanels = null;
+let alerts = [];
+
+function isMine(x) {
+    return SENSOR_ID ? x.sensor_id === SENSOR_ID : x.edge_id === EDGE_ID;
+}
+
+function formatValue(v) {
+    return v.toFixed(2) + (UNIT ? ' ' + UNIT : '');
+}
+
+function updateRangeStats() {
+    let count = 0, sum = 0, min = Infinity, max = -Infinity;
+    detailHistory.buckets.forEach(function(b) {
+        count += b.count;
+        sum += b.mean * b.count;
+        min = Math.min(min, b.min);
+        max = Math.max(max, b.max);
+    });
+    document.getElementById('range-count').innerText = count.toLocaleString();
+    document.getElementById('range-mean').innerText = count ? formatValue(sum / count) : '-';
+    document.getElementById('range-min').innerText = count ? min.toFixed(1) : '-';
+    document.getElementById('range-max').innerText = count ? max.toFixed(1) : '-';
+}
+
+function renderAlerts() {
+    const inRange = alerts.filter(a => detailHistory.preset || new Date(a.timestamp).getTime() < detailHistory.to);
+    document.getElementById('range-alerts').innerText = inRange.length;
+    document.getElementById('alerts-tbody').innerHTML = inRange.slice(0, 100).map(function(a) {
+        // The other end: the edge on a sensor page, the sensor on an edge page
+        const other = SENSOR_ID ?
+            '<a href="/edge/' + encodeURIComponent(a.edge_id) + '">' + a.edge_id + '</a>' :
+            '<a href="/sensor/' + encodeURIComponent(a.sensor_id) + '">' + a.sensor_id + '</a>';
+        return '<tr>' +
+            '<td>' + formatValue(a.value) + '</td>' +
+            '<td><span class="badge badge-threshold">' + a.type + (a.detector ? ' · ' + a.detector : '') + '</span></td>' +
+            '<td style="max-width: 300px; overflow: hidden; text-overflow: ellipsis; white-space: nowrap;">' + a.message + '</td>' +
+            '<td style="font-size: 0.75rem; color: #6b7280;">' + other + '</td>' +
+            '<td>' + new Date(a.timestamp).toLocaleString() + '</td>' +
+        '</tr>';
+    }).join('');
+}
+
+function loadAlerts() {
+    let query = 'from=' + detailHistory.from + '&to=' + detailHistory.to + '&limit=1000';
+    for (const k in FILTER) query += '&' + k + '=' + encodeURIComponent(FILTER[k]);
+    fetch('/api/history/alerts?' + query)
+        .then(resp => resp.ok ? resp.json() : [])
+        .then(function(list) { alerts = list; renderAlerts(); })
+        .catch(err => console.error('alerts', err));
+}
+
+function showLast(r) {
+    document.getElementById('last-value').innerText = formatValue(r.value);
+    document.getElementById('last-time').innerHTML = new Date(r.timestamp).toLocaleString() + ' · ' +
+        '<a href="/edge/' + encodeURIComponent(r.edge_id) + '">' + r.edge_id + '</a>';
+}
+
+const streamHandlers = {
+    snapshot: function(data, again) {
+        // Back after missing too much: reload the range
+        if (again) {
+            loadHistory(detailHistory);
+            if (edgePanels) loadPanels(edgePanels);
+        }
+    },
+    readings: function(list) {
+        if (edgePanels) panelReadings(edgePanels, list);
+        list.forEach(function(r) {
+            if (!isMine(r)) return;
+            appendHistory(detailHistory, new Date(r.timestamp).getTime(), r.value);
+            if (SENSOR_ID) showLast(r);
+        });
+    },
+    alerts: function(list) {
+        if (edgePanels) panelAlerts(edgePanels, list);
+        const mine = list.filter(isMine).reverse();
+        if (mine.length && detailHistory.preset) {
+            alerts = mine.concat(alerts);
+            renderAlerts();
+        }
+    },
+    stats: function(data) {
+        advanceHistory(detailHistory);
+        updateRangeStats();
+        if (edgePanels) {
+            syncServerTime(data);
+            renderPanels(edgePanels);
+        }
+    }
+};
+
+document.addEventListener('DOMContentLoaded', () => {
+    loadSession();
+    document.getElementById('back-link').href = '/';
+    detailHistory = createHistoryChart('historyChart', FILTER);
+    detailHistory.onRange = function() { updateRangeStats(); loadAlerts(); };
+    bindRangePicker(detailHistory);
+    setRange(detailHistory, '1h');
+
+    if (SENSOR_ID) {
+        fetch('/api/history/sensors')
+            .then(resp => resp.ok ? resp.json() : [])
+            .then(function(latest) {
+                const r = latest.find(r => r.sensor_id === SENSOR_ID);
+                if (r) showLast(r);
+            })
+            .catch(err => console.error('sensors', err));
+    } else {
+        edgePanels = createPanels(document.getElementById('sensor-grid'), null, EDGE_ID);
+        loadPanels(edgePanels);
+    }
+
+    connectSSE(streamHandlers);
+});
@@ -0,0 +1,265 @@
+// history.js: history charts, the min, max and mean of each bucket of a time
+// range, loaded from /api/history. Preset ranges follow the present, with
+// live readings folded into the buckets as they arrive; custom ranges, typed
+// in or dragged on the chart, stay put. The range and filters of a chart are
+// also what its export button downloads. Also the helpers shared by the pages.
+
+const RANGE_PRESETS = { '15m': 15 * 60 * 1000, '1h': 60 * 60 * 1000, '24h': 24 * 60 * 60 * 1000 };
+const MAX_BUCKETS = 500;
+
+// The CSRF token of the session, sent back with the requests that change something
+function csrfHeaders(headers) {
+    const match = document.cookie.match(/(?:^|; )csrf=([^;]*)/);
+    headers = headers || {};
+    if (match) headers['X-CSRF-Token'] = decodeURIComponent(match[1]);
+    return headers;
+}
+
+// loadSession fetches who is signed in and their role, shows them in the
+// user-menu partial and passes them to callback. Without login every user
+// is an admin, with no name.
+function loadSession(callback) {
+    fetch('/api/me').then(resp => resp.ok ? resp.json() : { role: 'viewer' }).then(function(me) {
+        const menu = document.getElementById('user-menu');
+        if (menu && me.user) {
+            document.getElementById('user-name').textContent = me.user + ' · ' + me.role;
+            document.getElementById('logout').onclick = function() {
+                fetch('/logout', { method: 'POST', headers: csrfHeaders() }).then(() => window.location = '/login');
+            };
+            menu.hidden = false;
+        }
+        if (callback) callback(me);
+    });
+}
+
+function canDo(me, role) {
+    const rank = { viewer: 1, operator: 2, admin: 3 };
+    return (rank[me.role] || 0) >= rank[role];
+}
+
+function formatTick(value, span) {
+    const d = new Date(value);
+    if (span > 24 * 60 * 60 * 1000) {
+        return d.toLocaleDateString([], { day: '2-digit', month: '2-digit' }) + ' ' + d.toLocaleTimeString([], { hour: '2-digit', minute: '2-digit' });
+    }
+    return d.toLocaleTimeString();
+}
+
+function createHistoryChart(canvasId, params, extraDatasets) {
+    const canvas = document.getElementById(canvasId);
+    const h = {
+        params: params,   // filters of /api/history
+        preset: null,     // null for a custom range
+        lastPreset: '1h',
+        from: 0, to: 0, origin: 0, step: 1000,
+        buckets: [],
+        requested: 0, loaded: 0,
+        pending: [],      // live readings that arrived while loading
+        onRange: null
+    };
+    h.chart = new Charts.LineChart(canvas, {
+        datasets: [
+            { label: 'Máx', color: 'rgba(99, 102, 241, 0.3)', width: 1, fill: 'rgba(99, 102, 241, 0.1)', fillTo: 1 },
+            { label: 'Mín', color: 'rgba(99, 102, 241, 0.3)', width: 1 },
+            { label: 'Média', color: '#6366f1', width: 2 }
+        ].concat(extraDatasets || []),
+        xFormat: v => formatTick(v, h.to - h.from)
+    });
+    enableDragZoom(h, canvas);
+    return h;
+}
+
+function renderHistory(h) {
+    const ds = h.chart.datasets;
+    ds[0].data = h.buckets.map(b => ({ x: b.t, y: b.max }));
+    ds[1].data = h.buckets.map(b => ({ x: b.t, y: b.min }));
+    ds[2].data = h.buckets.map(b => ({ x: b.t, y: b.mean }));
+    h.chart.setRange(h.from, h.to);
+    h.chart.update();
+}
+
+// setRange switches to a preset ('15m', '1h', '24h') or, with a null preset,
+// to the custom range [from, to) in Unix milliseconds.
+function setRange(h, preset, from, to) {
+    h.preset = preset;
+    if (preset) {
+        h.lastPreset = preset;
+        h.to = Date.now();
+        h.from = h.to - RANGE_PRESETS[preset];
+    } else {
+        h.from = from;
+        h.to = to;
+    }
+    // Same buckets as the server picks, so live readings line up
+    h.step = Math.max(1000, Math.ceil((h.to - h.from) / MAX_BUCKETS / 1000) * 1000);
+    h.origin = h.from;
+    h.buckets = [];
+    renderHistory(h);
+    syncRangePicker(h);
+    loadHistory(h);
+}
+
+// rangeQuery returns the time range and filters of a chart as a query string.
+function rangeQuery(h) {
+    let query = h.preset ? 'range=' + h.preset : 'from=' + h.from + '&to=' + h.to;
+    for (const k in h.params) {
+        if (h.params[k]) query += '&' + k + '=' + encodeURIComponent(h.params[k]);
+    }
+    return query;
+}
+
+function loadHistory(h) {
+    const query = rangeQuery(h);
+    const seq = ++h.requested;
+    h.pending = [];
+    fetch('/api/history?' + query).then(function(resp) {
+        if (!resp.ok) throw new Error(resp.status + ' ' + resp.statusText);
+        return resp.json();
+    }).then(function(series) {
+        if (seq !== h.requested) return; // a newer range was picked meanwhile
+        if (h.preset) h.to = series.to;
+        h.from = series.from;
+        h.origin = series.from;
+        h.step = series.step;
+        h.buckets = series.buckets;
+        h.pending.filter(p => p[0] >= series.to).forEach(p => addToBucket(h, p[0], p[1]));
+    }).catch(function(err) {
+        console.error('history', err);
+    }).finally(function() {
+        if (seq !== h.requested) return;
+        h.loaded = seq;
+        h.pending = [];
+        renderHistory(h);
+        if (h.onRange) h.onRange(h);
+    });
+}
+
+function addToBucket(h, t, value) {
+    const start = h.origin + Math.floor((t - h.origin) / h.step) * h.step;
+    let i = h.buckets.length - 1;
+    while (i >= 0 && h.buckets[i].t > start) i--;
+    const b = h.buckets[i];
+    if (b && b.t === start) {
+        b.mean += (value - b.mean) / (b.count + 1);
+        b.count++;
+        b.min = Math.min(b.min, value);
+        b.max = Math.max(b.max, value);
+    } else {
+        h.buckets.splice(i + 1, 0, { t: start, count: 1, min: value, max: value, mean: value });
+    }
+}
+
+// appendHistory folds a live reading into the chart.
+function appendHistory(h, t, value) {
+    if (h.loaded !== h.requested) h.pending.push([t, value]);
+    if (!h.preset && (t < h.from || t >= h.to)) return;
+    addToBucket(h, t, value);
+}
+
+// advanceHistory moves a preset range to the present and redraws.
+function advanceHistory(h) {
+    if (h.preset) {
+        h.to = Date.now();
+        h.from = h.to - RANGE_PRESETS[h.preset];
+        while (h.buckets.length && h.buckets[0].t + h.step <= h.from) h.buckets.shift();
+    }
+    renderHistory(h);
+}
+
+// Drag over the chart to zoom in; double click to go back to the preset.
+function enableDragZoom(h, canvas) {
+    const box = document.createElement('div');
+    box.className = 'zoom-box';
+    canvas.parentNode.appendChild(box);
+    let startX = null;
+    canvas.addEventListener('mousedown', function(e) {
+        startX = e.offsetX;
+    });
+    canvas.addEventListener('mousemove', function(e) {
+        if (startX === null) return;
+        box.style.display = 'block';
+        box.style.left = Math.min(startX, e.offsetX) + 'px';
+        box.style.width = Math.abs(e.offsetX - startX) + 'px';
+    });
+    window.addEventListener('mouseup', function(e) {
+        if (startX === null) return;
+        const endX = e.target === canvas ? e.offsetX : startX;
+        const a = Math.min(startX, endX), b = Math.max(startX, endX);
+        startX = null;
+        box.style.display = 'none';
+        if (b - a < 5) return;
+        setRange(h, null, Math.round(h.chart.valueAt(a)), Math.round(h.chart.valueAt(b)));
+    });
+    canvas.addEventListener('dblclick', () => setRange(h, h.lastPreset));
+}
+
+function toLocalInput(ms) {
+    const d = new Date(ms);
+    return new Date(ms - d.getTimezoneOffset() * 60000).toISOString().slice(0, 16);
+}
+
+function syncRangePicker(h) {
+    document.querySelectorAll('.range-picker [data-range]').forEach(function(btn) {
+        btn.classList.toggle('active', btn.dataset.range === h.preset);
+    });
+    document.querySelector('.range-picker .range-from').value = toLocalInput(h.from);
+    document.querySelector('.range-picker .range-to').value = toLocalInput(h.to);
+}
+
+function bindRangePicker(h) {
+    document.querySelectorAll('.range-picker [data-range]').forEach(function(btn) {
+        btn.addEventListener('click', () => setRange(h, btn.dataset.range));
+    });
+    document.querySelector('.range-picker .range-apply').addEventListener('click', function() {
+        const from = new Date(document.querySelector('.range-picker .range-from').value).getTime();
+        const to = new Date(document.querySelector('.range-picker .range-to').value).getTime();
+        if (from < to) setRange(h, null, from, to);
+    });
+    document.querySelector('.range-picker .range-export').addEventListener('click', function() {
+        const kind = document.querySelector('.range-picker .export-kind').value;
+        const format = document.querySelector('.range-picker .export-format').value.split('.');
+        let url = '/api/export?kind=' + kind + '&format=' + format[0] + '&' + rangeQuery(h);
+        if (format[1] === 'gz') url += '&gzip=1';
+        window.location.href = url; // a download, the page stays
+    });
+}
+
+// Snapshots received, the first one included
+let sseSnapshots = 0;
+
+// connectSSE streams /api/events into handlers.snapshot(data, again),
+// handlers.readings(list), handlers.alerts(list) and handlers.stats(data).
+// The browser reconnects by itself and resumes from the last event id; if the
+// server refuses the stream, a new one is opened a bit later, from the same id.
+function connectSSE(handlers, lastEventId) {
+    let url = '/api/events';
+    if (lastEventId) url += '?last_event_id=' + encodeURIComponent(lastEventId);
+    const evtSource = new EventSource(url);
+    const statusBadge = document.getElementById('status');
+    let lastId = lastEventId;
+
+    ['snapshot', 'readings', 'alerts', 'stats'].forEach(function(type) {
+        evtSource.addEventListener(type, function(event) {
+            if (event.lastEventId) lastId = event.lastEventId;
+            const data = JSON.parse(event.data);
+            if (type === 'snapshot') {
+                handlers.snapshot(data, sseSnapshots++ > 0);
+            } else {
+                handlers[type](data);
+            }
+
+            if (!statusBadge.classList.contains('online')) {
+                statusBadge.className = 'status-badge online';
+                statusBadge.innerHTML = '<span class="status-dot"></span>Online';
+            }
+        });
+    });
+
+    evtSource.onerror = () => {
+        statusBadge.className = 'status-badge';
+        statusBadge.innerHTML = '<span class="status-dot"></span>Reconnecting...';
+        if (evtSource.readyState === EventSource.CLOSED) {
+            setTimeout(() => connectSSE(handlers, lastId), 3000);
+        }
+    };
+}
@@ -0,0 +1,184 @@
+// panels.js: the sensors grid, a tile per sensor with its value, sparkline
+// and state colour, and the edge topology, the sensors flowing through each
+// edge. Loaded from /api/grid and /api/topology, then kept current with the
+// readings and alerts of /api/events. States follow the rules of panels.go,
+// passed in the data-stale-after and data-alert-window attributes of the body.
+
+const STALE_AFTER = Number(document.body.dataset.staleAfter);
+const ALERT_WINDOW = Number(document.body.dataset.alertWindow);
+const SPARKLINE_POINTS = 60;
+const STATE_COLORS = { ok: '#10b981', warning: '#f59e0b', critical: '#ef4444', stale: '#9ca3af' };
+const STATE_RANK = { ok: 0, warning: 1, critical: 2, stale: 3 };
+
+// The server's clock runs serverOffset ms ahead of the browser's
+let serverOffset = 0;
+
+function syncServerTime(stats) {
+    if (stats.time) serverOffset = new Date(stats.time).getTime() - Date.now();
+}
+
+function escapeHTML(s) {
+    return String(s).replace(/[&<>"']/g, c => ({ '&': '&amp;', '<': '&lt;', '>': '&gt;', '"': '&quot;', "'": '&#39;' })[c]);
+}
+
+function sensorState(s, now) {
+    if (now - new Date(s.timestamp).getTime() > STALE_AFTER) return 'stale';
+    if (!s.alert_type || now - new Date(s.alert_time).getTime() > ALERT_WINDOW) return 'ok';
+    return s.alert_type === 'critical' ? 'critical' : 'warning';
+}
+
+// createPanels draws the grid in the element grid and the topology in the
+// element topology (either may be null), of one edge if edge is set.
+function createPanels(grid, topology, edge) {
+    return {
+        grid: grid, topology: topology, edge: edge || '',
+        sensors: new Map(), edges: new Map(),
+        tiles: new Map(), topologyHTML: null
+    };
+}
+
+function loadPanels(p) {
+    const get = url => fetch(url).then(resp => resp.ok ? resp.json() : []);
+    Promise.all([
+        get('/api/grid' + (p.edge ? '?edge=' + encodeURIComponent(p.edge) : '')),
+        p.topology ? get('/api/topology') : Promise.resolve([])
+    ]).then(function(results) {
+        p.sensors = new Map(results[0].map(s => [s.sensor_id, s]));
+        p.edges = new Map(results[1].map(e => [e.edge_id, e]));
+        p.tiles.forEach(tile => tile.remove());
+        p.tiles = new Map();
+        renderPanels(p);
+    }).catch(err => console.error('panels', err));
+}
+
+function panelReadings(p, list) {
+    list.forEach(function(r) {
+        if (p.edge && r.edge_id !== p.edge) {
+            // The sensor moved to another edge
+            if (p.tiles.has(r.sensor_id)) {
+                p.tiles.get(r.sensor_id).remove();
+                p.tiles.delete(r.sensor_id);
+            }
+            p.sensors.delete(r.sensor_id);
+            return;
+        }
+        let s = p.sensors.get(r.sensor_id);
+        if (!s) {
+            s = { sensor_id: r.sensor_id, count: 0, min: r.value, max: r.value, sparkline: [] };
+            p.sensors.set(r.sensor_id, s);
+        }
+        s.edge_id = r.edge_id;
+        if (r.location) s.location = r.location;
+        if (r.unit) s.unit = r.unit;
+        s.value = r.value;
+        s.timestamp = r.timestamp;
+        s.count++;
+        s.min = Math.min(s.min, r.value);
+        s.max = Math.max(s.max, r.value);
+        s.sparkline.push(r.value);
+        if (s.sparkline.length > SPARKLINE_POINTS) s.sparkline.shift();
+        s.dirty = true;
+
+        let e = p.edges.get(r.edge_id);
+        if (!e) {
+            e = { edge_id: r.edge_id, readings: 0, alerts: 0 };
+            p.edges.set(r.edge_id, e);
+        }
+        e.readings++;
+        e.last_seen = r.timestamp;
+    });
+}
+
+function panelAlerts(p, list) {
+    list.forEach(function(a) {
+        const s = p.sensors.get(a.sensor_id);
+        if (s) {
+            s.alert_type = a.type;
+            s.alert_time = a.timestamp;
+        }
+        const e = p.edges.get(a.edge_id);
+        if (e) e.alerts++;
+    });
+}
+
+// renderPanels redraws what changed; called once per push.
+function renderPanels(p) {
+    const now = Date.now() + serverOffset;
+    if (p.grid) renderGrid(p, now);
+    if (p.topology) renderTopology(p, now);
+}
+
+function createTile(s) {
+    const tile = document.createElement('a');
+    tile.href = '/sensor/' + encodeURIComponent(s.sensor_id);
+    tile.innerHTML = '<div class="tile-id"></div><div class="tile-location"></div>' +
+        '<div class="tile-value"></div><div class="tile-spark"><canvas></canvas></div>';
+    tile.querySelector('.tile-id').innerText = s.sensor_id;
+    return tile;
+}
+
+function renderGrid(p, now) {
+    const ids = Array.from(p.sensors.keys()).sort();
+    const hint = p.grid.querySelector('.chart-hint');
+    if (ids.length && hint) hint.remove();
+    if (!ids.length && !hint) p.grid.innerHTML = '<div class="chart-hint">Nenhuma leitura ainda.</div>';
+
+    let added = false;
+    ids.forEach(function(id) {
+        if (!p.tiles.has(id)) {
+            p.tiles.set(id, createTile(p.sensors.get(id)));
+            added = true;
+        }
+    });
+    // Appending moves the tiles into order
+    if (added) ids.forEach(id => p.grid.appendChild(p.tiles.get(id)));
+
+    ids.forEach(function(id) {
+        const s = p.sensors.get(id), tile = p.tiles.get(id);
+        const state = sensorState(s, now);
+        tile.className = 'sensor-tile state-' + state;
+        tile.title = s.sensor_id + ' via ' + s.edge_id;
+        tile.querySelector('.tile-location').innerText = s.location || s.edge_id;
+        tile.querySelector('.tile-value').innerText = s.value.toFixed(2) + (s.unit ? ' ' + s.unit : '');
+        if (s.dirty || tile.dataset.state !== state) {
+            Charts.sparkline(tile.querySelector('canvas'), s.sparkline, STATE_COLORS[state]);
+            tile.dataset.state = state;
+            s.dirty = false;
+        }
+    });
+}
+
+function renderTopology(p, now) {
+    const byEdge = new Map();
+    p.edges.forEach((e, id) => byEdge.set(id, []));
+    p.sensors.forEach(function(s) {
+        if (!byEdge.has(s.edge_id)) byEdge.set(s.edge_id, []);
+        byEdge.get(s.edge_id).push(s);
+    });
+    const ids = Array.from(byEdge.keys()).sort();
+
+    const html = ids.length ? ids.map(function(id) {
+        const e = p.edges.get(id) || { readings: 0, alerts: 0 };
+        const sensors = byEdge.get(id).sort((a, b) => a.sensor_id < b.sensor_id ? -1 : 1);
+        let state = e.last_seen && now - new Date(e.last_seen).getTime() <= STALE_AFTER ? 'ok' : 'stale';
+        if (state !== 'stale') {
+            sensors.forEach(function(s) {
+                // A silent sensor on a live edge is worth a look, not a stale edge
+                const st = sensorState(s, now) === 'stale' ? 'warning' : sensorState(s, now);
+                if (STATE_RANK[st] > STATE_RANK[state]) state = st;
+            });
+        }
+        return '<div class="edge-box state-' + state + '">' +
+            '<a class="edge-name" href="/edge/' + encodeURIComponent(id) + '">' + escapeHTML(id) + '</a>' +
+            '<div class="edge-meta">' + sensors.length + ' sensores · ' + e.readings.toLocaleString() + ' leituras · ' + e.alerts + ' alertas</div>' +
+            sensors.map(s => '<a class="sensor-chip state-' + sensorState(s, now) + '" href="' +
+                '/sensor/' + encodeURIComponent(s.sensor_id) + '">' + escapeHTML(s.sensor_id) + '</a>').join('') +
+        '</div>';
+    }).join('') : '<div class="chart-hint">Nenhum edge ainda.</div>';
+
+    // Rebuilt only when it changed, so hovering isn't interrupted
+    if (html !== p.topologyHTML) {
+        p.topology.innerHTML = html;
+        p.topologyHTML = html;
+    }
+}
@@ -0,0 +1,310 @@
+// settings.js: the settings page. Sensor thresholds go to the registry, the
+// edge pipeline and the notification routes to the central config, through
+// /api/settings; every change shows up in the audit log below.
+
+const BAND_FIELDS = ['warning_min', 'warning_max', 'critical_min', 'critical_max'];
+const ROUTE_LISTS = ['sinks', 'severity', 'sensors', 'edges', 'sites'];
+
+let edgeDocs = {};  // edge settings documents, by config key
+let routes = null;  // null while the cloud uses the routes of its -notify file
+
+function api(method, url, body) {
+    const init = { method: method, headers: method === 'GET' ? {} : csrfHeaders() };
+    if (body !== undefined) {
+        init.headers['Content-Type'] = 'application/json';
+        init.body = JSON.stringify(body);
+    }
+    return fetch(url, init).then(function(resp) {
+        if (!resp.ok) return resp.text().then(text => { throw new Error(text.trim() || resp.statusText); });
+        return resp.status === 204 ? null : resp.json();
+    });
+}
+
+function showMessage(text, error) {
+    const box = document.getElementById('message');
+    box.textContent = text;
+    box.className = 'message ' + (error ? 'message-error' : 'message-ok');
+    clearTimeout(showMessage.timer);
+    showMessage.timer = setTimeout(() => box.className = 'message', 5000);
+}
+
+function hintRow(tbody, columns, text) {
+    tbody.innerHTML = '<tr><td class="chart-hint" colspan="' + columns + '"></td></tr>';
+    tbody.querySelector('td').textContent = text;
+}
+
+// Sensor thresholds
+
+function loadSensors() {
+    const tbody = document.getElementById('sensors-tbody');
+    api('GET', '/api/sensors').then(function(sensors) {
+        tbody.innerHTML = '';
+        if (!sensors || !sensors.length) return hintRow(tbody, 7, 'Nenhum sensor registrado.');
+        sensors.forEach(s => tbody.appendChild(sensorRow(s)));
+    }).catch(err => hintRow(tbody, 7, 'Registro indisponível: ' + err.message));
+}
+
+function sensorRow(s) {
+    const tr = document.createElement('tr');
+    tr.innerHTML = '<td style="font-family: monospace;"></td><td></td>' +
+        BAND_FIELDS.map(f => '<td><input type="number" step="any" placeholder="edge" data-field="' + f + '"></td>').join('') +
+        '<td class="actions"><button class="btn btn-primary">Salvar</button><button class="btn">Limpar</button></td>';
+    tr.cells[0].textContent = s.id;
+    tr.cells[1].textContent = [s.location, s.unit].filter(Boolean).join(' · ');
+    const inputs = Array.from(tr.querySelectorAll('input'));
+    inputs.forEach(i => i.value = s.thresholds ? s.thresholds[i.dataset.field] : '');
+
+    const buttons = tr.querySelectorAll('button');
+    buttons[0].onclick = function() {
+        if (inputs.some(i => i.value === '')) {
+            showMessage('Preencha os quatro limites de ' + s.id + ', ou use Limpar', true);
+            return;
+        }
+        const t = {};
+        inputs.forEach(i => t[i.dataset.field] = Number(i.value));
+        putSensor(tr, s.id, t);
+    };
+    buttons[1].onclick = () => putSensor(tr, s.id, null);
+    return tr;
+}
+
+function putSensor(tr, id, thresholds) {
+    api('PUT', '/api/settings/sensors/' + encodeURIComponent(id), { thresholds: thresholds }).then(function(s) {
+        tr.replaceWith(sensorRow(s));
+        showMessage(thresholds ? 'Limites de ' + id + ' salvos' : id + ' volta aos limites do edge');
+        loadAudit();
+    }).catch(err => showMessage(err.message, true));
+}
+
+// Edge pipeline
+
+function edgeKey(target) {
+    return 'edge.' + (target === 'defaults' ? target : target.replace(/[^A-Za-z0-9_-]/g, '_'));
+}
+
+function loadEdges() {
+    Promise.all([
+        api('GET', '/api/settings/edge'),
+        api('GET', '/api/topology').catch(() => [])
+    ]).then(function(results) {
+        edgeDocs = results[0];
+        const ids = new Set(results[1].map(e => e.edge_id));
+        Object.keys(edgeDocs).forEach(k => { if (k !== 'edge.defaults') ids.add(k.slice('edge.'.length)); });
+
+        const select = document.getElementById('edge-target');
+        const current = select.value || 'defaults';
+        select.innerHTML = '<option value="defaults">Padrão (todos os edges)</option>';
+        Array.from(ids).sort().forEach(function(id) {
+            const option = document.createElement('option');
+            option.value = id;
+            option.textContent = id + (edgeDocs[edgeKey(id)] ? ' *' : '');
+            select.appendChild(option);
+        });
+        select.value = ids.has(current) ? current : 'defaults';
+        renderEdgeForm();
+    }).catch(function(err) {
+        document.getElementById('edge-doc').textContent = 'Configuração central indisponível: ' + err.message;
+        document.querySelectorAll('#edge-save, #edge-delete').forEach(b => b.disabled = true);
+    });
+}
+
+function renderEdgeForm() {
+    const doc = edgeDocs[edgeKey(document.getElementById('edge-target').value)] || {};
+    document.querySelectorAll('[data-edge]').forEach(function(input) {
+        const v = doc[input.dataset.edge];
+        input.value = v === undefined ? '' : v;
+    });
+    document.querySelectorAll('[data-band]').forEach(function(input) {
+        const v = doc.thresholds ? doc.thresholds[input.dataset.band] : undefined;
+        input.value = v === undefined ? '' : v;
+    });
+    const enabled = doc.detectors && doc.detectors.enabled;
+    document.getElementById('detectors-inherit').checked = !enabled;
+    document.querySelectorAll('[data-detector]').forEach(function(box) {
+        box.checked = !!enabled && enabled.indexOf(box.dataset.detector) >= 0;
+        box.disabled = !enabled;
+    });
+    previewEdgeDoc();
+}
+
+// buildEdgeDoc applies the form to the current document, keeping the fields
+// it doesn't show (detector tuning, for one).
+function buildEdgeDoc() {
+    const doc = JSON.parse(JSON.stringify(edgeDocs[edgeKey(document.getElementById('edge-target').value)] || {}));
+    document.querySelectorAll('[data-edge]').forEach(function(input) {
+        const field = input.dataset.edge;
+        if (input.value === '') delete doc[field];
+        else doc[field] = input.type === 'number' ? Number(input.value) : input.value.trim();
+    });
+
+    const bands = Array.from(document.querySelectorAll('[data-band]'));
+    if (bands.every(i => i.value === '')) {
+        delete doc.thresholds;
+    } else if (bands.some(i => i.value === '')) {
+        throw new Error('Preencha os quatro limites, ou nenhum');
+    } else {
+        doc.thresholds = {};
+        bands.forEach(i => doc.thresholds[i.dataset.band] = Number(i.value));
+    }
+
+    if (document.getElementById('detectors-inherit').checked) {
+        if (doc.detectors) {
+            delete doc.detectors.enabled;
+            if (!Object.keys(doc.detectors).length) delete doc.detectors;
+        }
+    } else {
+        doc.detectors = doc.detectors || {};
+        doc.detectors.enabled = Array.from(document.querySelectorAll('[data-detector]:checked')).map(b => b.dataset.detector);
+    }
+    return doc;
+}
+
+function previewEdgeDoc() {
+    const preview = document.getElementById('edge-doc');
+    try {
+        preview.textContent = JSON.stringify(buildEdgeDoc(), null, 2);
+    } catch (err) {
+        preview.textContent = err.message;
+    }
+}
+
+function saveEdge() {
+    const target = document.getElementById('edge-target').value;
+    let doc;
+    try {
+        doc = buildEdgeDoc();
+    } catch (err) {
+        showMessage(err.message, true);
+        return;
+    }
+    api('PUT', '/api/settings/edge/' + encodeURIComponent(target), doc).then(function() {
+        showMessage('Pipeline de ' + (target === 'defaults' ? 'todos os edges' : target) + ' salvo');
+        loadEdges();
+        loadAudit();
+    }).catch(err => showMessage(err.message, true));
+}
+
+function deleteEdge() {
+    const target = document.getElementById('edge-target').value;
+    if (!edgeDocs[edgeKey(target)] || !confirm('Remover as configurações de ' + target + '?')) return;
+    api('DELETE', '/api/settings/edge/' + encodeURIComponent(target)).then(function() {
+        showMessage('Configurações de ' + target + ' removidas');
+        loadEdges();
+        loadAudit();
+    }).catch(err => showMessage(err.message, true));
+}
+
+// Notification routes
+
+function loadRoutes() {
+    api('GET', '/api/settings/notify').then(function(data) {
+        routes = data.routes;
+        renderRoutes();
+    }).catch(function(err) {
+        document.getElementById('routes-hint').textContent = 'Configuração central indisponível: ' + err.message;
+        document.querySelectorAll('#route-add, #routes-save, #routes-file').forEach(b => b.disabled = true);
+    });
+}
+
+function renderRoutes() {
+    document.getElementById('routes-hint').textContent = routes === null ?
+        'O cloud usa as rotas do seu arquivo -notify. Listas separadas por vírgula, aceitam curingas (temp-*); vazias casam com tudo.' :
+        'Estas rotas substituem as do arquivo -notify do cloud; os destinos continuam os do arquivo.';
+    const tbody = document.getElementById('routes-tbody');
+    tbody.innerHTML = '';
+    (routes || []).forEach(function(route) {
+        const tr = document.createElement('tr');
+        tr.innerHTML = ROUTE_LISTS.map(f => '<td><input type="text" data-route="' + f + '"></td>').join('') +
+            '<td><input type="text" placeholder="5m" data-route="rate_limit"></td>' +
+            '<td><button class="btn">Remover</button></td>';
+        tr.querySelectorAll('input').forEach(function(input) {
+            const v = route[input.dataset.route];
+            input.value = Array.isArray(v) ? v.join(', ') : (v || '');
+        });
+        tr.querySelector('button').onclick = function() {
+            routes = collectRoutes();
+            routes.splice(tr.rowIndex - 1, 1);
+            renderRoutes();
+        };
+        tbody.appendChild(tr);
+    });
+}
+
+function collectRoutes() {
+    return Array.from(document.querySelectorAll('#routes-tbody tr')).map(function(tr) {
+        const route = {};
+        tr.querySelectorAll('input').forEach(function(input) {
+            const field = input.dataset.route;
+            if (field === 'rate_limit') {
+                if (input.value.trim()) route.rate_limit = input.value.trim();
+                return;
+            }
+            const list = input.value.split(',').map(s => s.trim()).filter(Boolean);
+            if (list.length || field === 'sinks') route[field] = list;
+        });
+        return route;
+    });
+}
+
+function saveRoutes(next) {
+    api('PUT', '/api/settings/notify', { routes: next }).then(function(data) {
+        routes = data.routes;
+        renderRoutes();
+        showMessage(routes === null ? 'O cloud volta às rotas do arquivo' : 'Rotas de notificação salvas');
+        loadAudit();
+    }).catch(err => showMessage(err.message, true));
+}
+
+// Audit log
+
+function loadAudit() {
+    const tbody = document.getElementById('audit-tbody');
+    api('GET', '/api/audit').then(function(entries) {
+        tbody.innerHTML = '';
+        if (!entries.length) return hintRow(tbody, 6, 'Nenhuma mudança registrada.');
+        entries.reverse().forEach(function(e) {
+            const tr = document.createElement('tr');
+            const cells = [new Date(e.time).toLocaleString(), e.actor, e.action, e.target,
+                JSON.stringify(e.before), JSON.stringify(e.after)];
+            cells.forEach(function(text, i) {
+                const td = document.createElement('td');
+                td.textContent = text === undefined ? '-' : text;
+                if (i >= 4) {
+                    td.className = 'audit-doc';
+                    td.title = td.textContent;
+                }
+                tr.appendChild(td);
+            });
+            tbody.appendChild(tr);
+        });
+    }).catch(err => hintRow(tbody, 6, err.message));
+}
+
+document.addEventListener('DOMContentLoaded', () => {
+    document.getElementById('back-link').href = '/';
+    loadSession();
+
+    document.getElementById('edge-target').addEventListener('change', renderEdgeForm);
+    document.querySelectorAll('[data-edge], [data-band], [data-detector]').forEach(i => i.addEventListener('input', previewEdgeDoc));
+    document.getElementById('detectors-inherit').addEventListener('change', function(e) {
+        document.querySelectorAll('[data-detector]').forEach(box => box.disabled = e.target.checked);
+        previewEdgeDoc();
+    });
+    document.getElementById('edge-save').addEventListener('click', saveEdge);
+    document.getElementById('edge-delete').addEventListener('click', deleteEdge);
+
+    document.getElementById('route-add').addEventListener('click', function() {
+        routes = collectRoutes();
+        routes.push({ sinks: [] });
+        renderRoutes();
+    });
+    document.getElementById('routes-save').addEventListener('click', () => saveRoutes(collectRoutes()));
+    document.getElementById('routes-file').addEventListener('click', function() {
+        if (confirm('Descartar estas rotas e voltar às do arquivo -notify?')) saveRoutes(null);
+    });
+
+    loadSensors();
+    loadEdges();
+    loadRoutes();
+    loadAudit();
+});
@@ -0,0 +1,65 @@
+package dashboard
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// TestStaticAssets checks that the pages load their assets from the binary,
+// under hashed URLs that can be cached for good.
+func TestStaticAssets(t *testing.T) {
+	srv := httptest.NewServer((&Dashboard{}).Handler())
+	defer srv.Close()
+
+	get := func(path string, header http.Header) (*http.Response, string) {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+path, nil)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp, string(body)
+	}
+
+	_, page := get("/", nil)
+	if strings.Contains(page, "https://") {
+		t.Error("the index loads something from the internet")
+	}
+	urls := regexp.MustCompile(`/static/[\w.]+`).FindAllString(page, -1)
+	if len(urls) != 5 {
+		t.Fatalf("index assets %v, want the stylesheet and 4 scripts", urls)
+	}
+
+	for _, u := range urls {
+		resp, body := get(u, nil)
+		if resp.StatusCode != http.StatusOK || body == "" {
+			t.Errorf("GET %s: %s", u, resp.Status)
+		}
+		if cc := resp.Header.Get("Cache-Control"); !strings.Contains(cc, "immutable") {
+			t.Errorf("GET %s: Cache-Control %q, want immutable", u, cc)
+		}
+	}
+	resp, body := get("/static/charts.js", nil)
+	if resp.Header.Get("Cache-Control") != "no-cache" || !strings.Contains(body, "LineChart") {
+		t.Errorf("GET /static/charts.js: Cache-Control %q", resp.Header.Get("Cache-Control"))
+	}
+	if ct := resp.Header.Get("Content-Type"); !strings.Contains(ct, "javascript") {
+		t.Errorf("GET /static/charts.js: Content-Type %q", ct)
+	}
+	resp, _ = get("/static/charts.js", http.Header{"If-None-Match": {resp.Header.Get("ETag")}})
+	if resp.StatusCode != http.StatusNotModified {
+		t.Errorf("revalidating /static/charts.js: %s, want 304", resp.Status)
+	}
+	if resp, _ := get("/static/charts.0000000000.js", nil); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("GET of a stale hash: %s, want 404", resp.Status)
+	}
+}
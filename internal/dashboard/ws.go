@@ -0,0 +1,329 @@
+package dashboard
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"sistemas_distribuidos_gb/internal/auth"
+)
+
+// wsBuffer is how many messages a WebSocket client may fall behind before
+// it is disconnected.
+const wsBuffer = 256
+
+// Timeouts of the WebSocket connections: writes to a client that stopped
+// reading, and pings that keep idle connections (and their proxies) alive.
+const (
+	wsWriteTimeout = 10 * time.Second
+	wsPingInterval = 30 * time.Second
+	wsPongTimeout  = 2 * wsPingInterval
+)
+
+var upgrader = websocket.Upgrader{ReadBufferSize: 1024, WriteBufferSize: 4096}
+
+// Subscription picks the readings and alerts a WebSocket client receives.
+// Empty filters match everything; sensor and edge filters are glob patterns
+// (temp-*).
+type Subscription struct {
+	Sensors  []string `json:"sensors,omitempty"`
+	Edges    []string `json:"edges,omitempty"`
+	Severity []string `json:"severity,omitempty"` // alert types: critical, warning, anomaly, security
+	Streams  []string `json:"streams,omitempty"`  // "readings", "alerts"; both if empty
+}
+
+func (s Subscription) validate() error {
+	for _, patterns := range [][]string{s.Sensors, s.Edges, s.Severity} {
+		for _, p := range patterns {
+			if _, err := path.Match(p, ""); err != nil {
+				return fmt.Errorf("bad pattern %q", p)
+			}
+		}
+	}
+	for _, stream := range s.Streams {
+		if stream != "readings" && stream != "alerts" {
+			return fmt.Errorf("unknown stream %q", stream)
+		}
+	}
+	return nil
+}
+
+func (s Subscription) wants(stream string) bool {
+	if len(s.Streams) == 0 {
+		return true
+	}
+	for _, st := range s.Streams {
+		if st == stream {
+			return true
+		}
+	}
+	return false
+}
+
+func (s Subscription) matchesReading(r *ReadingDisplay) bool {
+	return s.wants("readings") && matchAny(s.Sensors, r.SensorID) && matchAny(s.Edges, r.EdgeID)
+}
+
+func (s Subscription) matchesAlert(a *AlertDisplay) bool {
+	return s.wants("alerts") && matchAny(s.Sensors, a.SensorID) && matchAny(s.Edges, a.EdgeID) &&
+		matchAny(s.Severity, a.Type)
+}
+
+func matchAny(patterns []string, s string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, s); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// wsCommand is a message from a client. Type is "subscribe", which replaces
+// the subscription, or "ack", which acknowledges the alert with seq Seq. The
+// optional ID comes back in the result.
+type wsCommand struct {
+	Type         string       `json:"type"`
+	ID           string       `json:"id,omitempty"`
+	Subscription Subscription `json:"subscription"`
+	Seq          int64        `json:"seq,omitempty"`
+}
+
+// wsMessage is a message to a client: a "reading", an "alert", an "ack" of
+// an alert by any client, or the "result" of a command.
+type wsMessage struct {
+	Type         string          `json:"type"`
+	Reading      *ReadingDisplay `json:"reading,omitempty"`
+	Alert        *AlertDisplay   `json:"alert,omitempty"`
+	ID           string          `json:"id,omitempty"`
+	Error        string          `json:"error,omitempty"`
+	Subscription *Subscription   `json:"subscription,omitempty"`
+}
+
+// wsClient is a WebSocket connection and its subscription.
+type wsClient struct {
+	addr  string
+	actor string      // who opened it, for the audit log
+	role  auth.Role   // what they may do
+	send  chan []byte // closed when the client is dropped
+
+	// Close frame sent when dropped, set before send is closed
+	closeCode int
+	closeText string
+
+	mu  sync.Mutex
+	sub Subscription
+}
+
+func (c *wsClient) subscription() Subscription {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.sub
+}
+
+// fanOut sends a reading, an alert or an ack to the clients subscribed to it.
+func (d *Dashboard) fanOut(msg wsMessage) {
+	d.clientsMu.Lock()
+	defer d.clientsMu.Unlock()
+
+	var data []byte
+	for c := range d.wsClients {
+		sub := c.subscription()
+		if msg.Reading != nil && !sub.matchesReading(msg.Reading) ||
+			msg.Alert != nil && !sub.matchesAlert(msg.Alert) {
+			continue
+		}
+		if data == nil {
+			data, _ = json.Marshal(msg)
+		}
+		d.sendWS(c, data)
+	}
+}
+
+// sendWS queues data for c, dropping c if it's too far behind;
+// d.clientsMu must be held.
+func (d *Dashboard) sendWS(c *wsClient, data []byte) {
+	select {
+	case c.send <- data:
+	default:
+		log.Printf("Dropping slow WebSocket client %s", c.addr)
+		d.dropWS(c, websocket.ClosePolicyViolation, "too slow")
+	}
+}
+
+// reply sends the result of a command to c alone.
+func (d *Dashboard) reply(c *wsClient, msg wsMessage) {
+	data, _ := json.Marshal(msg)
+	d.clientsMu.Lock()
+	defer d.clientsMu.Unlock()
+	if _, ok := d.wsClients[c]; ok {
+		d.sendWS(c, data)
+	}
+}
+
+// dropWS ends the connection of c with a close frame; d.clientsMu must be
+// held.
+func (d *Dashboard) dropWS(c *wsClient, code int, text string) {
+	delete(d.wsClients, c)
+	c.closeCode, c.closeText = code, text
+	close(c.send)
+}
+
+func (d *Dashboard) removeWS(c *wsClient) {
+	d.clientsMu.Lock()
+	defer d.clientsMu.Unlock()
+	if _, ok := d.wsClients[c]; ok {
+		d.dropWS(c, websocket.CloseNormalClosure, "")
+	}
+}
+
+// ackAlert marks a recent alert as acknowledged, tells the clients
+// subscribed to it and returns it.
+func (d *Dashboard) ackAlert(seq int64) (*AlertDisplay, error) {
+	d.mu.Lock()
+	var acked *AlertDisplay
+	for i := range d.RecentAlerts {
+		if a := &d.RecentAlerts[i]; a.Seq == seq {
+			if a.AckedAt == 0 {
+				a.AckedAt = d.clock.Now().UnixMilli()
+			}
+			copied := *a
+			acked = &copied
+			break
+		}
+	}
+	d.mu.Unlock()
+
+	if acked == nil {
+		return nil, fmt.Errorf("no recent alert %d", seq)
+	}
+	d.fanOut(wsMessage{Type: "ack", Alert: acked})
+	return acked, nil
+}
+
+// querySubscription reads the initial subscription from the URL:
+// sensor, edge, severity and stream, repeated or comma separated.
+func querySubscription(r *http.Request) (Subscription, error) {
+	q := r.URL.Query()
+	list := func(key string) []string {
+		var values []string
+		for _, v := range q[key] {
+			for _, s := range strings.Split(v, ",") {
+				if s = strings.TrimSpace(s); s != "" {
+					values = append(values, s)
+				}
+			}
+		}
+		return values
+	}
+	sub := Subscription{Sensors: list("sensor"), Edges: list("edge"), Severity: list("severity"), Streams: list("stream")}
+	return sub, sub.validate()
+}
+
+// handleWS streams the readings and alerts matching a subscription over a
+// WebSocket as they arrive, and takes commands on the same socket.
+func (d *Dashboard) handleWS(w http.ResponseWriter, r *http.Request) {
+	sub, err := querySubscription(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return // the upgrader replied
+	}
+
+	c := &wsClient{addr: r.RemoteAddr, actor: actor(r), role: d.role(r), send: make(chan []byte, wsBuffer), sub: sub}
+	d.clientsMu.Lock()
+	d.wsClients[c] = struct{}{}
+	d.clientsMu.Unlock()
+	defer d.removeWS(c)
+
+	go d.writeWS(conn, c)
+
+	conn.SetReadLimit(64 << 10)
+	conn.SetReadDeadline(time.Now().Add(wsPongTimeout))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(wsPongTimeout))
+	})
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		var cmd wsCommand
+		if err := json.Unmarshal(data, &cmd); err != nil {
+			d.reply(c, wsMessage{Type: "result", Error: "bad command: " + err.Error()})
+			continue
+		}
+		d.reply(c, d.command(c, cmd))
+	}
+}
+
+// command runs a client command and returns its result.
+func (d *Dashboard) command(c *wsClient, cmd wsCommand) wsMessage {
+	result := wsMessage{Type: "result", ID: cmd.ID}
+	switch cmd.Type {
+	case "subscribe":
+		if err := cmd.Subscription.validate(); err != nil {
+			result.Error = err.Error()
+			break
+		}
+		c.mu.Lock()
+		c.sub = cmd.Subscription
+		c.mu.Unlock()
+		result.Subscription = &cmd.Subscription
+	case "ack":
+		if !c.role.Allows(auth.Operator) {
+			result.Error = "forbidden: needs the operator role"
+			break
+		}
+		if acked, err := d.ackAlert(cmd.Seq); err != nil {
+			result.Error = err.Error()
+		} else {
+			d.record(c.actor, "alert.ack", acked.SensorID, nil, acked)
+		}
+	default:
+		result.Error = fmt.Sprintf("unknown command %q", cmd.Type)
+	}
+	return result
+}
+
+// writeWS writes the queued messages of c and pings it, until c is dropped
+// or a write fails.
+func (d *Dashboard) writeWS(conn *websocket.Conn, c *wsClient) {
+	ping := time.NewTicker(wsPingInterval)
+	defer func() {
+		ping.Stop()
+		conn.Close()
+	}()
+	for {
+		select {
+		case data, ok := <-c.send:
+			conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+			if !ok {
+				conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(c.closeCode, c.closeText))
+				return
+			}
+			if err := conn.WriteMessage(websocket.TextMessage, data); err != nil {
+				d.removeWS(c)
+				return
+			}
+		case <-ping.C:
+			conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				d.removeWS(c)
+				return
+			}
+		}
+	}
+}
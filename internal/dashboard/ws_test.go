@@ -0,0 +1,150 @@
+package dashboard
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"sistemas_distribuidos_gb/internal/broker"
+	"sistemas_distribuidos_gb/internal/clock"
+	"sistemas_distribuidos_gb/internal/subjects"
+)
+
+// wsConn is a test client of /api/ws.
+type wsConn struct {
+	t    *testing.T
+	conn *websocket.Conn
+}
+
+func dialWS(t *testing.T, url, query string) *wsConn {
+	t.Helper()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(url, "http")+"/api/ws"+query, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &wsConn{t: t, conn: conn}
+}
+
+func (c *wsConn) read() wsMessage {
+	c.t.Helper()
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var msg wsMessage
+	if err := c.conn.ReadJSON(&msg); err != nil {
+		c.t.Fatalf("reading message: %v", err)
+	}
+	return msg
+}
+
+// command sends cmd and returns its result.
+func (c *wsConn) command(cmd wsCommand) wsMessage {
+	c.t.Helper()
+	if err := c.conn.WriteJSON(cmd); err != nil {
+		c.t.Fatal(err)
+	}
+	msg := c.read()
+	if msg.Type != "result" || msg.ID != cmd.ID {
+		c.t.Fatalf("got %+v, want the result of %s", msg, cmd.ID)
+	}
+	return msg
+}
+
+// TestWebSocket checks that clients only get what they subscribed to, can
+// change their subscription and acknowledge alerts.
+func TestWebSocket(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	b := broker.NewMemory()
+	opts := DefaultOptions()
+	opts.UseConfig = false
+	opts.Clock = clock.NewFake(time.Date(2024, 1, 1, 8, 0, 0, 0, time.UTC))
+	opts.HistoryDir = ""
+	opts.AuditFile = ""
+	d, err := New(b, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer d.Stop()
+	srv := httptest.NewServer(d.Handler())
+	defer srv.Close()
+
+	temps := dialWS(t, srv.URL, "?sensor=temp-*")
+	defer temps.conn.Close()
+	critical := dialWS(t, srv.URL, "?severity=critical&stream=alerts")
+	defer critical.conn.Close()
+	// Both registered once a command went through
+	temps.command(wsCommand{Type: "subscribe", ID: "1", Subscription: Subscription{Sensors: []string{"temp-*"}}})
+	critical.command(wsCommand{Type: "subscribe", ID: "1", Subscription: Subscription{Severity: []string{"critical"}, Streams: []string{"alerts"}}})
+
+	reading := func(sensor string) {
+		data, _ := json.Marshal(FilteredReading{SensorID: sensor, Value: 1, EdgeID: "edge-1"})
+		b.Publish(subjects.Filtered("edge-1"), data)
+	}
+	alert := func(sensor, typ string) {
+		data, _ := json.Marshal(Alert{SensorID: sensor, EdgeID: "edge-1", Type: typ})
+		b.Publish(subjects.Alerts("edge-1"), data)
+	}
+	reading("hum-01")
+	reading("temp-01")
+	alert("temp-01", "warning")
+	alert("hum-01", "critical")
+
+	if msg := temps.read(); msg.Type != "reading" || msg.Reading.SensorID != "temp-01" {
+		t.Errorf("temp-* subscriber got %+v, want the reading of temp-01", msg)
+	}
+	if msg := temps.read(); msg.Type != "alert" || msg.Alert.Type != "warning" {
+		t.Errorf("temp-* subscriber got %+v, want the warning of temp-01", msg)
+	}
+	msg := critical.read()
+	if msg.Type != "alert" || msg.Alert.SensorID != "hum-01" {
+		t.Fatalf("critical subscriber got %+v, want the alert of hum-01", msg)
+	}
+
+	// Acks reach the subscribers of the alert, the acknowledging client
+	// included, ahead of the result
+	critical.conn.WriteJSON(wsCommand{Type: "ack", ID: "2", Seq: msg.Alert.Seq})
+	if ack := critical.read(); ack.Type != "ack" || ack.Alert.Seq != msg.Alert.Seq || ack.Alert.AckedAt == 0 {
+		t.Errorf("got %+v, want the ack of alert %d", ack, msg.Alert.Seq)
+	}
+	if res := critical.read(); res.Type != "result" || res.Error != "" {
+		t.Fatalf("ack: %+v", res)
+	}
+	if stats := d.getStats(); stats.RecentAlerts[0].AckedAt == 0 {
+		t.Error("acknowledged alert not marked")
+	}
+
+	// Changing the subscription
+	temps.command(wsCommand{Type: "subscribe", ID: "3", Subscription: Subscription{Sensors: []string{"hum-*"}, Streams: []string{"readings"}}})
+	reading("temp-01")
+	reading("hum-02")
+	if msg := temps.read(); msg.Type != "reading" || msg.Reading.SensorID != "hum-02" {
+		t.Errorf("after resubscribing got %+v, want the reading of hum-02", msg)
+	}
+
+	for _, cmd := range []wsCommand{
+		{Type: "ack", ID: "4", Seq: 999},
+		{Type: "subscribe", ID: "5", Subscription: Subscription{Sensors: []string{"[temp"}}},
+		{Type: "subscribe", ID: "6", Subscription: Subscription{Streams: []string{"metrics"}}},
+		{Type: "reboot", ID: "7"},
+	} {
+		if res := temps.command(cmd); res.Error == "" {
+			t.Errorf("command %+v succeeded", cmd)
+		}
+	}
+
+	// A bad initial subscription is refused before upgrading
+	if _, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/api/ws?stream=metrics", nil); err == nil || resp.StatusCode != 400 {
+		t.Errorf("bad subscription accepted: %v", err)
+	}
+}
@@ -0,0 +1,153 @@
+package edge
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"sistemas_distribuidos_gb/internal/broker"
+	"sistemas_distribuidos_gb/internal/subjects"
+)
+
+// DefaultBridgeTopic is the MQTT topic layout the bridge expects unless told
+// otherwise: the reading subjects, with slashes.
+const DefaultBridgeTopic = "sensors/{site}/{line}/{sensor}/readings"
+
+// bridgeTopic is a parsed MQTT topic template. Levels are literals, + or one
+// of the {site}, {line} and {sensor} placeholders; {sensor} is required.
+type bridgeTopic []string
+
+func parseBridgeTopic(tmpl string) (bridgeTopic, error) {
+	levels := strings.Split(tmpl, "/")
+	hasSensor := false
+	for _, l := range levels {
+		switch {
+		case l == "{sensor}":
+			hasSensor = true
+		case l == "{site}", l == "{line}", l == "+":
+		case l == "" || l == "#" || strings.ContainsAny(l, ".*>{}+#"):
+			return nil, fmt.Errorf("invalid level %q in bridge topic %q", l, tmpl)
+		}
+	}
+	if !hasSensor {
+		return nil, fmt.Errorf("bridge topic %q has no {sensor} level", tmpl)
+	}
+	return bridgeTopic(levels), nil
+}
+
+// filter returns the subject pattern the bridge subscribes to.
+func (t bridgeTopic) filter() string {
+	tokens := make([]string, len(t))
+	for i, l := range t {
+		if l == "+" || strings.HasPrefix(l, "{") {
+			l = "*"
+		}
+		tokens[i] = l
+	}
+	return strings.Join(tokens, ".")
+}
+
+// parse extracts the placeholders from the subject of a bridged message.
+func (t bridgeTopic) parse(subject string) (site, line, sensorID string, ok bool) {
+	tokens := strings.Split(subject, ".")
+	if len(tokens) != len(t) {
+		return "", "", "", false
+	}
+	for i, l := range t {
+		switch l {
+		case "{site}":
+			site = tokens[i]
+		case "{line}":
+			line = tokens[i]
+		case "{sensor}":
+			sensorID = tokens[i]
+		}
+	}
+	return subjects.Token(site, subjects.DefaultSite), subjects.Token(line, subjects.DefaultLine), sensorID, sensorID != ""
+}
+
+// bridgesOwnOutput reports whether a bridge on topic would receive the
+// readings it republishes through b, and republish them again forever: when
+// the bridge is b itself, or b is an MQTT transport (on the same server, as
+// the edge command sets it up), and topic matches reading subjects.
+func bridgesOwnOutput(b, bridge broker.Broker, topic bridgeTopic) bool {
+	if _, mqtt := b.(*broker.MQTT); !mqtt && b != bridge {
+		return false
+	}
+	filter, readings := strings.Split(topic.filter(), "."), strings.Split(subjects.AllReadings, ".")
+	if len(filter) != len(readings) {
+		return false
+	}
+	for i := range filter {
+		if filter[i] != readings[i] && filter[i] != "*" && readings[i] != "*" {
+			return false
+		}
+	}
+	return true
+}
+
+// startBridge subscribes to the device topics on the bridge broker and
+// republishes every reading on its reading subject, where this edge (and
+// JetStream, other edges...) pick it up like any other reading.
+func (e *Edge) startBridge() error {
+	topic, err := parseBridgeTopic(e.opts.BridgeTopic)
+	if err != nil {
+		return err
+	}
+	sub, err := e.opts.Bridge.Subscribe(topic.filter(), func(subject string, data []byte) {
+		e.bridgeMessage(topic, subject, data)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to subscribe to bridge topic %s: %w", e.opts.BridgeTopic, err)
+	}
+	e.subs = append(e.subs, sub)
+	log.Printf("Bridging readings from MQTT topic %s", broker.TopicFromSubject(topic.filter()))
+	return nil
+}
+
+func (e *Edge) bridgeMessage(topic bridgeTopic, subject string, data []byte) {
+	site, line, sensorID, ok := topic.parse(subject)
+	if !ok {
+		return
+	}
+	reading, err := parseBridgedReading(data)
+	if err != nil {
+		log.Printf("Error parsing bridged reading on %s: %v", broker.TopicFromSubject(subject), err)
+		return
+	}
+	// The topic is authoritative for where the reading came from
+	reading.SensorID = subjects.Token(sensorID, "unknown")
+	reading.Site, reading.Line = site, line
+	if reading.Timestamp == 0 {
+		reading.Timestamp = e.clock.Now().UnixMilli()
+	}
+
+	out, err := json.Marshal(reading)
+	if err != nil {
+		return
+	}
+	if err := e.broker.Publish(subjects.Readings(site, line, reading.SensorID), out); err != nil {
+		log.Printf("Error publishing bridged reading: %v", err)
+	}
+}
+
+// parseBridgedReading accepts a SensorReading JSON object or a bare number.
+func parseBridgedReading(data []byte) (SensorReading, error) {
+	var reading SensorReading
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '{' {
+		if err := json.Unmarshal(data, &reading); err != nil {
+			return reading, err
+		}
+		return reading, nil
+	}
+	v, err := strconv.ParseFloat(string(data), 64)
+	if err != nil {
+		return reading, fmt.Errorf("payload is neither a reading nor a number")
+	}
+	reading.Value = v
+	return reading, nil
+}
@@ -0,0 +1,151 @@
+package edge
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"sistemas_distribuidos_gb/internal/broker"
+	"sistemas_distribuidos_gb/internal/clock"
+	"sistemas_distribuidos_gb/internal/embedded"
+	"sistemas_distribuidos_gb/internal/subjects"
+)
+
+func TestParseBridgeTopic(t *testing.T) {
+	for _, tmpl := range []string{"plant/{line}", "plant/{sensor}/#", "plant.a/{sensor}", "{sensor}/x{y}"} {
+		if _, err := parseBridgeTopic(tmpl); err == nil {
+			t.Errorf("parseBridgeTopic(%q) accepted an invalid template", tmpl)
+		}
+	}
+
+	topic, err := parseBridgeTopic("factory/{site}/+/{line}/{sensor}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f := topic.filter(); f != "factory.*.*.*.*" {
+		t.Errorf("filter = %q", f)
+	}
+	site, line, sensorID, ok := topic.parse("factory.plant.hall-2.l1.temp-01")
+	if !ok || site != "plant" || line != "l1" || sensorID != "temp-01" {
+		t.Errorf("parse = %q, %q, %q, %v", site, line, sensorID, ok)
+	}
+	if _, _, _, ok := topic.parse("factory.plant.l1.temp-01"); ok {
+		t.Error("parse accepted a subject with too few tokens")
+	}
+}
+
+// TestBridgeLoop checks that a bridge that would get back the readings it
+// republishes is refused.
+func TestBridgeLoop(t *testing.T) {
+	srv, err := embedded.StartMQTT("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Shutdown()
+	dial := func(id string) *broker.MQTT {
+		m, err := broker.DialMQTT(broker.MQTTOptions{URL: srv.URL(), ClientID: id})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(m.Close)
+		return m
+	}
+	transport, bridge := dial("edge"), dial("edge-bridge")
+	memory := broker.NewMemory()
+
+	for _, tc := range []struct {
+		name      string
+		b, bridge broker.Broker
+		topic     string
+		loops     bool
+	}{
+		{"mqtt transport, reading topics", transport, bridge, DefaultBridgeTopic, true},
+		{"mqtt transport, wildcard levels", transport, bridge, "+/{site}/{line}/{sensor}/+", true},
+		{"mqtt transport, device topics", transport, bridge, "devices/{site}/{line}/{sensor}", false},
+		{"same broker", memory, memory, DefaultBridgeTopic, true},
+		{"nats transport", memory, bridge, DefaultBridgeTopic, false},
+	} {
+		opts := DefaultOptions()
+		opts.ID = "loop"
+		opts.UseRegistry, opts.UseConfig = false, false
+		opts.Bridge, opts.BridgeTopic = tc.bridge, tc.topic
+		_, err := New(tc.b, opts)
+		if loops := err != nil; loops != tc.loops {
+			t.Errorf("%s: New() = %v, want a loop refused: %v", tc.name, err, tc.loops)
+		}
+	}
+}
+
+// startBridgeEdge runs a test edge that bridges readings from bridge.
+func startBridgeEdge(t *testing.T, bridge broker.Broker, topic string) (*broker.Memory, *clock.Fake) {
+	t.Helper()
+	_, b, clk := startTestEdgeWith(t, func(opts *Options) {
+		opts.ID = "bridge"
+		opts.Bridge, opts.BridgeTopic = bridge, topic
+	})
+	return b, clk
+}
+
+func TestBridge(t *testing.T) {
+	devices := broker.NewMemory()
+	b, clk := startBridgeEdge(t, devices, "factory/{site}/{line}/{sensor}")
+	readings := capture(t, b, subjects.AllReadings)
+	filtered := capture(t, b, subjects.Filtered("bridge"))
+
+	// A bare number, and a reading with its own timestamp
+	devices.Publish("factory.plant.l1.temp-01", []byte(" 21.5\n"))
+	devices.Publish("factory.plant.l1.temp-02", []byte(`{"value": 22, "timestamp": 1000}`))
+	devices.Publish("factory.plant.l1.temp-03", []byte("not a number"))
+
+	if len(readings) != 2 || len(filtered) != 2 {
+		t.Fatalf("got %d readings and %d filtered readings, want 2 of each", len(readings), len(filtered))
+	}
+	var r1, r2 SensorReading
+	json.Unmarshal(<-readings, &r1)
+	json.Unmarshal(<-readings, &r2)
+	if r1.SensorID != "temp-01" || r1.Site != "plant" || r1.Line != "l1" || r1.Value != 21.5 || r1.Timestamp != clk.Now().UnixMilli() {
+		t.Errorf("unexpected bridged reading %+v", r1)
+	}
+	if r2.SensorID != "temp-02" || r2.Value != 22 || r2.Timestamp != 1000 {
+		t.Errorf("unexpected bridged reading %+v", r2)
+	}
+}
+
+// TestBridgeMQTT feeds the edge from a device on an embedded MQTT server.
+// The device speaks MQTT 5 and the bridge MQTT 3.1.1.
+func TestBridgeMQTT(t *testing.T) {
+	srv, err := embedded.StartMQTT("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Shutdown()
+
+	bridge, err := broker.DialMQTT(broker.MQTTOptions{URL: srv.URL(), Version: broker.MQTT311, ClientID: "edge-bridge", QoS: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bridge.Close()
+	device, err := broker.DialMQTT(broker.MQTTOptions{URL: srv.URL(), Version: broker.MQTT5, ClientID: "device", QoS: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer device.Close()
+
+	b, _ := startBridgeEdge(t, bridge, DefaultBridgeTopic)
+	alerts := make(chan []byte, 1)
+	b.Subscribe(subjects.Alerts("bridge"), func(_ string, data []byte) { alerts <- data })
+
+	if err := device.Publish("sensors.plant.l1.press-01.readings", []byte("150")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case data := <-alerts:
+		var a Alert
+		json.Unmarshal(data, &a)
+		if a.SensorID != "press-01" || a.Type != "critical" || a.Value != 150 {
+			t.Errorf("unexpected alert %+v", a)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("reading published over MQTT raised no alert")
+	}
+}
@@ -0,0 +1,64 @@
+package edge
+
+import (
+	"time"
+
+	"sistemas_distribuidos_gb/internal/anomaly"
+)
+
+// Per-sensor detector state. Each sensor gets its own set of detectors the
+// first time it is seen; the sets are dropped when the detector settings
+// change so every sensor relearns with the new parameters.
+func (e *Edge) resetDetectors() {
+	e.detectorsMu.Lock()
+	e.detectors = map[string][]anomaly.Detector{}
+	e.detectorsMu.Unlock()
+}
+
+// detect feeds a calibrated value to the sensor's detectors and returns the
+// results that flagged it and aren't repeated alerts.
+func (e *Edge) detect(sensorID string, t time.Time, value float64, cfg anomaly.Config) []anomaly.Result {
+	e.detectorsMu.Lock()
+	defer e.detectorsMu.Unlock()
+
+	ds, ok := e.detectors[sensorID]
+	if !ok {
+		ds = cfg.New()
+		e.detectors[sensorID] = ds
+	}
+
+	var found []anomaly.Result
+	for _, d := range ds {
+		r := d.Update(t, value)
+		kind := ""
+		if r.Anomaly {
+			kind = r.Kind
+		}
+		if !e.repeated(sensorID, r.Detector, kind) && r.Anomaly {
+			found = append(found, r)
+		}
+	}
+	return found
+}
+
+// alertKey identifies the alerts of one detector for one sensor.
+type alertKey struct{ sensorID, detector string }
+
+// repeated records the outcome of a detector for a sensor, alertType being
+// empty for a normal value, and reports whether the alert repeats one already
+// raised: an adaptive detector alerts once until the sensor is back to normal.
+func (e *Edge) repeated(sensorID, detector, alertType string) bool {
+	e.raisedMu.Lock()
+	defer e.raisedMu.Unlock()
+
+	k := alertKey{sensorID, detector}
+	if alertType == "" {
+		delete(e.raised, k)
+		return false
+	}
+	if _, ok := e.raised[k]; ok {
+		return true
+	}
+	e.raised[k] = alertType
+	return false
+}
@@ -0,0 +1,704 @@
+// Package edge implements the edge node: it consumes the readings of the
+// sensors it owns, applies calibration and signature checks, runs the anomaly
+// detectors and publishes filtered readings, alerts and periodic aggregates
+// for the cloud.
+package edge
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+
+	"sistemas_distribuidos_gb/internal/anomaly"
+	"sistemas_distribuidos_gb/internal/broker"
+	"sistemas_distribuidos_gb/internal/clock"
+	"sistemas_distribuidos_gb/internal/config"
+	"sistemas_distribuidos_gb/internal/config/settings"
+	"sistemas_distribuidos_gb/internal/modbus"
+	"sistemas_distribuidos_gb/internal/opcua"
+	"sistemas_distribuidos_gb/internal/outbox"
+	"sistemas_distribuidos_gb/internal/registry"
+	"sistemas_distribuidos_gb/internal/subjects"
+)
+
+type SensorReading struct {
+	SensorID  string  `json:"sensor_id"`
+	Site      string  `json:"site,omitempty"`
+	Line      string  `json:"line,omitempty"`
+	Value     float64 `json:"value"`
+	Timestamp int64   `json:"timestamp"`
+	Signature string  `json:"signature,omitempty"`
+}
+
+type FilteredReading struct {
+	SensorID  string  `json:"sensor_id"`
+	Site      string  `json:"site,omitempty"`
+	Line      string  `json:"line,omitempty"`
+	Location  string  `json:"location,omitempty"`
+	Unit      string  `json:"unit,omitempty"`
+	Value     float64 `json:"value"`
+	RawValue  float64 `json:"raw_value,omitempty"`
+	Timestamp int64   `json:"timestamp"`
+	EdgeID    string  `json:"edge_id"`
+}
+
+type Alert struct {
+	SensorID  string  `json:"sensor_id"`
+	Site      string  `json:"site,omitempty"`
+	Line      string  `json:"line,omitempty"`
+	Location  string  `json:"location,omitempty"`
+	Unit      string  `json:"unit,omitempty"`
+	Value     float64 `json:"value"`
+	Timestamp int64   `json:"timestamp"`
+	EdgeID    string  `json:"edge_id"`
+	Type      string  `json:"type"`
+	Message   string  `json:"message"`
+	Detector  string  `json:"detector,omitempty"`
+	Score     float64 `json:"score,omitempty"`
+	Baseline  float64 `json:"baseline,omitempty"`
+}
+
+type Stats struct {
+	mu            sync.RWMutex
+	Count         int       `json:"count"`
+	Sum           float64   `json:"sum"`
+	Min           float64   `json:"min"`
+	Max           float64   `json:"max"`
+	WindowValues  []float64 `json:"-"`
+	WindowSize    int       `json:"window_size"`
+	LastAggregate time.Time `json:"last_aggregate"`
+	EdgeID        string    `json:"edge_id"`
+	StartTime     time.Time `json:"start_time"`
+
+	// Signature checks, cumulative since start
+	SignatureFailures int `json:"signature_failures"`
+	Quarantined       int `json:"quarantined"`
+	Rejected          int `json:"rejected"`
+}
+
+// Options configure an edge node. Settings that can change at runtime
+// (thresholds, noise filter, aggregation interval, detectors) are the initial
+// values, overridden by the central config when UseConfig is set.
+type Options struct {
+	ID                string   // generated if empty
+	Subjects          []string // reading subjects this edge owns
+	NoiseFilter       float64
+	WindowSize        int
+	AggregateInterval time.Duration
+	JetStream         bool // consume through a durable JetStream consumer
+	UseRegistry       bool
+	UseConfig         bool
+	Detectors         anomaly.Config
+	Signatures        string // off, warn, quarantine or reject
+	// MaxClockSkew is how far a signed reading's timestamp may be from the
+	// edge clock (DefaultMaxClockSkew if zero); see verifyReading.
+	MaxClockSkew time.Duration
+
+	// Bridge, when set, is an MQTT broker whose device readings are
+	// republished on the reading subjects. BridgeTopic is the device topic
+	// layout (DefaultBridgeTopic if empty).
+	Bridge      broker.Broker
+	BridgeTopic string
+
+	// Modbus devices are polled and OPC UA servers subscribed to directly;
+	// their values skip the signature checks.
+	Modbus []modbus.Device
+	OPCUA  []opcua.Server
+
+	// Uplink, when set, is the broker of the site's hub, apart from the
+	// local broker the sensors publish to: the edge's output (filtered
+	// readings, aggregates, alerts and quarantined readings) goes there, and
+	// the registry and central config are looked up there.
+	Uplink broker.Broker
+	// BufferDir keeps the output on disk until the uplink confirms it, so
+	// that it survives uplink outages and restarts (see package outbox);
+	// disabled if empty. BufferMaxBytes bounds it, dropping the oldest
+	// messages (outbox.DefaultMaxBytes if zero).
+	BufferDir      string
+	BufferMaxBytes int64
+
+	Clock clock.Clock // nil uses the wall clock
+}
+
+// DefaultOptions returns the options used by the edge command by default.
+func DefaultOptions() Options {
+	defaults := settings.DefaultEdge()
+	return Options{
+		Subjects:          []string{subjects.AllReadings},
+		NoiseFilter:       defaults.NoiseFilter,
+		WindowSize:        10,
+		AggregateInterval: defaults.AggregateInterval.Std(),
+		UseRegistry:       true,
+		UseConfig:         true,
+		Detectors:         defaults.Detectors,
+		Signatures:        policyQuarantine,
+		MaxClockSkew:      DefaultMaxClockSkew,
+	}
+}
+
+// Edge is an edge node.
+type Edge struct {
+	opts     Options
+	broker   broker.Broker
+	uplink   broker.Broker // where the output goes: the hub, or outbox
+	outbox   *outbox.Outbox
+	clock    clock.Clock
+	stats    *Stats
+	registry *registry.Client
+
+	settingsMu     sync.RWMutex
+	settings       settings.Edge
+	aggregateReset chan struct{} // tells the aggregation loop that its interval changed
+
+	detectorsMu sync.Mutex
+	detectors   map[string][]anomaly.Detector
+
+	raisedMu sync.Mutex
+	raised   map[alertKey]string // alert type raised by each detector, until back to normal
+
+	securityAlertsMu   sync.Mutex
+	lastSecurityAlerts map[string]time.Time
+
+	seenMu sync.Mutex
+	seen   map[string]*seenTimestamps // of the recent trusted readings of each sensor
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	subs   []broker.Subscription
+	msgs   jetstream.MessagesContext
+}
+
+// New creates an edge node that consumes and publishes through b, and
+// through opts.Uplink if set. JetStream consumers need b to be backed by
+// NATS, and the registry the uplink.
+func New(b broker.Broker, opts Options) (*Edge, error) {
+	clk := clock.Or(opts.Clock)
+	// Generate edge ID if not provided
+	if opts.ID == "" {
+		opts.ID = "edge-" + clk.Now().Format("20060102-150405")
+	}
+	if len(opts.Subjects) == 0 {
+		return nil, errors.New("no subjects to subscribe to")
+	}
+	if opts.WindowSize <= 0 || opts.AggregateInterval <= 0 {
+		return nil, errors.New("window size and aggregation interval must be positive")
+	}
+	if opts.Signatures == "" {
+		opts.Signatures = policyQuarantine
+	}
+	if !validPolicy(opts.Signatures) {
+		return nil, fmt.Errorf("invalid signature policy %q (want off, warn, quarantine or reject)", opts.Signatures)
+	}
+	if opts.MaxClockSkew == 0 {
+		opts.MaxClockSkew = DefaultMaxClockSkew
+	}
+	if opts.MaxClockSkew < 0 {
+		return nil, errors.New("the maximum clock skew can't be negative")
+	}
+	hub := opts.Uplink
+	if hub == nil {
+		hub = b
+	}
+	if _, err := broker.Conn(b); err != nil && opts.JetStream {
+		return nil, fmt.Errorf("JetStream needs the NATS transport")
+	}
+	if _, err := broker.Conn(hub); err != nil {
+		if opts.UseRegistry {
+			log.Printf("The registry needs the NATS transport; disabling it")
+			opts.UseRegistry = false
+		}
+	}
+	if !opts.UseRegistry && opts.Signatures != policyOff {
+		log.Printf("Signature checks need the registry; disabling them")
+		opts.Signatures = policyOff
+	}
+	if err := opts.Detectors.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid detectors: %w", err)
+	}
+	if opts.Bridge != nil {
+		if opts.BridgeTopic == "" {
+			opts.BridgeTopic = DefaultBridgeTopic
+		}
+		topic, err := parseBridgeTopic(opts.BridgeTopic)
+		if err != nil {
+			return nil, err
+		}
+		if bridgesOwnOutput(b, opts.Bridge, topic) {
+			return nil, fmt.Errorf("bridge topic %s overlaps the reading topics the bridge publishes to; use another layout, e.g. devices/{site}/{line}/{sensor}", opts.BridgeTopic)
+		}
+	}
+	for i := range opts.Modbus {
+		if err := opts.Modbus[i].Normalize(); err != nil {
+			return nil, err
+		}
+	}
+	for i := range opts.OPCUA {
+		if err := opts.OPCUA[i].Normalize(); err != nil {
+			return nil, err
+		}
+	}
+
+	return &Edge{
+		opts:   opts,
+		broker: b,
+		uplink: hub,
+		clock:  clk,
+		stats: &Stats{
+			WindowValues: make([]float64, 0, opts.WindowSize),
+			WindowSize:   opts.WindowSize,
+			Min:          math.Inf(1),
+			Max:          math.Inf(-1),
+			EdgeID:       opts.ID,
+			StartTime:    clk.Now(),
+		},
+		aggregateReset:     make(chan struct{}, 1),
+		detectors:          map[string][]anomaly.Detector{},
+		raised:             map[alertKey]string{},
+		lastSecurityAlerts: map[string]time.Time{},
+		seen:               map[string]*seenTimestamps{},
+	}, nil
+}
+
+// ID returns the edge node ID.
+func (e *Edge) ID() string { return e.opts.ID }
+
+// Start opens the buffer, loads the settings, starts the aggregation loop
+// and subscribes to the sensor readings. Everything stops when ctx is done
+// or Stop is called.
+func (e *Edge) Start(ctx context.Context) error {
+	ctx, e.cancel = context.WithCancel(ctx)
+
+	if e.opts.BufferDir != "" {
+		var err error
+		e.outbox, err = outbox.Open(e.uplink, outbox.Options{
+			Dir:      e.opts.BufferDir,
+			MaxBytes: e.opts.BufferMaxBytes,
+			Clock:    e.clock,
+		})
+		if err != nil {
+			return fmt.Errorf("open buffer: %w", err)
+		}
+		e.uplink = e.outbox
+	}
+
+	if e.opts.UseRegistry {
+		nc, err := broker.Conn(e.hub())
+		if err == nil {
+			e.registry, err = registry.NewClient(nc, 500*time.Millisecond)
+		}
+		if err != nil {
+			return fmt.Errorf("create registry client: %w", err)
+		}
+	}
+
+	// Load settings, from the config bucket if available
+	base := e.defaultSettings()
+	if e.opts.UseConfig {
+		nc, err := broker.Conn(e.hub())
+		if err == nil {
+			err = config.Start(ctx, nc, "edge", e.opts.ID, base, e.applySettings)
+		}
+		if err != nil {
+			log.Printf("Central config unavailable, using flags: %v", err)
+		}
+	} else {
+		e.applySettings(base)
+	}
+
+	// Start aggregation timer
+	e.wg.Add(1)
+	go func() {
+		defer e.wg.Done()
+		ticker := e.clock.NewTicker(e.currentSettings().AggregateInterval.Std())
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C():
+				e.stats.publishAggregate(e.uplink, e.opts.ID, e.clock.Now())
+			case <-e.aggregateReset:
+				ticker.Reset(e.currentSettings().AggregateInterval.Std())
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	// Subscribe to sensor readings
+	var err error
+	if e.opts.JetStream {
+		err = e.consume(ctx)
+	} else {
+		err = e.subscribe()
+	}
+	if err == nil && e.opts.Bridge != nil {
+		err = e.startBridge()
+	}
+	if err != nil {
+		e.Stop()
+		return err
+	}
+	e.startModbus(ctx)
+	e.startOPCUA(ctx)
+
+	log.Printf("Edge Node %s started, listening to %v (signatures: %s)", e.opts.ID, e.opts.Subjects, e.opts.Signatures)
+	return nil
+}
+
+// hub returns the broker of the hub: the uplink, or the local broker.
+func (e *Edge) hub() broker.Broker {
+	if e.opts.Uplink != nil {
+		return e.opts.Uplink
+	}
+	return e.broker
+}
+
+// Stop unsubscribes, waits for the background loops to finish and closes
+// the buffer, leaving what wasn't sent for the next start.
+func (e *Edge) Stop() {
+	if e.cancel != nil {
+		e.cancel()
+	}
+	for _, sub := range e.subs {
+		sub.Unsubscribe()
+	}
+	e.subs = nil
+	if e.msgs != nil {
+		e.msgs.Stop()
+		e.msgs = nil
+	}
+	e.wg.Wait()
+	if e.registry != nil {
+		e.registry.Close()
+	}
+	if e.outbox != nil {
+		if err := e.outbox.Close(); err != nil {
+			log.Printf("Error closing buffer: %v", err)
+		}
+	}
+}
+
+// consume processes readings through a durable JetStream consumer, so that
+// readings published while the edge is down are processed when it returns.
+func (e *Edge) consume(ctx context.Context) error {
+	nc, err := broker.Conn(e.broker)
+	if err != nil {
+		return fmt.Errorf("JetStream consumer: %w", err)
+	}
+	js, err := jetstream.New(nc)
+	if err != nil {
+		return fmt.Errorf("failed to create JetStream context: %w", err)
+	}
+
+	// Create or widen the stream so it captures every sensor subject
+	_, err = js.CreateOrUpdateStream(ctx, jetstream.StreamConfig{
+		Name:     "SENSORS",
+		Subjects: []string{"sensors.>"},
+		Replicas: 1,
+	})
+	if err != nil {
+		log.Printf("Error creating stream (may already exist): %v", err)
+	}
+
+	consumer, err := js.CreateOrUpdateConsumer(ctx, "SENSORS", jetstream.ConsumerConfig{
+		Durable:   "EDGE-" + e.opts.ID,
+		AckPolicy: jetstream.AckExplicitPolicy,
+		// Readings buffered but not processed when the edge stops are
+		// dropped by the client; redeliver them soon after a restart.
+		AckWait:        5 * time.Second,
+		FilterSubjects: e.opts.Subjects,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create consumer: %w", err)
+	}
+
+	msgs, err := consumer.Messages()
+	if err != nil {
+		return fmt.Errorf("failed to get messages: %w", err)
+	}
+	e.msgs = msgs
+
+	// Process messages in a goroutine
+	e.wg.Add(1)
+	go func() {
+		defer e.wg.Done()
+		for {
+			msg, err := msgs.Next()
+			if errors.Is(err, jetstream.ErrMsgIteratorClosed) {
+				return
+			}
+			if err != nil {
+				log.Printf("Error getting next message: %v", err)
+				continue
+			}
+
+			e.processMessage(msg.Subject(), msg.Data())
+			if err := msg.Ack(); err != nil {
+				log.Printf("Error acking message: %v", err)
+			}
+		}
+	}()
+	return nil
+}
+
+// subscribe processes the readings as they arrive, without persistence.
+func (e *Edge) subscribe() error {
+	for _, filter := range e.opts.Subjects {
+		sub, err := e.broker.Subscribe(filter, e.processMessage)
+		if err != nil {
+			return fmt.Errorf("failed to subscribe to %s: %w", filter, err)
+		}
+		e.subs = append(e.subs, sub)
+	}
+	return nil
+}
+
+// Handler returns the edge HTTP API: /health, /settings, /metrics and
+// POST /ingest.
+func (e *Edge) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("OK"))
+	})
+
+	mux.HandleFunc("/settings", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(e.currentSettings())
+	})
+
+	mux.HandleFunc("/ingest", e.handleIngest)
+
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		e.stats.mu.RLock()
+		defer e.stats.mu.RUnlock()
+
+		// Display struct
+		type DisplayStats struct {
+			*Stats
+			Mean   float64       `json:"mean"`
+			Uptime string        `json:"uptime"`
+			Buffer *outbox.Stats `json:"buffer,omitempty"`
+		}
+
+		mean := 0.0
+		if e.stats.Count > 0 {
+			mean = e.stats.Sum / float64(e.stats.Count)
+		}
+
+		display := DisplayStats{
+			Stats:  e.stats,
+			Mean:   mean,
+			Uptime: e.clock.Since(e.stats.StartTime).String(),
+		}
+		if e.outbox != nil {
+			buffer := e.outbox.Stats()
+			display.Buffer = &buffer
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(display)
+	})
+	return mux
+}
+
+func (e *Edge) processMessage(subject string, data []byte) {
+	var reading SensorReading
+	if err := json.Unmarshal(data, &reading); err != nil {
+		log.Printf("Error unmarshaling reading: %v", err)
+		return
+	}
+
+	if e.opts.Signatures != policyOff {
+		if err := e.verifyReading(subject, reading); err != nil && !e.handleUntrusted(subject, data, reading, err) {
+			return
+		}
+	}
+
+	// The subject is authoritative for where the reading came from
+	if site, line, sensorID, ok := subjects.ParseReadings(subject); ok {
+		reading.Site = site
+		reading.Line = line
+		if reading.SensorID == "" {
+			reading.SensorID = sensorID
+		}
+	}
+
+	e.processReading(reading)
+}
+
+// processReading runs a trusted reading through calibration, statistics and
+// the detectors, and publishes the filtered reading and any alerts.
+func (e *Edge) processReading(reading SensorReading) {
+	cfg := e.currentSettings()
+	stats := e.stats
+
+	// Apply calibration and pick up labels from the registry
+	thresholds := cfg.Thresholds
+	var location, unit string
+	rawValue := reading.Value
+	if sensor, ok := e.lookupSensor(reading.SensorID); ok {
+		reading.Value = sensor.Calibration.Apply(reading.Value)
+		location, unit = sensor.Location, sensor.Unit
+		if sensor.Thresholds != nil {
+			thresholds = *sensor.Thresholds
+		}
+	}
+
+	// Update statistics
+	stats.mu.Lock()
+	stats.Count++
+	stats.Sum += reading.Value
+	if reading.Value < stats.Min {
+		stats.Min = reading.Value
+	}
+	if reading.Value > stats.Max {
+		stats.Max = reading.Value
+	}
+	stats.WindowValues = append(stats.WindowValues, reading.Value)
+	if len(stats.WindowValues) > stats.WindowSize {
+		stats.WindowValues = stats.WindowValues[1:]
+	}
+	stats.mu.Unlock()
+
+	// Create filtered reading
+	filtered := FilteredReading{
+		SensorID:  reading.SensorID,
+		Site:      reading.Site,
+		Line:      reading.Line,
+		Location:  location,
+		Unit:      unit,
+		Value:     reading.Value,
+		Timestamp: reading.Timestamp,
+		EdgeID:    e.opts.ID,
+	}
+	if rawValue != reading.Value {
+		filtered.RawValue = rawValue
+	}
+
+	filteredData, err := json.Marshal(filtered)
+	if err != nil {
+		log.Printf("Error marshaling filtered reading: %v", err)
+		return
+	}
+
+	// Publish filtered reading
+	if err := e.uplink.Publish(subjects.Filtered(e.opts.ID), filteredData); err != nil {
+		log.Printf("Error publishing filtered reading: %v", err)
+	}
+
+	alert := Alert{
+		SensorID:  reading.SensorID,
+		Site:      reading.Site,
+		Line:      reading.Line,
+		Location:  location,
+		Unit:      unit,
+		Value:     reading.Value,
+		Timestamp: reading.Timestamp,
+		EdgeID:    e.opts.ID,
+	}
+
+	// Check for threshold violations. Unlike the adaptive detectors these
+	// aren't deduplicated: every reading out of range raises an alert
+	if cfg.Detectors.Has(anomaly.Bands) {
+		if alertType, alertMsg := classify(reading.Value, thresholds); alertType != "" {
+			a := alert
+			a.Type, a.Message, a.Detector = alertType, alertMsg, anomaly.Bands
+			a.Baseline = (thresholds.WarningMin + thresholds.WarningMax) / 2
+			if halfWidth := (thresholds.WarningMax - thresholds.WarningMin) / 2; halfWidth > 0 {
+				a.Score = math.Abs(reading.Value-a.Baseline) / halfWidth
+			}
+			e.publishAlert(a)
+		}
+	}
+
+	// Check the adaptive detectors
+	for _, r := range e.detect(reading.SensorID, time.UnixMilli(reading.Timestamp), reading.Value, cfg.Detectors) {
+		a := alert
+		a.Type = "anomaly"
+		a.Message = fmt.Sprintf("Anomaly (%s) detected by %s: score %.2f, baseline %.2f", r.Kind, r.Detector, r.Score, r.Baseline)
+		a.Detector, a.Score, a.Baseline = r.Detector, r.Score, r.Baseline
+		e.publishAlert(a)
+	}
+}
+
+func (e *Edge) publishAlert(alert Alert) {
+	alertData, err := json.Marshal(alert)
+	if err != nil {
+		log.Printf("Error marshaling alert: %v", err)
+		return
+	}
+
+	if err := e.uplink.Publish(subjects.Alerts(e.opts.ID), alertData); err != nil {
+		log.Printf("Error publishing alert: %v", err)
+	}
+
+	log.Printf("Alert published [%s]: sensor_id=%s, value=%.2f, %s", alert.Type, alert.SensorID, alert.Value, alert.Message)
+}
+
+// lookupSensor returns the registry entry of a sensor, if the registry is in use.
+func (e *Edge) lookupSensor(sensorID string) (*registry.Sensor, bool) {
+	if e.registry == nil {
+		return nil, false
+	}
+	return e.registry.Lookup(sensorID)
+}
+
+// classify checks a value against the alert bands.
+func classify(value float64, t registry.Thresholds) (alertType, alertMsg string) {
+	if value < t.CriticalMin || value > t.CriticalMax {
+		return "critical", "Critical value detected (Spike)"
+	}
+	if value < t.WarningMin || value > t.WarningMax {
+		return "warning", "Process drift detected (Warning)"
+	}
+	return "", ""
+}
+
+func (s *Stats) publishAggregate(b broker.Broker, edgeID string, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.Count == 0 {
+		return
+	}
+
+	mean := s.Sum / float64(s.Count)
+
+	aggregate := map[string]interface{}{
+		"edge_id":   edgeID,
+		"count":     s.Count,
+		"mean":      mean,
+		"min":       s.Min,
+		"max":       s.Max,
+		"timestamp": now.Unix(),
+	}
+
+	data, err := json.Marshal(aggregate)
+	if err != nil {
+		log.Printf("Error marshaling aggregate: %v", err)
+		return
+	}
+
+	// Publish aggregates on a dedicated subject to avoid mixing with per-reading stream
+	if err := b.Publish(subjects.Aggregate(edgeID), data); err != nil {
+		log.Printf("Error publishing aggregate: %v", err)
+	}
+
+	log.Printf("Aggregate published: edge_id=%s, count=%d, mean=%.2f, min=%.2f, max=%.2f",
+		edgeID, s.Count, mean, s.Min, s.Max)
+
+	// Reset stats
+	s.Count = 0
+	s.Sum = 0
+	s.Min = math.Inf(1)
+	s.Max = math.Inf(-1)
+	s.WindowValues = s.WindowValues[:0]
+}
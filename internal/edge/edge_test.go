@@ -0,0 +1,489 @@
+package edge
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nkeys"
+
+	"sistemas_distribuidos_gb/internal/anomaly"
+	"sistemas_distribuidos_gb/internal/broker"
+	"sistemas_distribuidos_gb/internal/clock"
+	"sistemas_distribuidos_gb/internal/embedded"
+	"sistemas_distribuidos_gb/internal/registry"
+	"sistemas_distribuidos_gb/internal/signing"
+	"sistemas_distribuidos_gb/internal/subjects"
+)
+
+// startTestEdge runs an edge on an in-process broker and a fake clock,
+// without the registry or central config.
+func startTestEdge(t *testing.T) (*Edge, *broker.Memory, *clock.Fake) {
+	t.Helper()
+	return startTestEdgeWith(t, nil)
+}
+
+// startTestEdgeWith is startTestEdge with options changed by configure.
+func startTestEdgeWith(t *testing.T, configure func(*Options)) (*Edge, *broker.Memory, *clock.Fake) {
+	t.Helper()
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	b := broker.NewMemory()
+	clk := clock.NewFake(time.Date(2024, 1, 1, 8, 0, 0, 0, time.UTC))
+	opts := DefaultOptions()
+	opts.ID = "test"
+	opts.UseRegistry, opts.UseConfig = false, false
+	opts.Signatures = policyOff
+	opts.Detectors.Enabled = []string{anomaly.Bands}
+	opts.Clock = clk
+	if configure != nil {
+		configure(&opts)
+	}
+
+	e, err := New(b, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(e.Stop)
+	return e, b, clk
+}
+
+// capture collects the messages published on subject.
+func capture(t *testing.T, b broker.Broker, subject string) chan []byte {
+	t.Helper()
+	c := make(chan []byte, 16)
+	sub, err := b.Subscribe(subject, func(_ string, data []byte) { c <- data })
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sub.Unsubscribe() })
+	return c
+}
+
+func publishReading(t *testing.T, b broker.Broker, r SensorReading) {
+	t.Helper()
+	data, _ := json.Marshal(r)
+	if err := b.Publish(subjects.Readings(r.Site, r.Line, r.SensorID), data); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEdgeForwardsAndAlerts(t *testing.T) {
+	_, b, clk := startTestEdge(t)
+	filtered := capture(t, b, subjects.Filtered("test"))
+	alerts := capture(t, b, subjects.Alerts("test"))
+
+	now := clk.Now().UnixMilli()
+	publishReading(t, b, SensorReading{SensorID: "s1", Site: "plant", Line: "l1", Value: 50, Timestamp: now})
+	publishReading(t, b, SensorReading{SensorID: "s1", Site: "plant", Line: "l1", Value: 150, Timestamp: now + 1})
+
+	// The memory broker delivers synchronously: everything is out already
+	if len(filtered) != 2 {
+		t.Fatalf("got %d filtered readings, want 2", len(filtered))
+	}
+	var f FilteredReading
+	json.Unmarshal(<-filtered, &f)
+	if f.SensorID != "s1" || f.Site != "plant" || f.Line != "l1" || f.EdgeID != "test" || f.Timestamp != now {
+		t.Errorf("unexpected filtered reading %+v", f)
+	}
+
+	if len(alerts) != 1 {
+		t.Fatalf("got %d alerts, want 1", len(alerts))
+	}
+	var a Alert
+	json.Unmarshal(<-alerts, &a)
+	if a.Type != "critical" || a.Value != 150 || a.Detector != anomaly.Bands {
+		t.Errorf("unexpected alert %+v", a)
+	}
+}
+
+// TestEdgeBandsAlertEveryReading checks that the fixed ranges aren't
+// deduplicated: every reading out of them raises an alert.
+func TestEdgeBandsAlertEveryReading(t *testing.T) {
+	_, b, clk := startTestEdge(t)
+	alerts := capture(t, b, subjects.Alerts("test"))
+
+	now := clk.Now().UnixMilli()
+	var want []string
+	for i, tc := range []struct {
+		sensor string
+		value  float64
+		alert  string
+	}{
+		{"s1", 70, "warning"},
+		{"s1", 75, "warning"},
+		{"s2", 70, "warning"},
+		{"s1", 150, "critical"},
+		{"s1", 80, "warning"},
+		{"s1", 50, ""},
+		{"s1", 150, "critical"},
+	} {
+		publishReading(t, b, SensorReading{SensorID: tc.sensor, Site: "plant", Line: "l1", Value: tc.value, Timestamp: now + int64(i)})
+		if tc.alert != "" {
+			want = append(want, tc.sensor+" "+tc.alert)
+		}
+	}
+
+	var got []string
+	for len(alerts) > 0 {
+		var a Alert
+		json.Unmarshal(<-alerts, &a)
+		if a.Detector == anomaly.Bands {
+			got = append(got, a.SensorID+" "+a.Type)
+		}
+	}
+	if strings.Join(got, ", ") != strings.Join(want, ", ") {
+		t.Errorf("alerts %v, want %v", got, want)
+	}
+}
+
+// TestRepeatedAlerts checks that an adaptive detector alerts once per
+// sensor until the value is back to normal.
+func TestRepeatedAlerts(t *testing.T) {
+	e := &Edge{raised: map[alertKey]string{}}
+	for i, tc := range []struct {
+		sensor, alert string
+		repeated      bool
+	}{
+		{"s1", "drift", false},
+		{"s1", "drift", true},
+		{"s2", "drift", false}, // other sensors alert on their own
+		{"s1", "spike", true},
+		{"s1", "", false},
+		{"s1", "spike", false},
+	} {
+		if got := e.repeated(tc.sensor, anomaly.CUSUM, tc.alert); got != tc.repeated {
+			t.Errorf("step %d: repeated(%s, %q) = %v, want %v", i, tc.sensor, tc.alert, got, tc.repeated)
+		}
+	}
+}
+
+func TestEdgeAggregatesOnClockTicks(t *testing.T) {
+	e, b, clk := startTestEdge(t)
+	aggregates := capture(t, b, subjects.Aggregate("test"))
+
+	for _, v := range []float64{48, 50, 52} {
+		publishReading(t, b, SensorReading{SensorID: "s1", Value: v, Timestamp: clk.Now().UnixMilli()})
+	}
+
+	// The aggregation loop may not have created its ticker yet; keep
+	// advancing until the aggregate comes out
+	var agg struct {
+		EdgeID    string  `json:"edge_id"`
+		Count     int     `json:"count"`
+		Mean      float64 `json:"mean"`
+		Timestamp int64   `json:"timestamp"`
+	}
+	deadline := time.After(5 * time.Second)
+	for agg.Count == 0 {
+		clk.Advance(e.opts.AggregateInterval)
+		select {
+		case data := <-aggregates:
+			json.Unmarshal(data, &agg)
+		case <-time.After(10 * time.Millisecond):
+		case <-deadline:
+			t.Fatal("no aggregate published")
+		}
+	}
+	if agg.EdgeID != "test" || agg.Count != 3 || agg.Mean != 50 {
+		t.Errorf("unexpected aggregate %+v", agg)
+	}
+	if ts := time.Unix(agg.Timestamp, 0); ts.Before(e.stats.StartTime) || ts.After(clk.Now()) {
+		t.Errorf("aggregate timestamp %d doesn't come from the fake clock", agg.Timestamp)
+	}
+}
+
+// TestEdgeBuffersUplinkOutage sends the output of an edge to a separate NATS
+// server, takes the server down while readings keep coming, and checks that
+// they all arrive, in order, once it's back.
+func TestEdgeBuffersUplinkOutage(t *testing.T) {
+	ns, err := embedded.Start(embedded.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := ns.Addr().(*net.TCPAddr).Port
+	connect := func() *nats.Conn {
+		nc, err := nats.Connect(ns.ClientURL(), nats.MaxReconnects(-1), nats.ReconnectWait(20*time.Millisecond))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(nc.Close)
+		return nc
+	}
+	hub, uplink := connect(), connect()
+	filtered := make(chan FilteredReading, 32)
+	hub.Subscribe(subjects.Filtered("test"), func(msg *nats.Msg) {
+		var f FilteredReading
+		json.Unmarshal(msg.Data, &f)
+		filtered <- f
+	})
+	hub.Flush()
+
+	e, local, clk := startTestEdgeWith(t, func(o *Options) {
+		o.Uplink = broker.NewNATS(uplink)
+		o.BufferDir = t.TempDir()
+	})
+	start := clk.Now().UnixMilli()
+	send := func(from, to int) {
+		for i := from; i < to; i++ {
+			publishReading(t, local, SensorReading{SensorID: "s1", Value: 50, Timestamp: start + int64(i)})
+		}
+	}
+	// The buffer retries only as the fake clock moves
+	retrying := true
+	waitFor := func(what string, cond func() bool) {
+		t.Helper()
+		for deadline := time.Now().Add(5 * time.Second); !cond(); time.Sleep(10 * time.Millisecond) {
+			if time.Now().After(deadline) {
+				t.Fatalf("timed out waiting for %s", what)
+			}
+			if retrying {
+				clk.Advance(30 * time.Second)
+			}
+		}
+	}
+
+	send(0, 5)
+	waitFor("the first readings to be sent", func() bool { return len(filtered) == 5 })
+	ns.Shutdown()
+	waitFor("the uplink to drop", func() bool { return !uplink.IsConnected() })
+	send(5, 20)
+	waitFor("the buffer to notice the outage", func() bool { return !e.outbox.Stats().Connected })
+	// The aggregates are buffered too
+	if pending := e.outbox.Stats().Pending; pending < 15 {
+		t.Errorf("%d messages buffered, want the 15 readings at least", pending)
+	}
+
+	ns, err = embedded.Start(embedded.Options{Port: port})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(ns.Shutdown)
+	retrying = false
+	waitFor("the clients to reconnect", func() bool {
+		return hub.IsConnected() && uplink.IsConnected() && hub.Flush() == nil
+	})
+	retrying = true
+	waitFor("the buffered readings", func() bool { return len(filtered) == 20 })
+	for i := 0; i < 20; i++ {
+		if f := <-filtered; f.Timestamp != start+int64(i) {
+			t.Fatalf("reading %d has timestamp %d, want %d", i, f.Timestamp, start+int64(i))
+		}
+	}
+	// What the client had queued while reconnecting may arrive before the
+	// buffer gets its confirmation, so it drains a little later
+	waitFor("the buffer to drain", func() bool {
+		s := e.outbox.Stats()
+		return s.Pending == 0 && s.Sent >= 20 && s.Connected
+	})
+
+	// Stopping again, as the cleanup does, is harmless
+	e.Stop()
+	e.Stop()
+}
+
+// TestEdgeLeafNode runs the edge and its sensors on a leaf node server of the
+// hub, and checks that only the edge output reaches the hub.
+func TestEdgeLeafNode(t *testing.T) {
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	hubServer, err := embedded.Start(embedded.Options{LeafPort: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(hubServer.Shutdown)
+	leafServer, err := embedded.Start(embedded.Options{JetStream: true, StoreDir: t.TempDir(), Leaf: &embedded.Leaf{Name: "leaf", URL: embedded.LeafURL(hubServer)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(leafServer.Shutdown)
+	connect := func(url string) *nats.Conn {
+		nc, err := nats.Connect(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(nc.Close)
+		return nc
+	}
+	hub, local := connect(hubServer.ClientURL()), connect(leafServer.ClientURL())
+
+	filtered, raw := make(chan FilteredReading, 64), make(chan string, 64)
+	hub.Subscribe(subjects.AllFiltered, func(msg *nats.Msg) {
+		var f FilteredReading
+		json.Unmarshal(msg.Data, &f)
+		filtered <- f
+	})
+	hub.Subscribe("sensors.>", func(msg *nats.Msg) { raw <- msg.Subject })
+	hub.Subscribe(subjects.RegistryLookup, func(msg *nats.Msg) { msg.Respond([]byte("from the hub")) })
+	hub.Flush()
+
+	opts := DefaultOptions()
+	opts.ID = "leaf"
+	opts.UseRegistry, opts.UseConfig = false, false
+	opts.Signatures = policyOff
+	opts.Detectors.Enabled = []string{anomaly.Bands}
+	opts.AggregateInterval = time.Hour
+	e, err := New(broker.NewNATS(local), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(e.Stop)
+
+	// Readings are published until the hub's interest crosses the leaf node
+	sensor := broker.NewNATS(connect(leafServer.ClientURL()))
+	var sent int64
+	deadline := time.Now().Add(5 * time.Second)
+	for got := false; !got; {
+		if time.Now().After(deadline) {
+			t.Fatal("no filtered reading reached the hub")
+		}
+		sent++
+		publishReading(t, sensor, SensorReading{SensorID: "s1", Value: 50, Timestamp: sent})
+		select {
+		case f := <-filtered:
+			got = f.SensorID == "s1"
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+
+	// The registry is still reached through the leaf node
+	if msg, err := local.Request(subjects.RegistryLookup, nil, 2*time.Second); err != nil {
+		t.Errorf("registry request through the leaf node: %v", err)
+	} else if string(msg.Data) != "from the hub" {
+		t.Errorf("registry reply %q", msg.Data)
+	}
+	// while the readings stay local
+	if err := hub.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case subject := <-raw:
+		t.Errorf("the hub received %s", subject)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+// TestEdgeVerifiesReadings checks the signature policy against a registry:
+// bad signatures, unknown sensors, replays and stale readings are quarantined,
+// and only trusted readings move a sensor's last timestamp forward.
+func TestEdgeVerifiesReadings(t *testing.T) {
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	ns, err := embedded.Start(embedded.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(ns.Shutdown)
+	nc, err := nats.Connect(ns.ClientURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(nc.Close)
+
+	store, err := registry.NewFileStore(filepath.Join(t.TempDir(), "sensors.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc := registry.NewService(nc, store)
+	if err := svc.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(svc.Stop)
+	key, _ := nkeys.CreateUser()
+	other, _ := nkeys.CreateUser()
+	pub, _ := key.PublicKey()
+	if _, err := svc.Announce(context.Background(), registry.Sensor{ID: "s1", Site: "a", Line: "l", PublicKey: pub}); err != nil {
+		t.Fatal(err)
+	}
+
+	b := broker.NewNATS(nc)
+	clk := clock.NewFake(time.Date(2024, 1, 1, 8, 0, 0, 0, time.UTC))
+	opts := DefaultOptions()
+	opts.ID = "test"
+	opts.UseConfig = false
+	opts.Detectors.Enabled = []string{anomaly.Bands}
+	opts.Clock = clk
+	e, err := New(b, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(e.Stop)
+
+	filtered := capture(t, b, subjects.Filtered("test"))
+	quarantine := capture(t, b, subjects.Quarantine("test"))
+	signed := func(kp nkeys.KeyPair, sensorID string, value float64, at time.Time) SensorReading {
+		r := SensorReading{SensorID: sensorID, Site: "a", Line: "l", Value: value, Timestamp: at.UnixMilli()}
+		r.Signature, _ = signing.Sign(kp, signing.Payload(r.SensorID, r.Site, r.Line, r.Value, r.Timestamp))
+		return r
+	}
+	expect := func(what string, r SensorReading, reason string) {
+		t.Helper()
+		publishReading(t, b, r)
+		select {
+		case data := <-filtered:
+			if reason != "" {
+				t.Errorf("%s: processed, want it quarantined for %q", what, reason)
+			}
+			var f FilteredReading
+			json.Unmarshal(data, &f)
+			if f.Timestamp != r.Timestamp {
+				t.Errorf("%s: filtered reading %+v", what, f)
+			}
+		case data := <-quarantine:
+			var q QuarantinedReading
+			json.Unmarshal(data, &q)
+			if reason == "" {
+				t.Errorf("%s: quarantined (%s)", what, q.Reason)
+			} else if !strings.Contains(q.Reason, reason) {
+				t.Errorf("%s: quarantined for %q, want %q", what, q.Reason, reason)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: neither processed nor quarantined", what)
+		}
+	}
+
+	now := clk.Now()
+	first := signed(key, "s1", 20, now)
+	expect("a signed reading", first, "")
+	expect("the same reading again", first, "replayed")
+	late := signed(key, "s1", 20, now.Add(-time.Second))
+	expect("an earlier reading, arriving late", late, "")
+	expect("the earlier reading again", late, "replayed")
+	expect("a stale reading", signed(key, "s1", 20, now.Add(-10*time.Minute)), "off the edge clock")
+	expect("a reading from the future", signed(key, "s1", 20, now.Add(10*time.Minute)), "off the edge clock")
+	expect("another key", signed(other, "s1", 20, now.Add(time.Second)), "invalid signature")
+	expect("an unsigned reading", SensorReading{SensorID: "s1", Site: "a", Line: "l", Value: 20, Timestamp: now.Add(time.Second).UnixMilli()}, "missing signature")
+	expect("an unknown sensor", signed(key, "s2", 20, now.Add(time.Second)), "not registered")
+	tampered := signed(key, "s1", 20, now.Add(time.Second))
+	tampered.Value = 30
+	expect("a changed value", tampered, "invalid signature")
+
+	// None of the failures was taken as seen
+	expect("the next reading", signed(key, "s1", 21, now.Add(time.Second)), "")
+
+	e.stats.mu.RLock()
+	defer e.stats.mu.RUnlock()
+	if e.stats.SignatureFailures != 8 || e.stats.Quarantined != 8 {
+		t.Errorf("%d signature failures and %d quarantined, want 8", e.stats.SignatureFailures, e.stats.Quarantined)
+	}
+}
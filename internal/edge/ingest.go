@@ -0,0 +1,228 @@
+package edge
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"sistemas_distribuidos_gb/internal/subjects"
+)
+
+// maxIngestBody limits the size of a POST /ingest request.
+const maxIngestBody = 10 << 20
+
+// maxIngestErrors limits the errors reported back by POST /ingest.
+const maxIngestErrors = 20
+
+// IngestResult is the response to POST /ingest.
+type IngestResult struct {
+	Accepted int      `json:"accepted"`
+	Rejected int      `json:"rejected"`
+	Errors   []string `json:"errors,omitempty"`
+}
+
+func (r *IngestResult) reject(err error) {
+	r.Rejected++
+	if len(r.Errors) < maxIngestErrors {
+		r.Errors = append(r.Errors, err.Error())
+	}
+}
+
+// ingestedReading is a pushed reading; Value is a pointer to tell a missing
+// value from a zero.
+type ingestedReading struct {
+	SensorID  string   `json:"sensor_id"`
+	Site      string   `json:"site,omitempty"`
+	Line      string   `json:"line,omitempty"`
+	Value     *float64 `json:"value"`
+	Timestamp int64    `json:"timestamp"`
+	Signature string   `json:"signature,omitempty"`
+}
+
+// handleIngest accepts readings pushed over HTTP by clients that can't
+// publish to the broker: a SensorReading JSON object, an array of them, or
+// CSV with a header row naming the sensor_id and value columns (and
+// optionally timestamp, site, line and signature). Every reading goes through
+// processMessage, signature checks included.
+func (e *Edge) handleIngest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body := http.MaxBytesReader(w, r.Body, maxIngestBody)
+	var (
+		readings []ingestedReading
+		result   IngestResult
+		err      error
+	)
+	switch ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); ct {
+	case "application/json", "":
+		readings, err = parseJSONReadings(body)
+	case "text/csv":
+		readings, err = parseCSVReadings(body, &result)
+	default:
+		http.Error(w, "unsupported content type "+ct+" (want application/json or text/csv)", http.StatusUnsupportedMediaType)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	now := e.clock.Now().UnixMilli()
+	for i, in := range readings {
+		reading, err := in.normalize(now)
+		if err != nil {
+			result.reject(fmt.Errorf("reading %d: %w", i+1, err))
+			continue
+		}
+		data, err := json.Marshal(reading)
+		if err != nil {
+			result.reject(fmt.Errorf("reading %d: %w", i+1, err))
+			continue
+		}
+		e.processMessage(subjects.Readings(reading.Site, reading.Line, reading.SensorID), data)
+		result.Accepted++
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if result.Accepted == 0 && result.Rejected > 0 {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+	json.NewEncoder(w).Encode(result)
+}
+
+// normalize checks a pushed reading and fills in the defaults: the default
+// site and line, and now for a missing timestamp.
+func (in ingestedReading) normalize(now int64) (SensorReading, error) {
+	if strings.TrimSpace(in.SensorID) == "" {
+		return SensorReading{}, errors.New("missing sensor_id")
+	}
+	if in.Value == nil {
+		return SensorReading{}, errors.New("missing value")
+	}
+	reading := SensorReading{
+		SensorID:  subjects.Token(in.SensorID, ""),
+		Site:      subjects.Token(in.Site, subjects.DefaultSite),
+		Line:      subjects.Token(in.Line, subjects.DefaultLine),
+		Value:     *in.Value,
+		Timestamp: in.Timestamp,
+		Signature: in.Signature,
+	}
+	if reading.Timestamp == 0 {
+		reading.Timestamp = now
+	}
+	return reading, nil
+}
+
+// parseJSONReadings reads a reading or an array of readings.
+func parseJSONReadings(r io.Reader) ([]ingestedReading, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	data = bytes.TrimSpace(data)
+	var readings []ingestedReading
+	if len(data) > 0 && data[0] == '[' {
+		err = json.Unmarshal(data, &readings)
+	} else {
+		readings = make([]ingestedReading, 1)
+		err = json.Unmarshal(data, &readings[0])
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid JSON: %w", err)
+	}
+	return readings, nil
+}
+
+// parseCSVReadings reads CSV readings. Rows that can't be parsed are
+// rejected in result; only an unreadable header fails the whole request.
+func parseCSVReadings(r io.Reader, result *IngestResult) ([]ingestedReading, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("invalid CSV header: %w", err)
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, required := range []string{"sensor_id", "value"} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("CSV header has no %s column", required)
+		}
+	}
+
+	var readings []ingestedReading
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return readings, nil
+		}
+		if err != nil {
+			var parseErr *csv.ParseError
+			if !errors.As(err, &parseErr) {
+				return nil, err
+			}
+			result.reject(parseErr)
+			continue
+		}
+		line, _ := cr.FieldPos(0)
+		field := func(name string) string {
+			if i, ok := columns[name]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+
+		in := ingestedReading{
+			SensorID:  field("sensor_id"),
+			Site:      field("site"),
+			Line:      field("line"),
+			Signature: field("signature"),
+		}
+		if s := field("value"); s != "" {
+			v, err := strconv.ParseFloat(s, 64)
+			if err != nil {
+				result.reject(fmt.Errorf("line %d: invalid value %q", line, s))
+				continue
+			}
+			in.Value = &v
+		}
+		if s := field("timestamp"); s != "" {
+			if in.Timestamp, err = parseTimestamp(s); err != nil {
+				result.reject(fmt.Errorf("line %d: %w", line, err))
+				continue
+			}
+		}
+		if _, err := in.normalize(0); err != nil {
+			result.reject(fmt.Errorf("line %d: %w", line, err))
+			continue
+		}
+		readings = append(readings, in)
+	}
+}
+
+// parseTimestamp reads Unix milliseconds or an RFC 3339 time.
+func parseTimestamp(s string) (int64, error) {
+	if ms, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return ms, nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid timestamp %q (want Unix milliseconds or RFC 3339)", s)
+	}
+	return t.UnixMilli(), nil
+}
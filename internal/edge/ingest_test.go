@@ -0,0 +1,90 @@
+package edge
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"sistemas_distribuidos_gb/internal/subjects"
+)
+
+func ingest(t *testing.T, e *Edge, contentType, body string) (int, IngestResult) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/ingest", strings.NewReader(body))
+	req.Header.Set("Content-Type", contentType)
+	rec := httptest.NewRecorder()
+	e.Handler().ServeHTTP(rec, req)
+	var result IngestResult
+	json.Unmarshal(rec.Body.Bytes(), &result)
+	return rec.Code, result
+}
+
+func TestIngestJSON(t *testing.T) {
+	e, b, clk := startTestEdge(t)
+	filtered := capture(t, b, subjects.Filtered("test"))
+	alerts := capture(t, b, subjects.Alerts("test"))
+
+	code, result := ingest(t, e, "application/json", `{"sensor_id": "gw-01", "site": "plant", "value": 0}`)
+	if code != http.StatusOK || result.Accepted != 1 {
+		t.Fatalf("single reading: %d %+v", code, result)
+	}
+	var f FilteredReading
+	json.Unmarshal(<-filtered, &f)
+	if f.SensorID != "gw-01" || f.Site != "plant" || f.Line != subjects.DefaultLine || f.Timestamp != clk.Now().UnixMilli() {
+		t.Errorf("unexpected filtered reading %+v", f)
+	}
+	<-alerts // 0 is below the warning band
+
+	code, result = ingest(t, e, "application/json; charset=utf-8", `[
+		{"sensor_id": "gw-01", "value": 50, "timestamp": 1000},
+		{"sensor_id": "gw-02", "value": 150},
+		{"value": 50},
+		{"sensor_id": "gw-03"}
+	]`)
+	if code != http.StatusOK || result.Accepted != 2 || result.Rejected != 2 || len(result.Errors) != 2 {
+		t.Fatalf("batch: %d %+v", code, result)
+	}
+	if len(filtered) != 2 || len(alerts) != 1 {
+		t.Errorf("got %d filtered readings and %d alerts, want 2 and 1", len(filtered), len(alerts))
+	}
+
+	if code, _ := ingest(t, e, "application/json", `{"sensor_id": `); code != http.StatusBadRequest {
+		t.Errorf("invalid JSON: status %d", code)
+	}
+	if code, _ := ingest(t, e, "application/xml", `<reading/>`); code != http.StatusUnsupportedMediaType {
+		t.Errorf("XML: status %d", code)
+	}
+	rec := httptest.NewRecorder()
+	e.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/ingest", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET: status %d", rec.Code)
+	}
+}
+
+func TestIngestCSV(t *testing.T) {
+	e, b, _ := startTestEdge(t)
+	filtered := capture(t, b, subjects.Filtered("test"))
+
+	code, result := ingest(t, e, "text/csv", "timestamp,sensor_id,value,line\n"+
+		"2024-01-01T08:00:00Z,gw-01,51.5,l2\n"+
+		"1704096000500,gw-02,49\n"+
+		"yesterday,gw-03,50\n"+
+		"1704096000500,gw-04,hot\n")
+	if code != http.StatusOK || result.Accepted != 2 || result.Rejected != 2 {
+		t.Fatalf("%d %+v", code, result)
+	}
+	if !strings.HasPrefix(result.Errors[0], "line 4:") {
+		t.Errorf("errors %q don't name the CSV line", result.Errors)
+	}
+	var f FilteredReading
+	json.Unmarshal(<-filtered, &f)
+	if f.SensorID != "gw-01" || f.Line != "l2" || f.Value != 51.5 || f.Timestamp != 1704096000000 {
+		t.Errorf("unexpected filtered reading %+v", f)
+	}
+
+	if code, _ := ingest(t, e, "text/csv", "sensor,reading\ngw-01,50\n"); code != http.StatusBadRequest {
+		t.Errorf("CSV without the required columns: status %d", code)
+	}
+}
@@ -0,0 +1,75 @@
+package edge
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"sistemas_distribuidos_gb/internal/modbus"
+	"sistemas_distribuidos_gb/internal/subjects"
+)
+
+// startModbus polls every Modbus device at its interval, until ctx is done.
+// The values go through the same pipeline as the readings received from the
+// broker.
+func (e *Edge) startModbus(ctx context.Context) {
+	for _, d := range e.opts.Modbus {
+		d := d
+		client := modbus.NewClient(d.Address, d.Timeout.Std())
+		ticker := e.clock.NewTicker(d.Interval.Std())
+		log.Printf("Polling Modbus device %s (%s, unit %d) every %v: %d points", d.Name, d.Address, d.Unit, d.Interval.Std(), len(d.Points))
+
+		e.wg.Add(1)
+		go func() {
+			defer e.wg.Done()
+			defer ticker.Stop()
+			defer client.Close()
+			var lastErr error
+			for {
+				select {
+				case <-ticker.C():
+					// Log when the device starts and stops failing, not on every poll
+					err := e.pollModbus(client, d)
+					if err != nil && lastErr == nil {
+						log.Printf("Error polling Modbus device %s: %v", d.Name, err)
+					} else if err == nil && lastErr != nil {
+						log.Printf("Modbus device %s is responding again", d.Name)
+					}
+					lastErr = err
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+}
+
+// pollModbus reads every point of a device and processes the values. It
+// returns the first error; points answered with an exception are skipped,
+// any other error ends the poll.
+func (e *Edge) pollModbus(client *modbus.Client, d modbus.Device) error {
+	var firstErr error
+	site := subjects.Token(d.Site, subjects.DefaultSite)
+	line := subjects.Token(d.Line, subjects.DefaultLine)
+	for _, p := range d.Points {
+		regs, err := client.ReadRegisters(d.Unit, p.Table, p.Address, p.Count())
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			var exc *modbus.Exception
+			if errors.As(err, &exc) {
+				continue
+			}
+			return firstErr
+		}
+		e.processReading(SensorReading{
+			SensorID:  subjects.Token(p.SensorID, "unknown"),
+			Site:      site,
+			Line:      line,
+			Value:     p.Decode(regs),
+			Timestamp: e.clock.Now().UnixMilli(),
+		})
+	}
+	return firstErr
+}
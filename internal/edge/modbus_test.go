@@ -0,0 +1,64 @@
+package edge
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"sistemas_distribuidos_gb/internal/modbus"
+	"sistemas_distribuidos_gb/internal/subjects"
+)
+
+func TestModbus(t *testing.T) {
+	plc, err := modbus.Listen("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer plc.Close()
+	temp := modbus.Point{SensorID: "temp-01", Address: 10, Type: modbus.Int16, Scale: 0.1}
+	press := modbus.Point{SensorID: "press-01", Table: modbus.InputRegisters, Address: 0, Type: modbus.Float32}
+	plc.SetRegisters(1, modbus.HoldingRegisters, 10, temp.Encode(51.5))
+	plc.SetRegisters(1, modbus.InputRegisters, 0, press.Encode(150))
+
+	_, b, clk := startTestEdgeWith(t, func(opts *Options) {
+		opts.Modbus = []modbus.Device{{
+			Address: plc.Addr(),
+			Unit:    1,
+			Site:    "plant",
+			Line:    "l1",
+			Points:  []modbus.Point{temp, press},
+		}}
+	})
+	filtered := capture(t, b, subjects.Filtered("test"))
+	alerts := capture(t, b, subjects.Alerts("test"))
+
+	clk.Advance(time.Second)
+	got := map[string]FilteredReading{}
+	for len(got) < 2 {
+		select {
+		case data := <-filtered:
+			var f FilteredReading
+			json.Unmarshal(data, &f)
+			got[f.SensorID] = f
+		case <-time.After(5 * time.Second):
+			t.Fatalf("got %d polled readings, want 2", len(got))
+		}
+	}
+	if f := got["temp-01"]; f.Value != 51.5 || f.Site != "plant" || f.Line != "l1" || f.Timestamp != clk.Now().UnixMilli() {
+		t.Errorf("unexpected temp-01 reading %+v", f)
+	}
+	if f := got["press-01"]; f.Value != 150 {
+		t.Errorf("unexpected press-01 reading %+v", f)
+	}
+
+	select {
+	case data := <-alerts:
+		var a Alert
+		json.Unmarshal(data, &a)
+		if a.SensorID != "press-01" || a.Type != "critical" {
+			t.Errorf("unexpected alert %+v", a)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no alert for the critical Modbus value")
+	}
+}
@@ -0,0 +1,33 @@
+package edge
+
+import (
+	"context"
+	"time"
+
+	"sistemas_distribuidos_gb/internal/opcua"
+	"sistemas_distribuidos_gb/internal/subjects"
+)
+
+// startOPCUA subscribes to the nodes of every OPC UA server until ctx is
+// done. Value changes go through the same pipeline as the readings received
+// from the broker.
+func (e *Edge) startOPCUA(ctx context.Context) {
+	for _, srv := range e.opts.OPCUA {
+		srv := srv
+		site := subjects.Token(srv.Site, subjects.DefaultSite)
+		line := subjects.Token(srv.Line, subjects.DefaultLine)
+		e.wg.Add(1)
+		go func() {
+			defer e.wg.Done()
+			opcua.Run(ctx, e.clock, srv, func(n opcua.Node, value float64, at time.Time) {
+				e.processReading(SensorReading{
+					SensorID:  subjects.Token(n.SensorID, "unknown"),
+					Site:      site,
+					Line:      line,
+					Value:     value,
+					Timestamp: at.UnixMilli(),
+				})
+			})
+		}()
+	}
+}
@@ -0,0 +1,50 @@
+package edge
+
+import (
+	"log"
+	"reflect"
+
+	"sistemas_distribuidos_gb/internal/config"
+	"sistemas_distribuidos_gb/internal/config/settings"
+)
+
+// defaultSettings builds the settings used before (or without) central config.
+func (e *Edge) defaultSettings() settings.Edge {
+	return e.opts.Settings()
+}
+
+// Settings returns the settings an edge started with o runs on until the
+// central config says otherwise.
+func (o Options) Settings() settings.Edge {
+	return settings.Edge{
+		Thresholds:        settings.DefaultEdge().Thresholds,
+		NoiseFilter:       o.NoiseFilter,
+		AggregateInterval: config.Duration(o.AggregateInterval),
+		Detectors:         o.Detectors,
+	}
+}
+
+func (e *Edge) currentSettings() settings.Edge {
+	e.settingsMu.RLock()
+	defer e.settingsMu.RUnlock()
+	return e.settings
+}
+
+func (e *Edge) applySettings(s settings.Edge) {
+	e.settingsMu.Lock()
+	previous := e.settings
+	e.settings = s
+	e.settingsMu.Unlock()
+
+	if !reflect.DeepEqual(previous.Detectors, s.Detectors) {
+		e.resetDetectors()
+	}
+	if previous.AggregateInterval != 0 && previous.AggregateInterval != s.AggregateInterval {
+		select {
+		case e.aggregateReset <- struct{}{}:
+		default:
+		}
+	}
+	log.Printf("Settings applied: thresholds=%+v, noise_filter=%.2f, aggregate_interval=%s, detectors=%v",
+		s.Thresholds, s.NoiseFilter, s.AggregateInterval.Std(), s.Detectors.Enabled)
+}
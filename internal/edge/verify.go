@@ -0,0 +1,176 @@
+package edge
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"sistemas_distribuidos_gb/internal/signing"
+	"sistemas_distribuidos_gb/internal/subjects"
+)
+
+// Signature policies, selected with -signatures.
+const (
+	policyOff        = "off"        // accept everything unchecked
+	policyWarn       = "warn"       // check, count and alert, but keep processing
+	policyQuarantine = "quarantine" // set failed readings aside on edge.<id>.quarantine
+	policyReject     = "reject"     // drop failed readings
+)
+
+// DefaultMaxClockSkew is how far a signed reading's timestamp may be from the
+// edge clock by default. It covers unsynchronised clocks and readings held up
+// in a durable consumer while the edge was down.
+const DefaultMaxClockSkew = 5 * time.Minute
+
+// securityAlertInterval limits security alerts to one per sensor and interval,
+// so a misbehaving device can't flood the alert stream.
+const securityAlertInterval = time.Minute
+
+// QuarantinedReading is a reading set aside because it couldn't be verified.
+type QuarantinedReading struct {
+	Subject  string          `json:"subject"`
+	Reason   string          `json:"reason"`
+	Reading  json.RawMessage `json:"reading"`
+	EdgeID   string          `json:"edge_id"`
+	Received int64           `json:"received"`
+}
+
+func validPolicy(p string) bool {
+	switch p {
+	case policyOff, policyWarn, policyQuarantine, policyReject:
+		return true
+	}
+	return false
+}
+
+// verifyReading checks that a reading was signed by the key registered for its
+// sensor, that it was published on that sensor's own subject and that it
+// isn't a replay (see checkFresh). It returns nil for trusted readings.
+func (e *Edge) verifyReading(subject string, reading SensorReading) error {
+	if site, line, sensorID, ok := subjects.ParseReadings(subject); ok {
+		if (reading.SensorID != "" && reading.SensorID != sensorID) ||
+			(reading.Site != "" && reading.Site != site) ||
+			(reading.Line != "" && reading.Line != line) {
+			return fmt.Errorf("reading doesn't match subject %s", subject)
+		}
+	}
+
+	sensor, ok := e.lookupSensor(reading.SensorID)
+	if !ok {
+		return errors.New("sensor not registered")
+	}
+	if sensor.PublicKey == "" {
+		return errors.New("no public key registered")
+	}
+	payload := signing.Payload(reading.SensorID, reading.Site, reading.Line, reading.Value, reading.Timestamp)
+	if err := signing.Verify(sensor.PublicKey, payload, reading.Signature); err != nil {
+		return err
+	}
+	return e.checkFresh(reading)
+}
+
+// checkFresh rejects replays of validly signed readings: the timestamp must be
+// within MaxClockSkew of the edge clock and not one already trusted from the
+// same sensor. Readings may arrive out of order, as a durable consumer
+// redelivers what a stopped edge left unacknowledged after newer ones.
+func (e *Edge) checkFresh(reading SensorReading) error {
+	now := e.clock.Now()
+	skew := now.Sub(time.UnixMilli(reading.Timestamp))
+	if skew > e.opts.MaxClockSkew || skew < -e.opts.MaxClockSkew {
+		return fmt.Errorf("timestamp %d is %s off the edge clock (max %s)", reading.Timestamp, skew.Round(time.Millisecond), e.opts.MaxClockSkew)
+	}
+
+	e.seenMu.Lock()
+	defer e.seenMu.Unlock()
+	seen := e.seen[reading.SensorID]
+	if seen == nil {
+		seen = &seenTimestamps{stamps: map[int64]struct{}{}}
+		e.seen[reading.SensorID] = seen
+	}
+	if _, ok := seen.stamps[reading.Timestamp]; ok {
+		return fmt.Errorf("replayed reading: timestamp %d already seen", reading.Timestamp)
+	}
+	seen.stamps[reading.Timestamp] = struct{}{}
+	// Forget the timestamps that are refused as stale by now, whenever the
+	// set has doubled since the last time
+	if len(seen.stamps) >= seen.prune {
+		oldest := now.Add(-e.opts.MaxClockSkew).UnixMilli()
+		for ts := range seen.stamps {
+			if ts < oldest {
+				delete(seen.stamps, ts)
+			}
+		}
+		seen.prune = 2*len(seen.stamps) + 16
+	}
+	return nil
+}
+
+// seenTimestamps are the timestamps of a sensor's trusted readings, within
+// MaxClockSkew of the edge clock.
+type seenTimestamps struct {
+	stamps map[int64]struct{}
+	prune  int // size at which the stale ones are dropped next
+}
+
+// handleUntrusted applies the signature policy to a reading that failed
+// verification. It reports whether the reading should still be processed.
+func (e *Edge) handleUntrusted(subject string, data []byte, reading SensorReading, reason error) bool {
+	stats, edgeID := e.stats, e.opts.ID
+	stats.mu.Lock()
+	stats.SignatureFailures++
+	switch e.opts.Signatures {
+	case policyQuarantine:
+		stats.Quarantined++
+	case policyReject:
+		stats.Rejected++
+	}
+	stats.mu.Unlock()
+
+	log.Printf("Untrusted reading (%s): sensor_id=%s, subject=%s: %v", e.opts.Signatures, reading.SensorID, subject, reason)
+	e.raiseSecurityAlert(reading, reason)
+
+	switch e.opts.Signatures {
+	case policyWarn:
+		return true
+	case policyQuarantine:
+		q := QuarantinedReading{
+			Subject:  subject,
+			Reason:   reason.Error(),
+			Reading:  json.RawMessage(data),
+			EdgeID:   edgeID,
+			Received: e.clock.Now().UnixMilli(),
+		}
+		if out, err := json.Marshal(q); err == nil {
+			if err := e.uplink.Publish(subjects.Quarantine(edgeID), out); err != nil {
+				log.Printf("Error publishing quarantined reading: %v", err)
+			}
+		}
+	}
+	return false
+}
+
+// raiseSecurityAlert publishes a "security" alert for a sensor, at most once
+// per securityAlertInterval.
+func (e *Edge) raiseSecurityAlert(reading SensorReading, reason error) {
+	now := e.clock.Now()
+	e.securityAlertsMu.Lock()
+	if last, ok := e.lastSecurityAlerts[reading.SensorID]; ok && now.Sub(last) < securityAlertInterval {
+		e.securityAlertsMu.Unlock()
+		return
+	}
+	e.lastSecurityAlerts[reading.SensorID] = now
+	e.securityAlertsMu.Unlock()
+
+	e.publishAlert(Alert{
+		SensorID:  reading.SensorID,
+		Site:      reading.Site,
+		Line:      reading.Line,
+		Value:     reading.Value,
+		Timestamp: reading.Timestamp,
+		EdgeID:    e.opts.ID,
+		Type:      "security",
+		Message:   "Untrusted reading: " + reason.Error(),
+	})
+}
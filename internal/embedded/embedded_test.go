@@ -0,0 +1,147 @@
+package embedded
+
+import (
+	"context"
+	"flag"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+
+	"sistemas_distribuidos_gb/internal/config"
+	"sistemas_distribuidos_gb/internal/subjects"
+)
+
+// TestLeafConfig starts a leaf server with StartLeaf and checks that its
+// clients read and write the hub's config bucket through its mirror, and
+// that the edge's quarantine crosses to the hub.
+func TestLeafConfig(t *testing.T) {
+	dir := t.TempDir()
+	hubServer, err := Start(Options{JetStream: true, StoreDir: filepath.Join(dir, "hub"), LeafPort: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hubServer.Shutdown()
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags := RegisterFlags(fs, false)
+	fs.Set("embedded-store", dir)
+	leafServer, err := flags.StartLeaf("nats://127.0.0.1:0", &Leaf{Name: "edge-a", URL: LeafURL(hubServer)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer leafServer.Shutdown()
+	if _, err := os.Stat(filepath.Join(dir, "leaf-edge-a")); err != nil {
+		t.Errorf("leaf JetStream not stored apart: %v", err)
+	}
+
+	connect := func(url string) (*nats.Conn, jetstream.KeyValue) {
+		nc, err := nats.Connect(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(nc.Close)
+		js, _ := jetstream.New(nc)
+		kv, err := config.Open(context.Background(), js)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return nc, kv
+	}
+	ctx := context.Background()
+	hub, hubKV := connect(hubServer.ClientURL())
+	hubKV.Put(ctx, config.DefaultsKey("edge"), []byte(`{"noise_filter": 2}`))
+	local, localKV := connect(leafServer.ClientURL())
+
+	eventually := func(what string, cond func() bool) {
+		t.Helper()
+		for deadline := time.Now().Add(10 * time.Second); !cond(); time.Sleep(20 * time.Millisecond) {
+			if time.Now().After(deadline) {
+				t.Fatalf("timed out waiting for %s", what)
+			}
+		}
+	}
+	value := func(kv jetstream.KeyValue, key string) string {
+		entry, err := kv.Get(ctx, key)
+		if err != nil {
+			return ""
+		}
+		return string(entry.Value())
+	}
+	eventually("the hub's settings on the leaf", func() bool {
+		return value(localKV, config.DefaultsKey("edge")) == `{"noise_filter": 2}`
+	})
+	if _, err := localKV.Put(ctx, config.InstanceKey("edge", "edge-a"), []byte(`{"aggregate_interval": "10s"}`)); err != nil {
+		t.Fatalf("write through the leaf: %v", err)
+	}
+	eventually("the leaf's write on the hub", func() bool {
+		return value(hubKV, config.InstanceKey("edge", "edge-a")) == `{"aggregate_interval": "10s"}`
+	})
+
+	quarantined := make(chan string, 1)
+	hub.Subscribe(subjects.AllQuarantined, func(msg *nats.Msg) {
+		select {
+		case quarantined <- msg.Subject:
+		default:
+		}
+	})
+	hub.Flush()
+	eventually("the quarantine on the hub", func() bool {
+		local.Publish(subjects.Quarantine("edge-a"), []byte("{}"))
+		select {
+		case <-quarantined:
+			return true
+		case <-time.After(20 * time.Millisecond):
+			return false
+		}
+	})
+}
+
+// TestLeafWatch takes the hub of a leaf server down and back, and checks
+// that the drop of the link is counted once.
+func TestLeafWatch(t *testing.T) {
+	hubServer, err := Start(Options{LeafPort: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	leafURL := LeafURL(hubServer)
+	u, _ := url.Parse(leafURL)
+	leafPort, _ := strconv.Atoi(u.Port())
+	leafServer, err := Start(Options{Leaf: &Leaf{Name: "edge-a", URL: leafURL}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer leafServer.Shutdown()
+
+	eventually := func(what string, cond func() bool) {
+		t.Helper()
+		for deadline := time.Now().Add(10 * time.Second); !cond(); time.Sleep(20 * time.Millisecond) {
+			if time.Now().After(deadline) {
+				t.Fatalf("timed out waiting for %s", what)
+			}
+		}
+	}
+	watch := WatchLeaf(leafServer)
+	defer watch.Stop()
+	eventually("the leaf connection", watch.Connected)
+	if drops := watch.Drops(); drops != 0 {
+		t.Errorf("%d drops while connected", drops)
+	}
+
+	hubServer.Shutdown()
+	eventually("the drop", func() bool { return watch.Drops() == 1 && !watch.Connected() })
+	hubServer, err = Start(Options{LeafPort: leafPort})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hubServer.Shutdown()
+	eventually("the reconnection", watch.Connected)
+	if drops := watch.Drops(); drops != 1 {
+		t.Errorf("%d drops after reconnecting, want 1", drops)
+	}
+}
@@ -0,0 +1,55 @@
+package embedded
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+
+	mqtt "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/hooks/auth"
+	"github.com/mochi-mqtt/server/v2/listeners"
+)
+
+// MQTTServer is an in-process MQTT 3.1.1/5 server, standing in for a
+// Mosquitto-style broker in tests and demos. It accepts every client.
+type MQTTServer struct {
+	srv  *mqtt.Server
+	addr string
+}
+
+// StartMQTT starts an MQTT server on addr (127.0.0.1:0 picks a free port).
+func StartMQTT(addr string) (*MQTTServer, error) {
+	if addr == "" {
+		addr = "127.0.0.1:0"
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("listen for MQTT: %w", err)
+	}
+
+	srv := mqtt.New(&mqtt.Options{Logger: slog.New(slog.NewTextHandler(io.Discard, nil))})
+	if err := srv.AddHook(new(auth.AllowHook), nil); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	if err := srv.AddListener(listeners.NewNet("tcp", ln)); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	if err := srv.Serve(); err != nil {
+		srv.Close()
+		return nil, fmt.Errorf("start embedded MQTT server: %w", err)
+	}
+	return &MQTTServer{srv: srv, addr: ln.Addr().String()}, nil
+}
+
+// URL returns the tcp:// URL clients connect to.
+func (s *MQTTServer) URL() string {
+	return "tcp://" + s.addr
+}
+
+// Shutdown disconnects every client and stops the server.
+func (s *MQTTServer) Shutdown() {
+	s.srv.Close()
+}
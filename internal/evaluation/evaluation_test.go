@@ -0,0 +1,100 @@
+package evaluation
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io"
+	"log"
+	"os"
+	"testing"
+
+	"sistemas_distribuidos_gb/internal/anomaly"
+	"sistemas_distribuidos_gb/internal/simulator"
+)
+
+var evaluationReport = flag.String("evaluation.report", "", "Write the detection quality report as JSON to this file")
+
+// detectorConfigs are the detector settings compared by TestDetectionQuality.
+func detectorConfigs() map[string]anomaly.Config {
+	all := []string{anomaly.Bands, anomaly.EWMA, anomaly.CUSUM, anomaly.ZScore, anomaly.Seasonal}
+
+	def := anomaly.DefaultConfig()
+	def.Enabled = all
+
+	sensitive := def
+	sensitive.EWMA.L = 3
+	sensitive.CUSUM.H = 5
+	sensitive.ZScore.Threshold = 3
+	sensitive.Seasonal.Threshold = 3
+
+	conservative := def
+	conservative.EWMA.L = 4.5
+	conservative.CUSUM.H = 12
+	conservative.ZScore.Threshold = 5
+	conservative.Seasonal.Threshold = 5
+
+	return map[string]anomaly.Config{"default": def, "sensitive": sensitive, "conservative": conservative}
+}
+
+// minRecall is the share of the episodes every detector enabled by default
+// must find, with the default parameters.
+const minRecall = 0.8
+
+// TestDetectionQuality plays simulated sensors with known drifts and spikes
+// through the pipeline and reports precision, recall and detection delay of
+// each detector, for each configuration.
+func TestDetectionQuality(t *testing.T) {
+	if testing.Short() {
+		t.Skip("detection quality evaluation skipped in short mode")
+	}
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	scenario := DefaultScenario()
+	report := map[string]*Result{}
+	configs := detectorConfigs()
+	for _, name := range []string{"default", "sensitive", "conservative"} {
+		result, err := Run(scenario, configs[name])
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		report[name] = result
+
+		var table bytes.Buffer
+		WriteTable(&table, result.Scores)
+		t.Logf("%s: %d readings, %d delivered, %d episodes\n%s", name, result.Published, result.Delivered, result.Episodes, table.String())
+
+		if result.Delivered != result.Published {
+			t.Errorf("%s: the cloud received %d of %d readings", name, result.Delivered, result.Published)
+		}
+	}
+
+	// The default detectors must find most episodes, and each what it's
+	// designed for
+	for _, s := range report["default"].Scores {
+		if anomaly.DefaultConfig().Has(s.Detector) && s.Recall < minRecall {
+			t.Errorf("%s recall = %.2f, want >= %.2f", s.Detector, s.Recall, minRecall)
+		}
+		switch s.Detector {
+		case anomaly.ZScore:
+			if r := s.RecallByKind[simulator.Spike]; r < 0.8 {
+				t.Errorf("zscore spike recall = %.2f, want >= 0.8", r)
+			}
+		case anomaly.CUSUM:
+			if r := s.RecallByKind[simulator.Drift]; r < 0.8 {
+				t.Errorf("cusum drift recall = %.2f, want >= 0.8", r)
+			}
+		}
+	}
+
+	if *evaluationReport != "" {
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(*evaluationReport, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,309 @@
+package evaluation
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+
+	"sistemas_distribuidos_gb/internal/anomaly"
+	"sistemas_distribuidos_gb/internal/broker"
+	"sistemas_distribuidos_gb/internal/clock"
+	"sistemas_distribuidos_gb/internal/cloud"
+	"sistemas_distribuidos_gb/internal/edge"
+	"sistemas_distribuidos_gb/internal/embedded"
+	"sistemas_distribuidos_gb/internal/sensor"
+	"sistemas_distribuidos_gb/internal/simulator"
+	"sistemas_distribuidos_gb/internal/subjects"
+)
+
+// Scenario describes the simulated sensors a run feeds to the pipeline.
+type Scenario struct {
+	Sensors  int
+	Samples  int           // readings per sensor
+	Interval time.Duration // simulated time between readings of a sensor
+	Seed     int64         // runs with the same seed see the same values
+
+	Base, Noise              float64
+	DriftChance, SpikeChance float64
+
+	// Grace extends episodes when matching alerts, for detectors that
+	// need a few readings to settle after the signal returns to normal.
+	Grace time.Duration
+}
+
+// DefaultScenario has enough drifts and spikes for stable figures while
+// running in a few seconds.
+func DefaultScenario() Scenario {
+	return Scenario{
+		Sensors:     5,
+		Samples:     3000,
+		Interval:    time.Second,
+		Seed:        1,
+		Base:        50,
+		Noise:       2,
+		DriftChance: 0.005,
+		SpikeChance: 0.005,
+		Grace:       10 * time.Second,
+	}
+}
+
+// Result is the outcome of a run.
+type Result struct {
+	Published int             `json:"published"` // readings sent by the sensors
+	Delivered int             `json:"delivered"` // filtered readings stored by the cloud
+	Episodes  int             `json:"episodes"`
+	Scores    []DetectorScore `json:"scores"`
+}
+
+// start is the simulated time runs start at.
+var start = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// Run plays the scenario through the whole pipeline, in process: simulated
+// sensors signing their readings, an edge running detectors and a cloud
+// processor, on an embedded NATS server and a shared fake clock. It scores
+// the alerts the cloud stored. Simulated time only moves once every sensor
+// has published, so delays are in simulated time no matter how fast the run
+// goes. Enabled detectors that stay silent are reported with zero recall.
+func Run(sc Scenario, detectors anomaly.Config) (*Result, error) {
+	dir, err := os.MkdirTemp("", "evaluation-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	ns, err := embedded.Start(embedded.Options{})
+	if err != nil {
+		return nil, err
+	}
+	defer ns.Shutdown()
+	connect := func(name string) (*nats.Conn, error) {
+		return nats.Connect(ns.ClientURL(), nats.Name(name))
+	}
+	clk := clock.NewFake(start)
+
+	// The cloud hosts the registry the sensors announce their keys to
+	cloudConn, err := connect("evaluation-cloud")
+	if err != nil {
+		return nil, err
+	}
+	defer cloudConn.Close()
+	cloudOpts := cloud.DefaultOptions()
+	cloudOpts.RegistryBackend = "file"
+	cloudOpts.RegistryFile = filepath.Join(dir, "registry.json")
+	cloudOpts.HistoryDir = filepath.Join(dir, "history")
+	cloudOpts.UseConfig = false
+	cloudOpts.Clock = clk
+	c, err := cloud.New(broker.NewNATS(cloudConn), cloudOpts)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.Start(context.Background()); err != nil {
+		return nil, fmt.Errorf("start cloud: %w", err)
+	}
+	defer c.Stop()
+	api := c.Handler()
+
+	edgeConn, err := connect("evaluation-edge")
+	if err != nil {
+		return nil, err
+	}
+	defer edgeConn.Close()
+	edgeOpts := edge.DefaultOptions()
+	edgeOpts.ID = "eval"
+	edgeOpts.UseConfig = false
+	edgeOpts.Detectors = detectors
+	edgeOpts.Clock = clk
+	e, err := edge.New(broker.NewNATS(edgeConn), edgeOpts)
+	if err != nil {
+		return nil, err
+	}
+	if err := e.Start(context.Background()); err != nil {
+		return nil, fmt.Errorf("start edge: %w", err)
+	}
+	defer e.Stop()
+
+	// Follow the labels and the edge output, to pace the sensors
+	nc, err := connect("evaluation")
+	if err != nil {
+		return nil, err
+	}
+	defer nc.Close()
+	var (
+		mu        sync.Mutex
+		labels    []simulator.GroundTruth
+		processed int // readings the edge forwarded or set aside
+		alerts    int
+		changed   = make(chan struct{}, 1)
+	)
+	notify := func() {
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	}
+	count := func(n *int) nats.MsgHandler {
+		return func(*nats.Msg) {
+			mu.Lock()
+			*n++
+			mu.Unlock()
+			notify()
+		}
+	}
+	handlers := map[string]nats.MsgHandler{
+		subjects.AllLabels: func(msg *nats.Msg) {
+			var gt simulator.GroundTruth
+			if json.Unmarshal(msg.Data, &gt) == nil {
+				mu.Lock()
+				labels = append(labels, gt)
+				mu.Unlock()
+				notify()
+			}
+		},
+		subjects.AllFiltered:    count(&processed),
+		subjects.AllQuarantined: count(&processed),
+		subjects.AllAlerts:      count(&alerts),
+	}
+	for subject, handler := range handlers {
+		sub, err := nc.Subscribe(subject, handler)
+		if err != nil {
+			return nil, err
+		}
+		sub.SetPendingLimits(-1, -1)
+	}
+	if err := nc.Flush(); err != nil {
+		return nil, err
+	}
+
+	for i := 0; i < sc.Sensors; i++ {
+		opts := sensor.DefaultOptions()
+		opts.ID = fmt.Sprintf("eval-%02d", i+1)
+		opts.Interval = sc.Interval
+		opts.BaseValue, opts.NoiseLevel = sc.Base, sc.Noise
+		opts.AnomalyChance, opts.SpikeChance = sc.DriftChance, sc.SpikeChance
+		opts.Seed = sc.Seed + int64(i)
+		opts.UseConfig = false
+		opts.KeyFile = filepath.Join(dir, "keys", opts.ID+".nk")
+		opts.Labels = true
+		opts.Clock = clk
+		conn, err := connect(opts.ID)
+		if err != nil {
+			return nil, err
+		}
+		defer conn.Close()
+		s, err := sensor.New(broker.NewNATS(conn), opts)
+		if err != nil {
+			return nil, err
+		}
+		if err := s.Start(context.Background()); err != nil {
+			return nil, err
+		}
+		defer s.Stop()
+	}
+
+	if err := play(clk, sc, changed, func() (int, int) {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(labels), processed
+	}); err != nil {
+		return nil, err
+	}
+
+	// Wait for the cloud to store everything the edge sent
+	mu.Lock()
+	wantReadings, wantAlerts := processed, alerts
+	mu.Unlock()
+	var stats struct {
+		TotalReadings int `json:"total_readings"`
+		TotalAlerts   int `json:"total_alerts"`
+	}
+	for deadline := time.Now().Add(30 * time.Second); ; time.Sleep(20 * time.Millisecond) {
+		if err := get(api, "/stats", func(body io.Reader) error { return json.NewDecoder(body).Decode(&stats) }); err != nil {
+			return nil, err
+		}
+		if stats.TotalReadings >= wantReadings && stats.TotalAlerts >= wantAlerts {
+			break
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("the cloud received %d of %d readings and %d of %d alerts", stats.TotalReadings, wantReadings, stats.TotalAlerts, wantAlerts)
+		}
+	}
+
+	var stored []AlertRecord
+	query := fmt.Sprintf("/export?kind=alerts&format=jsonl&from=%d&to=%d", start.UnixMilli(), clk.Now().Add(time.Hour).UnixMilli())
+	err = get(api, query, func(body io.Reader) error {
+		for dec := json.NewDecoder(body); dec.More(); {
+			var a AlertRecord
+			if err := dec.Decode(&a); err != nil {
+				return err
+			}
+			stored = append(stored, a)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	episodes := Episodes(labels)
+	return &Result{
+		Published: len(labels),
+		Delivered: stats.TotalReadings,
+		Episodes:  len(episodes),
+		Scores:    Score(episodes, stored, sc.Grace, detectors.Enabled...),
+	}, nil
+}
+
+// play moves the clock one interval at a time, waiting each time for every
+// sensor to publish and for the edge to handle the readings, so that it never
+// falls behind simulated time. progress returns the labels and the readings
+// the edge handled so far, and changed signals that they may have grown. The
+// first ticks wait for every sensor to start.
+func play(clk *clock.Fake, sc Scenario, changed <-chan struct{}, progress func() (labels, processed int)) error {
+	// Sensors start publishing once they have announced themselves
+	for deadline := time.Now().Add(10 * time.Second); ; {
+		before, _ := progress()
+		clk.Advance(sc.Interval)
+		time.Sleep(20 * time.Millisecond)
+		if after, _ := progress(); after-before == sc.Sensors {
+			break
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("the sensors didn't start")
+		}
+	}
+
+	for n := 1; n < sc.Samples; n++ {
+		want, _ := progress()
+		want += sc.Sensors
+		clk.Advance(sc.Interval)
+		for labels, processed := progress(); labels < want || processed < want; labels, processed = progress() {
+			select {
+			case <-changed:
+			case <-time.After(10 * time.Second):
+				return fmt.Errorf("no progress after %d intervals", n)
+			}
+		}
+	}
+	return nil
+}
+
+// get calls the cloud API in process and reads the response with read.
+func get(api http.Handler, path string, read func(io.Reader) error) error {
+	rec := httptest.NewRecorder()
+	api.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	if rec.Code != http.StatusOK {
+		return fmt.Errorf("GET %s: %d %s", path, rec.Code, rec.Body.String())
+	}
+	return read(rec.Body)
+}
@@ -0,0 +1,185 @@
+// Package evaluation measures how well the edge anomaly detectors find the
+// drifts and spikes injected by the simulator. Sensors publish the ground
+// truth of every reading (see simulator.GroundTruth); the harness replays a
+// scenario through an in-process pipeline, collects the alerts and scores each
+// detector with precision, recall and detection delay.
+package evaluation
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"sistemas_distribuidos_gb/internal/simulator"
+)
+
+// Episode is a run of consecutive anomalous readings of one sensor.
+type Episode struct {
+	SensorID string          `json:"sensor_id"`
+	Kind     simulator.Label `json:"kind"`
+	Start    int64           `json:"start"` // timestamp of the first reading, ms
+	End      int64           `json:"end"`   // timestamp of the last reading, ms
+}
+
+// AlertRecord is the part of an edge alert the scoring needs.
+type AlertRecord struct {
+	SensorID  string `json:"sensor_id"`
+	Timestamp int64  `json:"timestamp"`
+	Detector  string `json:"detector"`
+}
+
+// Episodes groups per-reading labels into episodes. Labels of each sensor
+// must be in timestamp order. A spike is an episode of its own and doesn't
+// interrupt a drift it happens during.
+func Episodes(labels []simulator.GroundTruth) []Episode {
+	var episodes []Episode
+	drifting := map[string]int{} // sensor -> index of its open drift episode
+	for _, gt := range labels {
+		switch gt.Label {
+		case simulator.Spike:
+			episodes = append(episodes, Episode{SensorID: gt.SensorID, Kind: gt.Label, Start: gt.Timestamp, End: gt.Timestamp})
+		case simulator.Drift:
+			if i, ok := drifting[gt.SensorID]; ok {
+				episodes[i].End = gt.Timestamp
+				continue
+			}
+			drifting[gt.SensorID] = len(episodes)
+			episodes = append(episodes, Episode{SensorID: gt.SensorID, Kind: gt.Label, Start: gt.Timestamp, End: gt.Timestamp})
+		default:
+			delete(drifting, gt.SensorID)
+		}
+	}
+	return episodes
+}
+
+// DetectorScore is the detection quality of one detector.
+type DetectorScore struct {
+	Detector string `json:"detector"`
+
+	Alerts        int     `json:"alerts"`
+	TruePositives int     `json:"true_positives"` // alerts raised during an episode
+	Precision     float64 `json:"precision"`
+
+	Episodes     int                         `json:"episodes"`
+	Detected     int                         `json:"detected"`
+	Recall       float64                     `json:"recall"`
+	RecallByKind map[simulator.Label]float64 `json:"recall_by_kind"`
+
+	// Delay from the first reading of an episode to its first alert, over
+	// detected episodes.
+	MeanDelay time.Duration `json:"mean_delay_ns"`
+	MaxDelay  time.Duration `json:"max_delay_ns"`
+}
+
+// Score rates every detector that raised alerts, plus those listed in
+// detectors (so that silent detectors show up with zero recall). An alert
+// counts as a true positive if it falls within an episode of its sensor,
+// extended by grace to allow for detectors that lag behind the signal.
+func Score(episodes []Episode, alerts []AlertRecord, grace time.Duration, detectors ...string) []DetectorScore {
+	bySensor := map[string][]int{}
+	for i, ep := range episodes {
+		bySensor[ep.SensorID] = append(bySensor[ep.SensorID], i)
+	}
+	// episode returns the index of the episode an alert belongs to, or -1.
+	// When episodes overlap (a spike during a drift) the shortest one wins.
+	episode := func(a AlertRecord) int {
+		best := -1
+		for _, i := range bySensor[a.SensorID] {
+			ep := episodes[i]
+			if a.Timestamp < ep.Start || a.Timestamp > ep.End+grace.Milliseconds() {
+				continue
+			}
+			if best < 0 || ep.End-ep.Start < episodes[best].End-episodes[best].Start {
+				best = i
+			}
+		}
+		return best
+	}
+
+	type acc struct {
+		alerts, tp int
+		first      map[int]int64 // episode -> timestamp of first alert
+	}
+	accs := map[string]*acc{}
+	get := func(d string) *acc {
+		if accs[d] == nil {
+			accs[d] = &acc{first: map[int]int64{}}
+		}
+		return accs[d]
+	}
+	for _, d := range detectors {
+		get(d)
+	}
+	for _, a := range alerts {
+		c := get(a.Detector)
+		c.alerts++
+		i := episode(a)
+		if i < 0 {
+			continue
+		}
+		c.tp++
+		if t, ok := c.first[i]; !ok || a.Timestamp < t {
+			c.first[i] = a.Timestamp
+		}
+	}
+
+	kinds := map[simulator.Label]int{}
+	for _, ep := range episodes {
+		kinds[ep.Kind]++
+	}
+
+	var scores []DetectorScore
+	for d, c := range accs {
+		s := DetectorScore{
+			Detector:      d,
+			Alerts:        c.alerts,
+			TruePositives: c.tp,
+			Precision:     ratio(c.tp, c.alerts),
+			Episodes:      len(episodes),
+			Detected:      len(c.first),
+			Recall:        ratio(len(c.first), len(episodes)),
+			RecallByKind:  map[simulator.Label]float64{},
+		}
+		detectedByKind := map[simulator.Label]int{}
+		var total time.Duration
+		for i, t := range c.first {
+			detectedByKind[episodes[i].Kind]++
+			delay := time.Duration(t-episodes[i].Start) * time.Millisecond
+			total += delay
+			if delay > s.MaxDelay {
+				s.MaxDelay = delay
+			}
+		}
+		if len(c.first) > 0 {
+			s.MeanDelay = total / time.Duration(len(c.first))
+		}
+		for k, n := range kinds {
+			s.RecallByKind[k] = ratio(detectedByKind[k], n)
+		}
+		scores = append(scores, s)
+	}
+	sort.Slice(scores, func(i, j int) bool { return scores[i].Detector < scores[j].Detector })
+	return scores
+}
+
+func ratio(a, b int) float64 {
+	if b == 0 {
+		return 0
+	}
+	return float64(a) / float64(b)
+}
+
+// WriteTable prints scores as an aligned text table.
+func WriteTable(w io.Writer, scores []DetectorScore) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "DETECTOR\tALERTS\tPRECISION\tRECALL\tDRIFT\tSPIKE\tMEAN DELAY\tMAX DELAY")
+	for _, s := range scores {
+		fmt.Fprintf(tw, "%s\t%d\t%.2f\t%.2f\t%.2f\t%.2f\t%s\t%s\n",
+			s.Detector, s.Alerts, s.Precision, s.Recall,
+			s.RecallByKind[simulator.Drift], s.RecallByKind[simulator.Spike],
+			s.MeanDelay, s.MaxDelay)
+	}
+	return tw.Flush()
+}
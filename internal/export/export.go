@@ -0,0 +1,195 @@
+// Package export writes the readings and alerts of a history store as CSV,
+// JSON lines or Parquet files, streaming them record by record, for the
+// export endpoints of the cloud and the dashboard.
+package export
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"sistemas_distribuidos_gb/internal/history"
+)
+
+// Format is a file format.
+type Format string
+
+const (
+	CSV     Format = "csv"
+	JSONL   Format = "jsonl"
+	Parquet Format = "parquet"
+)
+
+// ParseFormat reads a format name; empty means CSV.
+func ParseFormat(s string) (Format, error) {
+	switch f := Format(s); f {
+	case "":
+		return CSV, nil
+	case CSV, JSONL, Parquet:
+		return f, nil
+	}
+	return "", fmt.Errorf("unknown format %q (want csv, jsonl or parquet)", s)
+}
+
+// ContentType returns the media type of files of the format.
+func (f Format) ContentType() string {
+	switch f {
+	case JSONL:
+		return "application/x-ndjson"
+	case Parquet:
+		return "application/vnd.apache.parquet"
+	}
+	return "text/csv; charset=utf-8"
+}
+
+// Type is the type of a column.
+type Type int
+
+const (
+	String Type = iota // string values
+	Float              // float64 values
+	Time               // int64 values, Unix milliseconds
+)
+
+// Column is a column of an exported table.
+type Column struct {
+	Name string
+	Type Type
+}
+
+// ReadingColumns are the columns of exported readings.
+var ReadingColumns = []Column{
+	{"timestamp", Time}, {"sensor_id", String}, {"edge_id", String}, {"site", String},
+	{"line", String}, {"location", String}, {"unit", String}, {"value", Float},
+}
+
+// AlertColumns are the columns of exported alerts.
+var AlertColumns = []Column{
+	{"timestamp", Time}, {"sensor_id", String}, {"edge_id", String}, {"site", String},
+	{"line", String}, {"location", String}, {"unit", String}, {"type", String},
+	{"value", Float}, {"message", String}, {"detector", String}, {"score", Float},
+	{"baseline", Float},
+}
+
+// ReadingRow returns the values of a reading, in the order of ReadingColumns.
+func ReadingRow(r history.Reading) []interface{} {
+	return []interface{}{r.Timestamp, r.SensorID, r.EdgeID, r.Site, r.Line, r.Location, r.Unit, r.Value}
+}
+
+// AlertRow returns the values of an alert, in the order of AlertColumns.
+func AlertRow(a history.Alert) []interface{} {
+	return []interface{}{a.Timestamp, a.SensorID, a.EdgeID, a.Site, a.Line, a.Location, a.Unit, a.Type,
+		a.Value, a.Message, a.Detector, a.Score, a.Baseline}
+}
+
+// Writer writes the rows of a table. Close finishes the file; it doesn't
+// close the underlying writer.
+type Writer interface {
+	Write(row []interface{}) error
+	Close() error
+}
+
+// NewWriter returns a writer of a table with the given columns to w. With
+// compress, CSV and JSON lines are gzipped as a whole, while Parquet files
+// compress their pages with gzip and stay readable as Parquet.
+func NewWriter(w io.Writer, f Format, columns []Column, compress bool) (Writer, error) {
+	if f == Parquet {
+		return newParquetWriter(w, columns, compress), nil
+	}
+	var gz *gzip.Writer
+	if compress {
+		gz = gzip.NewWriter(w)
+		w = gz
+	}
+	switch f {
+	case CSV:
+		cw := &csvWriter{w: csv.NewWriter(w), columns: columns, gz: gz}
+		header := make([]string, len(columns))
+		for i, c := range columns {
+			header[i] = c.Name
+		}
+		return cw, cw.w.Write(header)
+	case JSONL:
+		return &jsonlWriter{w: bufio.NewWriter(w), columns: columns, gz: gz}, nil
+	}
+	return nil, fmt.Errorf("unknown format %q", f)
+}
+
+// timeLayout is how CSV files write times: RFC 3339 in UTC, to the
+// millisecond, which spreadsheets read.
+const timeLayout = "2006-01-02T15:04:05.000Z07:00"
+
+type csvWriter struct {
+	w       *csv.Writer
+	columns []Column
+	gz      *gzip.Writer // nil when not compressing
+	record  []string
+}
+
+func (c *csvWriter) Write(row []interface{}) error {
+	c.record = c.record[:0]
+	for i, v := range row {
+		switch c.columns[i].Type {
+		case Time:
+			c.record = append(c.record, time.UnixMilli(v.(int64)).UTC().Format(timeLayout))
+		case Float:
+			c.record = append(c.record, strconv.FormatFloat(v.(float64), 'g', -1, 64))
+		default:
+			c.record = append(c.record, v.(string))
+		}
+	}
+	return c.w.Write(c.record)
+}
+
+func (c *csvWriter) Close() error {
+	c.w.Flush()
+	if err := c.w.Error(); err != nil {
+		return err
+	}
+	if c.gz != nil {
+		return c.gz.Close()
+	}
+	return nil
+}
+
+// jsonlWriter writes a JSON object per row, with the columns as keys in
+// their order and times as Unix milliseconds, like the rest of the API.
+type jsonlWriter struct {
+	w       *bufio.Writer
+	columns []Column
+	gz      *gzip.Writer // nil when not compressing
+}
+
+func (j *jsonlWriter) Write(row []interface{}) error {
+	j.w.WriteByte('{')
+	for i, v := range row {
+		if i > 0 {
+			j.w.WriteByte(',')
+		}
+		key, _ := json.Marshal(j.columns[i].Name)
+		j.w.Write(key)
+		j.w.WriteByte(':')
+		value, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Errorf("column %s: %w", j.columns[i].Name, err)
+		}
+		j.w.Write(value)
+	}
+	j.w.WriteByte('}')
+	return j.w.WriteByte('\n')
+}
+
+func (j *jsonlWriter) Close() error {
+	if err := j.w.Flush(); err != nil {
+		return err
+	}
+	if j.gz != nil {
+		return j.gz.Close()
+	}
+	return nil
+}
@@ -0,0 +1,261 @@
+package export
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"sistemas_distribuidos_gb/internal/clock"
+	"sistemas_distribuidos_gb/internal/history"
+)
+
+var start = time.Date(2024, 1, 1, 8, 0, 0, 0, time.UTC)
+
+func TestFormats(t *testing.T) {
+	rows := [][]interface{}{
+		ReadingRow(history.Reading{SensorID: "temp-01", EdgeID: "edge-1", Location: "Sala, 2", Unit: "°C", Value: 21.5, Timestamp: start.UnixMilli()}),
+		ReadingRow(history.Reading{SensorID: "temp-02", EdgeID: "edge-1", Value: -3, Timestamp: start.Add(time.Second).UnixMilli()}),
+	}
+	write := func(f Format, compress bool) string {
+		var buf bytes.Buffer
+		w, err := NewWriter(&buf, f, ReadingColumns, compress)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, row := range rows {
+			if err := w.Write(row); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if !compress {
+			return buf.String()
+		}
+		zr, err := gzip.NewReader(&buf)
+		if err != nil {
+			t.Fatalf("%s isn't gzipped: %v", f, err)
+		}
+		data, _ := io.ReadAll(zr)
+		return string(data)
+	}
+
+	want := "timestamp,sensor_id,edge_id,site,line,location,unit,value\n" +
+		"2024-01-01T08:00:00.000Z,temp-01,edge-1,,,\"Sala, 2\",°C,21.5\n" +
+		"2024-01-01T08:00:01.000Z,temp-02,edge-1,,,,,-3\n"
+	if got := write(CSV, true); got != want {
+		t.Errorf("CSV:\n%s\nwant:\n%s", got, want)
+	}
+	want = `{"timestamp":1704096000000,"sensor_id":"temp-01","edge_id":"edge-1","site":"","line":"","location":"Sala, 2","unit":"°C","value":21.5}` + "\n" +
+		`{"timestamp":1704096001000,"sensor_id":"temp-02","edge_id":"edge-1","site":"","line":"","location":"","unit":"","value":-3}` + "\n"
+	if got := write(JSONL, false); got != want {
+		t.Errorf("JSONL:\n%s\nwant:\n%s", got, want)
+	}
+	if _, err := ParseFormat("xlsx"); err == nil {
+		t.Error("ParseFormat accepted xlsx")
+	}
+}
+
+// TestParquet reads back the files written over several row groups.
+func TestParquet(t *testing.T) {
+	n := rowGroupSize*2 + 3
+	for _, compress := range []bool{false, true} {
+		var buf bytes.Buffer
+		w, _ := NewWriter(&buf, Parquet, AlertColumns, compress)
+		for i := 0; i < n; i++ {
+			a := history.Alert{SensorID: fmt.Sprintf("s%d", i%7), Type: "critical", Value: float64(i) / 2, Timestamp: start.UnixMilli() + int64(i)}
+			if err := w.Write(AlertRow(a)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		file := buf.Bytes()
+		if !bytes.HasPrefix(file, parquetMagic) || !bytes.HasSuffix(file, parquetMagic) {
+			t.Fatal("missing PAR1 magic")
+		}
+		size := int(binary.LittleEndian.Uint32(file[len(file)-8:]))
+		meta := (&thriftReader{b: file[len(file)-8-size : len(file)-8]}).structure()
+
+		schema := meta[2].([]interface{})
+		if len(schema) != len(AlertColumns)+1 || schema[0].(map[int16]interface{})[5] != int64(len(AlertColumns)) {
+			t.Fatalf("schema %v", schema)
+		}
+		for i, c := range AlertColumns {
+			if name := schema[i+1].(map[int16]interface{})[4]; name != c.Name {
+				t.Errorf("column %d is %v, want %s", i, name, c.Name)
+			}
+		}
+		if meta[3] != int64(n) {
+			t.Errorf("num_rows %v, want %d", meta[3], n)
+		}
+		groups := meta[4].([]interface{})
+		if len(groups) != 3 {
+			t.Fatalf("%d row groups, want 3", len(groups))
+		}
+
+		// The last value of every column of the last row group
+		last := groups[2].(map[int16]interface{})
+		if last[3] != int64(3) {
+			t.Errorf("last row group has %v rows, want 3", last[3])
+		}
+		chunks := last[1].([]interface{})
+		column := func(i int) []byte {
+			md := chunks[i].(map[int16]interface{})[3].(map[int16]interface{})
+			if md[4] != map[bool]int64{false: codecUncompressed, true: codecGzip}[compress] {
+				t.Errorf("codec %v with compress %v", md[4], compress)
+			}
+			r := &thriftReader{b: file[md[9].(int64):]}
+			header := r.structure()
+			page := r.b[r.pos : r.pos+int(header[3].(int64))]
+			if compress {
+				zr, err := gzip.NewReader(bytes.NewReader(page))
+				if err != nil {
+					t.Fatal(err)
+				}
+				page, _ = io.ReadAll(zr)
+			}
+			if len(page) != int(header[2].(int64)) {
+				t.Errorf("page of %d bytes, header says %v", len(page), header[2])
+			}
+			return page
+		}
+		timestamps := column(0)
+		if ts := int64(binary.LittleEndian.Uint64(timestamps[16:])); ts != start.UnixMilli()+int64(n-1) {
+			t.Errorf("last timestamp %d", ts)
+		}
+		sensors := column(1)
+		if got, want := string(sensors[len(sensors)-2:]), fmt.Sprintf("s%d", (n-1)%7); got != want {
+			t.Errorf("last sensor %q, want %q", got, want)
+		}
+		values := column(8)
+		if v := math.Float64frombits(binary.LittleEndian.Uint64(values[16:])); v != float64(n-1)/2 {
+			t.Errorf("last value %v", v)
+		}
+	}
+}
+
+func TestServe(t *testing.T) {
+	clk := clock.NewFake(start.Add(2 * time.Hour))
+	s, err := history.Open(t.TempDir(), 0, clk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	for i := 0; i < 12; i++ {
+		ts := start.Add(time.Duration(i) * 10 * time.Minute).UnixMilli()
+		s.AddReading(history.Reading{SensorID: "s1", EdgeID: "e1", Value: float64(i), Timestamp: ts})
+		s.AddReading(history.Reading{SensorID: "s2", EdgeID: "e2", Value: 100, Timestamp: ts})
+	}
+	s.AddAlert(history.Alert{SensorID: "s2", EdgeID: "e2", Type: "anomaly", Value: 100, Message: "spike", Timestamp: start.Add(80 * time.Minute).UnixMilli()})
+
+	get := func(query string) (*httptest.ResponseRecorder, string) {
+		rec := httptest.NewRecorder()
+		Serve(rec, httptest.NewRequest("GET", "/export?"+query, nil), s, clk.Now())
+		return rec, rec.Body.String()
+	}
+
+	rec, body := get("sensor=s1&range=1h&format=jsonl")
+	if lines := strings.Count(body, "\n"); lines != 6 || !strings.HasPrefix(body, `{"timestamp":1704099600000,"sensor_id":"s1"`) {
+		t.Errorf("readings of s1 in the last hour (%d lines):\n%s", lines, body)
+	}
+	if cd := rec.Header().Get("Content-Disposition"); cd != `attachment; filename="readings-20240101T0900Z-20240101T1000Z.jsonl"` {
+		t.Errorf("Content-Disposition %q", cd)
+	}
+
+	rec, body = get("kind=alerts&from=2024-01-01T08:00:00Z&gzip=1")
+	if rec.Header().Get("Content-Type") != "application/gzip" || !strings.HasSuffix(rec.Header().Get("Content-Disposition"), `.csv.gz"`) {
+		t.Errorf("gzipped CSV served as %q, %q", rec.Header().Get("Content-Type"), rec.Header().Get("Content-Disposition"))
+	}
+	zr, err := gzip.NewReader(strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(zr)
+	if lines := strings.Split(strings.TrimSpace(string(data)), "\n"); len(lines) != 2 || !strings.Contains(lines[1], ",anomaly,100,spike,") {
+		t.Errorf("alerts CSV:\n%s", data)
+	}
+
+	rec, body = get("edge=e2&format=parquet&gzip=1")
+	if rec.Header().Get("Content-Type") != "application/vnd.apache.parquet" || !strings.HasPrefix(body, "PAR1") {
+		t.Errorf("Parquet served as %q", rec.Header().Get("Content-Type"))
+	}
+
+	for _, query := range []string{"format=xlsx", "kind=sensors", "range=-1h"} {
+		if rec, _ := get(query); rec.Code != 400 {
+			t.Errorf("%s: %d, want 400", query, rec.Code)
+		}
+	}
+}
+
+// thriftReader decodes the Thrift compact protocol, into maps of field IDs
+// to int64, string, list and struct values, enough to check the footer.
+type thriftReader struct {
+	b   []byte
+	pos int
+}
+
+func (r *thriftReader) varint() uint64 {
+	v, n := binary.Uvarint(r.b[r.pos:])
+	r.pos += n
+	return v
+}
+
+func (r *thriftReader) zigzag() int64 {
+	v := r.varint()
+	return int64(v>>1) ^ -int64(v&1)
+}
+
+func (r *thriftReader) value(typ byte) interface{} {
+	switch typ {
+	case thriftI32, thriftI64:
+		return r.zigzag()
+	case thriftBinary:
+		n := int(r.varint())
+		r.pos += n
+		return string(r.b[r.pos-n : r.pos])
+	case thriftList:
+		head := r.b[r.pos]
+		r.pos++
+		n, elem := int(head>>4), head&0x0f
+		if n == 15 {
+			n = int(r.varint())
+		}
+		list := make([]interface{}, n)
+		for i := range list {
+			list[i] = r.value(elem)
+		}
+		return list
+	case thriftStruct:
+		return r.structure()
+	}
+	panic(fmt.Sprintf("unexpected thrift type %d", typ))
+}
+
+func (r *thriftReader) structure() map[int16]interface{} {
+	fields := map[int16]interface{}{}
+	var id int16
+	for {
+		head := r.b[r.pos]
+		r.pos++
+		if head == 0 {
+			return fields
+		}
+		if delta := int16(head >> 4); delta != 0 {
+			id += delta
+		} else {
+			id = int16(r.zigzag())
+		}
+		fields[id] = r.value(head & 0x0f)
+	}
+}
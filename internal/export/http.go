@@ -0,0 +1,69 @@
+package export
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"sistemas_distribuidos_gb/internal/history"
+)
+
+// fileStamp is how file names write the ends of the exported range.
+const fileStamp = "20060102T1504Z"
+
+// Serve answers an export request over s: kind (readings or alerts,
+// readings by default), format (csv, jsonl or parquet, csv by default),
+// gzip=1 to compress, and the time range and filters of history.ParseQuery,
+// relative to now. The records are streamed oldest hour first, as a file
+// download.
+func Serve(w http.ResponseWriter, r *http.Request, s *history.Store, now time.Time) {
+	params := r.URL.Query()
+	q, err := history.ParseQuery(params, now)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	format, err := ParseFormat(params.Get("format"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	kind := params.Get("kind")
+	columns := ReadingColumns
+	switch kind {
+	case "", "readings":
+		kind = "readings"
+	case "alerts":
+		columns = AlertColumns
+	default:
+		http.Error(w, fmt.Sprintf("unknown kind %q (want readings or alerts)", kind), http.StatusBadRequest)
+		return
+	}
+	compress := params.Get("gzip") == "1" || params.Get("gzip") == "true"
+
+	name := fmt.Sprintf("%s-%s-%s.%s", kind, q.From.UTC().Format(fileStamp), q.To.UTC().Format(fileStamp), format)
+	contentType := format.ContentType()
+	if compress && format != Parquet {
+		name += ".gz"
+		contentType = "application/gzip"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+
+	out, err := NewWriter(w, format, columns, compress)
+	if err == nil {
+		if kind == "alerts" {
+			err = s.EachAlert(q, func(a history.Alert) error { return out.Write(AlertRow(a)) })
+		} else {
+			err = s.EachReading(q, func(r history.Reading) error { return out.Write(ReadingRow(r)) })
+		}
+	}
+	if err == nil {
+		err = out.Close()
+	}
+	if err != nil {
+		// The headers are gone by now; a cut file is all the client sees
+		log.Printf("Error exporting %s to %s: %v", kind, r.RemoteAddr, err)
+	}
+}
@@ -0,0 +1,334 @@
+package export
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"io"
+	"math"
+)
+
+// Parquet files are written without a library: every column is REQUIRED and
+// PLAIN encoded, in one data page per column chunk, and the page headers and
+// footer use the Thrift compact protocol. Rows are buffered into row groups
+// of rowGroupSize, so exports stream in bounded memory.
+
+// rowGroupSize is how many rows a Parquet row group holds.
+const rowGroupSize = 50000
+
+// Parquet enum values, from parquet.thrift.
+const (
+	parquetInt64     = 2
+	parquetDouble    = 5
+	parquetByteArray = 6
+
+	parquetRequired = 0
+
+	convertedUTF8            = 0
+	convertedTimestampMillis = 9
+
+	encodingPlain = 0
+	encodingRLE   = 3
+
+	codecUncompressed = 0
+	codecGzip         = 2
+
+	pageData = 0
+)
+
+var parquetMagic = []byte("PAR1")
+
+type parquetWriter struct {
+	w        *countingWriter
+	columns  []Column
+	compress bool
+
+	values    []bytes.Buffer // PLAIN encoded values of the row group, per column
+	rows      int            // in the row group
+	total     int64
+	rowGroups []rowGroup
+	err       error
+}
+
+// rowGroup is what the footer records of a written row group.
+type rowGroup struct {
+	rows   int64
+	chunks []columnChunk
+}
+
+type columnChunk struct {
+	offset           int64
+	values           int64
+	uncompressedSize int64
+	compressedSize   int64
+}
+
+func newParquetWriter(w io.Writer, columns []Column, compress bool) *parquetWriter {
+	return &parquetWriter{w: &countingWriter{w: w}, columns: columns, compress: compress, values: make([]bytes.Buffer, len(columns))}
+}
+
+func (p *parquetWriter) Write(row []interface{}) error {
+	if p.err != nil {
+		return p.err
+	}
+	if p.w.n == 0 {
+		p.write(parquetMagic)
+	}
+	var scratch [8]byte
+	for i, v := range row {
+		buf := &p.values[i]
+		switch p.columns[i].Type {
+		case Time:
+			binary.LittleEndian.PutUint64(scratch[:], uint64(v.(int64)))
+			buf.Write(scratch[:])
+		case Float:
+			binary.LittleEndian.PutUint64(scratch[:], math.Float64bits(v.(float64)))
+			buf.Write(scratch[:])
+		default:
+			s := v.(string)
+			binary.LittleEndian.PutUint32(scratch[:4], uint32(len(s)))
+			buf.Write(scratch[:4])
+			buf.WriteString(s)
+		}
+	}
+	p.rows++
+	p.total++
+	if p.rows >= rowGroupSize {
+		p.flush()
+	}
+	return p.err
+}
+
+// flush writes the buffered rows as a row group.
+func (p *parquetWriter) flush() {
+	if p.rows == 0 {
+		return
+	}
+	group := rowGroup{rows: int64(p.rows)}
+	for i := range p.columns {
+		page := p.values[i].Bytes()
+		data := page
+		if p.compress {
+			var gz bytes.Buffer
+			zw := gzip.NewWriter(&gz)
+			zw.Write(page)
+			zw.Close()
+			data = gz.Bytes()
+		}
+
+		var header thriftWriter
+		header.i32(1, pageData)
+		header.i32(2, int32(len(page)))
+		header.i32(3, int32(len(data)))
+		header.beginStruct(5)
+		header.i32(1, int32(p.rows))
+		header.i32(2, encodingPlain)
+		header.i32(3, encodingRLE)
+		header.i32(4, encodingRLE)
+		header.endStruct()
+		header.stop()
+
+		chunk := columnChunk{
+			offset:           p.w.n,
+			values:           int64(p.rows),
+			uncompressedSize: int64(header.buf.Len() + len(page)),
+			compressedSize:   int64(header.buf.Len() + len(data)),
+		}
+		p.write(header.buf.Bytes())
+		p.write(data)
+		group.chunks = append(group.chunks, chunk)
+		p.values[i].Reset()
+	}
+	p.rowGroups = append(p.rowGroups, group)
+	p.rows = 0
+}
+
+// Close writes the last row group and the footer.
+func (p *parquetWriter) Close() error {
+	if p.err != nil {
+		return p.err
+	}
+	if p.w.n == 0 {
+		p.write(parquetMagic)
+	}
+	p.flush()
+
+	codec := int32(codecUncompressed)
+	if p.compress {
+		codec = codecGzip
+	}
+	var meta thriftWriter
+	meta.i32(1, 1)
+	meta.beginList(2, thriftStruct, len(p.columns)+1)
+	meta.beginElement()
+	meta.binary(4, "schema")
+	meta.i32(5, int32(len(p.columns)))
+	meta.endElement()
+	for _, c := range p.columns {
+		meta.beginElement()
+		meta.i32(1, physicalType(c.Type))
+		meta.i32(3, parquetRequired)
+		meta.binary(4, c.Name)
+		switch c.Type {
+		case String:
+			meta.i32(6, convertedUTF8)
+		case Time:
+			meta.i32(6, convertedTimestampMillis)
+		}
+		meta.endElement()
+	}
+	meta.i64(3, p.total)
+	meta.beginList(4, thriftStruct, len(p.rowGroups))
+	for _, g := range p.rowGroups {
+		var size int64
+		meta.beginElement()
+		meta.beginList(1, thriftStruct, len(g.chunks))
+		for i, chunk := range g.chunks {
+			size += chunk.uncompressedSize
+			meta.beginElement()
+			meta.i64(2, chunk.offset)
+			meta.beginStruct(3)
+			meta.i32(1, physicalType(p.columns[i].Type))
+			meta.beginList(2, thriftI32, 1)
+			meta.listI32(encodingPlain)
+			meta.beginList(3, thriftBinary, 1)
+			meta.listBinary(p.columns[i].Name)
+			meta.i32(4, codec)
+			meta.i64(5, chunk.values)
+			meta.i64(6, chunk.uncompressedSize)
+			meta.i64(7, chunk.compressedSize)
+			meta.i64(9, chunk.offset)
+			meta.endStruct()
+			meta.endElement()
+		}
+		meta.i64(2, size)
+		meta.i64(3, g.rows)
+		meta.endElement()
+	}
+	meta.binary(6, "sistemas_distribuidos_gb export")
+	meta.stop()
+
+	var length [4]byte
+	binary.LittleEndian.PutUint32(length[:], uint32(meta.buf.Len()))
+	p.write(meta.buf.Bytes())
+	p.write(length[:])
+	p.write(parquetMagic)
+	return p.err
+}
+
+func (p *parquetWriter) write(b []byte) {
+	if p.err == nil {
+		_, p.err = p.w.Write(b)
+	}
+}
+
+func physicalType(t Type) int32 {
+	switch t {
+	case Time:
+		return parquetInt64
+	case Float:
+		return parquetDouble
+	}
+	return parquetByteArray
+}
+
+// countingWriter counts the bytes written, for the offsets of the footer.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(b []byte) (int, error) {
+	n, err := c.w.Write(b)
+	c.n += int64(n)
+	return n, err
+}
+
+// Thrift compact protocol types.
+const (
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// thriftWriter encodes a Thrift struct with the compact protocol. Fields
+// are written in increasing ID order; nested structs and list elements keep
+// their own last field ID.
+type thriftWriter struct {
+	buf  bytes.Buffer
+	last []int16 // last field ID of the enclosing structs
+	id   int16   // last field ID of the current struct
+}
+
+func (t *thriftWriter) field(id int16, typ byte) {
+	if delta := id - t.id; delta > 0 && delta <= 15 {
+		t.buf.WriteByte(byte(delta)<<4 | typ)
+	} else {
+		t.buf.WriteByte(typ)
+		t.varint(zigzag(int64(id)))
+	}
+	t.id = id
+}
+
+func (t *thriftWriter) i32(id int16, v int32) {
+	t.field(id, thriftI32)
+	t.varint(zigzag(int64(v)))
+}
+
+func (t *thriftWriter) i64(id int16, v int64) {
+	t.field(id, thriftI64)
+	t.varint(zigzag(v))
+}
+
+func (t *thriftWriter) binary(id int16, s string) {
+	t.field(id, thriftBinary)
+	t.listBinary(s)
+}
+
+func (t *thriftWriter) beginList(id int16, elem byte, n int) {
+	t.field(id, thriftList)
+	if n < 15 {
+		t.buf.WriteByte(byte(n)<<4 | elem)
+	} else {
+		t.buf.WriteByte(0xf0 | elem)
+		t.varint(uint64(n))
+	}
+}
+
+func (t *thriftWriter) listI32(v int32) { t.varint(zigzag(int64(v))) }
+
+func (t *thriftWriter) listBinary(s string) {
+	t.varint(uint64(len(s)))
+	t.buf.WriteString(s)
+}
+
+// beginStruct starts a struct field; beginElement a struct list element.
+func (t *thriftWriter) beginStruct(id int16) {
+	t.field(id, thriftStruct)
+	t.beginElement()
+}
+
+func (t *thriftWriter) beginElement() {
+	t.last = append(t.last, t.id)
+	t.id = 0
+}
+
+func (t *thriftWriter) endStruct() {
+	t.stop()
+	t.id = t.last[len(t.last)-1]
+	t.last = t.last[:len(t.last)-1]
+}
+
+func (t *thriftWriter) endElement() { t.endStruct() }
+
+// stop ends the top-level struct.
+func (t *thriftWriter) stop() { t.buf.WriteByte(0) }
+
+func (t *thriftWriter) varint(v uint64) {
+	var b [binary.MaxVarintLen64]byte
+	t.buf.Write(b[:binary.PutUvarint(b[:], v)])
+}
+
+func zigzag(v int64) uint64 { return uint64(v<<1) ^ uint64(v>>63) }
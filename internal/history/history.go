@@ -0,0 +1,559 @@
+// Package history keeps the readings and alerts seen by the dashboard on
+// disk, in hourly JSON-lines segments, and answers time-range queries with
+// per-bucket aggregates sized for charts.
+package history
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"sistemas_distribuidos_gb/internal/clock"
+)
+
+// Reading is a stored filtered reading.
+type Reading struct {
+	SensorID  string  `json:"sensor_id"`
+	Site      string  `json:"site,omitempty"`
+	Line      string  `json:"line,omitempty"`
+	Location  string  `json:"location,omitempty"`
+	Unit      string  `json:"unit,omitempty"`
+	Value     float64 `json:"value"`
+	Timestamp int64   `json:"timestamp"`
+	EdgeID    string  `json:"edge_id"`
+}
+
+// Alert is a stored alert.
+type Alert struct {
+	SensorID  string  `json:"sensor_id"`
+	Site      string  `json:"site,omitempty"`
+	Line      string  `json:"line,omitempty"`
+	Location  string  `json:"location,omitempty"`
+	Unit      string  `json:"unit,omitempty"`
+	Value     float64 `json:"value"`
+	Timestamp int64   `json:"timestamp"`
+	EdgeID    string  `json:"edge_id"`
+	Type      string  `json:"type"`
+	Message   string  `json:"message"`
+	Detector  string  `json:"detector,omitempty"`
+	Score     float64 `json:"score,omitempty"`
+	Baseline  float64 `json:"baseline,omitempty"`
+}
+
+// Segment kinds, the prefix of their file names.
+const (
+	readingsKind = "readings"
+	alertsKind   = "alerts"
+)
+
+// segmentLayout names segments by their UTC hour: readings-2024010108.jsonl.
+const segmentLayout = "2006010215"
+
+// DefaultRetention is how long segments are kept by default.
+const DefaultRetention = 7 * 24 * time.Hour
+
+// flushInterval is how often buffered records are written out.
+const flushInterval = time.Second
+
+// MaxClockSkew is how far in the future a record may be. Records from before
+// the retention period, or further ahead, are rejected with ErrOutOfRange,
+// so that bad timestamps can't open a segment each.
+const MaxClockSkew = 5 * time.Minute
+
+// maxOpenSegments bounds the segments open for appending; the least recently
+// used is closed to open another.
+const maxOpenSegments = 8
+
+// ErrOutOfRange is returned for records outside the stored time range.
+var ErrOutOfRange = errors.New("timestamp out of the history range")
+
+// Store is an append-only store of readings and alerts.
+type Store struct {
+	dir       string
+	retention time.Duration
+	clock     clock.Clock
+
+	mu       sync.Mutex
+	segments map[string]*segment // open for appending, by file name
+	latest   map[string]Reading  // last reading per sensor
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+type segment struct {
+	hour time.Time
+	file *os.File
+	w    *bufio.Writer
+	used time.Time // last append, for closing the least recently used
+}
+
+// Open opens the store in dir, creating it if needed, and starts flushing
+// and pruning segments older than retention (DefaultRetention if zero). A nil
+// clock uses the wall clock.
+func Open(dir string, retention time.Duration, clk clock.Clock) (*Store, error) {
+	if dir == "" {
+		return nil, errors.New("no history directory")
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	if retention <= 0 {
+		retention = DefaultRetention
+	}
+	s := &Store{
+		dir:       dir,
+		retention: retention,
+		clock:     clock.Or(clk),
+		segments:  make(map[string]*segment),
+		latest:    make(map[string]Reading),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	s.prune()
+	s.loadLatest()
+
+	ticker := s.clock.NewTicker(flushInterval)
+	go func() {
+		defer close(s.done)
+		defer ticker.Stop()
+		lastPrune := s.clock.Now()
+		for {
+			select {
+			case <-ticker.C():
+				s.flush(true)
+				if now := s.clock.Now(); now.Sub(lastPrune) >= time.Hour {
+					s.prune()
+					lastPrune = now
+				}
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+	return s, nil
+}
+
+// Close flushes and closes the segments.
+func (s *Store) Close() error {
+	close(s.stop)
+	<-s.done
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var errs []error
+	for name, seg := range s.segments {
+		errs = append(errs, seg.w.Flush(), seg.file.Close())
+		delete(s.segments, name)
+	}
+	return errors.Join(errs...)
+}
+
+// AddReading stores a reading; a zero timestamp means now.
+func (s *Store) AddReading(r Reading) error {
+	if r.Timestamp == 0 {
+		r.Timestamp = s.clock.Now().UnixMilli()
+	}
+	if err := s.inRange(r.Timestamp); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if last, ok := s.latest[r.SensorID]; !ok || r.Timestamp >= last.Timestamp {
+		s.latest[r.SensorID] = r
+	}
+	return s.append(readingsKind, r.Timestamp, r)
+}
+
+// AddAlert stores an alert; a zero timestamp means now.
+func (s *Store) AddAlert(a Alert) error {
+	if a.Timestamp == 0 {
+		a.Timestamp = s.clock.Now().UnixMilli()
+	}
+	if err := s.inRange(a.Timestamp); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.append(alertsKind, a.Timestamp, a)
+}
+
+// inRange rejects timestamps from before the retention period or more than
+// MaxClockSkew ahead.
+func (s *Store) inRange(ts int64) error {
+	now := s.clock.Now()
+	if t := time.UnixMilli(ts); t.Before(now.Add(-s.retention)) || t.After(now.Add(MaxClockSkew)) {
+		return fmt.Errorf("%w: %s", ErrOutOfRange, t.UTC().Format(time.RFC3339))
+	}
+	return nil
+}
+
+func (s *Store) append(kind string, ts int64, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	hour := time.UnixMilli(ts).UTC().Truncate(time.Hour)
+	name := segmentName(kind, hour)
+	seg, ok := s.segments[name]
+	if !ok {
+		if len(s.segments) >= maxOpenSegments {
+			s.closeLeastUsed()
+		}
+		f, err := os.OpenFile(filepath.Join(s.dir, name), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+		if err != nil {
+			return err
+		}
+		seg = &segment{hour: hour, file: f, w: bufio.NewWriter(f)}
+		s.segments[name] = seg
+	}
+	seg.used = s.clock.Now()
+	seg.w.Write(data)
+	return seg.w.WriteByte('\n')
+}
+
+// closeLeastUsed flushes and closes the open segment appended to least
+// recently. Called with s.mu held.
+func (s *Store) closeLeastUsed() {
+	var oldest string
+	for name, seg := range s.segments {
+		if oldest == "" || seg.used.Before(s.segments[oldest].used) {
+			oldest = name
+		}
+	}
+	seg := s.segments[oldest]
+	if err := seg.w.Flush(); err != nil {
+		log.Printf("Error writing history segment %s: %v", oldest, err)
+	}
+	seg.file.Close()
+	delete(s.segments, oldest)
+}
+
+// flush writes out the buffered records. With closeOld, it also closes the
+// segments of past hours, which late records reopen.
+func (s *Store) flush(closeOld bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	current := s.clock.Now().UTC().Truncate(time.Hour)
+	for name, seg := range s.segments {
+		if err := seg.w.Flush(); err != nil {
+			log.Printf("Error writing history segment %s: %v", name, err)
+		}
+		if closeOld && seg.hour.Before(current) {
+			seg.file.Close()
+			delete(s.segments, name)
+		}
+	}
+}
+
+// prune deletes the segments that ended before the retention period.
+func (s *Store) prune() {
+	cutoff := s.clock.Now().Add(-s.retention)
+	for _, kind := range []string{readingsKind, alertsKind} {
+		for _, seg := range s.list(kind) {
+			if seg.hour.Add(time.Hour).After(cutoff) {
+				continue
+			}
+			s.mu.Lock()
+			if open, ok := s.segments[seg.name]; ok {
+				open.file.Close()
+				delete(s.segments, seg.name)
+			}
+			s.mu.Unlock()
+			if err := os.Remove(filepath.Join(s.dir, seg.name)); err != nil {
+				log.Printf("Error pruning history segment %s: %v", seg.name, err)
+			}
+		}
+	}
+}
+
+// loadLatest seeds the last reading of every sensor from the newest segment.
+func (s *Store) loadLatest() {
+	segs := s.list(readingsKind)
+	if len(segs) == 0 {
+		return
+	}
+	scan(filepath.Join(s.dir, segs[len(segs)-1].name), func(line []byte) error {
+		var r Reading
+		if json.Unmarshal(line, &r) == nil {
+			if last, ok := s.latest[r.SensorID]; !ok || r.Timestamp >= last.Timestamp {
+				s.latest[r.SensorID] = r
+			}
+		}
+		return nil
+	})
+}
+
+type segmentFile struct {
+	name string
+	hour time.Time
+}
+
+func segmentName(kind string, hour time.Time) string {
+	return kind + "-" + hour.Format(segmentLayout) + ".jsonl"
+}
+
+// list returns the segments of a kind, oldest first.
+func (s *Store) list(kind string) []segmentFile {
+	entries, _ := os.ReadDir(s.dir)
+	var segs []segmentFile
+	for _, e := range entries {
+		stamp, ok := strings.CutPrefix(e.Name(), kind+"-")
+		if !ok {
+			continue
+		}
+		hour, err := time.Parse(segmentLayout, strings.TrimSuffix(stamp, ".jsonl"))
+		if err != nil {
+			continue
+		}
+		segs = append(segs, segmentFile{name: e.Name(), hour: hour})
+	}
+	sort.Slice(segs, func(i, j int) bool { return segs[i].hour.Before(segs[j].hour) })
+	return segs
+}
+
+// read calls fn with every line of the segments of a kind that overlap
+// [from, to), oldest segment first, until fn returns an error.
+func (s *Store) read(kind string, from, to time.Time, fn func(line []byte) error) error {
+	s.flush(false)
+	for _, seg := range s.list(kind) {
+		if !seg.hour.Before(to) || !seg.hour.Add(time.Hour).After(from) {
+			continue
+		}
+		if err := scan(filepath.Join(s.dir, seg.name), fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// scan calls fn with every line of a file until it returns an error. A line
+// still being written is skipped by the callers, as it doesn't decode.
+func scan(path string, fn func(line []byte) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64<<10), 1<<20)
+	for sc.Scan() {
+		if err := fn(sc.Bytes()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Query selects the records of a time range.
+type Query struct {
+	From, To time.Time
+	Sensor   string // all sensors if empty
+	Edge     string // all edges if empty
+	// Step is the bucket width of ReadingSeries, at least 1ms; zero picks
+	// one that makes at most MaxBuckets buckets
+	Step time.Duration
+}
+
+// MaxBuckets bounds the buckets of a series with an automatic step.
+const MaxBuckets = 500
+
+// DefaultRange is the time range of a parsed query without one.
+const DefaultRange = time.Hour
+
+// ParseQuery reads the time range and filters of a query string: range=15m
+// for the 15 minutes before now, or from and to as Unix milliseconds or RFC
+// 3339 times (to defaults to now and from to DefaultRange before it), plus
+// sensor, edge and step.
+func ParseQuery(params url.Values, now time.Time) (Query, error) {
+	q := Query{Sensor: params.Get("sensor"), Edge: params.Get("edge")}
+	if s := params.Get("range"); s != "" {
+		span, err := time.ParseDuration(s)
+		if err != nil || span <= 0 {
+			return q, fmt.Errorf("invalid range %q", s)
+		}
+		q.From, q.To = now.Add(-span), now
+	} else {
+		q.To = now
+		if s := params.Get("to"); s != "" {
+			t, err := parseTime(s)
+			if err != nil {
+				return q, err
+			}
+			q.To = t
+		}
+		q.From = q.To.Add(-DefaultRange)
+		if s := params.Get("from"); s != "" {
+			t, err := parseTime(s)
+			if err != nil {
+				return q, err
+			}
+			q.From = t
+		}
+	}
+	if !q.To.After(q.From) {
+		return q, fmt.Errorf("from must be before to")
+	}
+
+	if s := params.Get("step"); s != "" {
+		step, err := time.ParseDuration(s)
+		if err != nil {
+			return q, fmt.Errorf("invalid step %q", s)
+		}
+		if step < time.Millisecond {
+			return q, fmt.Errorf("step must be at least 1ms")
+		}
+		q.Step = step
+	}
+	return q, nil
+}
+
+// parseTime reads Unix milliseconds or an RFC 3339 time.
+func parseTime(s string) (time.Time, error) {
+	if ms, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.UnixMilli(ms), nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q (want Unix milliseconds or RFC 3339)", s)
+	}
+	return t, nil
+}
+
+func (q Query) matches(sensorID, edgeID string, ts int64) bool {
+	return (q.Sensor == "" || q.Sensor == sensorID) && (q.Edge == "" || q.Edge == edgeID) &&
+		ts >= q.From.UnixMilli() && ts < q.To.UnixMilli()
+}
+
+// Bucket aggregates the readings of a step.
+type Bucket struct {
+	Time  int64   `json:"t"` // start, Unix milliseconds
+	Count int     `json:"count"`
+	Min   float64 `json:"min"`
+	Max   float64 `json:"max"`
+	Mean  float64 `json:"mean"`
+}
+
+// Series is the answer to a reading query. Buckets without readings are
+// left out.
+type Series struct {
+	From    int64    `json:"from"`
+	To      int64    `json:"to"`
+	Step    int64    `json:"step"` // milliseconds
+	Sensor  string   `json:"sensor,omitempty"`
+	Edge    string   `json:"edge,omitempty"`
+	Count   int      `json:"count"`
+	Buckets []Bucket `json:"buckets"`
+}
+
+// ReadingSeries aggregates the readings matching q into buckets of q.Step.
+func (s *Store) ReadingSeries(q Query) (Series, error) {
+	if !q.To.After(q.From) {
+		return Series{}, fmt.Errorf("empty time range %v - %v", q.From, q.To)
+	}
+	step := q.Step
+	if step == 0 {
+		step = q.To.Sub(q.From) / MaxBuckets
+		step = max((step + time.Second - 1).Truncate(time.Second), time.Second)
+	}
+	if step < time.Millisecond {
+		return Series{}, fmt.Errorf("step %v is under 1ms", step)
+	}
+	if q.To.Sub(q.From)/step > 10*MaxBuckets {
+		return Series{}, fmt.Errorf("step %v is too small for the range", step)
+	}
+	stepMs := step.Milliseconds()
+	from := q.From.UnixMilli()
+
+	buckets := map[int64]*Bucket{}
+	sums := map[int64]float64{}
+	s.read(readingsKind, q.From, q.To, func(line []byte) error {
+		var r Reading
+		if json.Unmarshal(line, &r) != nil || !q.matches(r.SensorID, r.EdgeID, r.Timestamp) {
+			return nil
+		}
+		start := from + (r.Timestamp-from)/stepMs*stepMs
+		b, ok := buckets[start]
+		if !ok {
+			b = &Bucket{Time: start, Min: math.Inf(1), Max: math.Inf(-1)}
+			buckets[start] = b
+		}
+		b.Count++
+		b.Min = math.Min(b.Min, r.Value)
+		b.Max = math.Max(b.Max, r.Value)
+		sums[start] += r.Value
+		return nil
+	})
+
+	series := Series{From: from, To: q.To.UnixMilli(), Step: stepMs, Sensor: q.Sensor, Edge: q.Edge, Buckets: make([]Bucket, 0, len(buckets))}
+	for start, b := range buckets {
+		b.Mean = sums[start] / float64(b.Count)
+		series.Count += b.Count
+		series.Buckets = append(series.Buckets, *b)
+	}
+	sort.Slice(series.Buckets, func(i, j int) bool { return series.Buckets[i].Time < series.Buckets[j].Time })
+	return series, nil
+}
+
+// Alerts returns up to limit alerts matching q, newest first.
+func (s *Store) Alerts(q Query, limit int) []Alert {
+	alerts := []Alert{}
+	s.read(alertsKind, q.From, q.To, func(line []byte) error {
+		var a Alert
+		if json.Unmarshal(line, &a) == nil && q.matches(a.SensorID, a.EdgeID, a.Timestamp) {
+			alerts = append(alerts, a)
+		}
+		return nil
+	})
+	sort.SliceStable(alerts, func(i, j int) bool { return alerts[i].Timestamp > alerts[j].Timestamp })
+	if limit > 0 && len(alerts) > limit {
+		alerts = alerts[:limit]
+	}
+	return alerts
+}
+
+// EachReading calls fn with the readings matching q, hour by hour and in
+// the order they were stored within an hour, so without loading them all. It
+// stops at the first error fn returns, and returns it.
+func (s *Store) EachReading(q Query, fn func(Reading) error) error {
+	return s.read(readingsKind, q.From, q.To, func(line []byte) error {
+		var r Reading
+		if json.Unmarshal(line, &r) != nil || !q.matches(r.SensorID, r.EdgeID, r.Timestamp) {
+			return nil
+		}
+		return fn(r)
+	})
+}
+
+// EachAlert calls fn with the alerts matching q, as EachReading does.
+func (s *Store) EachAlert(q Query, fn func(Alert) error) error {
+	return s.read(alertsKind, q.From, q.To, func(line []byte) error {
+		var a Alert
+		if json.Unmarshal(line, &a) != nil || !q.matches(a.SensorID, a.EdgeID, a.Timestamp) {
+			return nil
+		}
+		return fn(a)
+	})
+}
+
+// Latest returns the last stored reading of every sensor, by sensor ID.
+func (s *Store) Latest() []Reading {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	readings := make([]Reading, 0, len(s.latest))
+	for _, r := range s.latest {
+		readings = append(readings, r)
+	}
+	sort.Slice(readings, func(i, j int) bool { return readings[i].SensorID < readings[j].SensorID })
+	return readings
+}
@@ -0,0 +1,160 @@
+package history
+
+import (
+	"errors"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"sistemas_distribuidos_gb/internal/clock"
+)
+
+func TestReadingSeries(t *testing.T) {
+	dir := t.TempDir()
+	start := time.Date(2024, 1, 1, 8, 0, 0, 0, time.UTC)
+	clk := clock.NewFake(start.Add(2 * time.Hour))
+	s, err := Open(dir, 0, clk)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Readings every 10 minutes over two hours, from two sensors
+	for i := 0; i < 12; i++ {
+		ts := start.Add(time.Duration(i) * 10 * time.Minute).UnixMilli()
+		s.AddReading(Reading{SensorID: "s1", EdgeID: "e1", Value: float64(i), Timestamp: ts})
+		s.AddReading(Reading{SensorID: "s2", EdgeID: "e2", Value: 100, Timestamp: ts})
+	}
+	s.AddAlert(Alert{SensorID: "s1", EdgeID: "e1", Type: "critical", Timestamp: start.Add(15 * time.Minute).UnixMilli()})
+	s.AddAlert(Alert{SensorID: "s1", EdgeID: "e1", Type: "warning", Timestamp: start.Add(75 * time.Minute).UnixMilli()})
+	s.AddAlert(Alert{SensorID: "s2", EdgeID: "e2", Type: "anomaly", Timestamp: start.Add(80 * time.Minute).UnixMilli()})
+
+	series, err := s.ReadingSeries(Query{From: start, To: start.Add(2 * time.Hour), Sensor: "s1", Step: 30 * time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if series.Count != 12 || len(series.Buckets) != 4 {
+		t.Fatalf("got %d readings in %d buckets, want 12 in 4: %+v", series.Count, len(series.Buckets), series)
+	}
+	if b := series.Buckets[1]; b.Time != start.Add(30*time.Minute).UnixMilli() || b.Count != 3 || b.Min != 3 || b.Max != 5 || b.Mean != 4 {
+		t.Errorf("second bucket %+v", b)
+	}
+
+	// An automatic step covers the range in at most MaxBuckets buckets
+	series, _ = s.ReadingSeries(Query{From: start, To: start.Add(time.Hour), Edge: "e2"})
+	if series.Step != 8000 || series.Count != 6 {
+		t.Errorf("auto step %dms with %d readings, want 8000ms with 6", series.Step, series.Count)
+	}
+
+	alerts := s.Alerts(Query{From: start, To: start.Add(2 * time.Hour), Sensor: "s1"}, 0)
+	if len(alerts) != 2 || alerts[0].Type != "warning" {
+		t.Errorf("alerts of s1 %+v, want 2 newest first", alerts)
+	}
+	if alerts := s.Alerts(Query{From: start.Add(time.Hour), To: start.Add(2 * time.Hour)}, 1); len(alerts) != 1 || alerts[0].Type != "anomaly" {
+		t.Errorf("limited alerts %+v", alerts)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// History survives a restart
+	s, err = Open(dir, 0, clk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	latest := s.Latest()
+	if len(latest) != 2 || latest[0].SensorID != "s1" || latest[0].Value != 11 {
+		t.Errorf("latest readings after reopening %+v", latest)
+	}
+	series, _ = s.ReadingSeries(Query{From: start, To: start.Add(2 * time.Hour), Step: time.Hour})
+	if series.Count != 24 {
+		t.Errorf("got %d readings after reopening, want 24", series.Count)
+	}
+}
+
+func TestRetention(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Date(2024, 1, 10, 8, 30, 0, 0, time.UTC)
+	clk := clock.NewFake(now)
+	s, err := Open(dir, 24*time.Hour, clk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, age := range []time.Duration{30 * time.Minute, 23 * time.Hour, 26 * time.Hour} {
+		s.AddReading(Reading{SensorID: "s1", Timestamp: now.Add(-age).UnixMilli()})
+	}
+	s.Close()
+
+	s, err = Open(dir, 24*time.Hour, clk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 2 {
+		t.Errorf("%d segments left, want 2", len(entries))
+	}
+}
+
+func TestStepUnderMillisecond(t *testing.T) {
+	now := time.Date(2024, 1, 1, 8, 0, 0, 0, time.UTC)
+	if _, err := ParseQuery(url.Values{"range": {"1m"}, "step": {"500us"}}, now); err == nil {
+		t.Error("ParseQuery accepted a 500us step")
+	}
+	if q, err := ParseQuery(url.Values{"range": {"1m"}, "step": {"1ms"}}, now); err != nil || q.Step != time.Millisecond {
+		t.Errorf("1ms step: %+v, %v", q, err)
+	}
+
+	s, err := Open(t.TempDir(), 0, clock.NewFake(now))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if _, err := s.ReadingSeries(Query{From: now.Add(-time.Minute), To: now, Step: time.Microsecond}); err == nil {
+		t.Error("ReadingSeries accepted a 1us step")
+	}
+	// An automatic step over a range shorter than MaxBuckets nanoseconds
+	if series, err := s.ReadingSeries(Query{From: now.Add(-time.Microsecond), To: now}); err != nil || series.Step != 1000 {
+		t.Errorf("auto step over 1us: %+v, %v", series, err)
+	}
+}
+
+func TestOutOfRange(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Date(2024, 1, 10, 8, 30, 0, 0, time.UTC)
+	s, err := Open(dir, 24*time.Hour, clock.NewFake(now))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ts := range []time.Time{now.Add(-25 * time.Hour), now.Add(time.Hour), time.UnixMilli(1)} {
+		if err := s.AddReading(Reading{SensorID: "s1", Timestamp: ts.UnixMilli()}); !errors.Is(err, ErrOutOfRange) {
+			t.Errorf("reading at %v: %v, want ErrOutOfRange", ts, err)
+		}
+	}
+	if err := s.AddAlert(Alert{SensorID: "s1", Timestamp: now.Add(-25 * time.Hour).UnixMilli()}); !errors.Is(err, ErrOutOfRange) {
+		t.Errorf("old alert: %v, want ErrOutOfRange", err)
+	}
+	if latest := s.Latest(); len(latest) != 0 {
+		t.Errorf("rejected readings became the latest: %+v", latest)
+	}
+
+	// Readings spread over the whole retention period keep a bounded set of
+	// segments open, and none is lost
+	for h := 0; h < 24; h++ {
+		if err := s.AddReading(Reading{SensorID: "s1", Timestamp: now.Add(-time.Duration(h) * time.Hour).UnixMilli()}); err != nil {
+			t.Fatal(err)
+		}
+		s.mu.Lock()
+		open := len(s.segments)
+		s.mu.Unlock()
+		if open > maxOpenSegments {
+			t.Fatalf("%d segments open, want at most %d", open, maxOpenSegments)
+		}
+	}
+	series, _ := s.ReadingSeries(Query{From: now.Add(-24 * time.Hour), To: now.Add(time.Minute), Step: time.Hour})
+	if series.Count != 24 {
+		t.Errorf("got %d readings, want 24", series.Count)
+	}
+	s.Close()
+}
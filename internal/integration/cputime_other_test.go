@@ -0,0 +1,8 @@
+//go:build !unix
+
+package integration
+
+import "time"
+
+// cpuTime isn't measured on this platform.
+func cpuTime() time.Duration { return -1 }
@@ -0,0 +1,10 @@
+// Package integration holds the end-to-end tests of the pipeline:
+// scalability, latency, edge failure with and without JetStream, noise
+// filtering and resource usage. They run every component in-process on an
+// embedded NATS server, so they need no external service:
+//
+//	go test ./internal/integration -args -integration.report logs/integration.json
+//
+// -integration.duration sets how long each load phase runs; -short skips the
+// suite.
+package integration
@@ -0,0 +1,113 @@
+package integration
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+
+	"sistemas_distribuidos_gb/internal/allinone"
+	"sistemas_distribuidos_gb/internal/broker"
+	"sistemas_distribuidos_gb/internal/edge"
+	"sistemas_distribuidos_gb/internal/subjects"
+)
+
+// FailureResult is the outcome of taking the edge down while sensors keep
+// publishing.
+type FailureResult struct {
+	Published int `json:"published"`
+	Delivered int `json:"delivered"` // distinct readings that reached the cloud
+	Lost      int `json:"lost"`
+	// Recovered are the readings published while the edge was down that it
+	// processed after restarting.
+	Recovered int `json:"recovered"`
+	// Redelivered counts readings delivered more than once.
+	Redelivered int `json:"redelivered"`
+}
+
+// TestEdgeFailure stops the edge for a while and restarts it. Without
+// JetStream the readings published meanwhile are lost; with it the restarted
+// edge picks them up from its durable consumer.
+func TestEdgeFailure(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		jetStream bool
+	}{
+		{"without_jetstream", false},
+		{"with_jetstream", true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			e := startEnv(t, 1, 5, 50*time.Millisecond, func(o *allinone.Options) {
+				o.Edge.JetStream = tc.jetStream
+			})
+			phase := *duration / 2
+
+			time.Sleep(phase)
+			e.system.Edges[0].Stop()
+			// Let in-flight readings land before counting
+			time.Sleep(100 * time.Millisecond)
+			_, beforeRestart := e.cloud.counts()
+
+			time.Sleep(phase)
+			published := e.stopSensors()
+
+			restarted := e.restartEdge(0)
+			defer restarted.Stop()
+			// Readings the stopped edge had buffered but not acked come back
+			// once the consumer's ack wait expires
+			e.cloud.waitFor(published, 7*time.Second, 15*time.Second)
+
+			delivered, unique := e.cloud.counts()
+			r := FailureResult{
+				Published:   published,
+				Delivered:   unique,
+				Lost:        published - unique,
+				Recovered:   unique - beforeRestart,
+				Redelivered: delivered - unique,
+			}
+			report.add(func(rep *Report) { rep.EdgeFailure[tc.name] = r })
+			t.Logf("%s: published %d, delivered %d, lost %d, recovered after restart %d, redelivered %d",
+				tc.name, r.Published, r.Delivered, r.Lost, r.Recovered, r.Redelivered)
+
+			if tc.jetStream {
+				if r.Lost != 0 {
+					t.Errorf("lost %d readings with JetStream, want 0", r.Lost)
+				}
+				if r.Recovered == 0 {
+					t.Error("restarted edge recovered no readings")
+				}
+			} else {
+				if r.Lost == 0 {
+					t.Error("no readings lost while the edge was down without JetStream")
+				}
+				if r.Recovered != 0 {
+					t.Errorf("recovered %d readings without JetStream, want 0", r.Recovered)
+				}
+			}
+		})
+	}
+}
+
+// restartEdge starts a new edge with the ID and subjects of edge i, as a
+// restarted process would.
+func (e *env) restartEdge(i int) *edge.Edge {
+	e.t.Helper()
+	opts := e.opts.Edge
+	opts.ID = e.system.Edges[i].ID()
+	opts.Subjects = []string{subjects.LineReadings(e.opts.Site, allinone.Line(i))}
+
+	nc, err := nats.Connect(e.ns.ClientURL(), nats.Name("edge-"+opts.ID))
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	e.t.Cleanup(nc.Close)
+	node, err := edge.New(broker.NewNATS(nc), opts)
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	if err := node.Start(context.Background()); err != nil {
+		e.t.Fatal(err)
+	}
+	return node
+}
@@ -0,0 +1,322 @@
+package integration
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+
+	"sistemas_distribuidos_gb/internal/allinone"
+	"sistemas_distribuidos_gb/internal/embedded"
+	"sistemas_distribuidos_gb/internal/subjects"
+)
+
+var (
+	reportPath = flag.String("integration.report", "", "Write the integration report as JSON to this file")
+	duration   = flag.Duration("integration.duration", 2*time.Second, "How long each load phase runs")
+)
+
+// Report gathers the figures of every test, written by TestMain when
+// -integration.report is set. Tests that didn't run are left out.
+type Report struct {
+	mu             sync.Mutex
+	Scalability    []LoadResult             `json:"scalability,omitempty"`
+	Latency        *LoadResult              `json:"latency,omitempty"`
+	EdgeFailure    map[string]FailureResult `json:"edge_failure,omitempty"`
+	NoiseFiltering *NoiseResult             `json:"noise_filtering,omitempty"`
+	Resources      []ResourceResult         `json:"resources,omitempty"`
+}
+
+var report = &Report{EdgeFailure: map[string]FailureResult{}}
+
+func (r *Report) add(f func(r *Report)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	f(r)
+}
+
+func TestMain(m *testing.M) {
+	flag.Parse()
+	log.SetOutput(io.Discard)
+	code := m.Run()
+	log.SetOutput(os.Stderr)
+
+	if *reportPath != "" {
+		report.mu.Lock()
+		data, err := json.MarshalIndent(report, "", "  ")
+		report.mu.Unlock()
+		if err == nil {
+			err = os.WriteFile(*reportPath, data, 0o644)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "write integration report: %v\n", err)
+			code = 1
+		}
+	}
+	os.Exit(code)
+}
+
+// LoadResult is what the cloud side saw of a load phase.
+type LoadResult struct {
+	Sensors    int            `json:"sensors"`
+	Interval   string         `json:"interval"`
+	Duration   string         `json:"duration"`
+	Published  int            `json:"published"`
+	Delivered  int            `json:"delivered"`
+	Lost       int            `json:"lost"`
+	LossRate   float64        `json:"loss_rate"`
+	MsgsPerSec float64        `json:"msgs_per_sec"`
+	Latency    LatencySummary `json:"latency"`
+}
+
+// LatencySummary is the sensor-to-cloud latency of the delivered readings, in
+// milliseconds.
+type LatencySummary struct {
+	AvgMs float64 `json:"avg_ms"`
+	P95Ms float64 `json:"p95_ms"`
+	P99Ms float64 `json:"p99_ms"`
+	MaxMs float64 `json:"max_ms"`
+}
+
+// env is a pipeline running on its own embedded NATS server.
+type env struct {
+	t       *testing.T
+	ns      *server.Server
+	opts    allinone.Options
+	system  *allinone.System
+	handler http.Handler
+	cloud   *collector
+}
+
+// startEnv starts a pipeline with the given number of edges and sensors;
+// configure adjusts the options further.
+func startEnv(t *testing.T, edges, sensors int, interval time.Duration, configure func(*allinone.Options)) *env {
+	t.Helper()
+	if testing.Short() {
+		t.Skip("integration tests skipped in short mode")
+	}
+
+	dir := t.TempDir()
+	ns, err := embedded.Start(embedded.Options{JetStream: true, StoreDir: filepath.Join(dir, "jetstream")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(ns.Shutdown)
+
+	opts := allinone.DefaultOptions()
+	opts.Edges = edges
+	opts.Sensors = sensors
+	opts.KeyDir = filepath.Join(dir, "keys")
+	opts.Cloud.RegistryFile = filepath.Join(dir, "registry.json")
+	opts.Dashboard.HistoryDir = filepath.Join(dir, "history")
+	opts.Cloud.HistoryDir = filepath.Join(dir, "cloud-history")
+	opts.Cloud.UseConfig = false
+	opts.Dashboard.UseConfig = false
+	opts.Edge.UseConfig = false
+	opts.Sensor.UseConfig = false
+	opts.Sensor.Interval = interval
+	opts.Connect = func(name string) (*nats.Conn, error) {
+		// Generous timeout: 100 sensors connect at once, slowly under -race
+		return nats.Connect(ns.ClientURL(), nats.Name(name), nats.Timeout(10*time.Second))
+	}
+	if configure != nil {
+		configure(&opts)
+	}
+
+	// The collector stands for the cloud: it sees what the edges deliver
+	c, err := newCollector(ns.ClientURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(c.close)
+
+	system, err := allinone.Start(context.Background(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(system.Stop)
+
+	return &env{t: t, ns: ns, opts: opts, system: system, handler: system.Handler(), cloud: c}
+}
+
+// stopSensors stops every sensor and returns how many readings they
+// published.
+func (e *env) stopSensors() int {
+	published := 0
+	for _, s := range e.system.Sensors {
+		s.Stop()
+		var status struct {
+			TotalReadings int `json:"total_readings"`
+		}
+		e.get("/sensor/"+s.ID()+"/status", &status)
+		published += status.TotalReadings
+	}
+	return published
+}
+
+// get decodes the JSON served by the pipeline at path.
+func (e *env) get(path string, v any) {
+	e.t.Helper()
+	rec := httptest.NewRecorder()
+	e.handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	if rec.Code != http.StatusOK {
+		e.t.Fatalf("GET %s: %d %s", path, rec.Code, rec.Body.String())
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+		e.t.Fatalf("GET %s: %v", path, err)
+	}
+}
+
+// edgeMetrics returns the signature counters of an edge.
+func (e *env) edgeMetrics(id string) (failures, quarantined int) {
+	var m struct {
+		SignatureFailures int `json:"signature_failures"`
+		Quarantined       int `json:"quarantined"`
+	}
+	e.get("/edge/"+id+"/metrics", &m)
+	return m.SignatureFailures, m.Quarantined
+}
+
+// runLoad lets the sensors publish for d, stops them, waits for the edges to
+// deliver what was published and summarises the phase.
+func (e *env) runLoad(d time.Duration) LoadResult {
+	start := time.Now()
+	time.Sleep(d)
+	published := e.stopSensors()
+	elapsed := time.Since(start)
+	delivered := e.cloud.waitFor(published, time.Second, 10*time.Second)
+
+	r := LoadResult{
+		Sensors:    len(e.system.Sensors),
+		Interval:   e.opts.Sensor.Interval.String(),
+		Duration:   elapsed.Round(time.Millisecond).String(),
+		Published:  published,
+		Delivered:  delivered,
+		Lost:       published - delivered,
+		MsgsPerSec: float64(delivered) / elapsed.Seconds(),
+		Latency:    e.cloud.latency(),
+	}
+	if published > 0 {
+		r.LossRate = float64(r.Lost) / float64(published)
+	}
+	return r
+}
+
+// collector counts the filtered readings the edges publish and measures
+// their latency from the sensor timestamp.
+type collector struct {
+	nc *nats.Conn
+
+	mu        sync.Mutex
+	delivered int
+	// unique holds sensor/timestamp/value keys, to spot redeliveries; at
+	// 10ms intervals two readings can share a millisecond
+	unique    map[string]bool
+	latencies []time.Duration
+	last      time.Time
+}
+
+func newCollector(url string) (*collector, error) {
+	nc, err := nats.Connect(url, nats.Name("integration-collector"))
+	if err != nil {
+		return nil, err
+	}
+	c := &collector{nc: nc, unique: map[string]bool{}}
+	sub, err := nc.Subscribe(subjects.AllFiltered, c.handle)
+	if err != nil {
+		nc.Close()
+		return nil, err
+	}
+	sub.SetPendingLimits(-1, -1)
+	return c, nc.Flush()
+}
+
+func (c *collector) handle(msg *nats.Msg) {
+	var r struct {
+		SensorID  string  `json:"sensor_id"`
+		Value     float64 `json:"value"`
+		Timestamp int64   `json:"timestamp"`
+	}
+	if json.Unmarshal(msg.Data, &r) != nil {
+		return
+	}
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.delivered++
+	c.unique[fmt.Sprintf("%s/%d/%v", r.SensorID, r.Timestamp, r.Value)] = true
+	c.latencies = append(c.latencies, now.Sub(time.UnixMilli(r.Timestamp)))
+	c.last = now
+}
+
+func (c *collector) close() { c.nc.Close() }
+
+// counts returns the number of readings received and of distinct readings.
+func (c *collector) counts() (delivered, unique int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.delivered, len(c.unique)
+}
+
+// waitFor waits until n distinct readings arrived, or until nothing arrived
+// for idle since the call, or for timeout. It returns the distinct readings
+// received.
+func (c *collector) waitFor(n int, idle, timeout time.Duration) int {
+	start := time.Now()
+	deadline := start.Add(timeout)
+	for {
+		c.mu.Lock()
+		last := c.last
+		if last.Before(start) {
+			last = start
+		}
+		unique, quiet := len(c.unique), time.Since(last) > idle
+		c.mu.Unlock()
+		if unique >= n || quiet || time.Now().After(deadline) {
+			return unique
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func (c *collector) latency() LatencySummary {
+	c.mu.Lock()
+	sorted := append([]time.Duration(nil), c.latencies...)
+	c.mu.Unlock()
+	if len(sorted) == 0 {
+		return LatencySummary{}
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	var sum time.Duration
+	for _, l := range sorted {
+		sum += l
+	}
+	ms := func(d time.Duration) float64 { return float64(d) / float64(time.Millisecond) }
+	pct := func(p float64) time.Duration {
+		i := int(float64(len(sorted)) * p)
+		if i >= len(sorted) {
+			i = len(sorted) - 1
+		}
+		return sorted[i]
+	}
+	return LatencySummary{
+		AvgMs: ms(sum / time.Duration(len(sorted))),
+		P95Ms: ms(pct(0.95)),
+		P99Ms: ms(pct(0.99)),
+		MaxMs: ms(sorted[len(sorted)-1]),
+	}
+}
@@ -0,0 +1,26 @@
+package integration
+
+import (
+	"testing"
+	"time"
+)
+
+// maxP99 bounds the sensor-to-cloud latency. Everything runs in one process,
+// so this only catches readings stuck in a queue.
+const maxP99 = 250 * time.Millisecond
+
+// TestLatency measures the sensor → edge → cloud latency of 10 sensors.
+func TestLatency(t *testing.T) {
+	e := startEnv(t, 1, 10, 50*time.Millisecond, nil)
+	r := e.runLoad(*duration)
+	report.add(func(rep *Report) { rep.Latency = &r })
+
+	t.Logf("latency over %d readings: avg %.2fms, p95 %.2fms, p99 %.2fms, max %.2fms",
+		r.Delivered, r.Latency.AvgMs, r.Latency.P95Ms, r.Latency.P99Ms, r.Latency.MaxMs)
+	if r.Delivered == 0 {
+		t.Fatal("no readings delivered")
+	}
+	if p99 := time.Duration(r.Latency.P99Ms * float64(time.Millisecond)); p99 > maxP99 {
+		t.Errorf("p99 latency = %v, want <= %v", p99, maxP99)
+	}
+}
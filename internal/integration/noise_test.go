@@ -0,0 +1,75 @@
+package integration
+
+import (
+	"testing"
+
+	"sistemas_distribuidos_gb/internal/anomaly"
+	"sistemas_distribuidos_gb/internal/evaluation"
+)
+
+// maxFalseAlarmRate bounds the alerts the default detectors may raise on
+// noise alone, per reading.
+const maxFalseAlarmRate = 0.005
+
+// NoiseResult is how the default detectors behave on a noisy signal without
+// anomalies, and how well they find the injected ones.
+type NoiseResult struct {
+	Readings        int                        `json:"readings"`
+	FalseAlarms     map[string]int             `json:"false_alarms"`
+	FalseAlarmRate  map[string]float64         `json:"false_alarm_rate"`
+	WithAnomalies   []evaluation.DetectorScore `json:"with_anomalies"`
+	AnomalyEpisodes int                        `json:"anomaly_episodes"`
+}
+
+// TestNoiseFiltering plays noisy sensors through the pipeline with the
+// default detectors, first without anomalies, where every alert is a false
+// alarm, then with drifts and spikes. The per-configuration comparison lives
+// in the evaluation package (TestDetectionQuality).
+func TestNoiseFiltering(t *testing.T) {
+	if testing.Short() {
+		t.Skip("integration tests skipped in short mode")
+	}
+
+	detectors := anomaly.DefaultConfig()
+
+	clean := evaluation.DefaultScenario()
+	clean.Samples = 1000
+	clean.DriftChance, clean.SpikeChance = 0, 0
+	quiet, err := evaluation.Run(clean, detectors)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	noisy := evaluation.DefaultScenario()
+	noisy.Samples = 1000
+	loud, err := evaluation.Run(noisy, detectors)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := NoiseResult{
+		Readings:        quiet.Published,
+		FalseAlarms:     map[string]int{},
+		FalseAlarmRate:  map[string]float64{},
+		WithAnomalies:   loud.Scores,
+		AnomalyEpisodes: loud.Episodes,
+	}
+	for _, s := range quiet.Scores {
+		r.FalseAlarms[s.Detector] = s.Alerts
+		r.FalseAlarmRate[s.Detector] = float64(s.Alerts) / float64(quiet.Published)
+	}
+	report.add(func(rep *Report) { rep.NoiseFiltering = &r })
+
+	if quiet.Delivered != quiet.Published {
+		t.Errorf("the cloud received %d of %d readings", quiet.Delivered, quiet.Published)
+	}
+	for d, rate := range r.FalseAlarmRate {
+		t.Logf("%s: %d false alarms over %d readings", d, r.FalseAlarms[d], r.Readings)
+		if rate > maxFalseAlarmRate {
+			t.Errorf("%s false alarm rate = %.4f, want <= %.4f", d, rate, maxFalseAlarmRate)
+		}
+	}
+	for _, s := range loud.Scores {
+		t.Logf("%s: recall %.2f, precision %.2f over %d episodes", s.Detector, s.Recall, s.Precision, loud.Episodes)
+	}
+}
@@ -0,0 +1,65 @@
+package integration
+
+import (
+	"runtime"
+	"testing"
+	"time"
+)
+
+// ResourceResult is the resource usage of the whole pipeline (NATS server
+// included) at one publication rate.
+type ResourceResult struct {
+	Sensors    int     `json:"sensors"`
+	Interval   string  `json:"interval"`
+	MsgsPerSec float64 `json:"msgs_per_sec"`
+	// CPUPercent is the process CPU time over wall time; 100 is one core.
+	CPUPercent float64 `json:"cpu_percent"`
+	HeapBytes  uint64  `json:"heap_bytes"`
+	Goroutines int     `json:"goroutines"`
+	Lost       int     `json:"lost"`
+}
+
+// TestResourceUsage compares 10 sensors publishing 1 msg/s with 10 sensors
+// publishing 100 msg/s.
+func TestResourceUsage(t *testing.T) {
+	for _, interval := range []time.Duration{time.Second, 10 * time.Millisecond} {
+		t.Run(interval.String(), func(t *testing.T) {
+			e := startEnv(t, 1, 10, interval, nil)
+
+			cpuBefore, wallBefore := cpuTime(), time.Now()
+			var goroutines int
+			done := make(chan struct{})
+			go func() {
+				// Sample mid-run, while everything is busy
+				time.Sleep(*duration / 2)
+				goroutines = runtime.NumGoroutine()
+				close(done)
+			}()
+			r := e.runLoad(*duration)
+			<-done
+			cpu, wall := cpuTime()-cpuBefore, time.Since(wallBefore)
+
+			var mem runtime.MemStats
+			runtime.ReadMemStats(&mem)
+
+			res := ResourceResult{
+				Sensors:    r.Sensors,
+				Interval:   interval.String(),
+				MsgsPerSec: r.MsgsPerSec,
+				HeapBytes:  mem.HeapAlloc,
+				Goroutines: goroutines,
+				Lost:       r.Lost,
+			}
+			if cpu >= 0 {
+				res.CPUPercent = 100 * cpu.Seconds() / wall.Seconds()
+			}
+			report.add(func(rep *Report) { rep.Resources = append(rep.Resources, res) })
+
+			t.Logf("%s: %.1f msg/s, CPU %.1f%%, heap %.1f MiB, %d goroutines",
+				interval, res.MsgsPerSec, res.CPUPercent, float64(res.HeapBytes)/(1<<20), res.Goroutines)
+			if r.Lost != 0 {
+				t.Errorf("lost %d of %d readings", r.Lost, r.Published)
+			}
+		})
+	}
+}
@@ -0,0 +1,29 @@
+package integration
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// TestScalability runs one edge with 5, 20, 50 and 100 sensors and checks
+// that every reading reaches the cloud.
+func TestScalability(t *testing.T) {
+	for _, sensors := range []int{5, 20, 50, 100} {
+		t.Run(fmt.Sprintf("%d_sensors", sensors), func(t *testing.T) {
+			e := startEnv(t, 1, sensors, 100*time.Millisecond, nil)
+			r := e.runLoad(*duration)
+			report.add(func(rep *Report) { rep.Scalability = append(rep.Scalability, r) })
+
+			t.Logf("%d sensors: published %d, delivered %d, %.1f msg/s, latency avg %.2fms p99 %.2fms",
+				sensors, r.Published, r.Delivered, r.MsgsPerSec, r.Latency.AvgMs, r.Latency.P99Ms)
+			if r.Published == 0 {
+				t.Fatal("sensors published nothing")
+			}
+			if r.Lost != 0 {
+				failures, quarantined := e.edgeMetrics("edge-1")
+				t.Errorf("lost %d of %d readings (signature failures %d, quarantined %d)", r.Lost, r.Published, failures, quarantined)
+			}
+		})
+	}
+}
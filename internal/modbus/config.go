@@ -0,0 +1,185 @@
+package modbus
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"time"
+
+	"sistemas_distribuidos_gb/internal/config"
+)
+
+// Register value types.
+const (
+	Uint16  = "uint16"
+	Int16   = "int16"
+	Uint32  = "uint32"
+	Int32   = "int32"
+	Float32 = "float32"
+)
+
+// Device is a Modbus/TCP device polled by the edge.
+type Device struct {
+	Name     string          `json:"name"`
+	Address  string          `json:"address"` // host:port
+	Unit     byte            `json:"unit_id"`
+	Interval config.Duration `json:"interval"` // default 1s
+	Timeout  config.Duration `json:"timeout"`  // default 1s
+	// Site and Line locate the sensors of the device
+	Site   string  `json:"site,omitempty"`
+	Line   string  `json:"line,omitempty"`
+	Points []Point `json:"points"`
+}
+
+// Point maps a register (or a pair of registers, for 32-bit types) to a
+// sensor. The reading is raw*scale + offset.
+type Point struct {
+	SensorID  string  `json:"sensor_id"`
+	Table     Table   `json:"table,omitempty"` // holding (default) or input
+	Address   uint16  `json:"address"`
+	Type      string  `json:"type,omitempty"`       // uint16 (default), int16, uint32, int32 or float32
+	SwapWords bool    `json:"swap_words,omitempty"` // low word first, for 32-bit types
+	Scale     float64 `json:"scale,omitempty"`      // 0 means 1
+	Offset    float64 `json:"offset,omitempty"`
+
+	// Simulate shapes the values served by the simulator.
+	Simulate *Simulation `json:"simulate,omitempty"`
+}
+
+// Config is the file read by LoadConfig.
+type Config struct {
+	Devices []Device `json:"devices"`
+}
+
+// LoadConfig reads the devices to poll from a JSON file.
+func LoadConfig(path string) ([]Device, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cfg Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	for i := range cfg.Devices {
+		if err := cfg.Devices[i].Normalize(); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	return cfg.Devices, nil
+}
+
+// Normalize fills in the defaults and validates the device.
+func (d *Device) Normalize() error {
+	if d.Address == "" {
+		return errors.New("modbus device without an address")
+	}
+	if d.Name == "" {
+		d.Name = d.Address
+	}
+	if d.Interval <= 0 {
+		d.Interval = config.Duration(time.Second)
+	}
+	if d.Timeout <= 0 {
+		d.Timeout = config.Duration(time.Second)
+	}
+	if len(d.Points) == 0 {
+		return fmt.Errorf("modbus device %s has no points", d.Name)
+	}
+	for i := range d.Points {
+		p := &d.Points[i]
+		if p.SensorID == "" {
+			return fmt.Errorf("modbus device %s: point at address %d has no sensor_id", d.Name, p.Address)
+		}
+		if p.Table == "" {
+			p.Table = HoldingRegisters
+		}
+		if _, err := p.Table.function(); err != nil {
+			return fmt.Errorf("modbus device %s, sensor %s: %w", d.Name, p.SensorID, err)
+		}
+		switch p.Type {
+		case "":
+			p.Type = Uint16
+		case Uint16, Int16, Uint32, Int32, Float32:
+		default:
+			return fmt.Errorf("modbus device %s, sensor %s: unknown type %q", d.Name, p.SensorID, p.Type)
+		}
+		if p.Scale == 0 {
+			p.Scale = 1
+		}
+	}
+	return nil
+}
+
+// Count returns the number of registers the point spans.
+func (p Point) Count() uint16 {
+	switch p.Type {
+	case Uint32, Int32, Float32:
+		return 2
+	}
+	return 1
+}
+
+// Decode converts the registers of the point to a scaled value.
+func (p Point) Decode(regs []uint16) float64 {
+	var raw float64
+	switch p.Type {
+	case Int16:
+		raw = float64(int16(regs[0]))
+	case Uint32:
+		raw = float64(p.uint32(regs))
+	case Int32:
+		raw = float64(int32(p.uint32(regs)))
+	case Float32:
+		raw = float64(math.Float32frombits(p.uint32(regs)))
+	default:
+		raw = float64(regs[0])
+	}
+	return raw*p.scale() + p.Offset
+}
+
+// Encode converts a scaled value to the registers of the point, the inverse
+// of Decode. Values out of the range of the type saturate.
+func (p Point) Encode(v float64) []uint16 {
+	raw := (v - p.Offset) / p.scale()
+	switch p.Type {
+	case Int16:
+		return []uint16{uint16(int16(clamp(math.Round(raw), math.MinInt16, math.MaxInt16)))}
+	case Uint32:
+		return p.words(uint32(clamp(math.Round(raw), 0, math.MaxUint32)))
+	case Int32:
+		return p.words(uint32(int32(clamp(math.Round(raw), math.MinInt32, math.MaxInt32))))
+	case Float32:
+		return p.words(math.Float32bits(float32(raw)))
+	}
+	return []uint16{uint16(clamp(math.Round(raw), 0, math.MaxUint16))}
+}
+
+func (p Point) scale() float64 {
+	if p.Scale == 0 {
+		return 1
+	}
+	return p.Scale
+}
+
+func (p Point) uint32(regs []uint16) uint32 {
+	hi, lo := regs[0], regs[1]
+	if p.SwapWords {
+		hi, lo = lo, hi
+	}
+	return uint32(hi)<<16 | uint32(lo)
+}
+
+func (p Point) words(v uint32) []uint16 {
+	hi, lo := uint16(v>>16), uint16(v)
+	if p.SwapWords {
+		return []uint16{lo, hi}
+	}
+	return []uint16{hi, lo}
+}
+
+func clamp(v, lo, hi float64) float64 {
+	return math.Max(lo, math.Min(hi, v))
+}
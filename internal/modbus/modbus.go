@@ -0,0 +1,213 @@
+// Package modbus is a small Modbus/TCP client and server, enough for the edge
+// to poll the holding and input registers of PLCs and for a simulator to
+// stand in for them. Only the register read functions (3 and 4) are
+// implemented.
+package modbus
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// Table is a register table of a Modbus device.
+type Table string
+
+const (
+	HoldingRegisters Table = "holding" // read with function 3
+	InputRegisters   Table = "input"   // read with function 4
+)
+
+// Function codes.
+const (
+	fcReadHoldingRegisters = 0x03
+	fcReadInputRegisters   = 0x04
+)
+
+// Exception codes.
+const (
+	IllegalFunction    = 0x01
+	IllegalDataAddress = 0x02
+	IllegalDataValue   = 0x03
+)
+
+// maxRegisters is the most registers a single read can return.
+const maxRegisters = 125
+
+// mbapLen is the length of the Modbus/TCP (MBAP) header, unit ID included.
+const mbapLen = 7
+
+// Exception is an error response from a device.
+type Exception struct {
+	Function byte
+	Code     byte
+}
+
+func (e *Exception) Error() string {
+	switch e.Code {
+	case IllegalFunction:
+		return fmt.Sprintf("modbus exception: illegal function %d", e.Function)
+	case IllegalDataAddress:
+		return "modbus exception: illegal data address"
+	case IllegalDataValue:
+		return "modbus exception: illegal data value"
+	}
+	return fmt.Sprintf("modbus exception %d", e.Code)
+}
+
+func (t Table) function() (byte, error) {
+	switch t {
+	case HoldingRegisters, "":
+		return fcReadHoldingRegisters, nil
+	case InputRegisters:
+		return fcReadInputRegisters, nil
+	}
+	return 0, fmt.Errorf("unknown register table %q (want holding or input)", t)
+}
+
+// Client is a Modbus/TCP client. It connects on first use and reconnects
+// after I/O errors; requests are serialized.
+type Client struct {
+	addr    string
+	timeout time.Duration
+
+	mu   sync.Mutex
+	conn net.Conn
+	txID uint16
+}
+
+// NewClient returns a client of the device at addr (host:port). timeout
+// bounds connecting and each request.
+func NewClient(addr string, timeout time.Duration) *Client {
+	return &Client{addr: addr, timeout: timeout}
+}
+
+// ReadRegisters reads count registers of a table starting at addr, from
+// the given unit.
+func (c *Client) ReadRegisters(unit byte, table Table, addr, count uint16) ([]uint16, error) {
+	fc, err := table.function()
+	if err != nil {
+		return nil, err
+	}
+	if count == 0 || count > maxRegisters {
+		return nil, fmt.Errorf("can't read %d registers (max %d)", count, maxRegisters)
+	}
+
+	pdu := make([]byte, 5)
+	pdu[0] = fc
+	binary.BigEndian.PutUint16(pdu[1:], addr)
+	binary.BigEndian.PutUint16(pdu[3:], count)
+	resp, err := c.do(unit, pdu)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp) < 2 || int(resp[1]) != 2*int(count) || len(resp) != 2+2*int(count) {
+		return nil, errors.New("modbus: malformed read response")
+	}
+	values := make([]uint16, count)
+	for i := range values {
+		values[i] = binary.BigEndian.Uint16(resp[2+2*i:])
+	}
+	return values, nil
+}
+
+// do sends a request PDU and returns the response PDU.
+func (c *Client) do(unit byte, pdu []byte) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.conn == nil {
+		conn, err := net.DialTimeout("tcp", c.addr, c.timeout)
+		if err != nil {
+			return nil, err
+		}
+		c.conn = conn
+	}
+	c.txID++
+	resp, err := c.roundTrip(c.txID, unit, pdu)
+	if err != nil {
+		// The connection is in an unknown state: start over on the next request
+		c.conn.Close()
+		c.conn = nil
+		return nil, err
+	}
+	if resp[0] == pdu[0]|0x80 {
+		if len(resp) < 2 {
+			return nil, errors.New("modbus: malformed exception response")
+		}
+		return nil, &Exception{Function: pdu[0], Code: resp[1]}
+	}
+	if resp[0] != pdu[0] {
+		return nil, fmt.Errorf("modbus: response to function %d for function %d", resp[0], pdu[0])
+	}
+	return resp, nil
+}
+
+func (c *Client) roundTrip(txID uint16, unit byte, pdu []byte) ([]byte, error) {
+	if c.timeout > 0 {
+		c.conn.SetDeadline(time.Now().Add(c.timeout))
+	}
+	if _, err := c.conn.Write(frame(txID, unit, pdu)); err != nil {
+		return nil, err
+	}
+	for {
+		gotID, gotUnit, resp, err := readFrame(c.conn)
+		if err != nil {
+			return nil, err
+		}
+		// Skip late responses to requests that timed out
+		if gotID == txID && gotUnit == unit {
+			if len(resp) == 0 {
+				return nil, errors.New("modbus: empty response")
+			}
+			return resp, nil
+		}
+	}
+}
+
+// Close closes the connection, if any.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn == nil {
+		return nil
+	}
+	err := c.conn.Close()
+	c.conn = nil
+	return err
+}
+
+// frame wraps a PDU in an MBAP header.
+func frame(txID uint16, unit byte, pdu []byte) []byte {
+	adu := make([]byte, mbapLen+len(pdu))
+	binary.BigEndian.PutUint16(adu[0:], txID)
+	// adu[2:4] is the protocol ID, always 0
+	binary.BigEndian.PutUint16(adu[4:], uint16(len(pdu)+1))
+	adu[6] = unit
+	copy(adu[mbapLen:], pdu)
+	return adu
+}
+
+// readFrame reads one MBAP frame and returns its transaction ID, unit and PDU.
+func readFrame(r io.Reader) (txID uint16, unit byte, pdu []byte, err error) {
+	var header [mbapLen]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, 0, nil, err
+	}
+	if binary.BigEndian.Uint16(header[2:]) != 0 {
+		return 0, 0, nil, errors.New("modbus: unknown protocol ID")
+	}
+	length := int(binary.BigEndian.Uint16(header[4:]))
+	if length < 2 || length > 254 {
+		return 0, 0, nil, fmt.Errorf("modbus: invalid frame length %d", length)
+	}
+	pdu = make([]byte, length-1)
+	if _, err := io.ReadFull(r, pdu); err != nil {
+		return 0, 0, nil, err
+	}
+	return binary.BigEndian.Uint16(header[0:]), header[6], pdu, nil
+}
@@ -0,0 +1,108 @@
+package modbus
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"sistemas_distribuidos_gb/internal/clock"
+)
+
+func TestPointCodec(t *testing.T) {
+	for _, p := range []Point{
+		{Type: Uint16, Scale: 0.5},
+		{Type: Int16, Scale: 0.5, Offset: -40},
+		{Type: Uint32, Scale: 0.01},
+		{Type: Int32, SwapWords: true, Scale: 0.5},
+		{Type: Float32},
+		{Type: Float32, SwapWords: true, Scale: 2, Offset: 1},
+	} {
+		regs := p.Encode(-12.5)
+		if len(regs) != int(p.Count()) {
+			t.Errorf("%+v: encoded to %d registers, want %d", p, len(regs), p.Count())
+			continue
+		}
+		want := -12.5
+		if p.Type == Uint16 || p.Type == Uint32 {
+			want = 0 // saturated
+		}
+		if got := p.Decode(regs); math.Abs(got-want) > 1e-6 {
+			t.Errorf("%+v: decoded %v, want %v", p, got, want)
+		}
+	}
+
+	// 0x41480000 is 12.5 as a float32
+	if got := (Point{Type: Float32}).Decode([]uint16{0x4148, 0}); got != 12.5 {
+		t.Errorf("big-endian float32 = %v", got)
+	}
+	if got := (Point{Type: Float32, SwapWords: true}).Decode([]uint16{0, 0x4148}); got != 12.5 {
+		t.Errorf("word-swapped float32 = %v", got)
+	}
+}
+
+func TestClient(t *testing.T) {
+	srv, err := Listen("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	srv.SetRegisters(1, HoldingRegisters, 100, []uint16{1, 2, 3})
+	srv.SetRegisters(1, InputRegisters, 100, []uint16{7})
+
+	c := NewClient(srv.Addr(), time.Second)
+	defer c.Close()
+	regs, err := c.ReadRegisters(1, HoldingRegisters, 100, 3)
+	if err != nil || len(regs) != 3 || regs[0] != 1 || regs[2] != 3 {
+		t.Fatalf("holding registers = %v, %v", regs, err)
+	}
+	if regs, err := c.ReadRegisters(1, InputRegisters, 100, 1); err != nil || regs[0] != 7 {
+		t.Fatalf("input registers = %v, %v", regs, err)
+	}
+
+	var exc *Exception
+	if _, err := c.ReadRegisters(1, HoldingRegisters, 101, 3); !errors.As(err, &exc) || exc.Code != IllegalDataAddress {
+		t.Errorf("reading past the registers: %v", err)
+	}
+	if _, err := c.ReadRegisters(2, HoldingRegisters, 100, 1); !errors.As(err, &exc) {
+		t.Errorf("reading another unit: %v", err)
+	}
+	// Exceptions don't break the connection
+	if _, err := c.ReadRegisters(1, HoldingRegisters, 100, 1); err != nil {
+		t.Errorf("read after an exception: %v", err)
+	}
+}
+
+func TestSimulator(t *testing.T) {
+	devices := []Device{{
+		Address: "127.0.0.1:0",
+		Unit:    3,
+		Points: []Point{
+			{SensorID: "temp", Address: 0, Type: Float32, Simulate: &Simulation{Base: 20, Noise: 0}},
+			{SensorID: "level", Table: InputRegisters, Address: 5, Scale: 0.1},
+		},
+	}}
+	sim, err := StartSimulator(devices, clock.NewFake(time.Now()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sim.Close()
+
+	c := NewClient(sim.Addrs()[0], time.Second)
+	defer c.Close()
+	temp, level := devices[0].Points[0], devices[0].Points[1]
+	regs, err := c.ReadRegisters(3, temp.Table, temp.Address, temp.Count())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := temp.Decode(regs); v != 20 {
+		t.Errorf("temp = %v, want 20", v)
+	}
+	regs, err = c.ReadRegisters(3, level.Table, level.Address, level.Count())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := level.Decode(regs); v < 30 || v > 70 {
+		t.Errorf("level = %v, want about %v", v, DefaultSimulation.Base)
+	}
+}
@@ -0,0 +1,150 @@
+package modbus
+
+import (
+	"encoding/binary"
+	"net"
+	"sync"
+)
+
+// Server is an in-process Modbus/TCP device serving register values set with
+// SetRegisters. Reads of registers that were never set fail with an illegal
+// data address exception.
+type Server struct {
+	ln net.Listener
+
+	mu    sync.RWMutex
+	regs  map[register]uint16
+	conns map[net.Conn]struct{}
+	done  bool
+
+	wg sync.WaitGroup
+}
+
+type register struct {
+	unit  byte
+	table Table
+	addr  uint16
+}
+
+// Listen starts a server on addr (127.0.0.1:0 picks a free port).
+func Listen(addr string) (*Server, error) {
+	if addr == "" {
+		addr = "127.0.0.1:0"
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{
+		ln:    ln,
+		regs:  map[register]uint16{},
+		conns: map[net.Conn]struct{}{},
+	}
+	s.wg.Add(1)
+	go s.accept()
+	return s, nil
+}
+
+// Addr returns the address the server listens on.
+func (s *Server) Addr() string {
+	return s.ln.Addr().String()
+}
+
+// SetRegisters sets consecutive registers of a unit, starting at addr.
+func (s *Server) SetRegisters(unit byte, table Table, addr uint16, values []uint16) {
+	if table == "" {
+		table = HoldingRegisters
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, v := range values {
+		s.regs[register{unit, table, addr + uint16(i)}] = v
+	}
+}
+
+// Close stops the server and closes every connection.
+func (s *Server) Close() error {
+	err := s.ln.Close()
+	s.mu.Lock()
+	s.done = true
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	return err
+}
+
+func (s *Server) accept() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		if s.done {
+			s.mu.Unlock()
+			conn.Close()
+			return
+		}
+		s.conns[conn] = struct{}{}
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.serve(conn)
+			s.mu.Lock()
+			delete(s.conns, conn)
+			s.mu.Unlock()
+			conn.Close()
+		}()
+	}
+}
+
+func (s *Server) serve(conn net.Conn) {
+	for {
+		txID, unit, req, err := readFrame(conn)
+		if err != nil {
+			return
+		}
+		if _, err := conn.Write(frame(txID, unit, s.handle(unit, req))); err != nil {
+			return
+		}
+	}
+}
+
+// handle returns the response PDU to a request PDU.
+func (s *Server) handle(unit byte, req []byte) []byte {
+	var table Table
+	switch req[0] {
+	case fcReadHoldingRegisters:
+		table = HoldingRegisters
+	case fcReadInputRegisters:
+		table = InputRegisters
+	default:
+		return []byte{req[0] | 0x80, IllegalFunction}
+	}
+	if len(req) != 5 {
+		return []byte{req[0] | 0x80, IllegalDataValue}
+	}
+	addr := binary.BigEndian.Uint16(req[1:])
+	count := binary.BigEndian.Uint16(req[3:])
+	if count == 0 || count > maxRegisters {
+		return []byte{req[0] | 0x80, IllegalDataValue}
+	}
+
+	resp := make([]byte, 2+2*int(count))
+	resp[0], resp[1] = req[0], byte(2*count)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for i := uint16(0); i < count; i++ {
+		v, ok := s.regs[register{unit, table, addr + i}]
+		if !ok {
+			return []byte{req[0] | 0x80, IllegalDataAddress}
+		}
+		binary.BigEndian.PutUint16(resp[2+2*i:], v)
+	}
+	return resp
+}
@@ -0,0 +1,112 @@
+package modbus
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+
+	"sistemas_distribuidos_gb/internal/clock"
+	"sistemas_distribuidos_gb/internal/simulator"
+)
+
+// Simulation shapes the values the simulator serves for a point, like the
+// flags of a simulated sensor.
+type Simulation struct {
+	Base          float64 `json:"base"`
+	Noise         float64 `json:"noise"`
+	AnomalyChance float64 `json:"anomaly_chance"` // probability of a drift starting, per update
+	SpikeChance   float64 `json:"spike_chance"`
+}
+
+// DefaultSimulation is used for points without a simulate block.
+var DefaultSimulation = Simulation{Base: 50, Noise: 2, AnomalyChance: 0.005, SpikeChance: 0.001}
+
+// Simulator serves simulated values for the points of a set of devices, one
+// server per device address, so that the edge can be tried without PLCs.
+// Values change every device interval.
+type Simulator struct {
+	servers []*Server
+	stop    chan struct{}
+	wg      sync.WaitGroup
+}
+
+// StartSimulator listens on the address of every device and starts updating
+// their registers. A nil clock uses the wall clock.
+func StartSimulator(devices []Device, clk clock.Clock) (*Simulator, error) {
+	clk = clock.Or(clk)
+	sim := &Simulator{stop: make(chan struct{})}
+	servers := map[string]*Server{}
+	for _, d := range devices {
+		if err := d.Normalize(); err != nil {
+			sim.Close()
+			return nil, err
+		}
+		srv, ok := servers[d.Address]
+		if !ok {
+			var err error
+			if srv, err = Listen(d.Address); err != nil {
+				sim.Close()
+				return nil, fmt.Errorf("simulate modbus device %s: %w", d.Name, err)
+			}
+			servers[d.Address] = srv
+			sim.servers = append(sim.servers, srv)
+		}
+
+		points := make([]simulatedPoint, len(d.Points))
+		for i, p := range d.Points {
+			points[i] = simulatedPoint{Point: p, sim: new(simulator.Simulator), settings: DefaultSimulation}
+			if p.Simulate != nil {
+				points[i].settings = *p.Simulate
+			}
+		}
+		unit := d.Unit
+		rng := rand.New(rand.NewSource(clk.Now().UnixNano()))
+		update := func() {
+			for _, p := range points {
+				v, _ := p.sim.Next(rng, p.settings.Base, p.settings.Noise, p.settings.AnomalyChance, p.settings.SpikeChance)
+				srv.SetRegisters(unit, p.Table, p.Address, p.Encode(v))
+			}
+		}
+		update()
+
+		ticker := clk.NewTicker(d.Interval.Std())
+		sim.wg.Add(1)
+		go func() {
+			defer sim.wg.Done()
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C():
+					update()
+				case <-sim.stop:
+					return
+				}
+			}
+		}()
+	}
+	return sim, nil
+}
+
+type simulatedPoint struct {
+	Point
+	sim      *simulator.Simulator
+	settings Simulation
+}
+
+// Addrs returns the addresses the simulated devices listen on.
+func (s *Simulator) Addrs() []string {
+	addrs := make([]string, len(s.servers))
+	for i, srv := range s.servers {
+		addrs[i] = srv.Addr()
+	}
+	return addrs
+}
+
+// Close stops the updates and the servers.
+func (s *Simulator) Close() {
+	close(s.stop)
+	s.wg.Wait()
+	for _, srv := range s.servers {
+		srv.Close()
+	}
+}
@@ -0,0 +1,362 @@
+// Package notify routes alerts to notification sinks (webhooks, Slack or
+// Teams channels, email, scripts), with per-route filters and rate limits,
+// retries with backoff and a log of every notification.
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"sistemas_distribuidos_gb/internal/clock"
+	"sistemas_distribuidos_gb/internal/config"
+)
+
+// Alert is an alert to notify: an edge alert or one raised by the cloud.
+type Alert struct {
+	SensorID  string  `json:"sensor_id"`
+	Site      string  `json:"site,omitempty"`
+	Line      string  `json:"line,omitempty"`
+	Location  string  `json:"location,omitempty"`
+	Unit      string  `json:"unit,omitempty"`
+	Value     float64 `json:"value"`
+	Timestamp int64   `json:"timestamp"`
+	EdgeID    string  `json:"edge_id"`
+	Type      string  `json:"type"`
+	Message   string  `json:"message"`
+	Detector  string  `json:"detector,omitempty"`
+	Score     float64 `json:"score,omitempty"`
+	Baseline  float64 `json:"baseline,omitempty"`
+}
+
+// Time returns the alert timestamp, for templates.
+func (a Alert) Time() time.Time {
+	return time.UnixMilli(a.Timestamp)
+}
+
+// Config is the notification setup, usually read from a file by LoadConfig.
+type Config struct {
+	Sinks  []SinkConfig `json:"sinks"`
+	Routes []Route      `json:"routes"`
+
+	Retries int             `json:"retries"` // attempts after the first, default 3 (-1 for none)
+	Backoff config.Duration `json:"backoff"` // before the first retry, doubled each time; default 1s
+
+	LogFile string `json:"log_file,omitempty"` // JSON lines, appended; none if empty
+	LogSize int    `json:"log_size,omitempty"` // entries kept for Entries, default 200
+}
+
+// Route sends the alerts it matches to its sinks. Empty filters match
+// everything; sensor and edge filters are glob patterns (temp-*).
+type Route struct {
+	Sinks    []string `json:"sinks"`
+	Severity []string `json:"severity,omitempty"` // alert types: critical, warning, anomaly, security, edge_offline
+	Sensors  []string `json:"sensors,omitempty"`
+	Edges    []string `json:"edges,omitempty"`
+	Sites    []string `json:"sites,omitempty"`
+	// RateLimit allows one notification per sensor, edge and alert type per
+	// interval; the rest are logged as suppressed.
+	RateLimit config.Duration `json:"rate_limit,omitempty"`
+}
+
+// Validate checks the route on its own; New and SetRoutes also check that
+// its sinks exist.
+func (r Route) Validate() error {
+	if len(r.Sinks) == 0 {
+		return errors.New("no sinks")
+	}
+	for _, patterns := range [][]string{r.Severity, r.Sensors, r.Edges, r.Sites} {
+		for _, p := range patterns {
+			if _, err := path.Match(p, ""); err != nil {
+				return fmt.Errorf("invalid pattern %q", p)
+			}
+		}
+	}
+	return nil
+}
+
+func (r Route) matches(a Alert) bool {
+	return matchAny(r.Severity, a.Type) && matchAny(r.Sensors, a.SensorID) &&
+		matchAny(r.Edges, a.EdgeID) && matchAny(r.Sites, a.Site)
+}
+
+func matchAny(patterns []string, s string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, s); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// LoadConfig reads the notification setup from a JSON file.
+func LoadConfig(file string) (Config, error) {
+	var cfg Config
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return cfg, err
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("parse %s: %w", file, err)
+	}
+	return cfg, nil
+}
+
+// Notification outcomes, in the log.
+const (
+	StatusSent       = "sent"
+	StatusFailed     = "failed"     // every attempt failed
+	StatusSuppressed = "suppressed" // rate limited
+	StatusDropped    = "dropped"    // the sink queue was full
+)
+
+// Entry is a line of the notification log.
+type Entry struct {
+	Time     time.Time `json:"time"`
+	Sink     string    `json:"sink"`
+	Status   string    `json:"status"`
+	Attempts int       `json:"attempts,omitempty"`
+	Error    string    `json:"error,omitempty"`
+	Alert    Alert     `json:"alert"`
+}
+
+// queueSize is how many notifications can wait for each sink.
+const queueSize = 100
+
+// Notifier delivers alerts to the sinks of the routes that match them. Each
+// sink has its own queue and worker, so a slow sink doesn't hold up others.
+type Notifier struct {
+	cfg   Config
+	clock clock.Clock
+	sinks map[string]*sinkWorker
+
+	mu      sync.Mutex
+	routes  []Route              // replaced by SetRoutes
+	limiter map[string]time.Time // last notification per route, sink and alert key
+	entries []Entry
+	logFile *os.File
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+type sinkWorker struct {
+	name  string
+	sink  Sink
+	queue chan Alert
+}
+
+// New builds the sinks and checks the routes. A nil clock uses the wall
+// clock.
+func New(cfg Config, clk clock.Clock) (*Notifier, error) {
+	switch {
+	case cfg.Retries == 0:
+		cfg.Retries = 3
+	case cfg.Retries < 0:
+		cfg.Retries = 0
+	}
+	if cfg.Backoff <= 0 {
+		cfg.Backoff = config.Duration(time.Second)
+	}
+	if cfg.LogSize <= 0 {
+		cfg.LogSize = 200
+	}
+
+	n := &Notifier{
+		cfg:     cfg,
+		clock:   clock.Or(clk),
+		sinks:   map[string]*sinkWorker{},
+		limiter: map[string]time.Time{},
+	}
+	for _, sc := range cfg.Sinks {
+		if _, ok := n.sinks[sc.Name]; ok || sc.Name == "" {
+			return nil, fmt.Errorf("sink names must be unique and not empty: %q", sc.Name)
+		}
+		sink, err := NewSink(sc)
+		if err != nil {
+			return nil, fmt.Errorf("sink %s: %w", sc.Name, err)
+		}
+		n.sinks[sc.Name] = &sinkWorker{name: sc.Name, sink: sink, queue: make(chan Alert, queueSize)}
+	}
+	if err := n.checkRoutes(cfg.Routes); err != nil {
+		return nil, err
+	}
+	n.routes = cfg.Routes
+	return n, nil
+}
+
+func (n *Notifier) checkRoutes(routes []Route) error {
+	for i, r := range routes {
+		if err := r.Validate(); err != nil {
+			return fmt.Errorf("route %d: %w", i+1, err)
+		}
+		for _, name := range r.Sinks {
+			if _, ok := n.sinks[name]; !ok {
+				return fmt.Errorf("route %d: unknown sink %q", i+1, name)
+			}
+		}
+	}
+	return nil
+}
+
+// SetRoutes replaces the routes, keeping the sinks and their queues. The rate
+// limits start over. Invalid routes are rejected and the current ones kept.
+func (n *Notifier) SetRoutes(routes []Route) error {
+	if err := n.checkRoutes(routes); err != nil {
+		return err
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.routes = routes
+	n.limiter = map[string]time.Time{}
+	return nil
+}
+
+// Routes returns the routes in use.
+func (n *Notifier) Routes() []Route {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return append([]Route(nil), n.routes...)
+}
+
+// Start opens the log file and starts the sink workers.
+func (n *Notifier) Start(ctx context.Context) error {
+	if n.cfg.LogFile != "" {
+		f, err := os.OpenFile(n.cfg.LogFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+		if err != nil {
+			return fmt.Errorf("open notification log: %w", err)
+		}
+		n.logFile = f
+	}
+
+	ctx, n.cancel = context.WithCancel(ctx)
+	for _, w := range n.sinks {
+		w := w
+		n.wg.Add(1)
+		go func() {
+			defer n.wg.Done()
+			for {
+				select {
+				case a := <-w.queue:
+					n.deliver(ctx, w, a)
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	log.Printf("Notifier started: %d sinks, %d routes", len(n.sinks), len(n.Routes()))
+	return nil
+}
+
+// Stop stops the workers, dropping queued notifications, and closes the log.
+func (n *Notifier) Stop() {
+	if n.cancel != nil {
+		n.cancel()
+	}
+	n.wg.Wait()
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.logFile != nil {
+		n.logFile.Close()
+		n.logFile = nil
+	}
+}
+
+// Notify queues an alert for the sinks of every route that matches it. A
+// sink gets the alert once even if several routes send it there.
+func (n *Notifier) Notify(a Alert) {
+	now := n.clock.Now()
+	queued := map[string]bool{}
+	for i, r := range n.Routes() {
+		if !r.matches(a) {
+			continue
+		}
+		for _, name := range r.Sinks {
+			if queued[name] {
+				continue
+			}
+			queued[name] = true
+			w := n.sinks[name]
+
+			if r.RateLimit > 0 {
+				key := fmt.Sprintf("%d/%s/%s/%s/%s", i, name, a.EdgeID, a.SensorID, a.Type)
+				n.mu.Lock()
+				last, seen := n.limiter[key]
+				limited := seen && now.Sub(last) < r.RateLimit.Std()
+				if !limited {
+					n.limiter[key] = now
+				}
+				n.mu.Unlock()
+				if limited {
+					n.record(Entry{Time: now, Sink: name, Status: StatusSuppressed, Alert: a})
+					continue
+				}
+			}
+
+			select {
+			case w.queue <- a:
+			default:
+				n.record(Entry{Time: now, Sink: name, Status: StatusDropped, Alert: a})
+			}
+		}
+	}
+}
+
+// deliver sends an alert to a sink, retrying with exponential backoff.
+func (n *Notifier) deliver(ctx context.Context, w *sinkWorker, a Alert) {
+	backoff := n.cfg.Backoff.Std()
+	var err error
+	attempts := 0
+	for attempts <= n.cfg.Retries {
+		if attempts > 0 {
+			select {
+			case <-n.clock.After(backoff):
+				backoff *= 2
+			case <-ctx.Done():
+				return
+			}
+		}
+		attempts++
+		if err = w.sink.Send(ctx, a); err == nil {
+			n.record(Entry{Time: n.clock.Now(), Sink: w.name, Status: StatusSent, Attempts: attempts, Alert: a})
+			return
+		}
+		if errors.Is(err, errPermanent) {
+			break
+		}
+	}
+	log.Printf("Notification to %s failed after %d attempts: %v", w.name, attempts, err)
+	n.record(Entry{Time: n.clock.Now(), Sink: w.name, Status: StatusFailed, Attempts: attempts, Error: err.Error(), Alert: a})
+}
+
+// record adds an entry to the notification log.
+func (n *Notifier) record(e Entry) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.entries = append(n.entries, e)
+	if len(n.entries) > n.cfg.LogSize {
+		n.entries = n.entries[1:]
+	}
+	if n.logFile != nil {
+		if data, err := json.Marshal(e); err == nil {
+			n.logFile.Write(append(data, '\n'))
+		}
+	}
+}
+
+// Entries returns the most recent notification log entries, oldest first.
+func (n *Notifier) Entries() []Entry {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return append([]Entry(nil), n.entries...)
+}
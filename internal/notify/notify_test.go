@@ -0,0 +1,315 @@
+package notify
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"sistemas_distribuidos_gb/internal/clock"
+	"sistemas_distribuidos_gb/internal/config"
+)
+
+// startNotifier runs a notifier until the test ends.
+func startNotifier(t *testing.T, cfg Config, clk clock.Clock) *Notifier {
+	t.Helper()
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	n, err := New(cfg, clk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(n.Stop)
+	return n
+}
+
+// waitEntries waits until the log has count entries.
+func waitEntries(t *testing.T, n *Notifier, count int) []Entry {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		entries := n.Entries()
+		if len(entries) >= count {
+			return entries
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("got %d log entries, want %d: %+v", len(entries), count, entries)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// receiver is an HTTP stand-in that records request bodies and answers with
+// the given statuses, then 200.
+func receiver(t *testing.T, statuses ...int) (*httptest.Server, chan string) {
+	bodies := make(chan string, 16)
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies <- string(body)
+		if i := int(calls.Add(1)) - 1; i < len(statuses) {
+			w.WriteHeader(statuses[i])
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv, bodies
+}
+
+func TestRouting(t *testing.T) {
+	srv, bodies := receiver(t)
+	clk := clock.NewFake(time.Date(2024, 1, 1, 8, 0, 0, 0, time.UTC))
+	n := startNotifier(t, Config{
+		Sinks: []SinkConfig{
+			{Name: "ops", Type: Webhook, URL: srv.URL, Template: `{"sensor": {{json .SensorID}}, "type": "{{.Type}}"}`},
+		},
+		Routes: []Route{
+			{Sinks: []string{"ops"}, Severity: []string{"critical"}, Sensors: []string{"temp-*"}, RateLimit: config.Duration(time.Minute)},
+			{Sinks: []string{"ops"}, Edges: []string{"edge-b"}},
+		},
+	}, clk)
+
+	n.Notify(Alert{SensorID: "temp-01", EdgeID: "edge-a", Type: "critical"})
+	n.Notify(Alert{SensorID: "temp-01", EdgeID: "edge-a", Type: "critical"}) // rate limited
+	n.Notify(Alert{SensorID: "temp-01", EdgeID: "edge-a", Type: "warning"})  // no route
+	n.Notify(Alert{SensorID: "press-01", EdgeID: "edge-a", Type: "critical"})
+	n.Notify(Alert{SensorID: "temp-02", EdgeID: "edge-b", Type: "critical"}) // both routes, sent once
+	clk.Advance(time.Minute)
+	n.Notify(Alert{SensorID: "temp-01", EdgeID: "edge-a", Type: "critical"})
+
+	entries := waitEntries(t, n, 4)
+	statuses := map[string]int{}
+	for _, e := range entries {
+		statuses[e.Status]++
+	}
+	if statuses[StatusSent] != 3 || statuses[StatusSuppressed] != 1 {
+		t.Errorf("got %v, want 3 sent and 1 suppressed", statuses)
+	}
+	if body := <-bodies; body != `{"sensor": "temp-01", "type": "critical"}` {
+		t.Errorf("webhook body %s", body)
+	}
+}
+
+func TestRetries(t *testing.T) {
+	flaky, flakyBodies := receiver(t, http.StatusServiceUnavailable, http.StatusBadGateway)
+	broken, _ := receiver(t, http.StatusBadRequest, http.StatusBadRequest)
+	logFile := filepath.Join(t.TempDir(), "notifications.jsonl")
+	n := startNotifier(t, Config{
+		Sinks: []SinkConfig{
+			{Name: "flaky", Type: Webhook, URL: flaky.URL},
+			{Name: "broken", Type: Slack, URL: broken.URL},
+		},
+		Routes:  []Route{{Sinks: []string{"flaky", "broken"}}},
+		Backoff: config.Duration(time.Millisecond),
+		LogFile: logFile,
+	}, nil)
+
+	n.Notify(Alert{SensorID: "s1", EdgeID: "e1", Type: "critical", Message: "Critical value detected (Spike)", Value: 150})
+	entries := waitEntries(t, n, 2)
+	for _, e := range entries {
+		switch e.Sink {
+		case "flaky":
+			if e.Status != StatusSent || e.Attempts != 3 {
+				t.Errorf("flaky sink: %+v", e)
+			}
+		case "broken":
+			// Client errors aren't retried
+			if e.Status != StatusFailed || e.Attempts != 1 || e.Error == "" {
+				t.Errorf("broken sink: %+v", e)
+			}
+		}
+	}
+	var a Alert
+	if err := json.Unmarshal([]byte(<-flakyBodies), &a); err != nil || a.SensorID != "s1" || a.Value != 150 {
+		t.Errorf("default webhook body isn't the alert: %+v, %v", a, err)
+	}
+
+	n.Stop()
+	data, _ := os.ReadFile(logFile)
+	if lines := strings.Count(string(data), "\n"); lines != 2 {
+		t.Errorf("notification log file has %d lines, want 2", lines)
+	}
+}
+
+func TestSlack(t *testing.T) {
+	srv, bodies := receiver(t)
+	n := startNotifier(t, Config{
+		Sinks:  []SinkConfig{{Name: "chat", Type: Slack, URL: srv.URL}},
+		Routes: []Route{{Sinks: []string{"chat"}}},
+	}, nil)
+	n.Notify(Alert{SensorID: "s1", EdgeID: "e1", Type: "warning", Message: "Process drift detected (Warning)", Value: 61.234, Unit: "°C"})
+	waitEntries(t, n, 1)
+
+	var msg struct{ Text string }
+	json.Unmarshal([]byte(<-bodies), &msg)
+	if msg.Text != "[warning] s1 on e1: Process drift detected (Warning) (value 61.23 °C)" {
+		t.Errorf("slack text %q", msg.Text)
+	}
+}
+
+// smtpServer is an SMTP stand-in that accepts every message.
+func smtpServer(t *testing.T) (string, chan string) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	messages := make(chan string, 4)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				reply := func(s string) { conn.Write([]byte(s + "\r\n")) }
+				reply("220 localhost ESMTP")
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					switch cmd := strings.ToUpper(strings.TrimSpace(line)); {
+					case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+						reply("250 localhost")
+					case cmd == "DATA":
+						reply("354 go ahead")
+						var msg strings.Builder
+						for {
+							line, err := r.ReadString('\n')
+							if err != nil || line == ".\r\n" {
+								break
+							}
+							msg.WriteString(line)
+						}
+						messages <- msg.String()
+						reply("250 queued")
+					case cmd == "QUIT":
+						reply("221 bye")
+						return
+					default:
+						reply("250 OK")
+					}
+				}
+			}()
+		}
+	}()
+	return ln.Addr().String(), messages
+}
+
+func TestEmail(t *testing.T) {
+	addr, messages := smtpServer(t)
+	n := startNotifier(t, Config{
+		Sinks: []SinkConfig{{
+			Name: "mail", Type: Email, SMTP: addr,
+			From: "alerts@plant.example", To: []string{"ops@plant.example", "eng@plant.example"},
+		}},
+		Routes: []Route{{Sinks: []string{"mail"}, Severity: []string{"edge_offline"}}},
+	}, nil)
+	n.Notify(Alert{EdgeID: "edge-a", Type: "edge_offline", Message: "No data from edge-a for 30s", Timestamp: 1704096000000})
+
+	select {
+	case msg := <-messages:
+		for _, want := range []string{
+			"To: ops@plant.example, eng@plant.example\r\n",
+			"Subject: [edge_offline] edge-a: No data from edge-a for 30s\r\n",
+			"Edge:     edge-a\r\n",
+		} {
+			if !strings.Contains(msg, want) {
+				t.Errorf("message lacks %q:\n%s", want, msg)
+			}
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no email sent")
+	}
+	if e := waitEntries(t, n, 1)[0]; e.Status != StatusSent {
+		t.Errorf("email entry %+v", e)
+	}
+}
+
+func TestExec(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "alert.json")
+	n := startNotifier(t, Config{
+		Sinks: []SinkConfig{
+			{Name: "script", Type: Exec, Command: []string{"sh", "-c", `cat > "$1"`, "hook", out}},
+			{Name: "failing", Type: Exec, Command: []string{"sh", "-c", "echo no pager configured >&2; exit 3"}},
+		},
+		Routes:  []Route{{Sinks: []string{"script", "failing"}}},
+		Retries: -1,
+	}, nil)
+	n.Notify(Alert{SensorID: "s1", EdgeID: "e1", Type: "anomaly"})
+
+	for _, e := range waitEntries(t, n, 2) {
+		switch e.Sink {
+		case "script":
+			if e.Status != StatusSent {
+				t.Errorf("script entry %+v", e)
+			}
+		case "failing":
+			if e.Status != StatusFailed || e.Attempts != 1 || !strings.Contains(e.Error, "no pager configured") {
+				t.Errorf("failing entry %+v", e)
+			}
+		}
+	}
+	var a Alert
+	data, _ := os.ReadFile(out)
+	if err := json.Unmarshal(data, &a); err != nil || a.Type != "anomaly" {
+		t.Errorf("the script got %q", data)
+	}
+}
+
+func TestNewRejectsInvalidConfig(t *testing.T) {
+	for _, cfg := range []Config{
+		{Sinks: []SinkConfig{{Name: "x", Type: "pager"}}},
+		{Sinks: []SinkConfig{{Name: "x", Type: Webhook}}},
+		{Sinks: []SinkConfig{{Name: "x", Type: Email, SMTP: "localhost"}}},
+		{Sinks: []SinkConfig{{Name: "x", Type: Slack, URL: "http://x", Template: "{{.Nope"}}},
+		{Sinks: []SinkConfig{{Name: "x", Type: Exec, Command: []string{"true"}}}, Routes: []Route{{Sinks: []string{"y"}}}},
+		{Sinks: []SinkConfig{{Name: "x", Type: Exec, Command: []string{"true"}}}, Routes: []Route{{Sinks: []string{"x"}, Sensors: []string{"["}}}},
+	} {
+		if _, err := New(cfg, nil); err == nil {
+			t.Errorf("New accepted %+v", cfg)
+		}
+	}
+}
+
+func TestSetRoutes(t *testing.T) {
+	srv, bodies := receiver(t)
+	n := startNotifier(t, Config{
+		Sinks:  []SinkConfig{{Name: "ops", Type: Webhook, URL: srv.URL, Template: `{{.SensorID}}`}},
+		Routes: []Route{{Sinks: []string{"ops"}, Severity: []string{"critical"}}},
+	}, nil)
+
+	for _, routes := range [][]Route{
+		{{Sinks: []string{"pager"}}},
+		{{Sinks: []string{"ops"}, Edges: []string{"["}}},
+		{{}},
+	} {
+		if err := n.SetRoutes(routes); err == nil {
+			t.Errorf("SetRoutes accepted %+v", routes)
+		}
+	}
+	if err := n.SetRoutes([]Route{{Sinks: []string{"ops"}, Sensors: []string{"temp-*"}}}); err != nil {
+		t.Fatal(err)
+	}
+	n.Notify(Alert{SensorID: "press-01", Type: "critical"})
+	n.Notify(Alert{SensorID: "temp-01", Type: "warning"})
+	waitEntries(t, n, 1)
+	if body := <-bodies; body != "temp-01" {
+		t.Errorf("sent %s, want the alert of temp-01 only", body)
+	}
+}
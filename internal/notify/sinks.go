@@ -0,0 +1,322 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/smtp"
+	"os/exec"
+	"strings"
+	"text/template"
+	"time"
+
+	"sistemas_distribuidos_gb/internal/config"
+)
+
+// Sink types.
+const (
+	Webhook = "webhook" // HTTP request with a templated body (the alert JSON by default)
+	Slack   = "slack"   // Slack, Teams or Mattermost incoming webhook: {"text": ...}
+	Email   = "email"   // SMTP
+	Exec    = "exec"    // runs a command with the alert JSON on stdin
+)
+
+// Default message templates.
+const (
+	defaultText    = `[{{.Type}}] {{if .SensorID}}{{.SensorID}} on {{end}}{{.EdgeID}}: {{.Message}}{{if .SensorID}} (value {{printf "%.2f" .Value}}{{with .Unit}} {{.}}{{end}}){{end}}`
+	defaultSubject = `[{{.Type}}] {{if .SensorID}}{{.SensorID}}{{else}}{{.EdgeID}}{{end}}: {{.Message}}`
+	defaultBody    = `Type:     {{.Type}}
+Message:  {{.Message}}
+Sensor:   {{.SensorID}}{{with .Location}} ({{.}}){{end}}
+Site:     {{.Site}} / {{.Line}}
+Edge:     {{.EdgeID}}
+Value:    {{printf "%.2f" .Value}}{{with .Unit}} {{.}}{{end}}
+Time:     {{.Time.Format "2006-01-02 15:04:05 MST"}}
+{{with .Detector}}Detector: {{.}} (score {{printf "%.2f" $.Score}}, baseline {{printf "%.2f" $.Baseline}})
+{{end}}`
+)
+
+// SinkConfig configures a sink. Which fields apply depends on the type.
+type SinkConfig struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+
+	// webhook and slack
+	URL         string            `json:"url,omitempty"`
+	Method      string            `json:"method,omitempty"` // default POST
+	Headers     map[string]string `json:"headers,omitempty"`
+	ContentType string            `json:"content_type,omitempty"` // default application/json
+	// Template renders the webhook body, the slack text or the email body
+	Template string `json:"template,omitempty"`
+
+	// email
+	SMTP     string   `json:"smtp,omitempty"` // host:port
+	Username string   `json:"username,omitempty"`
+	Password string   `json:"password,omitempty"`
+	From     string   `json:"from,omitempty"`
+	To       []string `json:"to,omitempty"`
+	Subject  string   `json:"subject,omitempty"` // template
+
+	// exec: the arguments are templates
+	Command []string `json:"command,omitempty"`
+
+	Timeout config.Duration `json:"timeout,omitempty"` // per attempt, default 10s
+}
+
+// Sink delivers alerts somewhere.
+type Sink interface {
+	Send(ctx context.Context, a Alert) error
+}
+
+// errPermanent marks failures that retrying won't fix.
+var errPermanent = errors.New("permanent failure")
+
+// templateFuncs are available to every template; json quotes a value, for
+// JSON bodies.
+var templateFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+}
+
+func parseTemplate(name, text, def string) (*template.Template, error) {
+	if text == "" {
+		text = def
+	}
+	return template.New(name).Funcs(templateFuncs).Parse(text)
+}
+
+func render(t *template.Template, a Alert) (string, error) {
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, a); err != nil {
+		return "", fmt.Errorf("%w: render %s: %v", errPermanent, t.Name(), err)
+	}
+	return buf.String(), nil
+}
+
+// NewSink builds a sink from its configuration.
+func NewSink(cfg SinkConfig) (Sink, error) {
+	timeout := cfg.Timeout.Std()
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	switch cfg.Type {
+	case Webhook, Slack:
+		if cfg.URL == "" {
+			return nil, errors.New("no url")
+		}
+		s := &webhookSink{cfg: cfg, client: &http.Client{Timeout: timeout}}
+		if s.cfg.Method == "" {
+			s.cfg.Method = http.MethodPost
+		}
+		if s.cfg.ContentType == "" {
+			s.cfg.ContentType = "application/json"
+		}
+		var err error
+		switch {
+		case cfg.Type == Slack:
+			s.text, err = parseTemplate("text", cfg.Template, defaultText)
+		case cfg.Template != "":
+			s.body, err = parseTemplate("body", cfg.Template, "")
+		}
+		return s, err
+	case Email:
+		if cfg.SMTP == "" || cfg.From == "" || len(cfg.To) == 0 {
+			return nil, errors.New("email sinks need smtp, from and to")
+		}
+		if _, _, err := net.SplitHostPort(cfg.SMTP); err != nil {
+			return nil, fmt.Errorf("invalid smtp address: %w", err)
+		}
+		s := &emailSink{cfg: cfg, timeout: timeout}
+		var err error
+		if s.subject, err = parseTemplate("subject", cfg.Subject, defaultSubject); err != nil {
+			return nil, err
+		}
+		s.body, err = parseTemplate("body", cfg.Template, defaultBody)
+		return s, err
+	case Exec:
+		if len(cfg.Command) == 0 {
+			return nil, errors.New("no command")
+		}
+		s := &execSink{timeout: timeout}
+		for i, arg := range cfg.Command {
+			t, err := parseTemplate(fmt.Sprintf("arg %d", i), arg, "")
+			if err != nil {
+				return nil, err
+			}
+			s.args = append(s.args, t)
+		}
+		return s, nil
+	}
+	return nil, fmt.Errorf("unknown sink type %q (want webhook, slack, email or exec)", cfg.Type)
+}
+
+type webhookSink struct {
+	cfg    SinkConfig
+	client *http.Client
+	body   *template.Template // nil sends the alert JSON
+	text   *template.Template // slack
+}
+
+func (s *webhookSink) Send(ctx context.Context, a Alert) error {
+	var body []byte
+	switch {
+	case s.text != nil:
+		text, err := render(s.text, a)
+		if err != nil {
+			return err
+		}
+		body, _ = json.Marshal(map[string]string{"text": text})
+	case s.body != nil:
+		text, err := render(s.body, a)
+		if err != nil {
+			return err
+		}
+		body = []byte(text)
+	default:
+		body, _ = json.Marshal(a)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, s.cfg.Method, s.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("%w: %v", errPermanent, err)
+	}
+	req.Header.Set("Content-Type", s.cfg.ContentType)
+	for k, v := range s.cfg.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode >= 300 {
+		err := fmt.Errorf("%s returned %s", s.cfg.URL, resp.Status)
+		// Client errors other than rate limiting won't go away by retrying
+		if resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests {
+			err = fmt.Errorf("%w: %v", errPermanent, err)
+		}
+		return err
+	}
+	return nil
+}
+
+type emailSink struct {
+	cfg     SinkConfig
+	timeout time.Duration
+	subject *template.Template
+	body    *template.Template
+}
+
+func (s *emailSink) Send(ctx context.Context, a Alert) error {
+	subject, err := render(s.subject, a)
+	if err != nil {
+		return err
+	}
+	body, err := render(s.body, a)
+	if err != nil {
+		return err
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", s.cfg.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(s.cfg.To, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", strings.NewReplacer("\r", " ", "\n", " ").Replace(subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+
+	host, _, _ := net.SplitHostPort(s.cfg.SMTP)
+	var auth smtp.Auth
+	if s.cfg.Username != "" {
+		auth = smtp.PlainAuth("", s.cfg.Username, s.cfg.Password, host)
+	}
+	// smtp.SendMail takes no context; bound it with a deadline on the
+	// connection instead
+	conn, err := (&net.Dialer{Timeout: s.timeout}).DialContext(ctx, "tcp", s.cfg.SMTP)
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(s.timeout))
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+	return sendMail(c, host, auth, s.cfg.From, s.cfg.To, msg.Bytes())
+}
+
+// sendMail is smtp.SendMail on an open client.
+func sendMail(c *smtp.Client, host string, auth smtp.Auth, from string, to []string, msg []byte) error {
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if auth != nil {
+		if ok, _ := c.Extension("AUTH"); !ok {
+			return fmt.Errorf("%w: server doesn't support AUTH", errPermanent)
+		}
+		if err := c.Auth(auth); err != nil {
+			return fmt.Errorf("%w: %v", errPermanent, err)
+		}
+	}
+	if err := c.Mail(from); err != nil {
+		return err
+	}
+	for _, addr := range to {
+		if err := c.Rcpt(addr); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+type execSink struct {
+	args    []*template.Template
+	timeout time.Duration
+}
+
+func (s *execSink) Send(ctx context.Context, a Alert) error {
+	args := make([]string, len(s.args))
+	for i, t := range s.args {
+		arg, err := render(t, a)
+		if err != nil {
+			return err
+		}
+		args[i] = arg
+	}
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	input, _ := json.Marshal(a)
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stdin = bytes.NewReader(input)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		if out = bytes.TrimSpace(out); len(out) > 0 {
+			return fmt.Errorf("%s: %v: %s", args[0], err, out)
+		}
+		return fmt.Errorf("%s: %v", args[0], err)
+	}
+	return nil
+}
@@ -0,0 +1,254 @@
+// Package opcua subscribes to the variables of OPC UA servers and reports
+// their numeric values, for the edge to process as sensor readings.
+package opcua
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	gopcua "github.com/gopcua/opcua"
+	"github.com/gopcua/opcua/monitor"
+	"github.com/gopcua/opcua/ua"
+
+	"sistemas_distribuidos_gb/internal/clock"
+	"sistemas_distribuidos_gb/internal/config"
+)
+
+// retryInterval is how long to wait before connecting again after a failure.
+const retryInterval = 5 * time.Second
+
+// Server is an OPC UA server whose variables the edge subscribes to.
+type Server struct {
+	Name     string          `json:"name"`
+	Endpoint string          `json:"endpoint"`            // opc.tcp://host:4840
+	Policy   string          `json:"policy,omitempty"`    // None (default), Basic256Sha256...
+	Mode     string          `json:"mode,omitempty"`      // None (default), Sign or SignAndEncrypt
+	CertFile string          `json:"cert_file,omitempty"` // client certificate, for Sign modes
+	KeyFile  string          `json:"key_file,omitempty"`
+	Username string          `json:"username,omitempty"` // anonymous if empty
+	Password string          `json:"password,omitempty"`
+	Interval config.Duration `json:"interval"` // publishing interval, default 1s
+	// Site and Line locate the sensors of the server
+	Site  string `json:"site,omitempty"`
+	Line  string `json:"line,omitempty"`
+	Nodes []Node `json:"nodes"`
+}
+
+// Node maps a variable to a sensor. The reading is value*scale + offset.
+type Node struct {
+	NodeID   string  `json:"node_id"` // ns=2;s=Forno.Temperatura
+	SensorID string  `json:"sensor_id"`
+	Scale    float64 `json:"scale,omitempty"` // 0 means 1
+	Offset   float64 `json:"offset,omitempty"`
+}
+
+// Config is the file read by LoadConfig.
+type Config struct {
+	Servers []Server `json:"servers"`
+}
+
+// LoadConfig reads the servers to subscribe to from a JSON file.
+func LoadConfig(path string) ([]Server, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cfg Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	for i := range cfg.Servers {
+		if err := cfg.Servers[i].Normalize(); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	return cfg.Servers, nil
+}
+
+// Normalize fills in the defaults and validates the server.
+func (s *Server) Normalize() error {
+	if s.Endpoint == "" {
+		return errors.New("OPC UA server without an endpoint")
+	}
+	if s.Name == "" {
+		s.Name = s.Endpoint
+	}
+	if s.Policy == "" {
+		s.Policy = "None"
+	}
+	if s.Mode == "" {
+		s.Mode = "None"
+	}
+	if s.Interval <= 0 {
+		s.Interval = config.Duration(time.Second)
+	}
+	if len(s.Nodes) == 0 {
+		return fmt.Errorf("OPC UA server %s has no nodes", s.Name)
+	}
+	for i := range s.Nodes {
+		n := &s.Nodes[i]
+		if n.SensorID == "" {
+			return fmt.Errorf("OPC UA server %s: node %s has no sensor_id", s.Name, n.NodeID)
+		}
+		if _, err := ua.ParseNodeID(n.NodeID); err != nil {
+			return fmt.Errorf("OPC UA server %s, sensor %s: %w", s.Name, n.SensorID, err)
+		}
+		if n.Scale == 0 {
+			n.Scale = 1
+		}
+	}
+	return nil
+}
+
+// Handler receives the scaled value of a node and when it was sampled.
+type Handler func(n Node, value float64, at time.Time)
+
+// Run connects to the server, subscribes to its nodes and calls handle with
+// every value change until ctx is done. Connection failures are logged and
+// retried; once connected, the client reconnects by itself.
+func Run(ctx context.Context, clk clock.Clock, srv Server, handle Handler) {
+	clk = clock.Or(clk)
+	for {
+		err := run(ctx, clk, srv, handle)
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("OPC UA server %s unavailable (retrying in %v): %v", srv.Name, retryInterval, err)
+		select {
+		case <-clk.After(retryInterval):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func run(ctx context.Context, clk clock.Clock, srv Server, handle Handler) error {
+	endpoints, err := gopcua.GetEndpoints(ctx, srv.Endpoint)
+	if err != nil {
+		return err
+	}
+	ep := gopcua.SelectEndpoint(endpoints, srv.Policy, ua.MessageSecurityModeFromString(srv.Mode))
+	if ep == nil {
+		return fmt.Errorf("no endpoint with policy %s and mode %s", srv.Policy, srv.Mode)
+	}
+
+	opts := []gopcua.Option{
+		gopcua.SecurityPolicy(srv.Policy),
+		gopcua.SecurityModeString(srv.Mode),
+	}
+	if srv.CertFile != "" {
+		opts = append(opts, gopcua.CertificateFile(srv.CertFile), gopcua.PrivateKeyFile(srv.KeyFile))
+	}
+	if srv.Username != "" {
+		opts = append(opts, gopcua.AuthUsername(srv.Username, srv.Password), gopcua.SecurityFromEndpoint(ep, ua.UserTokenTypeUserName))
+	} else {
+		opts = append(opts, gopcua.AuthAnonymous(), gopcua.SecurityFromEndpoint(ep, ua.UserTokenTypeAnonymous))
+	}
+
+	client, err := gopcua.NewClient(ep.EndpointURL, opts...)
+	if err != nil {
+		return err
+	}
+	if err := client.Connect(ctx); err != nil {
+		return err
+	}
+	defer client.Close(context.Background())
+
+	m, err := monitor.NewNodeMonitor(client)
+	if err != nil {
+		return err
+	}
+	m.SetErrorHandler(func(_ *gopcua.Client, _ *monitor.Subscription, err error) {
+		log.Printf("OPC UA subscription error on %s: %v", srv.Name, err)
+	})
+
+	nodes := make(map[string]Node, len(srv.Nodes))
+	ids := make([]string, 0, len(srv.Nodes))
+	for _, n := range srv.Nodes {
+		id, _ := ua.ParseNodeID(n.NodeID)
+		nodes[id.String()] = n
+		ids = append(ids, n.NodeID)
+	}
+	sub, err := m.Subscribe(ctx, &gopcua.SubscriptionParameters{Interval: srv.Interval.Std()}, func(_ *monitor.Subscription, msg *monitor.DataChangeMessage) {
+		if msg.Error != nil {
+			log.Printf("OPC UA data change error on %s: %v", srv.Name, msg.Error)
+			return
+		}
+		n, ok := nodes[msg.NodeID.String()]
+		if !ok {
+			return
+		}
+		v, at, err := value(msg.DataValue)
+		if err != nil {
+			log.Printf("Ignoring OPC UA value of %s on %s: %v", n.NodeID, srv.Name, err)
+			return
+		}
+		if at.IsZero() {
+			at = clk.Now()
+		}
+		handle(n, v*n.Scale+n.Offset, at)
+	}, ids...)
+	if err != nil {
+		return err
+	}
+	log.Printf("Subscribed to %d nodes of OPC UA server %s", len(ids), srv.Name)
+
+	<-ctx.Done()
+	sub.Unsubscribe(context.Background())
+	return nil
+}
+
+// value extracts a numeric value and its timestamp from a data value.
+func value(dv *ua.DataValue) (float64, time.Time, error) {
+	if dv == nil || dv.Value == nil {
+		return 0, time.Time{}, errors.New("no value")
+	}
+	if dv.Status != ua.StatusOK {
+		return 0, time.Time{}, fmt.Errorf("bad status: %v", dv.Status)
+	}
+	at := dv.SourceTimestamp
+	if at.IsZero() {
+		at = dv.ServerTimestamp
+	}
+	v, ok := toFloat(dv.Value.Value())
+	if !ok {
+		return 0, time.Time{}, fmt.Errorf("not a number: %T", dv.Value.Value())
+	}
+	return v, at, nil
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int8:
+		return float64(v), true
+	case int16:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case uint8:
+		return float64(v), true
+	case uint16:
+		return float64(v), true
+	case uint32:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case bool:
+		if v {
+			return 1, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
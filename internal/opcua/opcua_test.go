@@ -0,0 +1,56 @@
+package opcua
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gopcua/opcua/ua"
+)
+
+func TestLoadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "servers.json")
+	os.WriteFile(path, []byte(`{"servers": [{
+		"endpoint": "opc.tcp://localhost:4840",
+		"nodes": [{"node_id": "ns=2;s=Forno.Temperatura", "sensor_id": "forno-temp"}]
+	}]}`), 0o644)
+	servers, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := servers[0]
+	if s.Name != s.Endpoint || s.Policy != "None" || s.Mode != "None" || s.Interval.Std() != time.Second || s.Nodes[0].Scale != 1 {
+		t.Errorf("defaults not applied: %+v", s)
+	}
+
+	for _, bad := range []Server{
+		{Nodes: []Node{{NodeID: "i=85", SensorID: "s"}}},
+		{Endpoint: "opc.tcp://localhost:4840"},
+		{Endpoint: "opc.tcp://localhost:4840", Nodes: []Node{{NodeID: "i=85"}}},
+		{Endpoint: "opc.tcp://localhost:4840", Nodes: []Node{{NodeID: "ns=x;i=1", SensorID: "s"}}},
+	} {
+		if err := bad.Normalize(); err == nil {
+			t.Errorf("Normalize accepted %+v", bad)
+		}
+	}
+}
+
+func TestValue(t *testing.T) {
+	at := time.Date(2024, 1, 1, 8, 0, 0, 0, time.UTC)
+	for _, v := range []interface{}{float32(21.5), int16(-3), uint32(7), true} {
+		dv := &ua.DataValue{Value: ua.MustVariant(v), ServerTimestamp: at}
+		if _, got, err := value(dv); err != nil || !got.Equal(at) {
+			t.Errorf("value(%T) = %v, %v", v, got, err)
+		}
+	}
+	if v, _, _ := value(&ua.DataValue{Value: ua.MustVariant(float32(21.5))}); v != 21.5 {
+		t.Errorf("float32 value = %v", v)
+	}
+	if _, _, err := value(&ua.DataValue{Value: ua.MustVariant("21.5")}); err == nil {
+		t.Error("accepted a string value")
+	}
+	if _, _, err := value(&ua.DataValue{Value: ua.MustVariant(21.5), Status: ua.StatusBadSensorFailure}); err == nil {
+		t.Error("accepted a value with a bad status")
+	}
+}
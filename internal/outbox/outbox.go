@@ -0,0 +1,528 @@
+// Package outbox is a write-ahead buffer for the messages a service sends
+// upstream. Publish appends each message to JSON-lines segment files on
+// disk and returns; a sender replays them to the uplink broker in order, a
+// batch at a time, and moves a cursor past a batch once the uplink confirms
+// it. While the uplink is down the messages pile up on disk, up to a size
+// limit past which the oldest are dropped, and when it returns they're sent
+// at the pace the uplink confirms them.
+//
+// Delivery is at least once: a batch the uplink received but couldn't
+// confirm, or that was sent just before a crash, is sent again.
+package outbox
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"sistemas_distribuidos_gb/internal/broker"
+	"sistemas_distribuidos_gb/internal/clock"
+)
+
+// Defaults of Options.
+const (
+	DefaultMaxBytes     = 256 << 20
+	DefaultSegmentBytes = 4 << 20
+	DefaultBatch        = 256
+	DefaultTimeout      = 5 * time.Second
+	DefaultRetry        = time.Second
+	maxRetry            = 30 * time.Second
+	syncInterval        = time.Second
+)
+
+// cursorFile holds the position of the first unconfirmed message.
+const cursorFile = "cursor.json"
+
+// Options configure an outbox.
+type Options struct {
+	Dir string
+	// MaxBytes bounds the messages kept on disk; past it the oldest segment
+	// is dropped. DefaultMaxBytes if zero.
+	MaxBytes     int64
+	SegmentBytes int64 // DefaultSegmentBytes if zero
+	// Batch is how many messages are sent before waiting for the uplink to
+	// confirm them, within Timeout. DefaultBatch and DefaultTimeout if zero.
+	Batch   int
+	Timeout time.Duration
+	// Retry is the wait after a failed batch, doubling up to 30s while the
+	// uplink stays down. DefaultRetry if zero.
+	Retry time.Duration
+	Clock clock.Clock // nil uses the wall clock
+}
+
+// Flusher is implemented by uplinks that can confirm that the server
+// received everything published so far, such as broker.NATS. For the
+// others, a Publish without error counts as confirmed.
+type Flusher interface {
+	Flush(timeout time.Duration) error
+}
+
+// Stats describe the state of an outbox.
+type Stats struct {
+	Pending      int64  `json:"pending"` // messages not confirmed yet
+	PendingBytes int64  `json:"pending_bytes"`
+	Sent         int64  `json:"sent"`
+	Dropped      int64  `json:"dropped"` // over MaxBytes
+	Connected    bool   `json:"connected"`
+	LastError    string `json:"last_error,omitempty"`
+}
+
+// record is a line of a segment.
+type record struct {
+	Subject string `json:"subject"`
+	Data    []byte `json:"data"`
+}
+
+// position is where a message starts: a segment and an offset in it.
+type position struct {
+	Segment int64 `json:"segment"`
+	Offset  int64 `json:"offset"`
+}
+
+type segment struct {
+	seq  int64
+	size int64
+}
+
+// Outbox buffers messages bound to an uplink.
+type Outbox struct {
+	uplink broker.Broker
+	opts   Options
+	clock  clock.Clock
+
+	mu       sync.Mutex
+	segments []segment // oldest first; the last is appended to
+	file     *os.File  // the last segment
+	dirty    bool      // written since the last sync
+	cursor   position
+	stats    Stats
+
+	wake      chan struct{}
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// Open opens the outbox in opts.Dir, creating it if needed, and starts
+// sending what it holds to uplink.
+func Open(uplink broker.Broker, opts Options) (*Outbox, error) {
+	if opts.Dir == "" {
+		return nil, errors.New("no outbox directory")
+	}
+	if opts.MaxBytes <= 0 {
+		opts.MaxBytes = DefaultMaxBytes
+	}
+	if opts.SegmentBytes <= 0 {
+		opts.SegmentBytes = DefaultSegmentBytes
+	}
+	if opts.SegmentBytes > opts.MaxBytes/4 {
+		// Dropping a segment must not drop most of the outbox
+		opts.SegmentBytes = opts.MaxBytes / 4
+	}
+	if opts.Batch <= 0 {
+		opts.Batch = DefaultBatch
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultTimeout
+	}
+	if opts.Retry <= 0 {
+		opts.Retry = DefaultRetry
+	}
+	if err := os.MkdirAll(opts.Dir, 0o755); err != nil {
+		return nil, err
+	}
+	o := &Outbox{
+		uplink: uplink,
+		opts:   opts,
+		clock:  clock.Or(opts.Clock),
+		stats:  Stats{Connected: true},
+		wake:   make(chan struct{}, 1),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	if err := o.load(); err != nil {
+		return nil, err
+	}
+	if o.stats.Pending > 0 {
+		log.Printf("Outbox %s holds %d messages to send", opts.Dir, o.stats.Pending)
+	}
+	go o.run()
+	return o, nil
+}
+
+func segmentName(seq int64) string {
+	return fmt.Sprintf("%020d.jsonl", seq)
+}
+
+func (o *Outbox) path(seq int64) string {
+	return filepath.Join(o.opts.Dir, segmentName(seq))
+}
+
+// load finds the segments and the cursor, drops the segments already sent,
+// cuts a message left half-written by a crash and counts what's pending.
+func (o *Outbox) load() error {
+	names, err := filepath.Glob(filepath.Join(o.opts.Dir, "*.jsonl"))
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		seq, err := strconv.ParseInt(strings.TrimSuffix(filepath.Base(name), ".jsonl"), 10, 64)
+		if err != nil {
+			continue
+		}
+		info, err := os.Stat(name)
+		if err != nil {
+			return err
+		}
+		o.segments = append(o.segments, segment{seq: seq, size: info.Size()})
+	}
+	sort.Slice(o.segments, func(i, j int) bool { return o.segments[i].seq < o.segments[j].seq })
+
+	if data, err := os.ReadFile(filepath.Join(o.opts.Dir, cursorFile)); err == nil {
+		json.Unmarshal(data, &o.cursor)
+	}
+	for len(o.segments) > 0 && o.segments[0].seq < o.cursor.Segment {
+		os.Remove(o.path(o.segments[0].seq))
+		o.segments = o.segments[1:]
+	}
+	if len(o.segments) == 0 || o.segments[0].seq != o.cursor.Segment {
+		// The cursor's segment is gone: start from the oldest there is
+		o.cursor = position{}
+		if len(o.segments) > 0 {
+			o.cursor.Segment = o.segments[0].seq
+		}
+	}
+	if len(o.segments) == 0 {
+		o.segments = []segment{{seq: 1}}
+		o.cursor = position{Segment: 1}
+	}
+
+	last := &o.segments[len(o.segments)-1]
+	o.file, err = os.OpenFile(o.path(last.seq), os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return err
+	}
+	data, err := io.ReadAll(o.file)
+	if err != nil {
+		return err
+	}
+	if end := int64(bytes.LastIndexByte(data, '\n') + 1); end < int64(len(data)) {
+		log.Printf("Outbox %s: dropping a message cut short in %s", o.opts.Dir, segmentName(last.seq))
+		if err := o.file.Truncate(end); err != nil {
+			return err
+		}
+		last.size = end
+	}
+	if _, err := o.file.Seek(last.size, io.SeekStart); err != nil {
+		return err
+	}
+
+	for _, s := range o.segments {
+		from := int64(0)
+		if s.seq == o.cursor.Segment {
+			from = o.cursor.Offset
+		}
+		n, err := countLines(o.path(s.seq), from)
+		if err != nil {
+			return err
+		}
+		o.stats.Pending += n
+		o.stats.PendingBytes += s.size - from
+	}
+	return nil
+}
+
+func countLines(path string, from int64) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	if _, err := f.Seek(from, io.SeekStart); err != nil {
+		return 0, err
+	}
+	var n int64
+	buf := make([]byte, 64<<10)
+	for {
+		k, err := f.Read(buf)
+		n += int64(bytes.Count(buf[:k], []byte{'\n'}))
+		if err == io.EOF {
+			return n, nil
+		}
+		if err != nil {
+			return n, err
+		}
+	}
+}
+
+// Publish appends a message to the outbox. It fails only if the message
+// can't be written to disk.
+func (o *Outbox) Publish(subject string, data []byte) error {
+	line, err := json.Marshal(record{Subject: subject, Data: data})
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	last := &o.segments[len(o.segments)-1]
+	if last.size > 0 && last.size+int64(len(line)) > o.opts.SegmentBytes {
+		if err := o.rotate(); err != nil {
+			return err
+		}
+		last = &o.segments[len(o.segments)-1]
+	}
+	if _, err := o.file.Write(line); err != nil {
+		return fmt.Errorf("outbox: %w", err)
+	}
+	last.size += int64(len(line))
+	o.dirty = true
+	o.stats.Pending++
+	o.stats.PendingBytes += int64(len(line))
+	o.enforceLimit()
+
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Subscribe subscribes on the uplink.
+func (o *Outbox) Subscribe(subject string, handler broker.Handler) (broker.Subscription, error) {
+	return o.uplink.Subscribe(subject, handler)
+}
+
+// rotate starts a new segment. Called with mu held.
+func (o *Outbox) rotate() error {
+	seq := o.segments[len(o.segments)-1].seq + 1
+	f, err := os.OpenFile(o.path(seq), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return fmt.Errorf("outbox: %w", err)
+	}
+	o.file.Sync()
+	o.file.Close()
+	o.file, o.dirty = f, false
+	o.segments = append(o.segments, segment{seq: seq})
+	return nil
+}
+
+// enforceLimit drops the oldest segments while the outbox holds more than
+// MaxBytes, always keeping the one being written. Called with mu held.
+func (o *Outbox) enforceLimit() {
+	for len(o.segments) > 1 && o.stats.PendingBytes > o.opts.MaxBytes {
+		oldest := o.segments[0]
+		dropped, _ := countLines(o.path(oldest.seq), o.cursor.Offset)
+		os.Remove(o.path(oldest.seq))
+		o.segments = o.segments[1:]
+		o.stats.Pending -= dropped
+		o.stats.PendingBytes -= oldest.size - o.cursor.Offset
+		o.stats.Dropped += dropped
+		o.cursor = position{Segment: o.segments[0].seq}
+		o.saveCursor()
+		log.Printf("Outbox %s over %d bytes: dropped %d unsent messages", o.opts.Dir, o.opts.MaxBytes, dropped)
+	}
+}
+
+// saveCursor writes the cursor, atomically. Called with mu held.
+func (o *Outbox) saveCursor() {
+	data, _ := json.Marshal(o.cursor)
+	tmp := filepath.Join(o.opts.Dir, cursorFile+".tmp")
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		log.Printf("Error saving outbox cursor: %v", err)
+		return
+	}
+	if err := os.Rename(tmp, filepath.Join(o.opts.Dir, cursorFile)); err != nil {
+		log.Printf("Error saving outbox cursor: %v", err)
+	}
+}
+
+// Stats returns the state of the outbox.
+func (o *Outbox) Stats() Stats {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.stats
+}
+
+// Close stops sending and closes the outbox; what wasn't sent stays on disk
+// for the next Open. Closing again returns the same error.
+func (o *Outbox) Close() error {
+	o.closeOnce.Do(func() {
+		close(o.stop)
+		<-o.done
+		o.mu.Lock()
+		defer o.mu.Unlock()
+		o.saveCursor()
+		if err := o.file.Sync(); err != nil {
+			o.file.Close()
+			o.closeErr = err
+			return
+		}
+		o.closeErr = o.file.Close()
+	})
+	return o.closeErr
+}
+
+// run sends the pending messages whenever there are some, and syncs the
+// segment being written every syncInterval.
+func (o *Outbox) run() {
+	defer close(o.done)
+	ticker := o.clock.NewTicker(syncInterval)
+	defer ticker.Stop()
+	retry := o.opts.Retry
+	healthy := false // the last batch was confirmed
+	for {
+		batch, skipped, cur, next, err := o.read()
+		if err != nil {
+			log.Printf("Error reading outbox %s: %v", o.opts.Dir, err)
+		}
+		if len(batch) == 0 && next != cur {
+			o.commit(next, 0, skipped)
+			continue
+		}
+		if len(batch) > 0 {
+			if err := o.send(batch, healthy); err != nil {
+				healthy = false
+				o.fail(err)
+				select {
+				case <-o.clock.After(retry):
+				case <-o.stop:
+					return
+				}
+				if retry *= 2; retry > maxRetry {
+					retry = maxRetry
+				}
+				continue
+			}
+			healthy, retry = true, o.opts.Retry
+			o.commit(next, len(batch), skipped)
+			continue
+		}
+
+		select {
+		case <-o.wake:
+		case <-ticker.C():
+			o.sync()
+		case <-o.stop:
+			return
+		}
+	}
+}
+
+// read returns up to Batch messages from the cursor, how many corrupt ones it
+// skipped on the way, the cursor, and the position past them. At the end of
+// a segment that's no longer written, the position is the start of the next.
+func (o *Outbox) read() (batch []record, skipped int, cur, next position, err error) {
+	o.mu.Lock()
+	cur = o.cursor
+	lastSeq := o.segments[len(o.segments)-1].seq
+	o.mu.Unlock()
+
+	f, err := os.Open(o.path(cur.Segment))
+	if err != nil {
+		return nil, 0, cur, cur, err
+	}
+	defer f.Close()
+	if _, err := f.Seek(cur.Offset, io.SeekStart); err != nil {
+		return nil, 0, cur, cur, err
+	}
+	r := bufio.NewReader(f)
+	next = cur
+	for len(batch) < o.opts.Batch {
+		line, err := r.ReadBytes('\n')
+		if err != nil {
+			// A line without its newline is still being written
+			if cur.Segment < lastSeq && len(batch) == 0 && len(line) == 0 {
+				next = position{Segment: cur.Segment + 1}
+			}
+			break
+		}
+		next.Offset += int64(len(line))
+		var rec record
+		if err := json.Unmarshal(line, &rec); err != nil {
+			log.Printf("Outbox %s: skipping a corrupt message in %s", o.opts.Dir, segmentName(cur.Segment))
+			skipped++
+			continue
+		}
+		batch = append(batch, rec)
+	}
+	return batch, skipped, cur, next, nil
+}
+
+// send publishes a batch and waits for the uplink to confirm it. Unless the
+// last batch went through, it first checks that the uplink is up.
+func (o *Outbox) send(batch []record, healthy bool) error {
+	f, confirms := o.uplink.(Flusher)
+	if confirms && !healthy {
+		// Don't pile messages up in the client while it reconnects
+		if err := f.Flush(o.opts.Timeout); err != nil {
+			return err
+		}
+	}
+	for _, rec := range batch {
+		if err := o.uplink.Publish(rec.Subject, rec.Data); err != nil {
+			return err
+		}
+	}
+	if confirms {
+		return f.Flush(o.opts.Timeout)
+	}
+	return nil
+}
+
+func (o *Outbox) fail(err error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.stats.Connected {
+		log.Printf("Uplink unavailable, buffering in %s: %v", o.opts.Dir, err)
+	}
+	o.stats.Connected = false
+	o.stats.LastError = err.Error()
+}
+
+// commit moves the cursor to next, past n sent messages and skipped corrupt
+// ones, and removes the segment it left.
+func (o *Outbox) commit(next position, n, skipped int) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if next.Segment < o.cursor.Segment {
+		return // the segment was dropped meanwhile
+	}
+	if !o.stats.Connected {
+		log.Printf("Uplink back, replaying %d buffered messages", o.stats.Pending)
+		o.stats.Connected, o.stats.LastError = true, ""
+	}
+	if next.Segment > o.cursor.Segment {
+		os.Remove(o.path(o.cursor.Segment))
+		o.stats.PendingBytes -= o.segments[0].size - o.cursor.Offset
+		o.segments = o.segments[1:]
+	} else {
+		o.stats.PendingBytes -= next.Offset - o.cursor.Offset
+	}
+	o.cursor = next
+	o.stats.Pending -= int64(n + skipped)
+	o.stats.Sent += int64(n)
+	o.saveCursor()
+}
+
+func (o *Outbox) sync() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.dirty {
+		o.file.Sync()
+		o.dirty = false
+	}
+}
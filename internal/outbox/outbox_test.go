@@ -0,0 +1,200 @@
+package outbox
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"sistemas_distribuidos_gb/internal/broker"
+)
+
+// uplink records what it receives, confirms batches like broker.NATS and
+// can be taken down.
+type uplink struct {
+	mu         sync.Mutex
+	down       bool
+	got        []string
+	sinceFlush int
+	maxBatch   int
+}
+
+func (u *uplink) Publish(subject string, data []byte) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.down {
+		return errors.New("uplink down")
+	}
+	u.got = append(u.got, subject+" "+string(data))
+	u.sinceFlush++
+	return nil
+}
+
+func (u *uplink) Subscribe(string, broker.Handler) (broker.Subscription, error) {
+	return nil, errors.New("not supported")
+}
+
+func (u *uplink) Flush(time.Duration) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.down {
+		return errors.New("uplink down")
+	}
+	if u.sinceFlush > u.maxBatch {
+		u.maxBatch = u.sinceFlush
+	}
+	u.sinceFlush = 0
+	return nil
+}
+
+func (u *uplink) setDown(down bool) {
+	u.mu.Lock()
+	u.down = down
+	u.mu.Unlock()
+}
+
+func (u *uplink) received() []string {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return append([]string(nil), u.got...)
+}
+
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); !cond(); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+	}
+}
+
+// TestReplay buffers messages while the uplink is down, across a restart,
+// and checks they're sent in order, in confirmed batches, once it's back.
+func TestReplay(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	dir := t.TempDir()
+	up := &uplink{down: true}
+	opts := Options{Dir: dir, SegmentBytes: 4 << 10, Batch: 50, Retry: 10 * time.Millisecond}
+	o, err := Open(up, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const n = 1000
+	var want []string
+	for i := 0; i < n; i++ {
+		subject := []string{"edge.e1.filtered", "edge.e1.alerts"}[i%2]
+		data := fmt.Sprintf(`{"n":%d}`, i)
+		if err := o.Publish(subject, []byte(data)); err != nil {
+			t.Fatal(err)
+		}
+		want = append(want, subject+" "+data)
+	}
+	eventually(t, "the outbox to notice the uplink is down", func() bool { return !o.Stats().Connected })
+	if s := o.Stats(); s.Pending != n || s.Sent != 0 {
+		t.Errorf("stats while down: %+v", s)
+	}
+	if err := o.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A crash left half a message at the end
+	segments, _ := filepath.Glob(filepath.Join(dir, "*.jsonl"))
+	if len(segments) < 3 {
+		t.Fatalf("%d segments, want several", len(segments))
+	}
+	f, _ := os.OpenFile(segments[len(segments)-1], os.O_APPEND|os.O_WRONLY, 0)
+	f.WriteString(`{"subject":"edge.e1.filtered","da`)
+	f.Close()
+
+	o, err = Open(up, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer o.Close()
+	if s := o.Stats(); s.Pending != n {
+		t.Errorf("%d messages pending after the restart, want %d", s.Pending, n)
+	}
+	o.Publish("edge.e1.aggregate", []byte(`{"n":1000}`))
+	want = append(want, `edge.e1.aggregate {"n":1000}`)
+
+	up.setDown(false)
+	eventually(t, "the replay", func() bool { return o.Stats().Pending == 0 })
+	got := up.received()
+	if len(got) != len(want) {
+		t.Fatalf("received %d messages, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("message %d is %q, want %q", i, got[i], want[i])
+		}
+	}
+	if s := o.Stats(); !s.Connected || s.Sent != n+1 || s.PendingBytes != 0 {
+		t.Errorf("stats after the replay: %+v", s)
+	}
+	if up.maxBatch > opts.Batch {
+		t.Errorf("%d messages sent before a confirmation, want at most %d", up.maxBatch, opts.Batch)
+	}
+	if segments, _ := filepath.Glob(filepath.Join(dir, "*.jsonl")); len(segments) != 1 {
+		t.Errorf("%d segments left after the replay, want the one being written", len(segments))
+	}
+}
+
+// TestMaxBytes checks that the oldest messages are dropped past the limit.
+func TestMaxBytes(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	up := &uplink{down: true}
+	o, err := Open(up, Options{Dir: t.TempDir(), MaxBytes: 16 << 10, Retry: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer o.Close()
+	const n = 2000
+	for i := 0; i < n; i++ {
+		o.Publish("edge.e1.filtered", []byte(fmt.Sprintf(`{"n":%04d}`, i)))
+	}
+	s := o.Stats()
+	if s.Dropped == 0 || s.PendingBytes > 16<<10 || s.Pending+s.Dropped != n {
+		t.Errorf("stats over the limit: %+v", s)
+	}
+
+	up.setDown(false)
+	eventually(t, "the replay", func() bool { return o.Stats().Pending == 0 })
+	got := up.received()
+	if int64(len(got)) != n-s.Dropped || got[len(got)-1] != fmt.Sprintf(`edge.e1.filtered {"n":%04d}`, n-1) {
+		t.Errorf("received %d messages ending with %q; want the newest %d", len(got), got[len(got)-1], n-s.Dropped)
+	}
+}
+
+// TestCorrupt checks that corrupt messages are skipped and no longer count
+// as pending.
+func TestCorrupt(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	dir := t.TempDir()
+	lines := `{"subject":"edge.e1.filtered","data":"eyJuIjowfQ=="}` + "\n" +
+		"not json\n" +
+		`{"subject":"edge.e1.filtered","data":"eyJuIjoxfQ=="}` + "\n"
+	if err := os.WriteFile(filepath.Join(dir, segmentName(1)), []byte(lines), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	up := &uplink{}
+	o, err := Open(up, Options{Dir: dir, Retry: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer o.Close()
+	eventually(t, "the replay", func() bool { return o.Stats().Pending == 0 })
+	if s, got := o.Stats(), up.received(); s.Sent != 2 || s.PendingBytes != 0 || len(got) != 2 || got[1] != `edge.e1.filtered {"n":1}` {
+		t.Errorf("received %q, stats %+v", got, s)
+	}
+}
@@ -0,0 +1,171 @@
+package registry
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+
+	"sistemas_distribuidos_gb/internal/subjects"
+)
+
+// missTTL is how long an unsuccessful lookup is remembered, so that readings
+// from unregistered sensors don't trigger a request each. A sensor that
+// registers meanwhile is found at once: its announcement drops the miss.
+const missTTL = 5 * time.Second
+
+// maxCached bounds the entries a client keeps; past it the oldest is dropped.
+const maxCached = 10000
+
+type cacheEntry struct {
+	sensor  *Sensor
+	fetched time.Time
+}
+
+// Client resolves sensor metadata through the registry service and caches the
+// results. Cached entries are dropped when the registry announces a change.
+type Client struct {
+	mu      sync.RWMutex
+	nc      *nats.Conn
+	timeout time.Duration
+	cache   map[string]cacheEntry
+	sub     *nats.Subscription
+}
+
+// NewClient creates a client that waits up to timeout for registry replies.
+func NewClient(nc *nats.Conn, timeout time.Duration) (*Client, error) {
+	c := &Client{
+		nc:      nc,
+		timeout: timeout,
+		cache:   make(map[string]cacheEntry),
+	}
+	sub, err := nc.Subscribe(subjects.AllRegistryUpdates, c.handleUpdate)
+	if err != nil {
+		return nil, fmt.Errorf("subscribe to registry updates: %w", err)
+	}
+	c.sub = sub
+	return c, nil
+}
+
+// Close stops following registry updates.
+func (c *Client) Close() {
+	if c.sub != nil {
+		c.sub.Unsubscribe()
+	}
+}
+
+// Lookup returns the metadata of a sensor, or false if it isn't registered
+// or the registry couldn't be reached.
+func (c *Client) Lookup(id string) (*Sensor, bool) {
+	c.mu.RLock()
+	entry, ok := c.cache[id]
+	c.mu.RUnlock()
+	if ok && (entry.sensor != nil || time.Since(entry.fetched) < missTTL) {
+		return entry.sensor, entry.sensor != nil
+	}
+
+	reply, err := c.request(subjects.RegistryLookup, []byte(id))
+	var sensor *Sensor
+	if err == nil {
+		sensor = reply.Sensor
+	}
+
+	c.store(id, sensor)
+	return sensor, sensor != nil
+}
+
+// Announce registers the calling sensor and returns its stored entry.
+func (c *Client) Announce(s Sensor) (*Sensor, error) {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return nil, err
+	}
+	reply, err := c.request(subjects.RegistryAnnounce, data)
+	if err != nil {
+		return nil, err
+	}
+	c.store(reply.Sensor.ID, reply.Sensor)
+	return reply.Sensor, nil
+}
+
+// Get fetches the current entry of a sensor from the registry, bypassing the
+// cache.
+func (c *Client) Get(id string) (*Sensor, error) {
+	reply, err := c.request(subjects.RegistryLookup, []byte(id))
+	if err != nil {
+		return nil, err
+	}
+	return reply.Sensor, nil
+}
+
+// Update replaces the operator-managed fields of an entry (see
+// Service.Update) and returns the stored entry.
+func (c *Client) Update(s Sensor) (*Sensor, error) {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return nil, err
+	}
+	reply, err := c.request(subjects.RegistryUpdate, data)
+	if err != nil {
+		return nil, err
+	}
+	return reply.Sensor, nil
+}
+
+// List returns every registered sensor.
+func (c *Client) List() ([]Sensor, error) {
+	reply, err := c.request(subjects.RegistryList, nil)
+	if err != nil {
+		return nil, err
+	}
+	return reply.Sensors, nil
+}
+
+// store caches the result of a lookup, making room if the cache is full.
+func (c *Client) store(id string, sensor *Sensor) {
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.cache[id]; !ok && len(c.cache) >= maxCached {
+		oldest, at := "", now
+		for k, entry := range c.cache {
+			if entry.fetched.Before(at) || oldest == "" {
+				oldest, at = k, entry.fetched
+			}
+		}
+		delete(c.cache, oldest)
+	}
+	c.cache[id] = cacheEntry{sensor: sensor, fetched: now}
+}
+
+func (c *Client) request(subject string, data []byte) (*Reply, error) {
+	msg, err := c.nc.Request(subject, data, c.timeout)
+	if err != nil {
+		return nil, err
+	}
+	var reply Reply
+	if err := json.Unmarshal(msg.Data, &reply); err != nil {
+		return nil, err
+	}
+	if reply.Error != "" {
+		if reply.Error == ErrNotFound.Error() {
+			return nil, ErrNotFound
+		}
+		return nil, errors.New(reply.Error)
+	}
+	return &reply, nil
+}
+
+func (c *Client) handleUpdate(msg *nats.Msg) {
+	var sensor Sensor
+	if err := json.Unmarshal(msg.Data, &sensor); err != nil || sensor.ID == "" {
+		return
+	}
+	// Drop rather than store: the notification for a delete carries only the ID
+	c.mu.Lock()
+	delete(c.cache, sensor.ID)
+	c.mu.Unlock()
+}
@@ -0,0 +1,157 @@
+// Package registry keeps the metadata of every known sensor: where it is
+// installed, what it measures, who owns it, how to calibrate it and which
+// threshold rules apply to it.
+//
+// The cloud owns the registry (see Service). Sensors announce themselves on
+// startup, and edge, cloud and dashboard resolve metadata through a Client,
+// which caches entries and follows change notifications.
+package registry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrNotFound is returned when a sensor isn't registered.
+var ErrNotFound = errors.New("sensor not registered")
+
+// Sensor is the registry entry of a single device.
+type Sensor struct {
+	ID          string            `json:"id"`
+	Site        string            `json:"site,omitempty"`
+	Line        string            `json:"line,omitempty"`
+	Location    string            `json:"location,omitempty"`
+	Unit        string            `json:"unit,omitempty"`
+	Owner       string            `json:"owner,omitempty"`
+	Description string            `json:"description,omitempty"`
+	Calibration Calibration       `json:"calibration"`
+	Thresholds  *Thresholds       `json:"thresholds,omitempty"`
+	Tags        map[string]string `json:"tags,omitempty"`
+	// PublicKey is the NKey the sensor signs its readings with.
+	PublicKey string `json:"public_key,omitempty"`
+
+	RegisteredAt  time.Time `json:"registered_at"`
+	LastAnnounced time.Time `json:"last_announced,omitempty"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// Calibration corrects raw readings as value*Scale + Offset.
+// A zero Scale is treated as 1 so an empty calibration is the identity.
+type Calibration struct {
+	Offset float64 `json:"offset"`
+	Scale  float64 `json:"scale,omitempty"`
+}
+
+// Apply returns the calibrated value.
+func (c Calibration) Apply(v float64) float64 {
+	scale := c.Scale
+	if scale == 0 {
+		scale = 1
+	}
+	return v*scale + c.Offset
+}
+
+// Thresholds are the per-sensor alert bands. Values outside
+// [CriticalMin, CriticalMax] are critical, values outside
+// [WarningMin, WarningMax] are warnings.
+type Thresholds struct {
+	WarningMin  float64 `json:"warning_min"`
+	WarningMax  float64 `json:"warning_max"`
+	CriticalMin float64 `json:"critical_min"`
+	CriticalMax float64 `json:"critical_max"`
+}
+
+// Validate checks that the warning band lies within the critical one.
+func (t Thresholds) Validate() error {
+	if !(t.CriticalMin <= t.WarningMin && t.WarningMin <= t.WarningMax && t.WarningMax <= t.CriticalMax) {
+		return errors.New("thresholds must satisfy critical_min <= warning_min <= warning_max <= critical_max")
+	}
+	return nil
+}
+
+// Store persists registry entries.
+type Store interface {
+	Get(ctx context.Context, id string) (*Sensor, error)
+	Put(ctx context.Context, s *Sensor) error
+	Delete(ctx context.Context, id string) error
+	List(ctx context.Context) ([]Sensor, error)
+}
+
+// mergeAnnouncement folds what a sensor reports about itself into the stored
+// entry. Location facts that come from the sensor's own configuration (site
+// and line) always win; descriptive fields only fill gaps so that edits made
+// by an operator survive restarts. Calibration and thresholds are never taken
+// from announcements. The public key is trusted on first use: once stored, a
+// different key in an announcement is ignored and only an operator can change
+// it.
+func mergeAnnouncement(stored *Sensor, ann Sensor, now time.Time) *Sensor {
+	if stored == nil {
+		s := ann
+		s.Calibration = Calibration{}
+		s.Thresholds = nil
+		s.RegisteredAt = now
+		s.UpdatedAt = now
+		s.LastAnnounced = now
+		return &s
+	}
+
+	merged := *stored
+	if ann.Site != "" {
+		merged.Site = ann.Site
+	}
+	if ann.Line != "" {
+		merged.Line = ann.Line
+	}
+	fill := func(dst *string, v string) {
+		if *dst == "" {
+			*dst = v
+		}
+	}
+	fill(&merged.Location, ann.Location)
+	fill(&merged.Unit, ann.Unit)
+	fill(&merged.Owner, ann.Owner)
+	fill(&merged.Description, ann.Description)
+	fill(&merged.PublicKey, ann.PublicKey)
+	for k, v := range ann.Tags {
+		if merged.Tags == nil {
+			merged.Tags = make(map[string]string)
+		}
+		if _, ok := merged.Tags[k]; !ok {
+			merged.Tags[k] = v
+		}
+	}
+	merged.LastAnnounced = now
+	return &merged
+}
+
+// key maps a sensor ID onto the characters allowed in KV keys and file
+// names. Letters, digits, '-' and '_' are kept and every other byte is
+// escaped as =XX, so that different IDs never share a key.
+func key(id string) string {
+	var b strings.Builder
+	for i := 0; i < len(id); i++ {
+		c := id[i]
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_':
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "=%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// legacyKey is the key entries were stored under before key escaped IDs:
+// every disallowed character became '_'.
+func legacyKey(id string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_':
+			return r
+		}
+		return '_'
+	}, id)
+}
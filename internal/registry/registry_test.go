@@ -0,0 +1,163 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+
+	"sistemas_distribuidos_gb/internal/embedded"
+)
+
+// TestMergeAnnouncement checks which fields an announcement may set, and
+// that the first public key a sensor announces is the one kept.
+func TestMergeAnnouncement(t *testing.T) {
+	t0 := time.Date(2024, 1, 1, 8, 0, 0, 0, time.UTC)
+	first := mergeAnnouncement(nil, Sensor{
+		ID: "s1", Site: "plant-a", Line: "line-1", Unit: "°C", PublicKey: "UKEY1",
+		Calibration: Calibration{Offset: 5}, Thresholds: &Thresholds{CriticalMax: 1},
+	}, t0)
+	if first.Calibration != (Calibration{}) || first.Thresholds != nil {
+		t.Errorf("calibration and thresholds taken from the announcement: %+v", first)
+	}
+	if first.PublicKey != "UKEY1" || !first.RegisteredAt.Equal(t0) {
+		t.Errorf("new entry %+v", first)
+	}
+
+	// An operator set the unit and calibration
+	first.Unit, first.Calibration = "K", Calibration{Offset: 273.15}
+	t1 := t0.Add(time.Hour)
+	merged := mergeAnnouncement(first, Sensor{
+		ID: "s1", Site: "plant-b", Unit: "°C", Owner: "maintenance", PublicKey: "UKEY2",
+		Tags: map[string]string{"floor": "2"},
+	}, t1)
+	if merged.Site != "plant-b" || merged.Line != "line-1" {
+		t.Errorf("location %s/%s, want plant-b/line-1", merged.Site, merged.Line)
+	}
+	if merged.Unit != "K" || merged.Owner != "maintenance" || merged.Tags["floor"] != "2" {
+		t.Errorf("descriptive fields %+v", merged)
+	}
+	if merged.PublicKey != "UKEY1" {
+		t.Errorf("public key %s, want the first one announced", merged.PublicKey)
+	}
+	if merged.Calibration.Offset != 273.15 || !merged.LastAnnounced.Equal(t1) || !merged.RegisteredAt.Equal(t0) {
+		t.Errorf("merged entry %+v", merged)
+	}
+}
+
+func TestKey(t *testing.T) {
+	for _, tc := range []struct{ id, want string }{
+		{"sensor-01", "sensor-01"},
+		{"a_b", "a_b"},
+		{"a.b", "a=2Eb"},
+		{"a=b", "a=3Db"},
+		{"temp 1", "temp=201"},
+	} {
+		if got := key(tc.id); got != tc.want {
+			t.Errorf("key(%q) = %q, want %q", tc.id, got, tc.want)
+		}
+	}
+}
+
+// testStore checks the behaviour every Store shares, including that IDs
+// differing only in characters a key can't hold don't overwrite each other.
+func testStore(t *testing.T, store Store) {
+	t.Helper()
+	ctx := context.Background()
+	for _, id := range []string{"a.b", "a_b", "a b"} {
+		if err := store.Put(ctx, &Sensor{ID: id, Location: "at " + id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, id := range []string{"a.b", "a_b", "a b"} {
+		s, err := store.Get(ctx, id)
+		if err != nil || s.ID != id || s.Location != "at "+id {
+			t.Errorf("Get(%q) = %+v, %v", id, s, err)
+		}
+	}
+	if _, err := store.Get(ctx, "missing"); err != ErrNotFound {
+		t.Errorf("Get of a missing sensor: %v", err)
+	}
+	if err := store.Delete(ctx, "a.b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Delete(ctx, "a.b"); err != ErrNotFound {
+		t.Errorf("second Delete: %v", err)
+	}
+	sensors, err := store.List(ctx)
+	if err != nil || len(sensors) != 2 || sensors[0].ID != "a b" || sensors[1].ID != "a_b" {
+		t.Errorf("List = %+v, %v", sensors, err)
+	}
+}
+
+func TestFileStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "registry", "sensors.json")
+	store, err := NewFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testStore(t, store)
+
+	reloaded, err := NewFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sensors, _ := reloaded.List(context.Background()); len(sensors) != 2 {
+		t.Errorf("%d sensors after reloading, want 2", len(sensors))
+	}
+}
+
+func TestKVStore(t *testing.T) {
+	ns, err := embedded.Start(embedded.Options{JetStream: true, StoreDir: filepath.Join(t.TempDir(), "jetstream")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ns.Shutdown()
+	nc, err := nats.Connect(ns.ClientURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nc.Close()
+	js, _ := jetstream.New(nc)
+	ctx := context.Background()
+	store, err := NewKVStore(ctx, js, "test-registry")
+	if err != nil {
+		t.Fatal(err)
+	}
+	testStore(t, store)
+
+	// An entry written under the old lossy key is still found, and moved
+	kv, _ := js.KeyValue(ctx, "test-registry")
+	data, _ := json.Marshal(Sensor{ID: "old.sensor", Unit: "°C"})
+	kv.Put(ctx, "old_sensor", data)
+	if s, err := store.Get(ctx, "old.sensor"); err != nil || s.Unit != "°C" {
+		t.Fatalf("legacy entry: %+v, %v", s, err)
+	}
+	if _, err := store.Get(ctx, "old_sensor"); err != ErrNotFound {
+		t.Errorf("legacy entry found under another ID: %v", err)
+	}
+	if err := store.Put(ctx, &Sensor{ID: "old.sensor", Unit: "K"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := kv.Get(ctx, "old_sensor"); err != jetstream.ErrKeyNotFound {
+		t.Errorf("legacy key left after rewriting the entry: %v", err)
+	}
+}
+
+func TestClientCacheBound(t *testing.T) {
+	c := &Client{cache: make(map[string]cacheEntry)}
+	for i := 0; i < maxCached+10; i++ {
+		c.store(fmt.Sprintf("s%d", i), nil)
+	}
+	if len(c.cache) != maxCached {
+		t.Errorf("%d entries cached, want %d", len(c.cache), maxCached)
+	}
+	if _, ok := c.cache[fmt.Sprintf("s%d", maxCached+9)]; !ok {
+		t.Error("the newest entry was evicted")
+	}
+}
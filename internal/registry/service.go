@@ -0,0 +1,223 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+
+	"sistemas_distribuidos_gb/internal/subjects"
+)
+
+// Reply is the response to every registry request.
+type Reply struct {
+	Sensor  *Sensor  `json:"sensor,omitempty"`
+	Sensors []Sensor `json:"sensors,omitempty"`
+	Error   string   `json:"error,omitempty"`
+}
+
+// Service answers announcements and lookups over NATS and publishes a
+// notification whenever an entry changes.
+type Service struct {
+	mu    sync.Mutex // serializes read-modify-write cycles on the store
+	nc    *nats.Conn
+	store Store
+	subs  []*nats.Subscription
+}
+
+// NewService creates a registry service backed by store.
+func NewService(nc *nats.Conn, store Store) *Service {
+	return &Service{nc: nc, store: store}
+}
+
+// Start subscribes to the registry request subjects.
+func (s *Service) Start() error {
+	handlers := map[string]nats.MsgHandler{
+		subjects.RegistryAnnounce: s.handleAnnounce,
+		subjects.RegistryLookup:   s.handleLookup,
+		subjects.RegistryList:     s.handleList,
+		subjects.RegistryUpdate:   s.handleUpdate,
+	}
+	for subject, handler := range handlers {
+		// Queue group so that several cloud instances can share the work
+		sub, err := s.nc.QueueSubscribe(subject, "registry", handler)
+		if err != nil {
+			s.Stop()
+			return err
+		}
+		s.subs = append(s.subs, sub)
+	}
+	return nil
+}
+
+// Stop unsubscribes from the request subjects.
+func (s *Service) Stop() {
+	for _, sub := range s.subs {
+		sub.Unsubscribe()
+	}
+	s.subs = nil
+}
+
+// Announce registers a sensor or refreshes its entry.
+func (s *Service) Announce(ctx context.Context, ann Sensor) (*Sensor, error) {
+	if ann.ID == "" {
+		return nil, errors.New("sensor id is required")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, err := s.store.Get(ctx, ann.ID)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+	if stored != nil && stored.PublicKey != "" && ann.PublicKey != "" && ann.PublicKey != stored.PublicKey {
+		log.Printf("Sensor %s announced a different public key; keeping the registered one", ann.ID)
+	}
+	merged := mergeAnnouncement(stored, ann, time.Now())
+	if err := s.store.Put(ctx, merged); err != nil {
+		return nil, err
+	}
+	if stored == nil {
+		log.Printf("Sensor registered: id=%s, site=%s, line=%s, location=%s", merged.ID, merged.Site, merged.Line, merged.Location)
+	}
+	s.notify(merged)
+	return merged, nil
+}
+
+// Get returns a single entry.
+func (s *Service) Get(ctx context.Context, id string) (*Sensor, error) {
+	return s.store.Get(ctx, id)
+}
+
+// List returns every entry, sorted by ID.
+func (s *Service) List(ctx context.Context) ([]Sensor, error) {
+	return s.store.List(ctx)
+}
+
+// Update replaces the operator-managed fields of an entry, creating it if needed.
+func (s *Service) Update(ctx context.Context, sensor Sensor) (*Sensor, error) {
+	if sensor.ID == "" {
+		return nil, errors.New("sensor id is required")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	stored, err := s.store.Get(ctx, sensor.ID)
+	switch {
+	case errors.Is(err, ErrNotFound):
+		sensor.RegisteredAt = now
+	case err != nil:
+		return nil, err
+	default:
+		// Site, line and key come from the sensor itself; keep them unless overridden
+		if sensor.Site == "" {
+			sensor.Site = stored.Site
+		}
+		if sensor.Line == "" {
+			sensor.Line = stored.Line
+		}
+		if sensor.PublicKey == "" {
+			sensor.PublicKey = stored.PublicKey
+		}
+		sensor.RegisteredAt = stored.RegisteredAt
+		sensor.LastAnnounced = stored.LastAnnounced
+	}
+	sensor.UpdatedAt = now
+	if err := s.store.Put(ctx, &sensor); err != nil {
+		return nil, err
+	}
+	s.notify(&sensor)
+	return &sensor, nil
+}
+
+// Delete removes an entry.
+func (s *Service) Delete(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.store.Delete(ctx, id); err != nil {
+		return err
+	}
+	s.notify(&Sensor{ID: id})
+	return nil
+}
+
+// notify tells clients to drop their cached copy of the entry.
+func (s *Service) notify(sensor *Sensor) {
+	data, err := json.Marshal(sensor)
+	if err != nil {
+		return
+	}
+	if err := s.nc.Publish(subjects.RegistryUpdated(sensor.ID), data); err != nil {
+		log.Printf("Error publishing registry update: %v", err)
+	}
+}
+
+func (s *Service) handleAnnounce(msg *nats.Msg) {
+	var ann Sensor
+	if err := json.Unmarshal(msg.Data, &ann); err != nil {
+		respond(msg, Reply{Error: "invalid announcement: " + err.Error()})
+		return
+	}
+	sensor, err := s.Announce(context.Background(), ann)
+	if err != nil {
+		respond(msg, Reply{Error: err.Error()})
+		return
+	}
+	respond(msg, Reply{Sensor: sensor})
+}
+
+func (s *Service) handleLookup(msg *nats.Msg) {
+	id := strings.TrimSpace(string(msg.Data))
+	sensor, err := s.store.Get(context.Background(), id)
+	if err != nil {
+		respond(msg, Reply{Error: err.Error()})
+		return
+	}
+	respond(msg, Reply{Sensor: sensor})
+}
+
+func (s *Service) handleList(msg *nats.Msg) {
+	sensors, err := s.store.List(context.Background())
+	if err != nil {
+		respond(msg, Reply{Error: err.Error()})
+		return
+	}
+	respond(msg, Reply{Sensors: sensors})
+}
+
+func (s *Service) handleUpdate(msg *nats.Msg) {
+	var sensor Sensor
+	if err := json.Unmarshal(msg.Data, &sensor); err != nil {
+		respond(msg, Reply{Error: "invalid sensor: " + err.Error()})
+		return
+	}
+	updated, err := s.Update(context.Background(), sensor)
+	if err != nil {
+		respond(msg, Reply{Error: err.Error()})
+		return
+	}
+	log.Printf("Sensor metadata updated: id=%s", sensor.ID)
+	respond(msg, Reply{Sensor: updated})
+}
+
+func respond(msg *nats.Msg, reply Reply) {
+	if msg.Reply == "" {
+		return
+	}
+	data, err := json.Marshal(reply)
+	if err != nil {
+		return
+	}
+	if err := msg.Respond(data); err != nil {
+		log.Printf("Error responding to registry request: %v", err)
+	}
+}
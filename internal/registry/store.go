@@ -0,0 +1,216 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// DefaultBucket is the NATS KV bucket the registry is kept in.
+const DefaultBucket = "sensor-registry"
+
+// KVStore keeps entries in a NATS JetStream key-value bucket.
+type KVStore struct {
+	kv jetstream.KeyValue
+}
+
+// NewKVStore opens (creating it if needed) the registry bucket.
+func NewKVStore(ctx context.Context, js jetstream.JetStream, bucket string) (*KVStore, error) {
+	kv, err := js.KeyValue(ctx, bucket)
+	if errors.Is(err, jetstream.ErrBucketNotFound) {
+		kv, err = js.CreateKeyValue(ctx, jetstream.KeyValueConfig{
+			Bucket:      bucket,
+			Description: "Sensor metadata and calibration",
+			History:     5,
+		})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("open registry bucket %s: %w", bucket, err)
+	}
+	return &KVStore{kv: kv}, nil
+}
+
+func (s *KVStore) Get(ctx context.Context, id string) (*Sensor, error) {
+	sensor, err := s.get(ctx, id, key(id))
+	if errors.Is(err, ErrNotFound) && legacyKey(id) != key(id) {
+		// Entries written before IDs were escaped
+		sensor, err = s.get(ctx, id, legacyKey(id))
+	}
+	if err == nil && sensor.ID != id {
+		// A legacy key, taken by another ID
+		return nil, ErrNotFound
+	}
+	return sensor, err
+}
+
+func (s *KVStore) get(ctx context.Context, id, k string) (*Sensor, error) {
+	entry, err := s.kv.Get(ctx, k)
+	if errors.Is(err, jetstream.ErrKeyNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var sensor Sensor
+	if err := json.Unmarshal(entry.Value(), &sensor); err != nil {
+		return nil, fmt.Errorf("decode registry entry %s: %w", id, err)
+	}
+	return &sensor, nil
+}
+
+func (s *KVStore) Put(ctx context.Context, sensor *Sensor) error {
+	data, err := json.Marshal(sensor)
+	if err != nil {
+		return err
+	}
+	if _, err = s.kv.Put(ctx, key(sensor.ID), data); err != nil {
+		return err
+	}
+	s.deleteLegacy(ctx, sensor.ID)
+	return nil
+}
+
+func (s *KVStore) Delete(ctx context.Context, id string) error {
+	if _, err := s.Get(ctx, id); err != nil {
+		return err
+	}
+	if err := s.kv.Delete(ctx, key(id)); err != nil && !errors.Is(err, jetstream.ErrKeyNotFound) {
+		return err
+	}
+	s.deleteLegacy(ctx, id)
+	return nil
+}
+
+// deleteLegacy removes the entry of id stored under its legacy key, once
+// it has been rewritten under the escaped one.
+func (s *KVStore) deleteLegacy(ctx context.Context, id string) {
+	if legacyKey(id) == key(id) {
+		return
+	}
+	if old, err := s.get(ctx, id, legacyKey(id)); err == nil && old.ID == id {
+		s.kv.Delete(ctx, legacyKey(id))
+	}
+}
+
+func (s *KVStore) List(ctx context.Context) ([]Sensor, error) {
+	keys, err := s.kv.Keys(ctx)
+	if errors.Is(err, jetstream.ErrNoKeysFound) {
+		return []Sensor{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	sensors := make([]Sensor, 0, len(keys))
+	for _, k := range keys {
+		entry, err := s.kv.Get(ctx, k)
+		if err != nil {
+			continue // deleted between Keys and Get
+		}
+		var sensor Sensor
+		if err := json.Unmarshal(entry.Value(), &sensor); err != nil {
+			continue
+		}
+		sensors = append(sensors, sensor)
+	}
+	sortSensors(sensors)
+	return sensors, nil
+}
+
+// FileStore keeps entries in a single JSON file, for deployments without
+// JetStream. The whole file is rewritten on every change.
+type FileStore struct {
+	mu      sync.RWMutex
+	path    string
+	sensors map[string]Sensor
+}
+
+// NewFileStore loads the registry file at path, starting empty if it doesn't exist.
+func NewFileStore(path string) (*FileStore, error) {
+	s := &FileStore{path: path, sensors: make(map[string]Sensor)}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var sensors []Sensor
+	if err := json.Unmarshal(data, &sensors); err != nil {
+		return nil, fmt.Errorf("decode registry file %s: %w", path, err)
+	}
+	for _, sensor := range sensors {
+		s.sensors[sensor.ID] = sensor
+	}
+	return s, nil
+}
+
+func (s *FileStore) Get(_ context.Context, id string) (*Sensor, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	sensor, ok := s.sensors[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &sensor, nil
+}
+
+func (s *FileStore) Put(_ context.Context, sensor *Sensor) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sensors[sensor.ID] = *sensor
+	return s.save()
+}
+
+func (s *FileStore) Delete(_ context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.sensors[id]; !ok {
+		return ErrNotFound
+	}
+	delete(s.sensors, id)
+	return s.save()
+}
+
+func (s *FileStore) List(_ context.Context) ([]Sensor, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.list(), nil
+}
+
+func (s *FileStore) list() []Sensor {
+	sensors := make([]Sensor, 0, len(s.sensors))
+	for _, sensor := range s.sensors {
+		sensors = append(sensors, sensor)
+	}
+	sortSensors(sensors)
+	return sensors
+}
+
+// save writes the file atomically. Callers must hold the write lock.
+func (s *FileStore) save() error {
+	data, err := json.MarshalIndent(s.list(), "", "  ")
+	if err != nil {
+		return err
+	}
+	if dir := filepath.Dir(s.path); dir != "" {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return err
+		}
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+func sortSensors(sensors []Sensor) {
+	sort.Slice(sensors, func(i, j int) bool { return sensors[i].ID < sensors[j].ID })
+}
@@ -0,0 +1,113 @@
+package secure
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// HTTPFlags are the HTTP server options registered by RegisterHTTPFlags.
+type HTTPFlags struct {
+	Cert       *string
+	Key        *string
+	Token      *string
+	User       *string
+	Password   *string
+	CORSOrigin *string
+
+	// Session, when set, lets through the requests it reports as signed in,
+	// such as those of the dashboard's login sessions. Browsers can't add an
+	// Authorization header to page loads, EventSource or WebSocket, so they
+	// use a session cookie or basic auth.
+	Session func(r *http.Request) bool
+}
+
+// RegisterHTTPFlags registers the HTTP TLS and authentication flags on fs.
+func RegisterHTTPFlags(fs *flag.FlagSet) *HTTPFlags {
+	return &HTTPFlags{
+		Cert:       fs.String("http-cert", "", "TLS certificate for the HTTP API"),
+		Key:        fs.String("http-key", "", "TLS key for the HTTP API"),
+		Token:      fs.String("http-token", "", "Bearer token required by the HTTP API"),
+		User:       fs.String("http-user", "", "Basic auth username required by the HTTP API"),
+		Password:   fs.String("http-pass", "", "Basic auth password required by the HTTP API"),
+		CORSOrigin: fs.String("cors-origin", "", "Origin allowed to call the HTTP API from a browser (none if empty)"),
+	}
+}
+
+// publicPaths stay reachable without credentials so that health probes work.
+var publicPaths = map[string]bool{
+	"/health": true,
+}
+
+// publicPrefix holds the dashboard's static assets. They carry no data.
+const publicPrefix = "/static/"
+
+// signInPrefix and signInPage are the dashboard's sign-in pages, reachable
+// without credentials when Session is set so that browsers can get one.
+const (
+	signInPage   = "/login"
+	signInPrefix = "/auth/"
+)
+
+func (f *HTTPFlags) public(path string) bool {
+	if publicPaths[path] || strings.HasPrefix(path, publicPrefix) {
+		return true
+	}
+	return f.Session != nil && (path == signInPage || strings.HasPrefix(path, signInPrefix))
+}
+
+// Wrap protects h with the configured token and/or basic auth. Requests are
+// accepted if they satisfy either method, or Session. Without credentials configured, h
+// is returned unchanged apart from the CORS header.
+func (f *HTTPFlags) Wrap(h http.Handler) http.Handler {
+	token, user, pass, origin := *f.Token, *f.User, *f.Password, *f.CORSOrigin
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if origin != "" {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Set("Vary", "Origin")
+		}
+		if f.public(r.URL.Path) || (token == "" && user == "") {
+			h.ServeHTTP(w, r)
+			return
+		}
+		if token != "" && checkBearer(r, token) || f.Session != nil && f.Session(r) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		if user != "" {
+			if u, p, ok := r.BasicAuth(); ok && equal(u, user) && equal(p, pass) {
+				h.ServeHTTP(w, r)
+				return
+			}
+			w.Header().Set("WWW-Authenticate", `Basic realm="sistemas_distribuidos_gb"`)
+		}
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+	})
+}
+
+// ListenAndServe serves h on addr, over TLS if a certificate was given.
+func (f *HTTPFlags) ListenAndServe(addr string, h http.Handler) error {
+	if (*f.Cert == "") != (*f.Key == "") {
+		return fmt.Errorf("-http-cert and -http-key must be given together")
+	}
+	srv := &http.Server{Addr: addr, Handler: f.Wrap(h)}
+	if *f.Cert != "" {
+		srv.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+		return srv.ListenAndServeTLS(*f.Cert, *f.Key)
+	}
+	return srv.ListenAndServe()
+}
+
+func checkBearer(r *http.Request, token string) bool {
+	// Only from the header: in the URL it would end up in access logs and
+	// browser history
+	v, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && equal(v, token)
+}
+
+func equal(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
@@ -0,0 +1,74 @@
+package secure
+
+import (
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWrap(t *testing.T) {
+	f := RegisterHTTPFlags(flag.NewFlagSet("test", flag.ContinueOnError))
+	*f.Token, *f.User, *f.Password = "s3cret", "admin", "pw"
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	type request struct {
+		name, path string
+		set        func(r *http.Request)
+		want       int
+	}
+	check := func(h http.Handler, requests []request) {
+		t.Helper()
+		for _, tc := range requests {
+			r := httptest.NewRequest(http.MethodGet, tc.path, nil)
+			if tc.set != nil {
+				tc.set(r)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, r)
+			if rec.Code != tc.want {
+				t.Errorf("%s: %d, want %d", tc.name, rec.Code, tc.want)
+			}
+		}
+	}
+	bearer := func(token string) func(r *http.Request) {
+		return func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+token) }
+	}
+	basic := func(user, pass string) func(r *http.Request) {
+		return func(r *http.Request) { r.SetBasicAuth(user, pass) }
+	}
+	session := func(r *http.Request) { r.AddCookie(&http.Cookie{Name: "session", Value: "valid"}) }
+
+	check(f.Wrap(ok), []request{
+		{"no credentials", "/stats", nil, http.StatusUnauthorized},
+		{"bearer token", "/stats", bearer("s3cret"), http.StatusOK},
+		{"wrong token", "/stats", bearer("guess"), http.StatusUnauthorized},
+		{"token in the query", "/stats?token=s3cret", nil, http.StatusUnauthorized},
+		{"basic auth", "/stats", basic("admin", "pw"), http.StatusOK},
+		{"wrong password", "/stats", basic("admin", "nope"), http.StatusUnauthorized},
+		{"health", "/health", nil, http.StatusOK},
+		{"static assets", "/static/style.css", nil, http.StatusOK},
+		{"sign-in page without sessions", "/login", nil, http.StatusUnauthorized},
+		{"session without sessions", "/stats", session, http.StatusUnauthorized},
+	})
+
+	f.Session = func(r *http.Request) bool {
+		c, err := r.Cookie("session")
+		return err == nil && c.Value == "valid"
+	}
+	check(f.Wrap(ok), []request{
+		{"session", "/api/events", session, http.StatusOK},
+		{"no session", "/api/events", nil, http.StatusUnauthorized},
+		{"sign-in page", "/login", nil, http.StatusOK},
+		{"OIDC callback", "/auth/oidc/callback", nil, http.StatusOK},
+		{"bearer token with sessions", "/stats", bearer("s3cret"), http.StatusOK},
+	})
+
+	open := RegisterHTTPFlags(flag.NewFlagSet("open", flag.ContinueOnError))
+	*open.CORSOrigin = "https://ops.example"
+	rec := httptest.NewRecorder()
+	open.Wrap(ok).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/stats", nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("Access-Control-Allow-Origin") != "https://ops.example" {
+		t.Errorf("without credentials: %d, CORS %q", rec.Code, rec.Header().Get("Access-Control-Allow-Origin"))
+	}
+}
@@ -0,0 +1,95 @@
+// Package secure holds the authentication and TLS options shared by every
+// binary: credentials and TLS for the NATS connection, and TLS plus
+// token/basic auth for the HTTP APIs.
+package secure
+
+import (
+	"crypto/tls"
+	"flag"
+	"fmt"
+
+	"github.com/nats-io/nats.go"
+)
+
+// NATSFlags are the connection options registered by RegisterNATSFlags.
+type NATSFlags struct {
+	Creds    *string
+	NKeySeed *string
+	User     *string
+	Password *string
+	Token    *string
+	CA       *string
+	Cert     *string
+	Key      *string
+	TLS      *bool
+}
+
+// RegisterNATSFlags registers the NATS authentication and TLS flags on fs.
+func RegisterNATSFlags(fs *flag.FlagSet) *NATSFlags {
+	return &NATSFlags{
+		Creds:    fs.String("nats-creds", "", "NATS credentials file (JWT + NKey seed)"),
+		NKeySeed: fs.String("nats-nkey", "", "NATS NKey seed file"),
+		User:     fs.String("nats-user", "", "NATS username"),
+		Password: fs.String("nats-pass", "", "NATS password"),
+		Token:    fs.String("nats-token", "", "NATS authentication token"),
+		CA:       fs.String("nats-ca", "", "CA certificate used to verify the NATS server"),
+		Cert:     fs.String("nats-cert", "", "Client certificate for NATS mutual TLS"),
+		Key:      fs.String("nats-key", "", "Client key for NATS mutual TLS"),
+		TLS:      fs.Bool("nats-tls", false, "Require TLS on the NATS connection (implied by -nats-ca/-nats-cert or a tls:// URL)"),
+	}
+}
+
+// Options returns the nats.Options for the configured credentials and TLS.
+// At most one authentication method may be set.
+func (f *NATSFlags) Options(name string) ([]nats.Option, error) {
+	opts := []nats.Option{nats.Name(name)}
+
+	methods := 0
+	for _, set := range []bool{*f.Creds != "", *f.NKeySeed != "", *f.User != "", *f.Token != ""} {
+		if set {
+			methods++
+		}
+	}
+	if methods > 1 {
+		return nil, fmt.Errorf("use only one of -nats-creds, -nats-nkey, -nats-user or -nats-token")
+	}
+
+	switch {
+	case *f.Creds != "":
+		opts = append(opts, nats.UserCredentials(*f.Creds))
+	case *f.NKeySeed != "":
+		opt, err := nats.NkeyOptionFromSeed(*f.NKeySeed)
+		if err != nil {
+			return nil, fmt.Errorf("load NKey seed: %w", err)
+		}
+		opts = append(opts, opt)
+	case *f.User != "":
+		opts = append(opts, nats.UserInfo(*f.User, *f.Password))
+	case *f.Token != "":
+		opts = append(opts, nats.Token(*f.Token))
+	}
+
+	if (*f.Cert == "") != (*f.Key == "") {
+		return nil, fmt.Errorf("-nats-cert and -nats-key must be given together")
+	}
+	// Secure goes first: it replaces the TLS config the other two fill in
+	if *f.TLS {
+		opts = append(opts, nats.Secure(&tls.Config{MinVersion: tls.VersionTLS12}))
+	}
+	if *f.CA != "" {
+		opts = append(opts, nats.RootCAs(*f.CA))
+	}
+	if *f.Cert != "" {
+		opts = append(opts, nats.ClientCert(*f.Cert, *f.Key))
+	}
+	return opts, nil
+}
+
+// Connect connects to url with the configured credentials and TLS.
+func (f *NATSFlags) Connect(url, name string) (*nats.Conn, error) {
+	opts, err := f.Options(name)
+	if err != nil {
+		return nil, err
+	}
+	return nats.Connect(url, opts...)
+}
@@ -0,0 +1,381 @@
+// Package sensor implements a simulated industrial sensor: it publishes
+// signed readings of a value with noise, drifts and spikes, announces itself
+// to the cloud registry and optionally publishes the ground truth of every
+// reading for evaluation.
+package sensor
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nats-io/nkeys"
+
+	"sistemas_distribuidos_gb/internal/broker"
+	"sistemas_distribuidos_gb/internal/clock"
+	"sistemas_distribuidos_gb/internal/config"
+	"sistemas_distribuidos_gb/internal/config/settings"
+	"sistemas_distribuidos_gb/internal/registry"
+	"sistemas_distribuidos_gb/internal/signing"
+	"sistemas_distribuidos_gb/internal/simulator"
+	"sistemas_distribuidos_gb/internal/subjects"
+)
+
+type SensorReading struct {
+	SensorID  string  `json:"sensor_id"`
+	Site      string  `json:"site,omitempty"`
+	Line      string  `json:"line,omitempty"`
+	Value     float64 `json:"value"`
+	Timestamp int64   `json:"timestamp"`
+	Signature string  `json:"signature,omitempty"`
+}
+
+type SensorStatus struct {
+	mu            sync.RWMutex
+	SensorID      string         `json:"sensor_id"`
+	Status        string         `json:"status"`
+	LastReading   *SensorReading `json:"last_reading,omitempty"`
+	TotalReadings int64          `json:"total_readings"`
+	Uptime        time.Duration  `json:"uptime"`
+	startTime     time.Time
+}
+
+// announceRetry is how long to wait before announcing again after a failure.
+const announceRetry = 10 * time.Second
+
+// Options configure a sensor. The simulation values are the initial
+// settings, overridden by the central config when UseConfig is set.
+type Options struct {
+	ID       string // generated if empty
+	Site     string
+	Line     string
+	Location string // announced to the registry
+	Unit     string
+	Owner    string
+
+	Interval      time.Duration
+	BaseValue     float64
+	NoiseLevel    float64
+	AnomalyChance float64 // probability of a drift starting, per reading
+	SpikeChance   float64
+	Seed          int64 // of the simulation; zero seeds it from the clock
+
+	UseConfig bool
+	Sign      bool   // sign readings with the sensor's NKey
+	KeyFile   string // NKey seed, created if missing (default keys/<id>.nk)
+	Labels    bool   // publish the ground-truth label of every reading
+
+	Clock clock.Clock // nil uses the wall clock
+}
+
+// DefaultOptions returns the options used by the sensor command by default.
+func DefaultOptions() Options {
+	defaults := settings.DefaultSensor()
+	return Options{
+		Site:          subjects.DefaultSite,
+		Line:          subjects.DefaultLine,
+		Interval:      defaults.Interval.Std(),
+		BaseValue:     defaults.BaseValue,
+		NoiseLevel:    defaults.NoiseLevel,
+		AnomalyChance: defaults.AnomalyChance,
+		SpikeChance:   defaults.SpikeChance,
+		UseConfig:     true,
+		Sign:          true,
+	}
+}
+
+// Sensor is a simulated sensor.
+type Sensor struct {
+	opts         Options
+	broker       broker.Broker
+	clock        clock.Clock
+	subject      string
+	labelSubject string
+	keys         nkeys.KeyPair
+	publicKey    string
+
+	status   *SensorStatus
+	simState *simulator.Simulator
+
+	settingsMu    sync.RWMutex
+	settings      settings.Sensor
+	intervalReset chan struct{} // tells the publishing loop that its interval changed
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// New creates a sensor that publishes through b. It loads (or creates) the
+// signing key when opts.Sign is set.
+func New(b broker.Broker, opts Options) (*Sensor, error) {
+	// Generate sensor ID if not provided
+	if opts.ID == "" {
+		opts.ID = "sensor-" + uuid.New().String()[:8]
+	}
+	opts.Site = subjects.Token(opts.Site, subjects.DefaultSite)
+	opts.Line = subjects.Token(opts.Line, subjects.DefaultLine)
+	if opts.Interval <= 0 {
+		return nil, fmt.Errorf("invalid interval %v", opts.Interval)
+	}
+
+	s := &Sensor{
+		opts:         opts,
+		broker:       b,
+		clock:        clock.Or(opts.Clock),
+		subject:      subjects.Readings(opts.Site, opts.Line, opts.ID),
+		labelSubject: subjects.Labels(opts.Site, opts.Line, opts.ID),
+		status: &SensorStatus{
+			SensorID: opts.ID,
+			Status:   "Starting",
+		},
+		simState:      &simulator.Simulator{Logf: log.Printf},
+		intervalReset: make(chan struct{}, 1),
+	}
+	s.status.startTime = s.clock.Now()
+
+	if opts.Sign {
+		if s.opts.KeyFile == "" {
+			s.opts.KeyFile = filepath.Join("keys", subjects.Token(opts.ID, "sensor")+".nk")
+		}
+		var err error
+		if s.keys, err = signing.LoadOrCreateKey(s.opts.KeyFile); err != nil {
+			return nil, fmt.Errorf("load signing key: %w", err)
+		}
+		if s.publicKey, err = s.keys.PublicKey(); err != nil {
+			return nil, fmt.Errorf("read public key: %w", err)
+		}
+		log.Printf("Signing readings with key %s (%s)", s.publicKey, s.opts.KeyFile)
+	}
+	return s, nil
+}
+
+// ID returns the sensor ID.
+func (s *Sensor) ID() string { return s.opts.ID }
+
+// Start announces the sensor, loads its settings and starts publishing.
+// Publishing stops when ctx is done or Stop is called.
+func (s *Sensor) Start(ctx context.Context) error {
+	ctx, s.cancel = context.WithCancel(ctx)
+
+	// Publishing waits for the first announcement, so that edges checking
+	// signatures know the sensor's key before its first reading
+	announced := make(chan struct{})
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.announce(ctx, announced, registry.Sensor{
+			ID:        s.opts.ID,
+			Site:      s.opts.Site,
+			Line:      s.opts.Line,
+			Location:  s.opts.Location,
+			Unit:      s.opts.Unit,
+			Owner:     s.opts.Owner,
+			PublicKey: s.publicKey,
+		})
+	}()
+
+	// Load settings, from the config bucket if available
+	base := s.opts.Settings()
+	if s.opts.UseConfig {
+		nc, err := broker.Conn(s.broker)
+		if err == nil {
+			err = config.Start(ctx, nc, "sensor", s.opts.ID, base, s.applySettings)
+		}
+		if err != nil {
+			log.Printf("Central config unavailable, using flags: %v", err)
+		}
+	} else {
+		s.applySettings(base)
+	}
+
+	s.updateStatus("Running", nil)
+	log.Printf("Sensor %s started, publishing to %s every %v", s.opts.ID, s.subject, s.currentSettings().Interval.Std())
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		select {
+		case <-announced:
+		case <-ctx.Done():
+			return
+		}
+		s.run(ctx)
+	}()
+	return nil
+}
+
+// Stop stops publishing and waits for the sensor's goroutines to finish.
+func (s *Sensor) Stop() {
+	if s.cancel != nil {
+		s.cancel()
+	}
+	s.wg.Wait()
+	s.updateStatus("Stopped", nil)
+}
+
+func (s *Sensor) run(ctx context.Context) {
+	seed := s.opts.Seed
+	if seed == 0 {
+		seed = s.clock.Now().UnixNano()
+	}
+	rng := rand.New(rand.NewSource(seed))
+	ticker := s.clock.NewTicker(s.currentSettings().Interval.Std())
+	defer ticker.Stop()
+	var lastTimestamp int64
+
+	for {
+		select {
+		case <-ticker.C():
+		case <-s.intervalReset:
+			ticker.Reset(s.currentSettings().Interval.Std())
+			continue
+		case <-ctx.Done():
+			return
+		}
+
+		cfg := s.currentSettings()
+		value, label := s.simState.Next(rng, cfg.BaseValue, cfg.NoiseLevel, cfg.AnomalyChance, cfg.SpikeChance)
+
+		// Timestamps in ms to enable precise latency, strictly increasing
+		// because edges drop repeated ones as replays
+		timestamp := s.clock.Now().UnixMilli()
+		if timestamp <= lastTimestamp {
+			timestamp = lastTimestamp + 1
+		}
+		lastTimestamp = timestamp
+
+		reading := SensorReading{
+			SensorID:  s.opts.ID,
+			Site:      s.opts.Site,
+			Line:      s.opts.Line,
+			Value:     value,
+			Timestamp: timestamp,
+		}
+		if s.keys != nil {
+			sig, err := signing.Sign(s.keys, signing.Payload(reading.SensorID, reading.Site, reading.Line, reading.Value, reading.Timestamp))
+			if err != nil {
+				log.Printf("Error signing reading: %v", err)
+				continue
+			}
+			reading.Signature = sig
+		}
+
+		data, err := json.Marshal(reading)
+		if err != nil {
+			log.Printf("Error marshaling reading: %v", err)
+			continue
+		}
+
+		if err := s.broker.Publish(s.subject, data); err != nil {
+			log.Printf("Error publishing reading: %v", err)
+			s.updateStatus("Error Publishing", &reading)
+			continue
+		}
+
+		if s.opts.Labels {
+			publishLabel(s.broker, s.labelSubject, simulator.GroundTruth{SensorID: reading.SensorID, Timestamp: reading.Timestamp, Label: label})
+		}
+
+		s.updateStatus("Running", &reading)
+		log.Printf("Published: sensor_id=%s, value=%.2f, timestamp=%d", reading.SensorID, reading.Value, reading.Timestamp)
+	}
+}
+
+// announce registers the sensor with the cloud registry, retrying until the
+// registry answers. The registry is optional for publishing, but edges that
+// verify signatures only accept readings once the sensor's key is registered.
+// first is closed after the first attempt, successful or not.
+func (s *Sensor) announce(ctx context.Context, first chan<- struct{}, sensor registry.Sensor) {
+	attempted := sync.OnceFunc(func() { close(first) })
+	defer attempted()
+	nc, err := broker.Conn(s.broker)
+	if err != nil {
+		log.Printf("Registry unavailable: %v", err)
+		return
+	}
+	client, err := registry.NewClient(nc, 2*time.Second)
+	if err != nil {
+		log.Printf("Registry unavailable: %v", err)
+		return
+	}
+	defer client.Close()
+
+	for {
+		registered, err := client.Announce(sensor)
+		if err != nil {
+			attempted()
+			log.Printf("Could not announce to registry (retrying in %v): %v", announceRetry, err)
+			select {
+			case <-s.clock.After(announceRetry):
+			case <-ctx.Done():
+				return
+			}
+			continue
+		}
+		log.Printf("Announced to registry: id=%s, location=%s, unit=%s, calibration offset=%.2f scale=%.2f",
+			registered.ID, registered.Location, registered.Unit, registered.Calibration.Offset, registered.Calibration.Scale)
+		if sensor.PublicKey != "" && registered.PublicKey != sensor.PublicKey {
+			log.Printf("WARNING: registry holds a different public key for %s; edges will reject these readings", sensor.ID)
+		}
+		return
+	}
+}
+
+// publishLabel publishes the ground truth of a reading, used to evaluate the
+// edge detectors.
+func publishLabel(b broker.Broker, subject string, gt simulator.GroundTruth) {
+	data, err := json.Marshal(gt)
+	if err != nil {
+		return
+	}
+	if err := b.Publish(subject, data); err != nil {
+		log.Printf("Error publishing label: %v", err)
+	}
+}
+
+func (s *Sensor) updateStatus(status string, reading *SensorReading) {
+	s.status.mu.Lock()
+	defer s.status.mu.Unlock()
+
+	s.status.Status = status
+	if reading != nil {
+		s.status.LastReading = reading
+		s.status.TotalReadings++
+	}
+}
+
+// Handler returns the sensor HTTP API: /health and /status.
+func (s *Sensor) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("OK"))
+	})
+
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		s.status.mu.RLock()
+		defer s.status.mu.RUnlock()
+
+		// Create a display struct to handle calculated fields
+		type DisplayStatus struct {
+			*SensorStatus
+			UptimeString string `json:"uptime_string"`
+		}
+
+		display := DisplayStatus{
+			SensorStatus: s.status,
+			UptimeString: s.clock.Since(s.status.startTime).String(),
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(display)
+	})
+	return mux
+}
@@ -0,0 +1,85 @@
+package sensor
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"sistemas_distribuidos_gb/internal/broker"
+	"sistemas_distribuidos_gb/internal/clock"
+	"sistemas_distribuidos_gb/internal/subjects"
+)
+
+// TestSensorPublishesOnClockTicks drives a sensor with a fake clock: each
+// tick publishes one reading stamped with the fake time.
+func TestSensorPublishesOnClockTicks(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	b := broker.NewMemory()
+	clk := clock.NewFake(time.Date(2024, 1, 1, 8, 0, 0, 0, time.UTC))
+	opts := DefaultOptions()
+	opts.ID = "s1"
+	opts.Site, opts.Line = "plant", "l1"
+	opts.UseConfig, opts.Sign = false, false
+	opts.AnomalyChance, opts.SpikeChance = 0, 0
+	opts.Clock = clk
+
+	readings := make(chan SensorReading, 16)
+	if _, err := b.Subscribe(subjects.Readings("plant", "l1", "s1"), func(_ string, data []byte) {
+		var r SensorReading
+		json.Unmarshal(data, &r)
+		readings <- r
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := New(b, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+
+	// The publishing loop may not have created its ticker yet; keep
+	// advancing until readings come out
+	var got []SensorReading
+	deadline := time.After(5 * time.Second)
+	for len(got) < 3 {
+		clk.Advance(opts.Interval)
+		select {
+		case r := <-readings:
+			got = append(got, r)
+		case <-time.After(10 * time.Millisecond):
+		case <-deadline:
+			t.Fatalf("got %d readings, want 3", len(got))
+		}
+	}
+
+	for i, r := range got {
+		if r.SensorID != "s1" || r.Site != "plant" || r.Line != "l1" || r.Signature != "" {
+			t.Errorf("unexpected reading %+v", r)
+		}
+		if i > 0 && r.Timestamp-got[i-1].Timestamp < opts.Interval.Milliseconds() {
+			t.Errorf("readings %d and %d are %dms apart, want at least %v", i-1, i, r.Timestamp-got[i-1].Timestamp, opts.Interval)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/status", nil))
+	var status struct {
+		TotalReadings int `json:"total_readings"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &status)
+	if status.TotalReadings < len(got) {
+		t.Errorf("status reports %d readings, want at least %d", status.TotalReadings, len(got))
+	}
+}
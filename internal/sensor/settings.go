@@ -0,0 +1,42 @@
+package sensor
+
+import (
+	"log"
+
+	"sistemas_distribuidos_gb/internal/config"
+	"sistemas_distribuidos_gb/internal/config/settings"
+)
+
+// Settings returns the settings a sensor started with o runs on until the
+// central config says otherwise.
+func (o Options) Settings() settings.Sensor {
+	return settings.Sensor{
+		Interval:      config.Duration(o.Interval),
+		BaseValue:     o.BaseValue,
+		NoiseLevel:    o.NoiseLevel,
+		AnomalyChance: o.AnomalyChance,
+		SpikeChance:   o.SpikeChance,
+	}
+}
+
+func (sn *Sensor) currentSettings() settings.Sensor {
+	sn.settingsMu.RLock()
+	defer sn.settingsMu.RUnlock()
+	return sn.settings
+}
+
+func (sn *Sensor) applySettings(s settings.Sensor) {
+	sn.settingsMu.Lock()
+	previous := sn.settings
+	sn.settings = s
+	sn.settingsMu.Unlock()
+
+	if previous.Interval != 0 && previous.Interval != s.Interval {
+		select {
+		case sn.intervalReset <- struct{}{}:
+		default:
+		}
+	}
+	log.Printf("Settings applied: interval=%s, base=%.2f, noise=%.2f, anomaly=%.4f, spike=%.4f",
+		s.Interval.Std(), s.BaseValue, s.NoiseLevel, s.AnomalyChance, s.SpikeChance)
+}
@@ -0,0 +1,91 @@
+// Package signing signs and verifies sensor readings with per-device Ed25519
+// keys, encoded as NATS NKeys. The sensor keeps the seed; its public key is
+// announced to the registry, where edges pick it up to verify readings.
+package signing
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/nats-io/nkeys"
+)
+
+var (
+	// ErrMissing is returned for readings without a signature.
+	ErrMissing = errors.New("missing signature")
+	// ErrInvalid is returned when a signature doesn't match the reading.
+	ErrInvalid = errors.New("invalid signature")
+)
+
+// Payload returns the canonical bytes that are signed for a reading. Site and
+// line are included so a reading can't be replayed as coming from elsewhere.
+func Payload(sensorID, site, line string, value float64, timestamp int64) []byte {
+	return []byte(strings.Join([]string{
+		sensorID,
+		site,
+		line,
+		strconv.FormatFloat(value, 'g', -1, 64),
+		strconv.FormatInt(timestamp, 10),
+	}, "|"))
+}
+
+// Sign returns the base64 signature of payload.
+func Sign(kp nkeys.KeyPair, payload []byte) (string, error) {
+	sig, err := kp.Sign(payload)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+// Verify checks signature against payload with the given NKey public key.
+func Verify(publicKey string, payload []byte, signature string) error {
+	if signature == "" {
+		return ErrMissing
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil {
+		return ErrInvalid
+	}
+	kp, err := nkeys.FromPublicKey(publicKey)
+	if err != nil {
+		return fmt.Errorf("bad public key: %w", err)
+	}
+	if err := kp.Verify(payload, sig); err != nil {
+		return ErrInvalid
+	}
+	return nil
+}
+
+// LoadOrCreateKey reads the NKey seed at path, generating and saving a new
+// one (readable only by the owner) if the file doesn't exist.
+func LoadOrCreateKey(path string) (nkeys.KeyPair, error) {
+	seed, err := os.ReadFile(path)
+	if err == nil {
+		return nkeys.FromSeed([]byte(strings.TrimSpace(string(seed))))
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	kp, err := nkeys.CreateUser()
+	if err != nil {
+		return nil, err
+	}
+	seed, err = kp.Seed()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path, append(seed, '\n'), 0o600); err != nil {
+		return nil, err
+	}
+	return kp, nil
+}
@@ -0,0 +1,73 @@
+package signing
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nats-io/nkeys"
+)
+
+func TestSignAndVerify(t *testing.T) {
+	kp, _ := nkeys.CreateUser()
+	other, _ := nkeys.CreateUser()
+	pub, _ := kp.PublicKey()
+	otherPub, _ := other.PublicKey()
+
+	payload := Payload("s1", "plant-a", "line-1", 21.5, 1700000000000)
+	sig, err := Sign(kp, payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Verify(pub, payload, sig); err != nil {
+		t.Errorf("valid signature: %v", err)
+	}
+
+	for _, tc := range []struct {
+		name      string
+		publicKey string
+		payload   []byte
+		signature string
+		want      error
+	}{
+		{"missing", pub, payload, "", ErrMissing},
+		{"not base64", pub, payload, "not base64!", ErrInvalid},
+		{"other key", otherPub, payload, sig, ErrInvalid},
+		{"other value", pub, Payload("s1", "plant-a", "line-1", 99, 1700000000000), sig, ErrInvalid},
+		{"other time", pub, Payload("s1", "plant-a", "line-1", 21.5, 1700000000001), sig, ErrInvalid},
+		{"other site", pub, Payload("s1", "plant-b", "line-1", 21.5, 1700000000000), sig, ErrInvalid},
+	} {
+		if err := Verify(tc.publicKey, tc.payload, tc.signature); err != tc.want {
+			t.Errorf("%s: Verify = %v, want %v", tc.name, err, tc.want)
+		}
+	}
+	if err := Verify("not a key", payload, sig); err == nil || errors.Is(err, ErrInvalid) {
+		t.Errorf("bad public key: Verify = %v", err)
+	}
+}
+
+func TestLoadOrCreateKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys", "s1.nk")
+	created, err := LoadOrCreateKey(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0o600 {
+		t.Errorf("seed file: %v, %v", info, err)
+	}
+
+	loaded, err := LoadOrCreateKey(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, _ := created.PublicKey()
+	if got, _ := loaded.PublicKey(); got != want {
+		t.Errorf("reloaded key %s, want %s", got, want)
+	}
+
+	os.WriteFile(path, []byte("garbage"), 0o600)
+	if _, err := LoadOrCreateKey(path); err == nil {
+		t.Error("a corrupt seed was accepted")
+	}
+}
@@ -0,0 +1,120 @@
+// Package simulator generates synthetic sensor values with realistic
+// anomalies: slow drifts of the process mean and instantaneous spikes. Since
+// the simulator knows when it is drifting or spiking, every value comes with a
+// ground-truth label that detectors can be evaluated against.
+package simulator
+
+import "math/rand"
+
+// Label is the ground truth for a generated value.
+type Label string
+
+const (
+	Normal Label = "normal"
+	Drift  Label = "drift" // the mean is shifted, including the recovery back to base
+	Spike  Label = "spike"
+)
+
+// GroundTruth is published by sensors next to each reading when labelling is
+// enabled (see subjects.Labels).
+type GroundTruth struct {
+	SensorID  string `json:"sensor_id"`
+	Timestamp int64  `json:"timestamp"` // same as the reading's
+	Label     Label  `json:"label"`
+}
+
+// Simulator maintains the state of drift simulation
+type Simulator struct {
+	isDrifting    bool
+	driftDuration int
+	currentOffset float64
+	targetOffset  float64
+
+	// Logf, if set, receives a line whenever a drift or spike starts or ends.
+	Logf func(format string, args ...any)
+}
+
+func (s *Simulator) logf(format string, args ...any) {
+	if s.Logf != nil {
+		s.Logf(format, args...)
+	}
+}
+
+// Next returns the next value and its label.
+func (s *Simulator) Next(rng *rand.Rand, base, noise, driftChance, spikeChance float64) (float64, Label) {
+	// 1. Manage Drift State (Gradual Transitions)
+	if s.isDrifting {
+		s.driftDuration--
+
+		// Move currentOffset towards targetOffset (Approach Phase)
+		step := 0.5 // Slower transition for realism
+		if s.currentOffset < s.targetOffset {
+			s.currentOffset += rng.Float64() * step
+			if s.currentOffset > s.targetOffset {
+				s.currentOffset = s.targetOffset
+			}
+		} else if s.currentOffset > s.targetOffset {
+			s.currentOffset -= rng.Float64() * step
+			if s.currentOffset < s.targetOffset {
+				s.currentOffset = s.targetOffset
+			}
+		}
+
+		if s.driftDuration <= 0 {
+			s.isDrifting = false
+			s.logf("End of Drift. Returning to normal.")
+		}
+	} else {
+		// Recovery Phase: Slowly return offset to 0
+		if s.currentOffset != 0 {
+			approachSpeed := rng.Float64() * 0.5 // Slower recovery
+			if s.currentOffset > 0 {
+				s.currentOffset -= approachSpeed
+				if s.currentOffset < 0 {
+					s.currentOffset = 0
+				}
+			} else {
+				s.currentOffset += approachSpeed
+				if s.currentOffset > 0 {
+					s.currentOffset = 0
+				}
+			}
+		}
+
+		// 2. Start new Drift?
+		if s.currentOffset == 0 && rng.Float64() < driftChance {
+			s.isDrifting = true
+			s.driftDuration = rng.Intn(30) + 30 // Drift for 30-60 seconds
+
+			// Target offset: +/- 35 (aiming for 15 or 85)
+			if rng.Float64() < 0.5 {
+				s.targetOffset = -35.0
+			} else {
+				s.targetOffset = 35.0
+			}
+			s.logf("Starting Drift! Target: %.2f", s.targetOffset)
+		}
+	}
+
+	label := Normal
+	if s.isDrifting || s.currentOffset != 0 {
+		label = Drift
+	}
+
+	// 3. Spike Anomaly (Instantaneous)
+	// Add spike ON TOP of current state
+	spike := 0.0
+	if rng.Float64() < spikeChance {
+		if rng.Float64() < 0.5 {
+			spike = 60.0 + rng.Float64()*20.0 // +60 to +80
+		} else {
+			spike = -60.0 - rng.Float64()*20.0 // -60 to -80
+		}
+		label = Spike
+		s.logf("Generating Spike! Value: %.2f", base+s.currentOffset+spike)
+	}
+
+	// 4. Calculate Final Value
+	// Base + Gradual Offset + Spike + Noise
+	return base + s.currentOffset + spike + rng.NormFloat64()*noise, label
+}
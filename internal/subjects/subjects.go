@@ -0,0 +1,168 @@
+// Package subjects defines the NATS subject namespace shared by every component.
+//
+// Sensor readings are published per device:
+//
+//	sensors.<site>.<line>.<sensor_id>.readings
+//	sensors.<site>.<line>.<sensor_id>.labels    (simulator ground truth, optional)
+//
+// and edge output is published per edge node:
+//
+//	edge.<edge_id>.filtered
+//	edge.<edge_id>.alerts
+//	edge.<edge_id>.aggregate
+//	edge.<edge_id>.quarantine
+//
+// Cloud instances running as a cluster hand edge output to the owner of its
+// sensor partition on
+//
+//	cloud.partition.<n>.<filtered|alerts|aggregate>
+//
+// Consumers pick what they need with wildcards, e.g. "sensors.plant-a.>" for a
+// whole site or "edge.*.alerts" for every edge's alerts.
+package subjects
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// DefaultSite and DefaultLine are used when a sensor doesn't declare its location.
+	DefaultSite = "default"
+	DefaultLine = "default"
+
+	// AllReadings matches every sensor reading regardless of site, line or sensor.
+	AllReadings = "sensors.*.*.*.readings"
+
+	// AllLabels matches the ground-truth labels of every simulated sensor.
+	AllLabels = "sensors.*.*.*.labels"
+
+	// AllFiltered, AllAlerts and AllAggregates match the output of every edge node.
+	AllFiltered   = "edge.*.filtered"
+	AllAlerts     = "edge.*.alerts"
+	AllAggregates = "edge.*.aggregate"
+
+	// AllQuarantined matches the readings every edge set aside as untrusted.
+	AllQuarantined = "edge.*.quarantine"
+
+	// AllEdge matches everything published by edge nodes.
+	AllEdge = "edge.>"
+
+	// AllCloudPartitions matches the edge output handed between the
+	// instances of a cloud cluster.
+	AllCloudPartitions = "cloud.partition.>"
+
+	// Sensor registry request/reply subjects, served by the cloud.
+	RegistryAnnounce = "registry.announce"
+	RegistryLookup   = "registry.lookup"
+	RegistryList     = "registry.list"
+	RegistryUpdate   = "registry.update"
+
+	// AllRegistryUpdates matches the change notifications of every registry entry.
+	AllRegistryUpdates = "registry.updated.*"
+)
+
+// Readings returns the subject a sensor publishes its readings on.
+func Readings(site, line, sensorID string) string {
+	return fmt.Sprintf("sensors.%s.%s.%s.readings", Token(site, DefaultSite), Token(line, DefaultLine), Token(sensorID, "unknown"))
+}
+
+// Labels returns the subject a simulated sensor publishes ground-truth labels on.
+func Labels(site, line, sensorID string) string {
+	return fmt.Sprintf("sensors.%s.%s.%s.labels", Token(site, DefaultSite), Token(line, DefaultLine), Token(sensorID, "unknown"))
+}
+
+// SiteReadings matches every reading published from a site.
+func SiteReadings(site string) string {
+	return fmt.Sprintf("sensors.%s.*.*.readings", Token(site, DefaultSite))
+}
+
+// LineReadings matches every reading published from a production line of a site.
+func LineReadings(site, line string) string {
+	return fmt.Sprintf("sensors.%s.%s.*.readings", Token(site, DefaultSite), Token(line, DefaultLine))
+}
+
+// SensorReadings matches the readings of a single sensor, wherever it is installed.
+func SensorReadings(sensorID string) string {
+	return fmt.Sprintf("sensors.*.*.%s.readings", Token(sensorID, "unknown"))
+}
+
+// Filtered returns the subject an edge node publishes filtered readings on.
+func Filtered(edgeID string) string {
+	return "edge." + Token(edgeID, "unknown") + ".filtered"
+}
+
+// Alerts returns the subject an edge node publishes alerts on.
+func Alerts(edgeID string) string {
+	return "edge." + Token(edgeID, "unknown") + ".alerts"
+}
+
+// Aggregate returns the subject an edge node publishes its periodic aggregate on.
+func Aggregate(edgeID string) string {
+	return "edge." + Token(edgeID, "unknown") + ".aggregate"
+}
+
+// CloudPartition returns the subject the instances of a cloud cluster hand
+// edge output of a kind (filtered, alerts or aggregate, or * for all) to the
+// owner of a partition on.
+func CloudPartition(partition int, kind string) string {
+	return fmt.Sprintf("cloud.partition.%d.%s", partition, kind)
+}
+
+// RegistryUpdated returns the subject the registry announces changes to a sensor on.
+func RegistryUpdated(sensorID string) string {
+	return "registry.updated." + Token(sensorID, "unknown")
+}
+
+// Quarantine returns the subject an edge node sets aside readings it couldn't
+// verify on, for later inspection.
+func Quarantine(edgeID string) string {
+	return "edge." + Token(edgeID, "unknown") + ".quarantine"
+}
+
+// ParseReadings extracts site, line and sensor ID from a readings subject.
+func ParseReadings(subject string) (site, line, sensorID string, ok bool) {
+	parts := strings.Split(subject, ".")
+	if len(parts) != 5 || parts[0] != "sensors" || parts[4] != "readings" {
+		return "", "", "", false
+	}
+	return parts[1], parts[2], parts[3], true
+}
+
+// ParseEdge extracts the edge ID and the message kind (filtered, alerts,
+// aggregate, quarantine) from an edge subject.
+func ParseEdge(subject string) (edgeID, kind string, ok bool) {
+	parts := strings.Split(subject, ".")
+	if len(parts) != 3 || parts[0] != "edge" {
+		return "", "", false
+	}
+	return parts[1], parts[2], true
+}
+
+// ParseFilters splits a comma-separated list of subject filters, as accepted by
+// the -subjects flags, dropping empty entries.
+func ParseFilters(s string) []string {
+	var filters []string
+	for _, f := range strings.Split(s, ",") {
+		if f = strings.TrimSpace(f); f != "" {
+			filters = append(filters, f)
+		}
+	}
+	return filters
+}
+
+// Token makes s safe to use as a single subject token. Dots, wildcards and
+// whitespace are replaced with '-'; an empty result falls back to def.
+func Token(s, def string) string {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return def
+	}
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '.', '*', '>', ' ', '\t', '\r', '\n':
+			return '-'
+		}
+		return r
+	}, s)
+}
@@ -0,0 +1,37 @@
+#!/bin/bash
+
+# Gera uma CA de desenvolvimento e certificados para o NATS Server, para os
+# clientes (mTLS) e para as APIs HTTP.
+
+set -e
+
+CERT_DIR="${1:-certs}"
+DAYS=365
+
+mkdir -p "$CERT_DIR"
+cd "$CERT_DIR"
+
+echo "=== Gerando certificados em $CERT_DIR ==="
+
+# CA
+openssl req -x509 -newkey rsa:2048 -nodes -days $DAYS \
+    -keyout ca-key.pem -out ca.pem -subj "/CN=sistemas-distribuidos-ca" 2>/dev/null
+echo "✓ CA"
+
+# Certificado assinado pela CA: $1 = nome, $2 = subjectAltName
+issue() {
+    openssl req -newkey rsa:2048 -nodes -keyout "$1-key.pem" -out "$1.csr" -subj "/CN=$1" 2>/dev/null
+    openssl x509 -req -in "$1.csr" -CA ca.pem -CAkey ca-key.pem -CAcreateserial \
+        -days $DAYS -out "$1.pem" -extfile <(printf "subjectAltName=%s" "$2") 2>/dev/null
+    rm -f "$1.csr"
+    echo "✓ $1"
+}
+
+issue server "DNS:localhost,IP:127.0.0.1"
+issue client "DNS:client"
+issue http "DNS:localhost,IP:127.0.0.1"
+
+echo ""
+echo "NATS Server: nats-server -c deploy/nats/nats-server.conf"
+echo "Clientes:    -nats tls://localhost:4222 -nats-ca $CERT_DIR/ca.pem"
+echo "HTTP:        -http-cert $CERT_DIR/http.pem -http-key $CERT_DIR/http-key.pem"